@@ -0,0 +1,166 @@
+// Foliage snapshot tool.
+// Exports a domain's key/value store into a portable NDJSON snapshot and restores it into another bucket or domain.
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/foliagecp/sdk/statefun"
+	"github.com/foliagecp/sdk/statefun/cache"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Println("usage: snapshot export|restore [option]")
+	fmt.Println("Run \"snapshot export -h\" or \"snapshot restore -h\" to see options")
+}
+
+type commonFlags struct {
+	natsURL       *string
+	domain        *string
+	cacheID       *string
+	bucket        *string
+	kvStorePrefix *string
+	file          *string
+}
+
+func newCommonFlags(fs *flag.FlagSet, fileFlag string, fileUsage string) commonFlags {
+	return commonFlags{
+		natsURL:       fs.String("nats", nats.DefaultURL, "NATS server URL"),
+		domain:        fs.String("domain", "hub", "Domain whose bucket is used"),
+		cacheID:       fs.String("cache", "", "Cache id of the domain's bucket"),
+		bucket:        fs.String("bucket", "", "Bucket name, overrides -domain and -cache"),
+		kvStorePrefix: fs.String("prefix", cache.KVStorePrefix, "Key prefix of cache values in the bucket"),
+		file:          fs.String(fileFlag, "-", fileUsage+", \"-\" for std stream, gzipped if ends with .gz"),
+	}
+}
+
+func (cf commonFlags) bucketName() (string, error) {
+	if len(*cf.bucket) > 0 {
+		return *cf.bucket, nil
+	}
+	if len(*cf.cacheID) == 0 {
+		return "", fmt.Errorf("either -bucket or -cache must be set")
+	}
+	return statefun.CacheBucketName(*cf.domain, *cf.cacheID), nil
+}
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	cf := newCommonFlags(fs, "o", "Output file")
+	pattern := fs.String("pattern", ">", "NATS-like pattern of keys to export")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	bucket, err := cf.bucketName()
+	if err != nil {
+		return err
+	}
+	nc, err := nats.Connect(*cf.natsURL)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	var w io.Writer = os.Stdout
+	if *cf.file != "-" {
+		f, err := os.Create(*cf.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+		if strings.HasSuffix(*cf.file, ".gz") {
+			gw := gzip.NewWriter(f)
+			defer gw.Close()
+			w = gw
+		}
+	}
+
+	config := statefun.NewSnapshotExportConfig().SetBucket(bucket).SetDomain(*cf.domain).SetKVStorePrefix(*cf.kvStorePrefix).SetKeyPattern(*pattern)
+	records, err := statefun.ExportSnapshot(nc, w, *config)
+	var skippedErr *statefun.SnapshotSkippedKeysError
+	if err != nil && !errors.As(err, &skippedErr) {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d values exported from %s\n", records, bucket)
+	return err // The snapshot is written without the skipped keys
+}
+
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	cf := newCommonFlags(fs, "i", "Input file")
+	fromDomain := fs.String("from-domain", "", "Domain of ids in the snapshot, the one in its header by default; ids are moved into -domain if differs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	bucket, err := cf.bucketName()
+	if err != nil {
+		return err
+	}
+	nc, err := nats.Connect(*cf.natsURL)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	var r io.Reader = os.Stdin
+	if *cf.file != "-" {
+		f, err := os.Open(*cf.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(*cf.file, ".gz") {
+			gr, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			defer gr.Close()
+			r = gr
+		}
+	}
+
+	config := statefun.NewSnapshotRestoreConfig().SetBucket(bucket).SetKVStorePrefix(*cf.kvStorePrefix).SetDomainRewrite(*fromDomain, *cf.domain)
+	records, err := statefun.RestoreSnapshot(nc, r, *config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d values restored into %s\n", records, bucket)
+	return nil
+}
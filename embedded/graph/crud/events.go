@@ -0,0 +1,79 @@
+package crud
+
+import (
+	"github.com/foliagecp/easyjson"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Change events are enabled by Config.SetChangeEvents. Object and link changes made through the high-level CRUD API are
+taken from the op-stack of the low-level operations the call made and published as egress messages of the CRUD function
+type handling the call on "egress.<typename>.<object id>" subjects (for links - the id of the object the link goes from),
+subscribe to "egress.functions.cmdb.api.>" to get them all:
+
+	{
+		"kind": "object",
+		"op": "create" | "update" | "delete",
+		"id": string,
+		"object_type": string,
+		"old_body": json, // optional
+		"new_body": json  // optional
+	}
+
+	{
+		"kind": "link",
+		"op": "create" | "update" | "delete",
+		"from": string,
+		"to": string,
+		"type": string,
+		"from_object_type": string,
+		"to_object_type": string,
+		"old_body": json, // optional
+		"new_body": json  // optional
+	}
+*/
+const changeEventsOption = "change_events"
+
+func changeEventsEnabled(ctx *sfPlugins.StatefunContextProcessor) bool {
+	return ctx.Options != nil && ctx.Options.GetByPath(changeEventsOption).AsBoolDefault(false)
+}
+
+func emitObjectChangeEvent(ctx *sfPlugins.StatefunContextProcessor, objectID, objectType string, oldBody, newBody *easyjson.JSON, tt int) {
+	if tt < 0 || tt > 2 || !changeEventsEnabled(ctx) {
+		return
+	}
+	event := easyjson.NewJSONObject()
+	event.SetByPath("kind", easyjson.NewJSON("object"))
+	event.SetByPath("op", easyjson.NewJSON([]string{"create", "update", "delete"}[tt]))
+	event.SetByPath("id", easyjson.NewJSON(objectID))
+	event.SetByPath("object_type", easyjson.NewJSON(objectType))
+	if oldBody != nil {
+		event.SetByPath("old_body", *oldBody)
+	}
+	if newBody != nil {
+		event.SetByPath("new_body", *newBody)
+	}
+	system.MsgOnErrorReturn(ctx.Egress(sfPlugins.NatsCoreEgress, &event, objectID))
+}
+
+func emitLinkChangeEvent(ctx *sfPlugins.StatefunContextProcessor, fromObjectId, toObjectId, fromObjectType, toObjectType, linkType string, oldBody, newBody *easyjson.JSON, tt int) {
+	if tt < 0 || tt > 2 || !changeEventsEnabled(ctx) {
+		return
+	}
+	event := easyjson.NewJSONObject()
+	event.SetByPath("kind", easyjson.NewJSON("link"))
+	event.SetByPath("op", easyjson.NewJSON([]string{"create", "update", "delete"}[tt]))
+	event.SetByPath("from", easyjson.NewJSON(fromObjectId))
+	event.SetByPath("to", easyjson.NewJSON(toObjectId))
+	event.SetByPath("type", easyjson.NewJSON(linkType))
+	event.SetByPath("from_object_type", easyjson.NewJSON(fromObjectType))
+	event.SetByPath("to_object_type", easyjson.NewJSON(toObjectType))
+	if oldBody != nil {
+		event.SetByPath("old_body", *oldBody)
+	}
+	if newBody != nil {
+		event.SetByPath("new_body", *newBody)
+	}
+	system.MsgOnErrorReturn(ctx.Egress(sfPlugins.NatsCoreEgress, &event, fromObjectId))
+}
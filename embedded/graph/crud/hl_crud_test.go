@@ -0,0 +1,80 @@
+package crud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/suite"
+)
+
+type HighLevelTestSuite struct {
+	test.StatefunTestSuite
+}
+
+func TestHighLevelTestSuite(t *testing.T) {
+	suite.Run(t, new(HighLevelTestSuite))
+}
+
+func (s *HighLevelTestSuite) request(typename string, id string, payload easyjson.JSON) {
+	result, err := s.Request(sfPlugins.AutoRequestSelect, typename, id, &payload, nil)
+	s.Require().NoError(err)
+	s.Require().Equal("ok", result.GetByPath("status").AsStringDefault("failed"), result.ToString())
+}
+
+func (s *HighLevelTestSuite) Test_ChangeEvents_ObjectAndLinkCreate() {
+	RegisterAllFunctionTypesWithConfig(s.Runtime(), *NewConfig().SetChangeEvents(true))
+	s.NoError(s.StartRuntime())
+
+	sub, err := s.SubscribeSync("egress.functions.cmdb.api.>")
+	s.Require().NoError(err)
+
+	s.request("functions.cmdb.api.type.create", "typea", easyjson.NewJSONObject())
+	s.request("functions.cmdb.api.type.create", "typeb", easyjson.NewJSONObject())
+	typesLink := easyjson.NewJSONObjectWithKeyValue("to", easyjson.NewJSON("typeb"))
+	typesLink.SetByPath("object_type", easyjson.NewJSON("ab"))
+	s.request("functions.cmdb.api.types.link.create", "typea", typesLink)
+
+	objectA := easyjson.NewJSONObjectWithKeyValue("origin_type", easyjson.NewJSON("typea"))
+	objectA.SetByPath("body.name", easyjson.NewJSON("a"))
+	s.request("functions.cmdb.api.object.create", "a", objectA)
+	s.request("functions.cmdb.api.object.create", "b", easyjson.NewJSONObjectWithKeyValue("origin_type", easyjson.NewJSON("typeb")))
+	s.request("functions.cmdb.api.objects.link.create", "a", easyjson.NewJSONObjectWithKeyValue("to", easyjson.NewJSON("b")))
+
+	var objectEvent, linkEvent *easyjson.JSON
+	for objectEvent == nil || linkEvent == nil {
+		msg, err := sub.NextMsg(5 * time.Second)
+		s.Require().NoError(err)
+		event, ok := easyjson.JSONFromBytes(msg.Data)
+		s.Require().True(ok)
+		switch {
+		case event.GetByPath("kind").AsStringDefault("") == "object" && event.GetByPath("id").AsStringDefault("") == s.SetThisDomainPreffix("a"):
+			objectEvent = &event
+		case event.GetByPath("kind").AsStringDefault("") == "link" && event.GetByPath("type").AsStringDefault("") == "ab":
+			linkEvent = &event
+		}
+	}
+
+	s.Equal("create", objectEvent.GetByPath("op").AsStringDefault(""))
+	s.Equal("a", objectEvent.GetByPath("new_body.name").AsStringDefault(""))
+	s.Equal("create", linkEvent.GetByPath("op").AsStringDefault(""))
+	s.Equal(s.SetThisDomainPreffix("a"), linkEvent.GetByPath("from").AsStringDefault(""))
+	s.Equal(s.SetThisDomainPreffix("b"), linkEvent.GetByPath("to").AsStringDefault(""))
+}
+
+func (s *HighLevelTestSuite) Test_ChangeEvents_DisabledByDefault() {
+	RegisterAllFunctionTypes(s.Runtime())
+	s.NoError(s.StartRuntime())
+
+	sub, err := s.SubscribeSync("egress.functions.cmdb.api.>")
+	s.Require().NoError(err)
+
+	s.request("functions.cmdb.api.type.create", "typea", easyjson.NewJSONObject())
+	s.request("functions.cmdb.api.object.create", "a", easyjson.NewJSONObjectWithKeyValue("origin_type", easyjson.NewJSON("typea")))
+
+	_, err = sub.NextMsg(500 * time.Millisecond)
+	s.ErrorIs(err, nats.ErrTimeout)
+}
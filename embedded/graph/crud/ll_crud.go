@@ -5,12 +5,14 @@
 package crud
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 
 	"github.com/foliagecp/easyjson"
 
+	"github.com/foliagecp/sdk/statefun/cache"
 	sfMediators "github.com/foliagecp/sdk/statefun/mediator"
 	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
 	"github.com/foliagecp/sdk/statefun/system"
@@ -385,16 +387,33 @@ func LLAPILinkCreate(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContex
 			return
 		}
 
+		// All keys of the link are written by one transaction, so a link is never created partially or twice. In link of
+		// a descendant vertex in other domain is created by the request to it after the transaction
+		cache := ctx.Domain.Cache()
+		transactionID := system.GetUniqueStrID()
+		cache.TransactionBegin(transactionID)
+
+		toIdInThisDomain := ctx.Domain.GetDomainFromObjectID(toId) == ctx.Domain.Name()
+		if toIdInThisDomain {
+			if _, err := cache.TransactionGetValue(transactionID, toId); err != nil {
+				cache.TransactionAbort(transactionID)
+				om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("vertex with id=%s does not exist", toId))).Reply()
+				return
+			}
+		}
+
 		// Check if link with this name already exists --------------
-		_, err := ctx.Domain.Cache().GetValue(fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName))
+		_, err := cache.TransactionGetValue(transactionID, fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName))
 		if err == nil {
+			cache.TransactionAbort(transactionID)
 			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("link from=%s with name=%s already exists", selfId, linkName))).Reply()
 			return
 		}
 		// ----------------------------------------------------------
 		// Check if link with this type "type" to "to" already exists
-		_, err = ctx.Domain.Cache().GetValue(fmt.Sprintf(OutLinkTypeKeyPrefPattern+LinkKeySuff2Pattern, selfId, linkType, toId))
+		_, err = cache.TransactionGetValue(transactionID, fmt.Sprintf(OutLinkTypeKeyPrefPattern+LinkKeySuff2Pattern, selfId, linkType, toId))
 		if err == nil {
+			cache.TransactionAbort(transactionID)
 			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("link from=%s with name=%s to=%s with type=%s already exists, two vertices can have a link with this type and direction only once", selfId, linkName, toId, linkType))).Reply()
 			return
 		}
@@ -402,31 +421,47 @@ func LLAPILinkCreate(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContex
 
 		// Create out link on this vertex -------------------------
 		// Set link target ------------------
-		ctx.Domain.Cache().SetValue(fmt.Sprintf(OutLinkTargetKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName), []byte(fmt.Sprintf("%s.%s", linkType, toId)), true, -1, "") // Store link body in KV
+		cache.SetValue(fmt.Sprintf(OutLinkTargetKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName), []byte(fmt.Sprintf("%s.%s", linkType, toId)), true, -1, transactionID) // Store link body in KV
 		// ----------------------------------
 		// Set link body --------------------
-		ctx.Domain.Cache().SetValue(fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName), linkBody.ToBytes(), true, -1, "") // Store link body in KV
+		cache.SetValue(fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName), linkBody.ToBytes(), true, -1, transactionID) // Store link body in KV
 		// ----------------------------------
 		// Set link type --------------------
-		ctx.Domain.Cache().SetValue(fmt.Sprintf(OutLinkTypeKeyPrefPattern+LinkKeySuff2Pattern, selfId, linkType, toId), []byte(linkName), true, -1, "") // Store link type
+		cache.SetValue(fmt.Sprintf(OutLinkTypeKeyPrefPattern+LinkKeySuff2Pattern, selfId, linkType, toId), []byte(linkName), true, -1, transactionID) // Store link type
 		// ----------------------------------
 		// Index link type ------------------
-		ctx.Domain.Cache().SetValue(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff3Pattern, selfId, linkName, "type", linkType), nil, true, -1, "")
+		cache.SetValue(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff3Pattern, selfId, linkName, "type", linkType), nil, true, -1, transactionID)
 		// ----------------------------------
 		// Index link tags ------------------
 		if payload.GetByPath("tags").IsNonEmptyArray() {
 			if linkTags, ok := payload.GetByPath("tags").AsArrayString(); ok {
 				for _, linkTag := range linkTags {
-					ctx.Domain.Cache().SetValue(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff3Pattern, selfId, linkName, "tag", linkTag), nil, true, -1, "")
+					cache.SetValue(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff3Pattern, selfId, linkName, "tag", linkTag), nil, true, -1, transactionID)
 				}
 			}
 		}
 		// ----------------------------------
 		// --------------------------------------------------------
 
+		// Create in link on descendant vertex --------------------
+		if toIdInThisDomain {
+			cache.SetValue(fmt.Sprintf(InLinkKeyPrefPattern+LinkKeySuff2Pattern, toId, selfId, linkName), nil, true, -1, transactionID)
+		}
+		// --------------------------------------------------------
+
+		if err := cache.TransactionEnd(transactionID); err != nil {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("link from=%s with name=%s was not created: %s", selfId, linkName, err))).Reply()
+			return
+		}
+
 		addLinkOpToOpStack(opStack, ctx.Self.Typename, ctx.Self.ID, toId, linkName, linkType, nil, &linkBody)
 
-		// Create in link on descendant vertex --------------------
+		if toIdInThisDomain {
+			om.AggregateOpMsg(sfMediators.OpMsgOk(resultWithOpStack(nil, opStack))).Reply()
+			return
+		}
+
+		// Create in link on descendant vertex in other domain ----
 		nextCallPayload := easyjson.NewJSONObject()
 		nextCallPayload.SetByPath("in_name", easyjson.NewJSON(linkName))
 		targetId := toId
@@ -502,11 +537,6 @@ func LLAPILinkUpdate(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContex
 		}
 		return
 	}
-	oldLinkBody, err := ctx.Domain.Cache().GetValueAsJSON(fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, ctx.Self.ID, linkName))
-	if err != nil {
-		om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("link from=%s with name=%s", ctx.Self.ID, linkName))).Reply()
-		return
-	}
 
 	linkTargetStr := string(linkTargetBytes)
 	linkTargetTokens := strings.Split(linkTargetStr, ".")
@@ -525,36 +555,58 @@ func LLAPILinkUpdate(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContex
 		linkBody = easyjson.NewJSONObject()
 	}
 
+	// Set link body, merged with the current one atomically against concurrent updates --------------------
+	var oldLinkBody *easyjson.JSON
+	newLinkBodyBytes, err := ctx.Domain.Cache().UpdateValue(fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, ctx.Self.ID, linkName), func(current []byte, exists bool) ([]byte, error) {
+		if !exists {
+			return nil, fmt.Errorf("link from=%s with name=%s does not exist", ctx.Self.ID, linkName)
+		}
+		currentBody, ok := easyjson.JSONFromBytes(current)
+		if !ok {
+			return nil, fmt.Errorf("link body from=%s with name=%s is not a JSON", ctx.Self.ID, linkName)
+		}
+		oldLinkBody = &currentBody
+		if replace {
+			return linkBody.ToBytes(), nil
+		}
+		newBody := currentBody.Clone().GetPtr()
+		newBody.DeepMerge(linkBody)
+		return newBody.ToBytes(), nil
+	})
+	if err != nil {
+		om.AggregateOpMsg(sfMediators.OpMsgFailed(err.Error())).Reply()
+		return
+	}
+	linkBody, _ = easyjson.JSONFromBytes(newLinkBodyBytes)
+	// -----------------------------------------------------------------------------------------------------
+
 	if replace {
 		// Remove all indices -----------------------------
-		indexKeys := ctx.Domain.Cache().GetKeysByPattern(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff2Pattern, ctx.Self.ID, linkName, ">"))
-		for _, indexKey := range indexKeys {
-			ctx.Domain.Cache().DeleteValue(indexKey, true, -1, "")
+		if err := deleteLinkIndices(ctx, ctx.Self.ID, linkName); err != nil {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("indices of link from=%s with name=%s were not removed: %s", ctx.Self.ID, linkName, err))).Reply()
+			return
 		}
 		// ------------------------------------------------
-	} else { // merge
-		newBody := oldLinkBody.Clone().GetPtr()
-		newBody.DeepMerge(linkBody)
-		linkBody = *newBody
 	}
 
-	// Create out link on this vertex -------------------------
-	// Set link body --------------------
-	ctx.Domain.Cache().SetValue(fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, ctx.Self.ID, linkName), linkBody.ToBytes(), true, -1, "") // Store link body in KV
-	// ----------------------------------
 	// Index link type ------------------
-	ctx.Domain.Cache().SetValue(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff3Pattern, ctx.Self.ID, linkName, "type", linkType), nil, true, -1, "")
+	indexKeys := []string{fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff3Pattern, ctx.Self.ID, linkName, "type", linkType)}
 	// ----------------------------------
 	// Index link tags ------------------
 	if payload.GetByPath("tags").IsNonEmptyArray() {
 		if linkTags, ok := payload.GetByPath("tags").AsArrayString(); ok {
 			for _, linkTag := range linkTags {
-				ctx.Domain.Cache().SetValue(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff3Pattern, ctx.Self.ID, linkName, "tag", linkTag), nil, true, -1, "")
+				indexKeys = append(indexKeys, fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff3Pattern, ctx.Self.ID, linkName, "tag", linkTag))
 			}
 		}
 	}
 	// ----------------------------------
-	// --------------------------------------------------------
+	for _, indexKey := range indexKeys {
+		if _, err := ctx.Domain.Cache().UpdateValue(indexKey, func(_ []byte, _ bool) ([]byte, error) { return nil, nil }); err != nil {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("link from=%s with name=%s was not indexed: %s", ctx.Self.ID, linkName, err))).Reply()
+			return
+		}
+	}
 
 	addLinkOpToOpStack(opStack, ctx.Self.Typename, ctx.Self.ID, toId, linkName, linkType, oldLinkBody, &linkBody)
 
@@ -622,7 +674,8 @@ func LLAPILinkDelete(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContex
 			return
 		}
 
-		linkTargetBytes, err := ctx.Domain.Cache().GetValue(fmt.Sprintf(OutLinkTargetKeyPrefPattern+LinkKeySuff1Pattern, ctx.Self.ID, linkName))
+		linkTargetKey := fmt.Sprintf(OutLinkTargetKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName)
+		linkTargetBytes, linkTargetRevision, err := ctx.Domain.Cache().GetValueRevision(linkTargetKey)
 		if err != nil {
 			om.AggregateOpMsg(sfMediators.OpMsgIdle(fmt.Sprintf("link from=%s with name=%s does not exist", ctx.Self.ID, linkName))).Reply()
 			return
@@ -641,10 +694,17 @@ func LLAPILinkDelete(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContex
 		linkType := linkTargetTokens[0]
 		toId := linkTargetTokens[1]
 
+		// Delete link target first, only one of concurrent deletions of the link gets further ---------------
+		if err := ctx.Domain.Cache().DeleteValueIfRevision(linkTargetKey, linkTargetRevision); err != nil {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("link from=%s with name=%s was not deleted: %s", ctx.Self.ID, linkName, err))).Reply()
+			return
+		}
+		// ---------------------------------------------------------------------------------------------------
+
 		// Remove all indices -----------------------------
-		indexKeys := ctx.Domain.Cache().GetKeysByPattern(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff2Pattern, ctx.Self.ID, linkName, ">"))
-		for _, indexKey := range indexKeys {
-			ctx.Domain.Cache().DeleteValue(indexKey, true, -1, "")
+		if err := deleteLinkIndices(ctx, ctx.Self.ID, linkName); err != nil {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("indices of link from=%s with name=%s were not removed: %s", ctx.Self.ID, linkName, err))).Reply()
+			return
 		}
 		// ------------------------------------------------
 
@@ -654,9 +714,6 @@ func LLAPILinkDelete(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContex
 		// Delete link body -----------------
 		ctx.Domain.Cache().DeleteValue(fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName), true, -1, "")
 		// ----------------------------------
-		// Delete link target ---------------
-		ctx.Domain.Cache().DeleteValue(fmt.Sprintf(OutLinkTargetKeyPrefPattern+LinkKeySuff1Pattern, selfId, linkName), true, -1, "")
-		// ----------------------------------
 
 		addLinkOpToOpStack(opStack, ctx.Self.Typename, selfId, toId, linkName, linkType, oldLinkBody, nil)
 
@@ -767,3 +824,24 @@ func LLAPILinkRead(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextP
 
 	om.AggregateOpMsg(sfMediators.OpMsgOk(resultWithOpStack(result.GetPtr(), opStack))).Reply()
 }
+
+// deleteLinkIndices deletes all indices of the link by their revisions, an index rewritten meanwhile is deleted again
+func deleteLinkIndices(ctx *sfPlugins.StatefunContextProcessor, selfId string, linkName string) error {
+	indexKeys := ctx.Domain.Cache().GetKeysByPattern(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff2Pattern, selfId, linkName, ">"))
+	for _, indexKey := range indexKeys {
+		for attempt := 0; ; attempt++ {
+			_, revision, err := ctx.Domain.Cache().GetValueRevision(indexKey)
+			if err != nil { // Index is deleted already
+				break
+			}
+			err = ctx.Domain.Cache().DeleteValueIfRevision(indexKey, revision)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, cache.ErrValueRevisionMismatch) || attempt+1 >= cache.UpdateValueMaxAttempts {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package crud
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -71,3 +72,96 @@ func (s *LowLevelTestSuite) Test_GraphAPI_UpdateVertex_BeforeVertexCreate() {
 	wantDetails := fmt.Sprintf("vertex with id=%v does not exist", s.SetThisDomainPreffix(vertexID))
 	s.Equal(wantDetails, result.GetByPath("details").AsStringDefault(""))
 }
+
+func (s *LowLevelTestSuite) Test_GraphAPI_CreateLink_OnlyOnce() {
+	cfg := *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.AutoRequestSelect).SetMaxIdHandlers(-1)
+	s.RegisterFunction("functions.graph.api.vertex.create", LLAPIVertexCreate, cfg)
+	s.RegisterFunction("functions.graph.api.link.create", LLAPILinkCreate, cfg)
+
+	err := s.StartRuntime()
+	s.NoError(err)
+
+	for _, vertexID := range []string{"1", "2"} {
+		result, err := s.Request(sfPlugins.AutoRequestSelect, "functions.graph.api.vertex.create", vertexID, nil, nil)
+		s.NoError(err)
+		s.Equal("ok", result.GetByPath("status").AsStringDefault("failed"))
+	}
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("to", easyjson.NewJSON("2"))
+	payload.SetByPath("name", easyjson.NewJSON("link"))
+	payload.SetByPath("type", easyjson.NewJSON("type"))
+	payload.SetByPath("tags", easyjson.JSONFromArray([]string{"tag"}))
+
+	result, err := s.Request(sfPlugins.AutoRequestSelect, "functions.graph.api.link.create", "1", &payload, nil)
+	s.NoError(err)
+	s.Equal("ok", result.GetByPath("status").AsStringDefault("failed"))
+
+	linkTarget, err := s.Runtime().Domain.Cache().GetValue(fmt.Sprintf(OutLinkTargetKeyPrefPattern+LinkKeySuff1Pattern, s.SetThisDomainPreffix("1"), "link"))
+	s.NoError(err)
+	s.Equal("type."+s.SetThisDomainPreffix("2"), string(linkTarget))
+
+	result, err = s.Request(sfPlugins.AutoRequestSelect, "functions.graph.api.link.create", "1", &payload, nil)
+	s.NoError(err)
+	s.Equal("failed", result.GetByPath("status").AsStringDefault(""))
+}
+
+func (s *LowLevelTestSuite) Test_GraphAPI_UpdateAndDeleteLink_Concurrently() {
+	cfg := *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.AutoRequestSelect).SetMaxIdHandlers(-1)
+	s.RegisterFunction("functions.graph.api.vertex.create", LLAPIVertexCreate, cfg)
+	s.RegisterFunction("functions.graph.api.link.create", LLAPILinkCreate, cfg)
+	s.RegisterFunction("functions.graph.api.link.update", LLAPILinkUpdate, cfg)
+	s.RegisterFunction("functions.graph.api.link.delete", LLAPILinkDelete, cfg)
+
+	err := s.StartRuntime()
+	s.NoError(err)
+
+	for _, vertexID := range []string{"1", "2"} {
+		result, err := s.Request(sfPlugins.AutoRequestSelect, "functions.graph.api.vertex.create", vertexID, nil, nil)
+		s.NoError(err)
+		s.Equal("ok", result.GetByPath("status").AsStringDefault("failed"))
+	}
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("to", easyjson.NewJSON("2"))
+	payload.SetByPath("name", easyjson.NewJSON("link"))
+	payload.SetByPath("type", easyjson.NewJSON("type"))
+	result, err := s.Request(sfPlugins.AutoRequestSelect, "functions.graph.api.link.create", "1", &payload, nil)
+	s.NoError(err)
+	s.Equal("ok", result.GetByPath("status").AsStringDefault("failed"))
+
+	// Body merges of concurrent updates must not overwrite each other
+	updates := 10
+	wg := sync.WaitGroup{}
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := easyjson.NewJSONObject()
+			payload.SetByPath("name", easyjson.NewJSON("link"))
+			payload.SetByPath(fmt.Sprintf("body.f%d", i), easyjson.NewJSON(i))
+			payload.SetByPath("tags", easyjson.JSONFromArray([]string{fmt.Sprintf("tag%d", i)}))
+			result, err := s.Request(sfPlugins.AutoRequestSelect, "functions.graph.api.link.update", "1", &payload, nil)
+			s.NoError(err)
+			s.Equal("ok", result.GetByPath("status").AsStringDefault("failed"))
+		}(i)
+	}
+	wg.Wait()
+
+	linkBody, err := s.Runtime().Domain.Cache().GetValueAsJSON(fmt.Sprintf(OutLinkBodyKeyPrefPattern+LinkKeySuff1Pattern, s.SetThisDomainPreffix("1"), "link"))
+	s.NoError(err)
+	for i := 0; i < updates; i++ {
+		s.True(linkBody.PathExists(fmt.Sprintf("f%d", i)), "field of update %d is lost", i)
+	}
+
+	deletePayload := easyjson.NewJSONObject()
+	deletePayload.SetByPath("name", easyjson.NewJSON("link"))
+	result, err = s.Request(sfPlugins.AutoRequestSelect, "functions.graph.api.link.delete", "1", &deletePayload, nil)
+	s.NoError(err)
+	s.Equal("ok", result.GetByPath("status").AsStringDefault("failed"))
+
+	indexKeys := s.Runtime().Domain.Cache().GetKeysByPattern(fmt.Sprintf(OutLinkIndexPrefPattern+LinkKeySuff2Pattern, s.SetThisDomainPreffix("1"), "link", ">"))
+	s.Empty(indexKeys)
+	_, err = s.Runtime().Domain.Cache().GetValue(fmt.Sprintf(OutLinkTargetKeyPrefPattern+LinkKeySuff1Pattern, s.SetThisDomainPreffix("1"), "link"))
+	s.Error(err)
+}
@@ -37,6 +37,7 @@ func executeTriggersFromLLOpStack(ctx *sfPlugins.StatefunContextProcessor, opSta
 									newBody = opData.GetByPath("new_body").GetPtr()
 								}
 								executeObjectTriggers(ctx, vId, objectType, oldBody, newBody, j)
+								emitObjectChangeEvent(ctx, vId, objectType, oldBody, newBody, j)
 							}
 
 						}
@@ -69,6 +70,7 @@ func executeTriggersFromLLOpStack(ctx *sfPlugins.StatefunContextProcessor, opSta
 								newBody = opData.GetByPath("new_body").GetPtr()
 							}
 							executeLinkTriggers(ctx, fromVId, toVId, fromObjectType, toObjectType, lType, oldBody, newBody, j)
+							emitLinkChangeEvent(ctx, fromVId, toVId, fromObjectType, toObjectType, lType, oldBody, newBody, j)
 						}
 					}
 				}
@@ -230,6 +230,11 @@ func KVDelete(js nats.JetStreamContext, kv nats.KeyValue, key string) error {
 }
 
 func KVPut(js nats.JetStreamContext, kv nats.KeyValue, key string, value []byte) (revision uint64, err error) {
+	return KVPutMsg(js, kv, key, value, nil)
+}
+
+// KVPutMsg puts the value with the headers, nil headers - the value is published alone
+func KVPutMsg(js nats.JetStreamContext, kv nats.KeyValue, key string, value []byte, header nats.Header) (revision uint64, err error) {
 	if !KeyValid(key) {
 		return 0, nats.ErrInvalidKey
 	}
@@ -253,7 +258,12 @@ func KVPut(js nats.JetStreamContext, kv nats.KeyValue, key string, value []byte)
 	}
 	b.WriteString(key)
 
-	pa, err := js.Publish(b.String(), value)
+	var pa *nats.PubAck
+	if header == nil {
+		pa, err = js.Publish(b.String(), value)
+	} else {
+		pa, err = js.PublishMsg(&nats.Msg{Subject: b.String(), Header: header, Data: value})
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -261,6 +271,11 @@ func KVPut(js nats.JetStreamContext, kv nats.KeyValue, key string, value []byte)
 }
 
 func KVUpdate(js nats.JetStreamContext, kv nats.KeyValue, key string, value []byte, revision uint64) (uint64, error) {
+	return KVUpdateMsg(js, kv, key, value, revision, nil)
+}
+
+// KVUpdateMsg puts the value with the headers only if the key's last revision is the given one, 0 - the key must not exist
+func KVUpdateMsg(js nats.JetStreamContext, kv nats.KeyValue, key string, value []byte, revision uint64, header nats.Header) (uint64, error) {
 	if !KeyValid(key) {
 		return 0, nats.ErrInvalidKey
 	}
@@ -278,6 +293,9 @@ func KVUpdate(js nats.JetStreamContext, kv nats.KeyValue, key string, value []by
 	b.WriteString(key)
 
 	m := nats.Msg{Subject: b.String(), Header: nats.Header{}, Data: value}
+	for name, values := range header {
+		m.Header[name] = values
+	}
 	m.Header.Set(nats.ExpectedLastSubjSeqHdr, strconv.FormatUint(revision, 10))
 
 	pa, err := js.PublishMsg(&m)
@@ -287,6 +305,12 @@ func KVUpdate(js nats.JetStreamContext, kv nats.KeyValue, key string, value []by
 	return pa.Sequence, err
 }
 
+// KVIsDeleteMarker tells if the message is a delete or purge marker of the key/value bucket
+func KVIsDeleteMarker(header nats.Header) bool {
+	op := header.Get(kvop)
+	return op == kvdel || op == kvpurge
+}
+
 // Underlying entry.
 type kve struct {
 	bucket   string
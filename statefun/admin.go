@@ -0,0 +1,172 @@
+package statefun
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/nats-io/nats.go"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	AdminPrefix = "admin"
+)
+
+type heldKeyMutex struct {
+	revision uint64
+	lockedAt int64
+}
+
+func (r *Runtime) getAdminSubject(runtimeName string) string {
+	return fmt.Sprintf("%s.%s.%s", AdminPrefix, r.Domain.name, runtimeName)
+}
+
+/*
+Every runtime replies with its admin report on "admin.<domain>.<runtime name>" request subject. Runtimes of the same name
+reply all, so a request made with nats.Conn.Request gets the report of any of them, use RequestAdminReports to gather all.
+*/
+func (r *Runtime) startAdminSubscription() error {
+	sub, err := r.nc.Subscribe(r.getAdminSubject(r.config.name), func(msg *nats.Msg) {
+		report := r.AdminReport()
+		system.MsgOnErrorReturn(msg.Respond(report.ToBytes()))
+	})
+	if err != nil {
+		lg.Logf(lg.ErrorLevel, "Invalid admin subscription for runtime %s: %s", r.config.name, err)
+		return err
+	}
+	r.adminSubscription = sub
+	return nil
+}
+
+func (r *Runtime) stopAdminSubscription() {
+	if r.adminSubscription != nil {
+		system.MsgOnErrorReturn(r.adminSubscription.Unsubscribe())
+	}
+}
+
+// AdminReport describes what the runtime is doing: its function types, id handlers, held key mutexes and cache state
+func (r *Runtime) AdminReport() easyjson.JSON {
+	report := easyjson.NewJSONObject()
+	report.SetByPath("runtime", r.adminRuntimeReport())
+
+	functionTypes := r.getRegisteredFunctionTypes()
+	sort.Slice(functionTypes, func(i, j int) bool { return functionTypes[i].name < functionTypes[j].name })
+	functionTypesReport := easyjson.NewJSONArray()
+	for _, ft := range functionTypes {
+		functionTypesReport.AddToArray(ft.adminReport())
+	}
+	report.SetByPath("function_types", functionTypesReport)
+
+	keyMutexes := easyjson.NewJSONArray()
+	r.heldKeyMutexes.Range(func(key, value any) bool {
+		m := value.(*heldKeyMutex)
+		j := easyjson.NewJSONObjectWithKeyValue("key", easyjson.NewJSON(key.(string)))
+		j.SetByPath("revision", easyjson.NewJSON(m.revision))
+		j.SetByPath("locked_at", easyjson.NewJSON(m.lockedAt))
+		keyMutexes.AddToArray(j)
+		return true
+	})
+	report.SetByPath("key_mutexes", keyMutexes)
+
+	if r.Domain.cache != nil {
+		stats := r.Domain.cache.GetStats()
+		cacheReport := easyjson.NewJSONObject()
+		cacheReport.SetByPath("values", easyjson.NewJSON(stats.ValuesInCache))
+		cacheReport.SetByPath("lru_size", easyjson.NewJSON(stats.LRUSize))
+		cacheReport.SetByPath("lru_treshold_time", easyjson.NewJSON(stats.LRUTresholdTime))
+		report.SetByPath("cache", cacheReport)
+	}
+
+	report.SetByPath("weak_cluster_domains", easyjson.JSONFromArray(r.Domain.GetWeakClusterDomains()))
+	return report
+}
+
+func (r *Runtime) adminRuntimeReport() easyjson.JSON {
+	j := easyjson.NewJSONObject()
+	j.SetByPath("name", easyjson.NewJSON(r.config.name))
+	j.SetByPath("domain", easyjson.NewJSON(r.Domain.name))
+	j.SetByPath("hub_domain", easyjson.NewJSON(r.Domain.hubDomainName))
+	j.SetByPath("started", easyjson.NewJSON(r.started.Load()))
+	j.SetByPath("time", easyjson.NewJSON(time.Now().UnixNano()))
+	routines := easyjson.NewJSONArray()
+	system.GlobalPrometrics.GetRoutinesCounter().Read(func(key string, value int64) bool {
+		routine := easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON(key))
+		routine.SetByPath("running", easyjson.NewJSON(value))
+		routines.AddToArray(routine)
+		return true
+	})
+	j.SetByPath("routines", routines)
+	return j
+}
+
+func (ft *FunctionType) adminReport() easyjson.JSON {
+	j := easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON(ft.name))
+	j.SetByPath("config", ft.config.ToJSON())
+	j.SetByPath("subscriptions", easyjson.NewJSON(len(ft.subscriptions)))
+	j.SetByPath("msgs_in_flight", easyjson.NewJSON(atomic.LoadInt64(&ft.msgsInFlight)))
+	j.SetByPath("draining", easyjson.NewJSON(ft.draining.Load()))
+
+	ft.runtime.singleInstanceRevisionsMutex.Lock()
+	_, singleInstanceLockHeld := ft.runtime.singleInstanceRevisions[ft.name]
+	ft.runtime.singleInstanceRevisionsMutex.Unlock()
+	j.SetByPath("single_instance_lock_held", easyjson.NewJSON(singleInstanceLockHeld))
+
+	idHandlers := easyjson.NewJSONArray()
+	ft.idHandlersChannel.Range(func(key, value any) bool {
+		id := key.(string)
+		handler := easyjson.NewJSONObjectWithKeyValue("id", easyjson.NewJSON(id))
+		msgQueue := value.(*idMsgQueue)
+		handler.SetByPath("queue", easyjson.NewJSON(msgQueue.len()))
+		handler.SetByPath("control_queue", easyjson.NewJSON(len(msgQueue.control)))
+		running := false
+		if v, ok := ft.idRunningStatus.Load(id); ok {
+			running, _ = v.(bool)
+		}
+		handler.SetByPath("running", easyjson.NewJSON(running))
+		if v, ok := ft.idHandlersLastMsgTime.Load(id); ok {
+			handler.SetByPath("last_msg_time", easyjson.NewJSON(v.(int64)))
+		}
+		handler.SetByPath("quarantined", easyjson.NewJSON(ft.isQuarantined(id)))
+		idHandlers.AddToArray(handler)
+		return true
+	})
+	j.SetByPath("id_handlers", idHandlers)
+	return j
+}
+
+// RequestAdminReports gathers admin reports of all runtimes with the given name in this runtime's domain
+func (r *Runtime) RequestAdminReports(runtimeName string, timeout time.Duration) ([]easyjson.JSON, error) {
+	if r.nc == nil {
+		return nil, ErrRuntimeWithoutNats
+	}
+	inbox := r.nc.NewRespInbox()
+	sub, err := r.nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		system.MsgOnErrorReturn(sub.Unsubscribe())
+	}()
+
+	if err := r.nc.PublishRequest(r.getAdminSubject(runtimeName), inbox, nil); err != nil {
+		return nil, err
+	}
+
+	reports := []easyjson.JSON{}
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, err := sub.NextMsg(time.Until(deadline))
+		if err != nil {
+			break // Timeout, all runtimes which were able to reply did it
+		}
+		if j, ok := easyjson.JSONFromBytes(msg.Data); ok {
+			reports = append(reports, j)
+		}
+	}
+	return reports, nil
+}
@@ -0,0 +1,323 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Backend is the key/value storage the cache store keeps its values, expirations and transaction journals in. Keys are
+dot-separated tokens, patterns are NATS-like: "*" matches a token, ">" matches all the rest ones. Every write gets
+a revision greater than all the previous ones of the backend.
+
+  - NewNatsBackend - NATS JetStream key/value bucket, shared by all runtimes of a domain (default)
+  - NewMemoryBackend - process memory, for tests and runtimes with no state to keep
+  - NewBoltBackend - local bbolt file, for edge runtimes keeping their state on disk
+*/
+
+var (
+	ErrBackendKeyNotFound      = errors.New("key not found")
+	ErrBackendRevisionMismatch = errors.New("revision mismatch")
+)
+
+type BackendEntry struct {
+	Key      string
+	Value    []byte
+	Revision uint64
+	Deleted  bool   // Delete marker
+	Origin   string // Origin the writer stamped the entry with, empty if the backend does not keep origins
+}
+
+type BackendWatcher interface {
+	// Updates delivers the last entries of matching keys, then nil, then every next change
+	Updates() <-chan *BackendEntry
+	Stop() error
+}
+
+type Backend interface {
+	// Get returns the last entry of the key, ErrBackendKeyNotFound if it does not exist or is deleted
+	Get(key string) (*BackendEntry, error)
+	// GetBefore returns the last kept entry of the key written before the revision, delete markers included;
+	// ErrBackendKeyNotFound if none is kept
+	GetBefore(key string, revision uint64) (*BackendEntry, error)
+	Put(key string, value []byte) (uint64, error)
+	// Update puts the value only if the key's last revision is the given one, 0 - the key must not exist
+	Update(key string, value []byte, revision uint64) (uint64, error)
+	// Delete leaves a delete marker watchers are notified about; non-zero revision must be the key's last one
+	Delete(key string, revision uint64) error
+	// Remove erases the key's entries up to the revision, 0 - all of them; entries written after it are kept, watchers are
+	// not notified
+	Remove(key string, revision uint64) error
+	Watch(pattern string, ignoreDeletes bool) (BackendWatcher, error)
+	// WatchAfter delivers the kept entries of matching keys written after the revision in revision order, delete markers
+	// included, then every next change; no nil marker is delivered. NATS backend keeps as many entries of a key as the
+	// bucket's history is, local backends keep only the last one, so a key written several times since the revision comes
+	// once from them
+	WatchAfter(pattern string, revision uint64) (BackendWatcher, error)
+	// List returns the last entries of keys matching the pattern, delete markers excluded
+	List(pattern string) ([]*BackendEntry, error)
+	// ListKeys returns up to limit (<= 0 - all) keys starting with the prefix and greater than startAfter in lexical
+	// order, delete markers and deleted cache values excluded
+	ListKeys(prefix string, startAfter string, limit int) ([]string, error)
+	// LastRevision returns the revision of the last write, reads bounded by it see the backend as of this moment
+	LastRevision() (uint64, error)
+	Close() error
+}
+
+// MatchKeyPattern tells if the key matches the NATS-like pattern
+func MatchKeyPattern(pattern string, key string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	keyTokens := strings.Split(key, ".")
+	for i, patternToken := range patternTokens {
+		if patternToken == ">" {
+			return len(keyTokens) > i
+		}
+		if i >= len(keyTokens) || (patternToken != "*" && patternToken != keyTokens[i]) {
+			return false
+		}
+	}
+	return len(keyTokens) == len(patternTokens)
+}
+
+// cacheValueDeleted tells if the value is a cache value deleted by the store: 8 bytes of time and 0 append flag
+func cacheValueDeleted(value []byte) bool {
+	return len(value) >= 9 && value[8] == 0
+}
+
+// patternLiteralPrefix returns the part of the pattern before its first wildcard
+func patternLiteralPrefix(pattern string) string {
+	prefix := []string{}
+	for _, token := range strings.Split(pattern, ".") {
+		if token == "*" || token == ">" {
+			return strings.Join(append(prefix, ""), ".")
+		}
+		prefix = append(prefix, token)
+	}
+	return pattern
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// localStorage keeps entries of a local backend
+type localStorage interface {
+	get(key string) (*BackendEntry, error) // nil if does not exist
+	set(entry *BackendEntry) error
+	remove(key string) error
+	// scan calls f in lexical key order for entries whose keys start with the prefix and are greater than startAfter
+	// until it returns false
+	scan(prefix string, startAfter string, f func(entry *BackendEntry) bool) error
+	lastRevision() (uint64, error)
+	close() error
+}
+
+type localWatcher struct {
+	backend       *localBackend
+	pattern       string
+	ignoreDeletes bool
+	in            chan *BackendEntry
+	out           chan *BackendEntry
+}
+
+func (lw *localWatcher) Updates() <-chan *BackendEntry {
+	return lw.out
+}
+
+func (lw *localWatcher) Stop() error {
+	lw.backend.mutex.Lock()
+	defer lw.backend.mutex.Unlock()
+	if _, ok := lw.backend.watchers[lw]; ok {
+		delete(lw.backend.watchers, lw)
+		close(lw.in)
+	}
+	return nil
+}
+
+// localBackend is a backend of a single process, revisions and watchers are kept in memory
+type localBackend struct {
+	storage  localStorage
+	mutex    sync.Mutex
+	revision uint64
+	watchers map[*localWatcher]struct{}
+}
+
+func newLocalBackend(storage localStorage) (*localBackend, error) {
+	revision, err := storage.lastRevision()
+	if err != nil {
+		return nil, err
+	}
+	return &localBackend{storage: storage, revision: revision, watchers: map[*localWatcher]struct{}{}}, nil
+}
+
+func (lb *localBackend) Get(key string) (*BackendEntry, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	entry, err := lb.storage.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || entry.Deleted {
+		return nil, ErrBackendKeyNotFound
+	}
+	return entry, nil
+}
+
+func (lb *localBackend) GetBefore(key string, revision uint64) (*BackendEntry, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	entry, err := lb.storage.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || entry.Revision >= revision {
+		return nil, ErrBackendKeyNotFound
+	}
+	return entry, nil
+}
+
+func (lb *localBackend) Put(key string, value []byte) (uint64, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.write(key, value, false)
+}
+
+func (lb *localBackend) Update(key string, value []byte, revision uint64) (uint64, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	if err := lb.checkRevision(key, revision, true); err != nil {
+		return 0, err
+	}
+	return lb.write(key, value, false)
+}
+
+func (lb *localBackend) Delete(key string, revision uint64) error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	if revision > 0 {
+		if err := lb.checkRevision(key, revision, false); err != nil {
+			return err
+		}
+	}
+	_, err := lb.write(key, nil, true)
+	return err
+}
+
+func (lb *localBackend) Remove(key string, revision uint64) error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	entry, err := lb.storage.get(key)
+	if err != nil || entry == nil || (revision > 0 && entry.Revision > revision) {
+		return err
+	}
+	return lb.storage.remove(key)
+}
+
+func (lb *localBackend) Watch(pattern string, ignoreDeletes bool) (BackendWatcher, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.watch(pattern, ignoreDeletes, 0, true)
+}
+
+func (lb *localBackend) WatchAfter(pattern string, revision uint64) (BackendWatcher, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.watch(pattern, false, revision, false)
+}
+
+func (lb *localBackend) List(pattern string) ([]*BackendEntry, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.list(pattern, true)
+}
+
+func (lb *localBackend) ListKeys(prefix string, startAfter string, limit int) ([]string, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	keys := []string{}
+	err := lb.storage.scan(prefix, startAfter, func(entry *BackendEntry) bool {
+		if !entry.Deleted && !cacheValueDeleted(entry.Value) {
+			keys = append(keys, entry.Key)
+		}
+		return limit <= 0 || len(keys) < limit
+	})
+	return keys, err
+}
+
+func (lb *localBackend) LastRevision() (uint64, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.revision, nil
+}
+
+func (lb *localBackend) Close() error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	for lw := range lb.watchers {
+		close(lw.in)
+	}
+	lb.watchers = map[*localWatcher]struct{}{}
+	return lb.storage.close()
+}
+
+func (lb *localBackend) checkRevision(key string, revision uint64, deletedIsAbsent bool) error {
+	entry, err := lb.storage.get(key)
+	if err != nil {
+		return err
+	}
+	var lastRevision uint64
+	if entry != nil && !(deletedIsAbsent && entry.Deleted && revision == 0) {
+		lastRevision = entry.Revision
+	}
+	if lastRevision != revision {
+		return ErrBackendRevisionMismatch
+	}
+	return nil
+}
+
+func (lb *localBackend) write(key string, value []byte, deleted bool) (uint64, error) {
+	entry := &BackendEntry{Key: key, Value: append([]byte(nil), value...), Revision: lb.revision + 1, Deleted: deleted}
+	if err := lb.storage.set(entry); err != nil {
+		return 0, err
+	}
+	lb.revision = entry.Revision
+	for lw := range lb.watchers {
+		if (!deleted || !lw.ignoreDeletes) && MatchKeyPattern(lw.pattern, key) {
+			lw.in <- entry
+		}
+	}
+	return entry.Revision, nil
+}
+
+func (lb *localBackend) watch(pattern string, ignoreDeletes bool, afterRevision uint64, initMarker bool) (BackendWatcher, error) {
+	entries, err := lb.list(pattern, ignoreDeletes)
+	if err != nil {
+		return nil, err
+	}
+	lw := &localWatcher{backend: lb, pattern: pattern, ignoreDeletes: ignoreDeletes}
+	lw.in, lw.out = system.CreateDimSizeChannel[*BackendEntry](LevelSubscriptionNotificationsBufferMaxSize, func() {})
+	for _, entry := range entries {
+		if entry.Revision > afterRevision {
+			lw.in <- entry
+		}
+	}
+	if initMarker {
+		lw.in <- nil
+	}
+	lb.watchers[lw] = struct{}{}
+	return lw, nil
+}
+
+func (lb *localBackend) list(pattern string, ignoreDeletes bool) ([]*BackendEntry, error) {
+	entries := []*BackendEntry{}
+	err := lb.storage.scan(patternLiteralPrefix(pattern), "", func(entry *BackendEntry) bool {
+		if (!entry.Deleted || !ignoreDeletes) && MatchKeyPattern(pattern, entry.Key) {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Revision < entries[j].Revision })
+	return entries, err
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltEntriesBucket  = []byte("entries")
+	boltMetaBucket     = []byte("meta")
+	boltRevisionMetaID = []byte("revision")
+)
+
+/*
+Entry is stored in the bbolt file as:
+
+	revision (8 bytes, big endian) | deleted flag (1 byte) | value
+*/
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltBackend creates a backend keeping values in the local bbolt file, the file is created if does not exist.
+// The file is locked by the process until the backend is closed.
+func NewBoltBackend(path string) (Backend, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltEntriesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	lb, err := newLocalBackend(&boltStorage{db: db})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return lb, nil
+}
+
+func (bs *boltStorage) get(key string) (entry *BackendEntry, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(boltEntriesBucket).Get([]byte(key)); data != nil {
+			entry = decodeBoltEntry(key, data)
+		}
+		return nil
+	})
+	return
+}
+
+func (bs *boltStorage) set(entry *BackendEntry) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		data := make([]byte, 9, 9+len(entry.Value))
+		binary.BigEndian.PutUint64(data, entry.Revision)
+		if entry.Deleted {
+			data[8] = 1
+		}
+		data = append(data, entry.Value...)
+		if err := tx.Bucket(boltEntriesBucket).Put([]byte(entry.Key), data); err != nil {
+			return err
+		}
+		revisionBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(revisionBytes, entry.Revision)
+		return tx.Bucket(boltMetaBucket).Put(boltRevisionMetaID, revisionBytes)
+	})
+}
+
+func (bs *boltStorage) remove(key string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEntriesBucket).Delete([]byte(key))
+	})
+}
+
+func (bs *boltStorage) scan(prefix string, startAfter string, f func(entry *BackendEntry) bool) error {
+	seek := prefix
+	if startAfter > seek {
+		seek = startAfter
+	}
+	return bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltEntriesBucket).Cursor()
+		for k, v := c.Seek([]byte(seek)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			if string(k) == startAfter {
+				continue
+			}
+			if !f(decodeBoltEntry(string(k), v)) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *boltStorage) lastRevision() (revision uint64, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(boltMetaBucket).Get(boltRevisionMetaID); len(data) == 8 {
+			revision = binary.BigEndian.Uint64(data)
+		}
+		return nil
+	})
+	return
+}
+
+func (bs *boltStorage) close() error {
+	return bs.db.Close()
+}
+
+func decodeBoltEntry(key string, data []byte) *BackendEntry {
+	return &BackendEntry{
+		Key:      key,
+		Value:    append([]byte(nil), data[9:]...),
+		Revision: binary.BigEndian.Uint64(data[:8]),
+		Deleted:  data[8] == 1,
+	}
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+)
+
+type memoryStorage struct {
+	entries map[string]*BackendEntry
+}
+
+// NewMemoryBackend creates a backend keeping values in the process memory, nothing is kept after the process stops
+func NewMemoryBackend() Backend {
+	lb, _ := newLocalBackend(&memoryStorage{entries: map[string]*BackendEntry{}})
+	return lb
+}
+
+func (ms *memoryStorage) get(key string) (*BackendEntry, error) {
+	return ms.entries[key], nil
+}
+
+func (ms *memoryStorage) set(entry *BackendEntry) error {
+	ms.entries[entry.Key] = entry
+	return nil
+}
+
+func (ms *memoryStorage) remove(key string) error {
+	delete(ms.entries, key)
+	return nil
+}
+
+func (ms *memoryStorage) scan(prefix string, startAfter string, f func(entry *BackendEntry) bool) error {
+	keys := []string{}
+	for key := range ms.entries {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !f(ms.entries[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (ms *memoryStorage) lastRevision() (uint64, error) {
+	return 0, nil
+}
+
+func (ms *memoryStorage) close() error {
+	return nil
+}
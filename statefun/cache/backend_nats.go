@@ -0,0 +1,330 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+
+	customNatsKv "github.com/foliagecp/sdk/embedded/nats/kv"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	// NatsChangeOriginHeader is the message header the backend stamps its writes with the origin in, see Store.SubscribeChanges
+	NatsChangeOriginHeader = "Foliage-Change-Origin"
+)
+
+type natsWatcher struct {
+	unsubscribe func() error
+	out         chan *BackendEntry
+	stop        chan struct{}
+	stopped     sync.Once
+}
+
+func (nw *natsWatcher) Updates() <-chan *BackendEntry {
+	return nw.out
+}
+
+func (nw *natsWatcher) Stop() error {
+	var err error
+	nw.stopped.Do(func() {
+		close(nw.stop)
+		err = nw.unsubscribe()
+	})
+	return err
+}
+
+func (nw *natsWatcher) send(entry *BackendEntry) bool {
+	select {
+	case <-nw.stop:
+		return false
+	case nw.out <- entry:
+		return true
+	}
+}
+
+type natsBackend struct {
+	js     nats.JetStreamContext
+	kv     nats.KeyValue
+	origin string
+}
+
+// NewNatsBackend creates a backend over the NATS JetStream key/value bucket, revisions are the bucket's stream sequences
+func NewNatsBackend(js nats.JetStreamContext, kv nats.KeyValue) Backend {
+	return &natsBackend{js: js, kv: kv}
+}
+
+// NewNatsBackendWithOrigin creates a NATS backend stamping every put and update with the origin header
+func NewNatsBackendWithOrigin(js nats.JetStreamContext, kv nats.KeyValue, origin string) Backend {
+	return &natsBackend{js: js, kv: kv, origin: origin}
+}
+
+func (nb *natsBackend) Get(key string) (*BackendEntry, error) {
+	entry, err := customNatsKv.KVGet(nb.js, nb.kv, key)
+	if err != nil {
+		return nil, natsBackendError(err)
+	}
+	return natsBackendEntry(entry), nil
+}
+
+func (nb *natsBackend) GetBefore(key string, revision uint64) (*BackendEntry, error) {
+	history, err := nb.kv.History(key)
+	if err != nil {
+		return nil, natsBackendError(err)
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Revision() < revision {
+			return natsBackendEntry(history[i]), nil
+		}
+	}
+	return nil, ErrBackendKeyNotFound
+}
+
+func (nb *natsBackend) Put(key string, value []byte) (uint64, error) {
+	return customNatsKv.KVPutMsg(nb.js, nb.kv, key, value, nb.header())
+}
+
+func (nb *natsBackend) Update(key string, value []byte, revision uint64) (uint64, error) {
+	var newRevision uint64
+	var err error
+	switch {
+	case revision > 0:
+		newRevision, err = customNatsKv.KVUpdateMsg(nb.js, nb.kv, key, value, revision, nb.header())
+	case len(nb.origin) == 0:
+		newRevision, err = nb.kv.Create(key, value) // Treats delete marker as absent key
+	default: // Same as kv.Create, with the origin header
+		newRevision, err = customNatsKv.KVUpdateMsg(nb.js, nb.kv, key, value, 0, nb.header())
+		if errors.Is(err, nats.ErrKeyExists) {
+			if last, lastErr := nb.js.GetLastMsg(nb.streamName(), nb.subjectPrefix()+key); lastErr == nil && customNatsKv.KVIsDeleteMarker(last.Header) {
+				newRevision, err = customNatsKv.KVUpdateMsg(nb.js, nb.kv, key, value, last.Sequence, nb.header())
+			}
+		}
+	}
+	return newRevision, natsBackendError(err)
+}
+
+func (nb *natsBackend) Delete(key string, revision uint64) error {
+	if revision > 0 {
+		return natsBackendError(nb.kv.Delete(key, nats.LastRevision(revision)))
+	}
+	return natsBackendError(nb.kv.Delete(key))
+}
+
+// Remove purges the key's messages from the bucket's stream up to the revision, no purge marker is left
+func (nb *natsBackend) Remove(key string, revision uint64) error {
+	request := &nats.StreamPurgeRequest{Subject: nb.subjectPrefix() + key}
+	if revision > 0 {
+		request.Sequence = revision + 1 // Up to but not including
+	}
+	return nb.js.PurgeStream(nb.streamName(), request)
+}
+
+// keepHistory makes the bucket's stream keep at least the number of messages of every key
+func (nb *natsBackend) keepHistory(history int64) error {
+	streamInfo, err := nb.js.StreamInfo(nb.streamName())
+	if err != nil {
+		return err
+	}
+	if streamInfo.Config.MaxMsgsPerSubject >= history {
+		return nil
+	}
+	streamConfig := streamInfo.Config
+	streamConfig.MaxMsgsPerSubject = history
+	_, err = nb.js.UpdateStream(&streamConfig)
+	return err
+}
+
+func (nb *natsBackend) Watch(pattern string, ignoreDeletes bool) (BackendWatcher, error) {
+	opts := []nats.WatchOpt{}
+	if ignoreDeletes {
+		opts = append(opts, nats.IgnoreDeletes())
+	}
+	w, err := nb.kv.Watch(pattern, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	nw := &natsWatcher{unsubscribe: w.Stop, out: make(chan *BackendEntry), stop: make(chan struct{})}
+	go func() {
+		system.GlobalPrometrics.GetRoutinesCounter().Started("cache.natsWatcher")
+		defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("cache.natsWatcher")
+		defer close(nw.out) // Readers ranging over the updates return on Stop
+		for {
+			select {
+			case <-nw.stop:
+				return
+			case entry, ok := <-w.Updates():
+				if !ok {
+					return
+				}
+				var backendEntry *BackendEntry
+				if entry != nil {
+					backendEntry = natsBackendEntry(entry)
+				}
+				if !nw.send(backendEntry) {
+					return
+				}
+			}
+		}
+	}()
+	return nw, nil
+}
+
+// WatchAfter reads the bucket's stream with an ordered consumer starting right after the revision, messages carry the
+// origin header the writers stamped
+func (nb *natsBackend) WatchAfter(pattern string, revision uint64) (BackendWatcher, error) {
+	startOpt := nats.DeliverAll()
+	if revision > 0 {
+		startOpt = nats.StartSequence(revision + 1)
+	}
+	nw := &natsWatcher{out: make(chan *BackendEntry), stop: make(chan struct{})}
+	msgs := make(chan *nats.Msg)
+	sub, err := nb.js.Subscribe(nb.subjectPrefix()+pattern, func(msg *nats.Msg) {
+		select {
+		case <-nw.stop:
+		case msgs <- msg:
+		}
+	}, nats.OrderedConsumer(), startOpt)
+	if err != nil {
+		return nil, err
+	}
+	nw.unsubscribe = sub.Unsubscribe
+
+	go func() {
+		system.GlobalPrometrics.GetRoutinesCounter().Started("cache.natsChangesWatcher")
+		defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("cache.natsChangesWatcher")
+		defer close(nw.out)
+		for {
+			select {
+			case <-nw.stop:
+				return
+			case msg := <-msgs:
+				meta, err := msg.Metadata()
+				if err != nil {
+					continue
+				}
+				entry := &BackendEntry{
+					Key:      strings.TrimPrefix(msg.Subject, nb.subjectPrefix()),
+					Value:    msg.Data,
+					Revision: meta.Sequence.Stream,
+					Deleted:  customNatsKv.KVIsDeleteMarker(msg.Header),
+					Origin:   msg.Header.Get(NatsChangeOriginHeader),
+				}
+				if !nw.send(entry) {
+					return
+				}
+			}
+		}
+	}()
+	return nw, nil
+}
+
+func (nb *natsBackend) List(pattern string) ([]*BackendEntry, error) {
+	w, err := nb.kv.Watch(pattern, nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { system.MsgOnErrorReturn(w.Stop()) }()
+
+	entries := []*BackendEntry{}
+	for entry := range w.Updates() {
+		if entry == nil {
+			break
+		}
+		entries = append(entries, natsBackendEntry(entry))
+	}
+	return entries, nil
+}
+
+// ListKeys lists subjects of the bucket's stream on the server, no messages are delivered; only the values of keys making
+// it into the page are read to skip deleted ones
+func (nb *natsBackend) ListKeys(prefix string, startAfter string, limit int) ([]string, error) {
+	pattern := ">"
+	if i := strings.LastIndex(prefix, "."); i >= 0 {
+		pattern = prefix[:i+1] + ">"
+	}
+	subjectPrefix := nb.subjectPrefix()
+	streamInfo, err := nb.js.StreamInfo(nb.streamName(), &nats.StreamInfoRequest{SubjectsFilter: subjectPrefix + pattern})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []string{}
+	for subject := range streamInfo.State.Subjects {
+		if key := strings.TrimPrefix(subject, subjectPrefix); strings.HasPrefix(key, prefix) && key > startAfter {
+			candidates = append(candidates, key)
+		}
+	}
+	sort.Strings(candidates)
+
+	keys := []string{}
+	for _, key := range candidates {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		entry, err := nb.Get(key)
+		if err != nil {
+			if errors.Is(err, ErrBackendKeyNotFound) { // Delete marker
+				continue
+			}
+			return nil, err
+		}
+		if cacheValueDeleted(entry.Value) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (nb *natsBackend) LastRevision() (uint64, error) {
+	streamInfo, err := nb.js.StreamInfo(nb.streamName())
+	if err != nil {
+		return 0, err
+	}
+	return streamInfo.State.LastSeq, nil
+}
+
+// Close does nothing, the bucket and its connection are owned by the caller
+func (nb *natsBackend) Close() error {
+	return nil
+}
+
+func (nb *natsBackend) header() nats.Header {
+	if len(nb.origin) == 0 {
+		return nil
+	}
+	return nats.Header{NatsChangeOriginHeader: []string{nb.origin}}
+}
+
+func (nb *natsBackend) streamName() string {
+	return "KV_" + nb.kv.Bucket()
+}
+
+func (nb *natsBackend) subjectPrefix() string {
+	return "$KV." + nb.kv.Bucket() + "."
+}
+
+func natsBackendEntry(entry nats.KeyValueEntry) *BackendEntry {
+	return &BackendEntry{
+		Key:      entry.Key(),
+		Value:    entry.Value(),
+		Revision: entry.Revision(),
+		Deleted:  entry.Operation() != nats.KeyValuePut,
+	}
+}
+
+func natsBackendError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, nats.ErrKeyNotFound), errors.Is(err, nats.ErrKeyDeleted):
+		return ErrBackendKeyNotFound
+	case errors.Is(err, nats.ErrKeyExists):
+		return ErrBackendRevisionMismatch
+	}
+	return err
+}
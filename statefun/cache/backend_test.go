@@ -0,0 +1,124 @@
+package cache_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	natsservertest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type BackendsTestSuite struct {
+	suite.Suite
+}
+
+func TestBackendsTestSuite(t *testing.T) {
+	suite.Run(t, new(BackendsTestSuite))
+}
+
+func (s *BackendsTestSuite) Test_MemoryAndBolt() {
+	memoryStore := cache.NewCacheStore(context.Background(), cache.NewCacheConfig("memory").SetBackend(cache.NewMemoryBackend()), nil, nil)
+	defer memoryStore.Destroy()
+
+	memoryStore.SetValue("backend.a", []byte("1"), true, -1, "")
+	ok, err := memoryStore.SetValueIfEquals("backend.a", []byte("2"), []byte("1"))
+	s.NoError(err)
+	s.True(ok)
+	s.ElementsMatch([]string{"backend.a"}, memoryStore.GetKeysByPattern("backend.*"))
+
+	expired := memoryStore.SubscribeExpirations("test")
+	defer memoryStore.UnsubscribeExpirations("test")
+	s.NoError(memoryStore.SetValueExpirationAfter("backend.a", 100*time.Millisecond))
+	select {
+	case kv := <-expired:
+		s.Equal("backend.a", kv.Key)
+	case <-time.After(5 * time.Second):
+		s.Fail("value did not expire")
+	}
+	_, err = memoryStore.GetValue("backend.a")
+	s.Error(err)
+
+	path := filepath.Join(s.T().TempDir(), "cache.db")
+	boltBackend, err := cache.NewBoltBackend(path)
+	s.Require().NoError(err)
+	boltStore := cache.NewCacheStore(context.Background(), cache.NewCacheConfig("bolt").SetBackend(boltBackend), nil, nil)
+	boltStore.SetValue("backend.b", []byte("kept"), true, -1, "")
+	s.NoError(boltStore.Flush())
+	boltStore.Destroy()
+	s.NoError(boltBackend.Close())
+
+	boltBackend, err = cache.NewBoltBackend(path)
+	s.Require().NoError(err)
+	defer func() { s.NoError(boltBackend.Close()) }()
+	boltStore = cache.NewCacheStore(context.Background(), cache.NewCacheConfig("bolt").SetBackend(boltBackend), nil, nil)
+	defer boltStore.Destroy()
+	value, err := boltStore.GetValue("backend.b")
+	s.NoError(err)
+	s.Equal([]byte("kept"), value)
+}
+
+// natsBucket runs a NATS server for the test and creates the key/value bucket on it
+func natsBucket(t *testing.T, name string) (nats.JetStreamContext, nats.KeyValue) {
+	opts := natsservertest.DefaultTestOptions
+	opts.JetStream = true
+	opts.Port = -1
+	opts.StoreDir = t.TempDir()
+	srv := natsservertest.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+
+	nc, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: name})
+	require.NoError(t, err)
+	return js, kv
+}
+
+func (s *BackendsTestSuite) Test_NatsWatcherStopClosesUpdates() {
+	js, kv := natsBucket(s.T(), "watcher")
+
+	w, err := cache.NewNatsBackend(js, kv).Watch(">", false)
+	s.Require().NoError(err)
+	s.Nil(<-w.Updates()) // Bucket is empty
+	s.NoError(w.Stop())
+
+	select {
+	case _, ok := <-w.Updates():
+		s.False(ok)
+	case <-time.After(5 * time.Second):
+		s.Fail("updates channel was not closed on stop")
+	}
+}
+
+func (s *BackendsTestSuite) Test_NatsListKeysSkipsDeleted() {
+	js, kv := natsBucket(s.T(), "list")
+	backend := cache.NewNatsBackend(js, kv)
+
+	cacheValue := func(appendFlag byte) []byte {
+		return append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, appendFlag, '1')
+	}
+	for _, key := range []string{"store.d", "store.a", "store.c", "store.b", "store.e", "other.a"} {
+		_, err := backend.Put(key, cacheValue(1))
+		s.Require().NoError(err)
+	}
+	_, err := backend.Put("store.b", cacheValue(0)) // Deleted by the cache store
+	s.Require().NoError(err)
+	s.Require().NoError(backend.Delete("store.c", 0))
+
+	keys, err := backend.ListKeys("store.", "", 2)
+	s.NoError(err)
+	s.Equal([]string{"store.a", "store.d"}, keys)
+	keys, err = backend.ListKeys("store.", "store.d", 2)
+	s.NoError(err)
+	s.Equal([]string{"store.e"}, keys)
+	keys, err = backend.ListKeys("store.", "", 0)
+	s.NoError(err)
+	s.Equal([]string{"store.a", "store.d", "store.e"}, keys)
+}
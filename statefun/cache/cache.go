@@ -20,7 +20,6 @@ import (
 
 	"github.com/foliagecp/easyjson"
 
-	customNatsKv "github.com/foliagecp/sdk/embedded/nats/kv"
 	"github.com/foliagecp/sdk/statefun/system"
 	"github.com/nats-io/nats.go"
 )
@@ -51,8 +50,29 @@ type StoreValue struct {
 	syncedWithKV                   bool
 }
 
-func notifySubscriber(c chan KeyValue, key interface{}, value interface{}) {
-	c <- KeyValue{Key: key, Value: value}
+// subscriberChannel is the inbound channel of a subscription, it is closed under the mutex so that notifiers which got
+// the subscription before it was removed never send to the closed channel
+type subscriberChannel struct {
+	in     chan KeyValue
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func (sc *subscriberChannel) close() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if !sc.closed {
+		sc.closed = true
+		close(sc.in)
+	}
+}
+
+func notifySubscriber(sc *subscriberChannel, key interface{}, value interface{}) {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+	if !sc.closed {
+		sc.in <- KeyValue{Key: key, Value: value}
+	}
 }
 
 func (csv *StoreValue) Lock(caller string) {
@@ -123,7 +143,7 @@ func (csv *StoreValue) StoreChild(key interface{}, child *StoreValue, safe bool)
 		csv.Unlock("StoreChild")
 	}
 	csv.notifyUpdates.Range(func(_, v interface{}) bool {
-		notifySubscriber(v.(chan KeyValue), key, child.value)
+		notifySubscriber(v.(*subscriberChannel), key, child.value)
 		return true
 	})
 }
@@ -144,7 +164,7 @@ func (csv *StoreValue) Put(value interface{}, updateInKV bool, customPutTime int
 
 	if csv.parent != nil {
 		csv.parent.notifyUpdates.Range(func(_, v interface{}) bool {
-			notifySubscriber(v.(chan KeyValue), key, value)
+			notifySubscriber(v.(*subscriberChannel), key, value)
 			return true
 		})
 	}
@@ -230,7 +250,7 @@ func (csv *StoreValue) Delete(updateInKV bool, customDeleteTime int64) {
 
 	if csv.parent != nil {
 		csv.parent.notifyUpdates.Range(func(_, v interface{}) bool {
-			notifySubscriber(v.(chan KeyValue), key, nil)
+			notifySubscriber(v.(*subscriberChannel), key, nil)
 			return true
 		})
 	}
@@ -246,43 +266,60 @@ func (csv *StoreValue) Range(f func(key, value interface{}) bool) {
 	}
 }
 
-type TransactionOperator struct {
-	operatorType int // 0 - set, 1 - delete
-	key          string
-	value        []byte
-	updateInKV   bool
-	customTime   int64
-}
-
-type Transaction struct {
-	operators    []*TransactionOperator
-	beginCounter int
-	mutex        *sync.Mutex
-}
-
 type Store struct {
 	cacheConfig *Config
-	js          nats.JetStreamContext
-	kv          nats.KeyValue
+	backend     Backend
 	ctx         context.Context
 	cancel      context.CancelFunc
 
 	rootValue       *StoreValue
 	lruTresholdTime int64
-	valuesInCache   int
+	valuesInCache   int64
 
 	transactions                sync.Map
 	transactionsMutex           *sync.Mutex
 	getKeysByPatternFromKVMutex *sync.Mutex
+	casMutex                    system.KeyMutex
+
+	expirations           map[string]*expirationItem
+	expirationsIndex      expirationHeap
+	expirationsMutex      sync.Mutex
+	expirationsWakeup     chan struct{}
+	expirationSubscribers sync.Map
+
+	transactionJournals         map[string]*transactionJournalEntry
+	transactionJournalsMutex    sync.Mutex
+	transactionJournalsRevision uint64
+
+	changeOrigin          string
+	changeSubscribers     sync.Map
+	changeTombstones      map[string]changeTombstone
+	changeTombstonesMutex sync.Mutex
 }
 
+// NewCacheStore creates the store over the backend set in the config or, if none, over the NATS key/value bucket
 func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamContext, kv nats.KeyValue) *Store {
+	changeOrigin := cacheConfig.changeOrigin
+	if len(changeOrigin) == 0 {
+		changeOrigin = system.GetUniqueStrID()
+	}
+	backend := cacheConfig.backend
+	if backend == nil {
+		if cacheConfig.changeFeed {
+			backend = NewNatsBackendWithOrigin(js, kv, changeOrigin)
+			if err := backend.(*natsBackend).keepHistory(cacheConfig.changeFeedHistory); err != nil {
+				lg.Logf(lg.ErrorLevel, "Cannot raise history of bucket %s for the change feed: %s", kv.Bucket(), err)
+			}
+		} else {
+			backend = NewNatsBackend(js, kv)
+		}
+	}
+
 	var inited atomic.Bool
 	initChan := make(chan bool)
 	cs := Store{
 		cacheConfig: cacheConfig,
-		js:          js,
-		kv:          kv,
+		backend:     backend,
 		rootValue: &StoreValue{
 			parent:                         nil,
 			value:                          nil,
@@ -298,6 +335,12 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 		valuesInCache:               0,
 		transactionsMutex:           &sync.Mutex{},
 		getKeysByPatternFromKVMutex: &sync.Mutex{},
+		casMutex:                    system.NewKeyMutex(),
+		expirations:                 map[string]*expirationItem{},
+		expirationsWakeup:           make(chan struct{}, 1),
+		transactionJournals:         map[string]*transactionJournalEntry{},
+		changeOrigin:                changeOrigin,
+		changeTombstones:            map[string]changeTombstone{},
 	}
 
 	cs.ctx, cs.cancel = context.WithCancel(ctx)
@@ -305,16 +348,18 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 	storeUpdatesHandler := func(cs *Store) {
 		system.GlobalPrometrics.GetRoutinesCounter().Started("cache.storeUpdatesHandler")
 		defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("cache.storeUpdatesHandler")
-		if w, err := kv.Watch(cacheConfig.kvStorePrefix+".>", nats.IgnoreDeletes()); err == nil {
+		if w, err := cs.backend.Watch(cacheConfig.kvStorePrefix+".>", true); err == nil {
 			activeKVSync := true
 			for activeKVSync {
 				select {
 				case <-cs.ctx.Done():
 					activeKVSync = false
-				case entry := <-w.Updates():
-					if entry != nil {
-						key := cs.fromStoreKey(entry.Key())
-						valueBytes := entry.Value()
+				case entry, ok := <-w.Updates():
+					if !ok { // Backend was closed
+						activeKVSync = false
+					} else if entry != nil {
+						key := cs.fromStoreKey(entry.Key)
+						valueBytes := entry.Value
 						if len(valueBytes) >= 9 { // Update or delete signal from KV store
 							appendFlag := valueBytes[8]
 							kvRecordTime := int64(binary.BigEndian.Uint64(valueBytes[:8]))
@@ -328,7 +373,7 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 									//lg.Logf("---CACHE_KV TF DELETE: %s, %d, %d", key, kvRecordTime, appendFlag)
 
 									//system.MsgOnErrorReturn(kv.Delete(entry.Key()))
-									system.MsgOnErrorReturn(customNatsKv.KVDelete(cs.js, cs.kv, entry.Key()))
+									cs.removeDeletedValue(entry)
 
 									//cs.rootValue.purgeReady
 									//if csv := cs.getLastKeyCacheStoreValue(key); csv != nil {
@@ -338,7 +383,7 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 							} else if kvRecordTime == cacheRecordTime { // KV confirmes update
 								if appendFlag == 0 {
 									//system.MsgOnErrorReturn(kv.Delete(entry.Key()))
-									system.MsgOnErrorReturn(customNatsKv.KVDelete(cs.js, cs.kv, entry.Key()))
+									cs.removeDeletedValue(entry)
 								}
 								if csv := cs.getLastKeyCacheStoreValue(key); csv != nil {
 									csv.Lock("storeUpdatesHandler")
@@ -380,6 +425,7 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 		for {
 			select {
 			case <-cs.ctx.Done():
+				return
 			default:
 				cacheStoreValueStack := []*StoreValue{cs.rootValue}
 				suffixPathsStack := []string{""}
@@ -414,23 +460,10 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 							newSuffix = currentSuffix + "." + key.(string)
 						}
 
-						var finalBytes []byte = nil
-
 						csvChild := value.(*StoreValue)
-						var valueUpdateTime int64 = 0
 						csvChild.Lock("kvLazyWriter")
-						if csvChild.syncNeeded {
-							valueUpdateTime = csvChild.valueUpdateTime
-							timeBytes := make([]byte, 8)
-							binary.BigEndian.PutUint64(timeBytes, uint64(csvChild.valueUpdateTime))
-							if csvChild.valueExists {
-								header := append(timeBytes, 1) // Add append flag "1"
-								finalBytes = append(header, csvChild.value.([]byte)...)
-							} else {
-								finalBytes = append(timeBytes, 0) // Add delete flag "0"
-							}
-						} else {
-							if csvChild.valueUpdateTime > 0 && csvChild.valueUpdateTime <= cs.lruTresholdTime && csvChild.purgeState == 0 { // Older than or equal to specific time
+						if !csvChild.syncNeeded {
+							if csvChild.valueUpdateTime > 0 && csvChild.valueUpdateTime <= atomic.LoadInt64(&cs.lruTresholdTime) && csvChild.purgeState == 0 { // Older than or equal to specific time
 								// currentStoreValue locked by range no locking/unlocking needed
 								currentStoreValue.ConsistencyLoss(system.GetCurrentTimeNs())
 								//lg.Logf("Consistency lost for key=\"%s\" store", currentStoreValue.GetFullKeyString())
@@ -442,20 +475,8 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 						csvChild.Unlock("kvLazyWriter")
 
 						// Putting value into KV store ------------------
-						if csvChild.syncNeeded {
-							keyStr := key.(string)
-							///_, putErr := kv.Put(cs.toStoreKey(newSuffix), finalBytes)
-
-							_, putErr := customNatsKv.KVPut(cs.js, kv, cs.toStoreKey(newSuffix), finalBytes)
-							if putErr == nil {
-								csvChild.Lock("kvLazyWriter")
-								if valueUpdateTime == csvChild.valueUpdateTime {
-									csvChild.syncNeeded = false
-								}
-								csvChild.Unlock("kvLazyWriter")
-							} else {
-								lg.Logf(lg.ErrorLevel, "Store kvLazyWriter cannot update key=%s\n: %s", keyStr, putErr)
-							}
+						if putErr := cs.syncStoreValueWithKV(newSuffix, csvChild); putErr != nil {
+							lg.Logf(lg.ErrorLevel, "Store kvLazyWriter cannot update key=%s\n: %s", key.(string), putErr)
 						}
 						// ----------------------------------------------
 
@@ -472,9 +493,9 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 
 				sort.Slice(lruTimes, func(i, j int) bool { return lruTimes[i] > lruTimes[j] })
 				if len(lruTimes) > cacheConfig.lruSize {
-					cs.lruTresholdTime = lruTimes[cacheConfig.lruSize-1]
+					atomic.StoreInt64(&cs.lruTresholdTime, lruTimes[cacheConfig.lruSize-1])
 				} else {
-					cs.lruTresholdTime = lruTimes[len(lruTimes)-1]
+					atomic.StoreInt64(&cs.lruTresholdTime, lruTimes[len(lruTimes)-1])
 				}
 
 				/*// Debug info -----------------------------------------------------
@@ -487,10 +508,10 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 				}
 				// ----------------------------------------------------------------*/
 
-				cs.valuesInCache = len(lruTimes)
+				atomic.StoreInt64(&cs.valuesInCache, int64(len(lruTimes)))
 
 				if gaugeVec, err := system.GlobalPrometrics.EnsureGaugeVecSimple("cache_values", "", []string{"id"}); err == nil {
-					gaugeVec.With(prometheus.Labels{"id": cs.cacheConfig.id}).Set(float64(cs.valuesInCache))
+					gaugeVec.With(prometheus.Labels{"id": cs.cacheConfig.id}).Set(float64(len(lruTimes)))
 				}
 
 				time.Sleep(100 * time.Millisecond) // Prevents too many locks and prevents too much processor time consumption
@@ -500,6 +521,26 @@ func NewCacheStore(ctx context.Context, cacheConfig *Config, js nats.JetStreamCo
 	go storeUpdatesHandler(&cs)
 	go kvLazyWriter(&cs)
 	<-initChan
+	if cacheConfig.changeFeed {
+		go cs.changeTombstonesTrimmer()
+	}
+
+	if w, err := cs.backend.Watch(cacheConfig.kvExpirationsPrefix+".>", false); err == nil {
+		expirationsInitChan := make(chan bool)
+		go cs.expirationsUpdatesHandler(w, expirationsInitChan)
+		<-expirationsInitChan
+		go cs.expirationsHandler()
+	} else {
+		lg.Logf(lg.ErrorLevel, "expirationsUpdatesHandler kv.Watch error %s", err)
+	}
+
+	if w, err := cs.backend.Watch(cacheConfig.kvTransactionsPrefix+".>", false); err == nil {
+		transactionsInitChan := make(chan bool)
+		go cs.transactionJournalsUpdatesHandler(w, transactionsInitChan)
+		<-transactionsInitChan
+	} else {
+		lg.Logf(lg.ErrorLevel, "transactionJournalsUpdatesHandler kv.Watch error %s", err)
+	}
 	return &cs
 }
 
@@ -511,7 +552,7 @@ func (cs *Store) SubscribeLevelCallback(key string, callbackID string) chan KeyV
 			lg.Logf(lg.WarnLevel, "SubscribeLevelCallback SubscriptionNotificationsBuffer overflow for key=%s!", key)
 		}
 		callbackChannelIn, callbackChannelOut := system.CreateDimSizeChannel[KeyValue](cs.cacheConfig.levelSubscriptionNotificationsBufferMaxSize, onBufferOverflow)
-		parentCacheStoreValue.notifyUpdates.Store(callbackID, callbackChannelIn)
+		parentCacheStoreValue.notifyUpdates.Store(callbackID, &subscriberChannel{in: callbackChannelIn})
 
 		return callbackChannelOut
 	}
@@ -520,12 +561,9 @@ func (cs *Store) SubscribeLevelCallback(key string, callbackID string) chan KeyV
 
 func (cs *Store) UnsubscribeLevelCallback(key string, callbackID string) {
 	if _, parentCacheStoreValue := cs.getLastKeyTokenAndItsParentCacheStoreValue(key, false); parentCacheStoreValue != nil {
-		if v, ok := parentCacheStoreValue.notifyUpdates.Load(callbackID); ok {
-			if callbackChannelIn, ok := v.(chan KeyValue); ok {
-				close(callbackChannelIn)
-			}
+		if v, ok := parentCacheStoreValue.notifyUpdates.LoadAndDelete(callbackID); ok {
+			v.(*subscriberChannel).close()
 		}
-		parentCacheStoreValue.notifyUpdates.Delete(callbackID)
 	}
 }
 
@@ -567,9 +605,9 @@ func (cs *Store) GetValue(key string) ([]byte, error) {
 
 	// Cache miss -----------------------------------------
 	if cacheMiss {
-		if entry, err := customNatsKv.KVGet(cs.js, cs.kv, cs.toStoreKey(key)); err == nil {
-			key := cs.fromStoreKey(entry.Key())
-			valueBytes := entry.Value()
+		if entry, err := cs.backend.Get(cs.toStoreKey(key)); err == nil {
+			key := cs.fromStoreKey(entry.Key)
+			valueBytes := entry.Value
 			result = valueBytes[9:]
 
 			if len(valueBytes) >= 9 { // Updated or deleted value exists in KV store
@@ -600,63 +638,6 @@ func (cs *Store) GetValueAsJSON(key string) (*easyjson.JSON, error) {
 	return nil, err
 }
 
-func (cs *Store) TransactionBegin(transactionID string) {
-	if v, ok := cs.transactions.Load(transactionID); ok {
-		transaction := v.(*Transaction)
-		transaction.mutex.Lock()
-		transaction.beginCounter++
-		transaction.mutex.Unlock()
-	} else {
-		cs.transactions.Store(transactionID, &Transaction{operators: []*TransactionOperator{}, beginCounter: 1, mutex: &sync.Mutex{}})
-	}
-}
-
-func (cs *Store) TransactionEnd(transactionID string) {
-	if v, ok := cs.transactions.Load(transactionID); ok {
-		transaction := v.(*Transaction)
-		transaction.mutex.Lock()
-		transaction.beginCounter--
-		if transaction.beginCounter == 0 {
-			cs.transactionsMutex.Lock()
-			for _, op := range transaction.operators {
-				switch op.operatorType {
-				case 0:
-					cs.SetValue(op.key, op.value, op.updateInKV, op.customTime, "")
-				case 1:
-					cs.DeleteValue(op.key, op.updateInKV, op.customTime, "")
-				}
-			}
-			cs.transactionsMutex.Unlock()
-			cs.transactions.Delete(transactionID)
-		}
-		transaction.mutex.Unlock()
-	}
-}
-
-/*func (cs *Store) SetValueIfEquals(key string, newValue []byte, updateInKV bool, customSetTime int64, compareValue []byte) bool {
-	if customSetTime < 0 {
-		customSetTime = GetCurrentTimeNs()
-	}
-	if keyLastToken, parentCacheStoreValue := cs.getLastKeyTokenAndItsParentCacheStoreValue(key, true); len(keyLastToken) > 0 && parentCacheStoreValue != nil {
-		parentCacheStoreValue.Lock("SetValueIfEquals parent")
-		defer parentCacheStoreValue.Unlock("SetValueIfEquals parent")
-
-		var csvUpdate *StoreValue = nil
-		if csv, ok := parentCacheStoreValue.LoadChild(keyLastToken, false); ok {
-			if currentByteValue, ok := csv.value.([]byte); ok && bytes.Equal(currentByteValue, compareValue) {
-				csv.Put(newValue, updateInKV, customSetTime)
-				return true
-			}
-			return false
-		} else {
-			csvUpdate = &StoreValue{value: newValue, storeMutex: &sync.Mutex{}, store: make(map[interface{}]*StoreValue), storeConsistencyWithKVLossTime: 0, valueExists: true, purgeState: 0, syncNeeded: updateInKV, syncedWithKV: !updateInKV, valueUpdateTime: customSetTime}
-			parentCacheStoreValue.StoreChild(keyLastToken, csvUpdate)
-			return true
-		}
-	}
-	return false
-}*/
-
 func (cs *Store) SetValueIfDoesNotExist(key string, newValue []byte, updateInKV bool, customSetTime int64) bool {
 	if !keyValidationRegexp.MatchString(key) {
 		return false
@@ -709,18 +690,93 @@ func (cs *Store) SetValue(key string, value []byte, updateInKV bool, customSetTi
 			}
 		}
 	} else {
-		if v, ok := cs.transactions.Load(transactionID); ok {
-			transaction := v.(*Transaction)
-			transaction.mutex.Lock()
-			transaction.operators = append(transaction.operators, &TransactionOperator{operatorType: 0, key: key, value: value, updateInKV: updateInKV, customTime: customSetTime})
-			transaction.mutex.Unlock()
-		} else {
-			lg.Logf(lg.ErrorLevel, "SetValue: transaction with id=%s doesn't exist", transactionID)
+		if err := cs.addTransactionOperator(transactionID, &TransactionOperator{operatorType: 0, key: key, value: value, updateInKV: updateInKV, customTime: customSetTime}); err != nil {
+			lg.Logf(lg.ErrorLevel, "SetValue: %s", err)
 		}
 	}
 	return true
 }
 
+// syncStoreValueWithKV puts the value into the KV store if it was not synced yet
+func (cs *Store) syncStoreValueWithKV(key string, csv *StoreValue) error {
+	csv.Lock("syncStoreValueWithKV")
+	if !csv.syncNeeded {
+		csv.Unlock("syncStoreValueWithKV")
+		return nil
+	}
+	valueUpdateTime := csv.valueUpdateTime
+	timeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(timeBytes, uint64(csv.valueUpdateTime))
+	var finalBytes []byte
+	if csv.valueExists {
+		header := append(timeBytes, 1) // Add append flag "1"
+		finalBytes = append(header, csv.value.([]byte)...)
+	} else {
+		finalBytes = append(timeBytes, 0) // Add delete flag "0"
+	}
+	csv.Unlock("syncStoreValueWithKV")
+
+	if _, err := cs.backend.Put(cs.toStoreKey(key), finalBytes); err != nil {
+		return err
+	}
+
+	csv.Lock("syncStoreValueWithKV")
+	if valueUpdateTime == csv.valueUpdateTime {
+		csv.syncNeeded = false
+	}
+	csv.Unlock("syncStoreValueWithKV")
+	return nil
+}
+
+// Flush synchronously puts all values which were not synced yet into the KV store
+func (cs *Store) Flush() error {
+	var flushErr error
+	cacheStoreValueStack := []*StoreValue{cs.rootValue}
+	suffixPathsStack := []string{""}
+	for len(cacheStoreValueStack) > 0 {
+		lastID := len(cacheStoreValueStack) - 1
+		currentStoreValue := cacheStoreValueStack[lastID]
+		currentSuffix := suffixPathsStack[lastID]
+		cacheStoreValueStack = cacheStoreValueStack[:lastID]
+		suffixPathsStack = suffixPathsStack[:lastID]
+
+		currentStoreValue.Range(func(key, value interface{}) bool {
+			newSuffix := key.(string)
+			if len(currentSuffix) > 0 {
+				newSuffix = currentSuffix + "." + newSuffix
+			}
+			csvChild := value.(*StoreValue)
+			if err := cs.syncStoreValueWithKV(newSuffix, csvChild); err != nil && flushErr == nil {
+				flushErr = err
+			}
+			cacheStoreValueStack = append(cacheStoreValueStack, csvChild)
+			suffixPathsStack = append(suffixPathsStack, newSuffix)
+			return true
+		})
+	}
+	return flushErr
+}
+
+// Stats describes the current cache state
+type Stats struct {
+	ValuesInCache   int
+	LRUSize         int
+	LRUTresholdTime int64
+}
+
+func (cs *Store) GetStats() Stats {
+	return Stats{
+		ValuesInCache:   int(atomic.LoadInt64(&cs.valuesInCache)),
+		LRUSize:         cs.cacheConfig.lruSize,
+		LRUTresholdTime: atomic.LoadInt64(&cs.lruTresholdTime),
+	}
+}
+
+// Backend returns the backend the store keeps its values in
+func (cs *Store) Backend() Backend {
+	return cs.backend
+}
+
 func (cs *Store) Destroy() {
 	cs.cancel()
 }
@@ -737,18 +793,17 @@ func (cs *Store) DeleteValue(key string, updateInKV bool, customDeleteTime int64
 				}
 			}
 		}
+		if updateInKV {
+			system.MsgOnErrorReturn(cs.RemoveValueExpiration(key))
+		}
 	} else {
-		if v, ok := cs.transactions.Load(transactionID); ok {
-			transaction := v.(*Transaction)
-			transaction.mutex.Lock()
-			transaction.operators = append(transaction.operators, &TransactionOperator{operatorType: 1, key: key, value: nil, updateInKV: updateInKV, customTime: customDeleteTime})
-			transaction.mutex.Unlock()
-		} else {
-			lg.Logf(lg.ErrorLevel, "DeleteValue: transaction with id=%s doesn't exist", transactionID)
+		if err := cs.addTransactionOperator(transactionID, &TransactionOperator{operatorType: 1, key: key, value: nil, updateInKV: updateInKV, customTime: customDeleteTime}); err != nil {
+			lg.Logf(lg.ErrorLevel, "DeleteValue: %s", err)
 		}
 	}
 }
 
+// GetKeysByPattern returns all keys matching the pattern unordered, use ScanKeys to list big levels page by page
 func (cs *Store) GetKeysByPattern(pattern string) []string {
 	start := time.Now()
 
@@ -757,16 +812,14 @@ func (cs *Store) GetKeysByPattern(pattern string) []string {
 	appendKeysFromKV := func() {
 		cs.getKeysByPatternFromKVMutex.Lock()
 		//lg.Logln("!!! GetKeysByPattern started appendKeysFromKV")
-		if w, err := cs.kv.Watch(cs.toStoreKey(pattern), nats.IgnoreDeletes()); err == nil {
-			for entry := range w.Updates() {
-				if entry != nil && len(entry.Value()) >= 9 {
-					keys[cs.fromStoreKey(entry.Key())] = true
-				} else {
-					break
+		if entries, err := cs.backend.List(cs.toStoreKey(pattern)); err == nil {
+			for _, entry := range entries {
+				if len(entry.Value) >= 9 {
+					keys[cs.fromStoreKey(entry.Key)] = true
 				}
 			}
 		} else {
-			lg.Logf(lg.ErrorLevel, "GetKeysByPattern kv.Watch error %s", err)
+			lg.Logf(lg.ErrorLevel, "GetKeysByPattern backend.List error %s", err)
 		}
 		//lg.Logln("!!! GetKeysByPattern ended appendKeysFromKV")
 		cs.getKeysByPatternFromKVMutex.Unlock()
@@ -2,8 +2,12 @@
 
 package cache
 
+import "time"
+
 const (
 	KVStorePrefix                               = "store"
+	KVExpirationsPrefix                         = "expirations"
+	KVTransactionsPrefix                        = "transactions"
 	LRUSize                                     = 1000000
 	LevelSubscriptionNotificationsBufferMaxSize = 30000 // ~16Mb: elemenets := 16 * 1024 * 1024 / (64 + 512), where 512 - avg value size, 64 - avg key size
 )
@@ -11,15 +15,26 @@ const (
 type Config struct {
 	id                                          string
 	kvStorePrefix                               string
+	kvExpirationsPrefix                         string
+	kvTransactionsPrefix                        string
+	changeFeed                                  bool
+	changeFeedHistory                           int64
+	changeFeedRetention                         time.Duration
+	changeOrigin                                string
 	lruSize                                     int
 	levelSubscriptionNotificationsBufferMaxSize int
+	backend                                     Backend
 }
 
 func NewCacheConfig(id string) *Config {
 	return &Config{
-		id:            id,
-		kvStorePrefix: KVStorePrefix,
-		lruSize:       LRUSize,
+		id:                   id,
+		kvStorePrefix:        KVStorePrefix,
+		kvExpirationsPrefix:  KVExpirationsPrefix,
+		kvTransactionsPrefix: KVTransactionsPrefix,
+		changeFeedHistory:    ChangeFeedHistory,
+		changeFeedRetention:  ChangeFeedRetention,
+		lruSize:              LRUSize,
 		levelSubscriptionNotificationsBufferMaxSize: LevelSubscriptionNotificationsBufferMaxSize,
 	}
 }
@@ -33,6 +48,55 @@ func (cc *Config) SetKVStorePrefix(kvStorePrefix string) *Config {
 	return cc
 }
 
+func (cc *Config) SetKVExpirationsPrefix(kvExpirationsPrefix string) *Config {
+	cc.kvExpirationsPrefix = kvExpirationsPrefix
+	return cc
+}
+
+func (cc *Config) SetKVTransactionsPrefix(kvTransactionsPrefix string) *Config {
+	cc.kvTransactionsPrefix = kvTransactionsPrefix
+	return cc
+}
+
+// SetBackend makes the store keep its values in the backend instead of the domain's NATS key/value bucket, the bucket is
+// not created then and the runtime keeps its timers, schedules and mutexes in the backend as well
+func (cc *Config) SetBackend(backend Backend) *Config {
+	cc.backend = backend
+	return cc
+}
+
+func (cc *Config) GetBackend() Backend {
+	return cc.backend
+}
+
+// SetChangeFeed lets the store's values be read as a feed of changes, see Store.SubscribeChanges
+func (cc *Config) SetChangeFeed(enabled bool) *Config {
+	cc.changeFeed = enabled
+	return cc
+}
+
+// SetChangeFeedHistory sets how many last writes of every key the NATS bucket keeps for the change feed, at most
+// nats.KeyValueMaxHistory; the bucket's history is raised to it when the store starts, so the bucket takes up to that
+// many times more space
+func (cc *Config) SetChangeFeedHistory(history int64) *Config {
+	cc.changeFeedHistory = history
+	return cc
+}
+
+// SetChangeFeedRetention sets how long deleted values are kept for the change feed before they are removed, 0 - they
+// are removed at once
+func (cc *Config) SetChangeFeedRetention(retention time.Duration) *Config {
+	cc.changeFeedRetention = retention
+	return cc
+}
+
+// SetChangeOrigin sets the origin the store stamps its writes with, unique id of the store by default. Only the default
+// NATS backend keeps origins, a custom one must be created by NewNatsBackendWithOrigin to keep them
+func (cc *Config) SetChangeOrigin(origin string) *Config {
+	cc.changeOrigin = origin
+	return cc
+}
+
 func (cc *Config) SetLRUSize(lruSize int) *Config {
 	cc.lruSize = lruSize
 	return cc
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Conditional writes are checked against the backend, not only against the local tree: the value's pending local update
+is synced first, the current backend revision is read, the new value is written only if the revision did not change meanwhile
+and is put into the local tree as already synced. So conditional writes of concurrent runtimes sharing the bucket cannot
+overwrite each other. Plain SetValue/DeleteValue writes are not checked and may still overwrite conditional ones.
+
+Revision of a value is the backend revision of its last write, 0 - the value was never written or was completely deleted.
+*/
+
+const (
+	UpdateValueMaxAttempts = 100
+)
+
+var (
+	ErrValueRevisionMismatch = errors.New("value revision mismatch")
+)
+
+type casState struct {
+	value    []byte
+	exists   bool
+	time     int64
+	revision uint64
+}
+
+// GetValueRevision returns the value and its revision from the backend
+func (cs *Store) GetValueRevision(key string) ([]byte, uint64, error) {
+	state, err := cs.casLoad(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !state.exists {
+		return nil, state.revision, fmt.Errorf("Value for key=%s does not exist", key)
+	}
+	return state.value, state.revision, nil
+}
+
+// SetValueIfEquals sets the value only if it exists and equals compareValue
+func (cs *Store) SetValueIfEquals(key string, newValue []byte, compareValue []byte) (bool, error) {
+	cs.casMutex.Lock(key)
+	defer cs.casMutex.Unlock(key)
+
+	state, err := cs.casLoad(key)
+	if err != nil {
+		return false, err
+	}
+	if !state.exists || !bytes.Equal(state.value, compareValue) {
+		return false, nil
+	}
+	if _, err := cs.casWrite(key, newValue, false, state); err != nil {
+		if errors.Is(err, ErrValueRevisionMismatch) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// SetValueIfRevision sets the value only if its revision is still the given one, returns the new revision
+func (cs *Store) SetValueIfRevision(key string, newValue []byte, revision uint64) (uint64, error) {
+	return cs.writeIfRevision(key, newValue, false, revision)
+}
+
+// DeleteValueIfRevision deletes the value only if its revision is still the given one
+func (cs *Store) DeleteValueIfRevision(key string, revision uint64) error {
+	_, err := cs.writeIfRevision(key, nil, true, revision)
+	return err
+}
+
+func (cs *Store) writeIfRevision(key string, newValue []byte, deleteValue bool, revision uint64) (uint64, error) {
+	cs.casMutex.Lock(key)
+	defer cs.casMutex.Unlock(key)
+
+	state, err := cs.casLoad(key)
+	if err != nil {
+		return 0, err
+	}
+	if state.revision != revision {
+		return state.revision, ErrValueRevisionMismatch
+	}
+	return cs.casWrite(key, newValue, deleteValue, state)
+}
+
+// UpdateValue atomically replaces the value with the one returned by update, which gets the current value and whether
+// it exists. update may be called several times if the value is being changed concurrently, so it must not have side
+// effects. Returning an error aborts the update.
+func (cs *Store) UpdateValue(key string, update func(current []byte, exists bool) ([]byte, error)) ([]byte, error) {
+	cs.casMutex.Lock(key)
+	defer cs.casMutex.Unlock(key)
+
+	for attempt := 0; attempt < UpdateValueMaxAttempts; attempt++ {
+		state, err := cs.casLoad(key)
+		if err != nil {
+			return nil, err
+		}
+		newValue, err := update(state.value, state.exists)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := cs.casWrite(key, newValue, false, state); err == nil {
+			return newValue, nil
+		} else if !errors.Is(err, ErrValueRevisionMismatch) {
+			return nil, err
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Millisecond)
+	}
+	return nil, fmt.Errorf("UpdateValue for key=%s: %w after %d attempts", key, ErrValueRevisionMismatch, UpdateValueMaxAttempts)
+}
+
+// casLoad syncs the pending local update of the value and reads the value from the backend
+func (cs *Store) casLoad(key string) (casState, error) {
+	if !keyValidationRegexp.MatchString(key) {
+		return casState{}, fmt.Errorf("invalid key %s", key)
+	}
+	if csv := cs.getLastKeyCacheStoreValue(key); csv != nil {
+		if err := cs.syncStoreValueWithKV(key, csv); err != nil {
+			return casState{}, err
+		}
+	}
+
+	entry, err := cs.backend.Get(cs.toStoreKey(key))
+	if err != nil {
+		if errors.Is(err, ErrBackendKeyNotFound) {
+			return casState{}, nil
+		}
+		return casState{}, err
+	}
+	valueBytes := entry.Value
+	state := casState{revision: entry.Revision}
+	if len(valueBytes) >= 9 {
+		state.time = int64(binary.BigEndian.Uint64(valueBytes[:8]))
+		if valueBytes[8] == 1 {
+			state.exists = true
+			state.value = valueBytes[9:]
+		}
+	}
+	return state, nil
+}
+
+// casWrite writes the value into the backend if its revision is still the loaded one, then into the local tree
+func (cs *Store) casWrite(key string, newValue []byte, deleteValue bool, state casState) (uint64, error) {
+	// Write time must be newer than any time of the value seen so far, otherwise updates are ignored by the watchers
+	writeTime := system.GetCurrentTimeNs()
+	if localTime := cs.GetValueUpdateTime(key); writeTime <= localTime {
+		writeTime = localTime + 1
+	}
+	if writeTime <= state.time {
+		writeTime = state.time + 1
+	}
+
+	if cs.transactionKeysLocked([]string{key}, 0) { // Key is being written by a transaction
+		return 0, ErrValueRevisionMismatch
+	}
+
+	header := make([]byte, 9)
+	binary.BigEndian.PutUint64(header, uint64(writeTime))
+	if !deleteValue {
+		header[8] = 1 // Append flag
+		header = append(header, newValue...)
+	}
+	revision, err := cs.backend.Update(cs.toStoreKey(key), header, state.revision)
+	if err != nil {
+		if errors.Is(err, ErrBackendRevisionMismatch) {
+			return 0, ErrValueRevisionMismatch
+		}
+		return 0, err
+	}
+
+	if deleteValue {
+		cs.DeleteValue(key, false, writeTime, "")
+	} else {
+		cs.SetValue(key, newValue, false, writeTime, "")
+	}
+	return revision, nil
+}
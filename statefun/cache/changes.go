@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Change feed is enabled by Config.SetChangeFeed. It is read from the backend itself: with the NATS backend it is the key/value
+bucket's stream, so every write of a value comes into the feed - of any runtime, of a transaction finished on recovery, of
+a restored snapshot - with nothing recorded next to it. Writers stamp the origin in the message header (see
+NatsChangeOriginHeader), writes of ones not stamping it come with an empty origin.
+
+Position of a change is the backend revision of the write. A consumer stores the position of the last change it handled
+and passes it to SubscribeChanges after restart, the backend starts reading right after it. What a resumed consumer gets
+is bounded by the retention:
+  - the bucket keeps the last Config.SetChangeFeedHistory writes of every key (ChangeFeedHistory by default, the store
+    raises the bucket's history to it), older writes of a key are dropped by the stream and are not delivered; local
+    backends keep only the last write of a key;
+  - deleted values are kept as delete records for Config.SetChangeFeedRetention (ChangeFeedRetention by default), then
+    every store watching the bucket removes the key's writes up to the delete record from the backend; every runtime
+    writing the keys must have the feed enabled, otherwise it removes its deleted values at once and deletes may be missed.
+So a consumer stopped for longer than the retention, or behind by more writes of a key than the history, misses some of
+them. Old value of a change is the previous write of the key the subscription delivered or, for the first change of a key,
+the previous one the backend still keeps; nil if there is none. The subscription keeps the last value of every delivered
+key which is not deleted.
+*/
+
+const (
+	ChangeFeedHistory      = 64 // nats.KeyValueMaxHistory
+	ChangeFeedRetention    = time.Hour
+	ChangeFeedTrimInterval = time.Minute
+
+	ChangeSet    = "set"
+	ChangeDelete = "delete"
+)
+
+type Change struct {
+	Position  uint64
+	Operation string
+	Key       string
+	OldValue  []byte // Nil if the key did not exist or its previous write is not kept
+	NewValue  []byte // Nil for a delete
+	Time      int64  // Write time of the value in ns, 0 for a delete made by the backend directly
+	Origin    string
+}
+
+type changeTombstone struct {
+	revision uint64
+	time     int64
+}
+
+type changeSubscription struct {
+	watcher BackendWatcher
+	in      chan Change
+}
+
+// SubscribeChanges returns a channel getting changes of keys matching any of the patterns (NATS-like: "*" matches a
+// token, ">" matches all the rest ones) written after the position, 0 - from the oldest kept value
+func (cs *Store) SubscribeChanges(callbackID string, patterns []string, afterPosition uint64) (chan Change, error) {
+	if !cs.cacheConfig.changeFeed {
+		return nil, fmt.Errorf("change feed is disabled for cache %s", cs.cacheConfig.id)
+	}
+	watchPattern := cs.toStoreKey(">")
+	if len(patterns) == 1 { // Filtered by the backend
+		watchPattern = cs.toStoreKey(patterns[0])
+	}
+	w, err := cs.backend.WatchAfter(watchPattern, afterPosition)
+	if err != nil {
+		return nil, err
+	}
+
+	onBufferOverflow := func() {
+		lg.Logf(lg.WarnLevel, "SubscribeChanges SubscriptionNotificationsBuffer overflow for callbackID=%s!", callbackID)
+	}
+	callbackChannelIn, callbackChannelOut := system.CreateDimSizeChannel[Change](cs.cacheConfig.levelSubscriptionNotificationsBufferMaxSize, onBufferOverflow)
+	subscription := &changeSubscription{watcher: w, in: callbackChannelIn}
+	if previous, loaded := cs.changeSubscribers.Swap(callbackID, subscription); loaded {
+		system.MsgOnErrorReturn(previous.(*changeSubscription).watcher.Stop())
+	}
+
+	go func() {
+		system.GlobalPrometrics.GetRoutinesCounter().Started("cache.changesSubscription")
+		defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("cache.changesSubscription")
+		defer close(callbackChannelIn)
+		lastValues := map[string][]byte{}
+		for {
+			select {
+			case <-cs.ctx.Done():
+				system.MsgOnErrorReturn(w.Stop())
+				return
+			case entry, ok := <-w.Updates():
+				if !ok {
+					return
+				}
+				if entry == nil {
+					continue
+				}
+				change, ok := cs.changeFromEntry(entry)
+				if !ok {
+					continue
+				}
+				for _, pattern := range patterns {
+					if MatchKeyPattern(pattern, change.Key) {
+						if lastValue, ok := lastValues[change.Key]; ok {
+							change.OldValue = lastValue
+						} else {
+							change.OldValue = cs.previousChangeValue(entry)
+						}
+						if change.Operation == ChangeSet {
+							lastValues[change.Key] = change.NewValue
+						} else {
+							delete(lastValues, change.Key)
+						}
+						callbackChannelIn <- change
+						break
+					}
+				}
+			}
+		}
+	}()
+	return callbackChannelOut, nil
+}
+
+func (cs *Store) UnsubscribeChanges(callbackID string) {
+	if v, ok := cs.changeSubscribers.LoadAndDelete(callbackID); ok {
+		system.MsgOnErrorReturn(v.(*changeSubscription).watcher.Stop())
+	}
+}
+
+// changeFromEntry makes the change of the backend's entry of the value, false if the entry is not a value
+func (cs *Store) changeFromEntry(entry *BackendEntry) (Change, bool) {
+	change := Change{
+		Position:  entry.Revision,
+		Operation: ChangeDelete,
+		Key:       cs.fromStoreKey(entry.Key),
+		Origin:    entry.Origin,
+	}
+	if entry.Deleted {
+		return change, true
+	}
+	if len(entry.Value) < 9 {
+		lg.Logf(lg.ErrorLevel, "SubscribeChanges: value of key=%s has no time and append flag", entry.Key)
+		return change, false
+	}
+	change.Time = int64(binary.BigEndian.Uint64(entry.Value[:8]))
+	if entry.Value[8] == 1 {
+		change.Operation = ChangeSet
+		change.NewValue = entry.Value[9:]
+	}
+	return change, true
+}
+
+// previousChangeValue returns the value of the write preceding the entry the backend still keeps, nil if there is none
+func (cs *Store) previousChangeValue(entry *BackendEntry) []byte {
+	previous, err := cs.backend.GetBefore(entry.Key, entry.Revision)
+	if err != nil {
+		if !errors.Is(err, ErrBackendKeyNotFound) {
+			lg.Logf(lg.ErrorLevel, "SubscribeChanges cannot read the previous value of key=%s: %s", entry.Key, err)
+		}
+		return nil
+	}
+	if previous.Deleted || len(previous.Value) < 9 || previous.Value[8] != 1 {
+		return nil
+	}
+	return previous.Value[9:]
+}
+
+// removeDeletedValue erases the deleted value from the backend, with the change feed enabled - once the retention passes
+func (cs *Store) removeDeletedValue(entry *BackendEntry) {
+	if !cs.cacheConfig.changeFeed || cs.cacheConfig.changeFeedRetention <= 0 {
+		system.MsgOnErrorReturn(cs.backend.Remove(entry.Key, entry.Revision))
+		return
+	}
+	cs.changeTombstonesMutex.Lock()
+	cs.changeTombstones[entry.Key] = changeTombstone{revision: entry.Revision, time: int64(binary.BigEndian.Uint64(entry.Value[:8]))}
+	cs.changeTombstonesMutex.Unlock()
+}
+
+// changeTombstonesTrimmer removes deleted values kept for the change feed longer than the retention. Every store
+// watching the bucket removes them, removal of an already removed one does nothing
+func (cs *Store) changeTombstonesTrimmer() {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("cache.changeTombstonesTrimmer")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("cache.changeTombstonesTrimmer")
+
+	interval := ChangeFeedTrimInterval
+	if retention := cs.cacheConfig.changeFeedRetention; retention > 0 && retention < interval {
+		interval = retention
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cs.ctx.Done():
+			return
+		case <-ticker.C:
+			cs.trimChangeTombstones(system.GetCurrentTimeNs() - int64(cs.cacheConfig.changeFeedRetention))
+		}
+	}
+}
+
+// trimChangeTombstones removes deleted values deleted before the time, writes made after the delete are kept
+func (cs *Store) trimChangeTombstones(deletedBefore int64) {
+	expired := map[string]changeTombstone{}
+	cs.changeTombstonesMutex.Lock()
+	for storeKey, tombstone := range cs.changeTombstones {
+		if tombstone.time < deletedBefore {
+			expired[storeKey] = tombstone
+			delete(cs.changeTombstones, storeKey)
+		}
+	}
+	cs.changeTombstonesMutex.Unlock()
+
+	for storeKey, tombstone := range expired {
+		if err := cs.backend.Remove(storeKey, tombstone.revision); err != nil {
+			lg.Logf(lg.ErrorLevel, "Cannot remove deleted value of key=%s: %s", storeKey, err)
+			cs.changeTombstonesMutex.Lock()
+			if _, ok := cs.changeTombstones[storeKey]; !ok { // Retried on the next trim
+				cs.changeTombstones[storeKey] = tombstone
+			}
+			cs.changeTombstonesMutex.Unlock()
+		}
+	}
+}
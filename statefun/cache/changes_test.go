@@ -0,0 +1,119 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/suite"
+)
+
+type ChangesTestSuite struct {
+	suite.Suite
+}
+
+func TestChangesTestSuite(t *testing.T) {
+	suite.Run(t, new(ChangesTestSuite))
+}
+
+func (s *ChangesTestSuite) Test_ResumesFromPosition() {
+	js, kv := natsBucket(s.T(), "feed")
+	cacheConfig := cache.NewCacheConfig("feed").SetChangeFeed(true).SetChangeOrigin("test-runtime")
+	store := cache.NewCacheStore(context.Background(), cacheConfig, js, kv)
+	defer store.Destroy()
+
+	store.SetValue("feed.a", []byte("1"), true, -1, "")
+	s.NoError(store.Flush())
+	store.SetValue("feed.a", []byte("2"), true, -1, "")
+	store.SetValue("other.a", []byte("filtered out"), true, -1, "")
+	s.NoError(store.Flush())
+	store.SetValue("feed.c", []byte("3"), true, -1, "")
+	s.NoError(store.Flush())
+	store.DeleteValue("feed.c", true, -1, "")
+	s.NoError(store.Flush())
+	// Written past the store, e.g. by a runtime without the feed or by a snapshot restore
+	_, err := cache.NewNatsBackend(js, kv).Put("store.feed.d", append([]byte{0, 0, 0, 0, 0, 0, 0, 1, 1}, "raw"...))
+	s.Require().NoError(err)
+
+	receive := func(changes chan cache.Change) cache.Change {
+		select {
+		case change := <-changes:
+			return change
+		case <-time.After(5 * time.Second):
+			s.FailNow("change was not received")
+		}
+		return cache.Change{}
+	}
+
+	changes, err := store.SubscribeChanges("consumer", []string{"feed.>"}, 0)
+	s.Require().NoError(err)
+	first, second, third, fourth, fifth := receive(changes), receive(changes), receive(changes), receive(changes), receive(changes)
+	store.UnsubscribeChanges("consumer")
+
+	// Every write of a key kept by the bucket's history comes, with the previous value
+	s.Equal("feed.a", first.Key)
+	s.Equal(cache.ChangeSet, first.Operation)
+	s.Nil(first.OldValue)
+	s.Equal([]byte("1"), first.NewValue)
+	s.Equal("test-runtime", first.Origin)
+	s.Equal("feed.a", second.Key)
+	s.Equal([]byte("1"), second.OldValue)
+	s.Equal([]byte("2"), second.NewValue)
+	s.Equal("feed.c", third.Key)
+	s.Equal([]byte("3"), third.NewValue)
+	s.Equal("feed.c", fourth.Key)
+	s.Equal(cache.ChangeDelete, fourth.Operation)
+	s.Equal([]byte("3"), fourth.OldValue)
+	s.Nil(fourth.NewValue)
+	s.Equal("test-runtime", fourth.Origin)
+	s.Equal("feed.d", fifth.Key)
+	s.Equal([]byte("raw"), fifth.NewValue)
+	s.Empty(fifth.Origin)
+	s.Less(first.Position, second.Position)
+	s.Less(fourth.Position, fifth.Position)
+
+	// Consumer restarted after handling the first change gets the old value from the bucket
+	changes, err = store.SubscribeChanges("consumer", []string{"feed.*", "more.*"}, first.Position)
+	s.Require().NoError(err)
+	defer store.UnsubscribeChanges("consumer")
+	s.Equal(second, receive(changes))
+	s.Equal(third, receive(changes))
+	s.Equal(fourth, receive(changes))
+	s.Equal(fifth, receive(changes))
+	store.SetValue("feed.a", []byte("live"), true, -1, "")
+	s.NoError(store.Flush())
+	live := receive(changes)
+	s.Equal("feed.a", live.Key)
+	s.Equal([]byte("2"), live.OldValue)
+	s.Equal("test-runtime", live.Origin)
+	s.Greater(live.Position, fifth.Position)
+}
+
+func (s *ChangesTestSuite) Test_DeletedValuesRemovedAfterRetention() {
+	js, kv := natsBucket(s.T(), "retention")
+	cacheConfig := cache.NewCacheConfig("retention").SetChangeFeed(true).SetChangeFeedRetention(500 * time.Millisecond)
+	store := cache.NewCacheStore(context.Background(), cacheConfig, js, kv)
+	defer store.Destroy()
+
+	store.SetValue("feed.a", []byte("1"), true, -1, "")
+	s.NoError(store.Flush())
+	store.DeleteValue("feed.a", true, -1, "")
+	s.NoError(store.Flush())
+
+	// Delete record is kept for the feed at first, then the key's writes are removed from the bucket
+	_, err := kv.Get("store.feed.a")
+	s.NoError(err)
+	s.Eventually(func() bool {
+		_, err := kv.History("store.feed.a")
+		return errors.Is(err, nats.ErrKeyNotFound)
+	}, 10*time.Second, 100*time.Millisecond)
+
+	store.SetValue("feed.a", []byte("2"), true, -1, "")
+	s.NoError(store.Flush())
+	value, err := store.GetValue("feed.a")
+	s.NoError(err)
+	s.Equal([]byte("2"), value)
+}
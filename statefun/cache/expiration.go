@@ -0,0 +1,266 @@
+package cache
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Expiration of a value lives in the backend next to the value as "<kvExpirationsPrefix>.<key>" with unix time in ns
+(8 bytes, big endian) the value expires at. Every store keeps all expirations in a time-ordered index, so no key scans
+are needed to find expired values.
+
+The store which succeeds in deleting the expiration record by its revision deletes the value and notifies its
+expiration subscribers, so each expiration is reported once in a domain even if several runtimes share the bucket.
+An expiration stays until the value expires or is deleted, updates of the value do not touch it.
+*/
+
+const (
+	expirationRetryInterval = time.Second
+)
+
+type expirationItem struct {
+	key       string
+	expireAt  int64
+	revision  uint64
+	heapIndex int
+}
+
+type expirationHeap []*expirationItem
+
+func (eh expirationHeap) Len() int           { return len(eh) }
+func (eh expirationHeap) Less(i, j int) bool { return eh[i].expireAt < eh[j].expireAt }
+func (eh expirationHeap) Swap(i, j int) {
+	eh[i], eh[j] = eh[j], eh[i]
+	eh[i].heapIndex = i
+	eh[j].heapIndex = j
+}
+
+func (eh *expirationHeap) Push(x interface{}) {
+	item := x.(*expirationItem)
+	item.heapIndex = len(*eh)
+	*eh = append(*eh, item)
+}
+
+func (eh *expirationHeap) Pop() interface{} {
+	old := *eh
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.heapIndex = -1
+	*eh = old[:n-1]
+	return item
+}
+
+// SetValueExpiration makes the value expire at the given time, the value does not need to exist yet
+func (cs *Store) SetValueExpiration(key string, expireAt time.Time) error {
+	if !keyValidationRegexp.MatchString(key) {
+		return fmt.Errorf("invalid key %s", key)
+	}
+	revision, err := cs.backend.Put(cs.toExpirationKey(key), system.Int64ToBytes(expireAt.UnixNano()))
+	if err != nil {
+		return err
+	}
+	cs.indexExpiration(key, expireAt.UnixNano(), revision)
+	return nil
+}
+
+// SetValueExpirationAfter makes the value expire after the given duration, negative duration removes the expiration
+func (cs *Store) SetValueExpirationAfter(key string, after time.Duration) error {
+	if after < 0 {
+		return cs.RemoveValueExpiration(key)
+	}
+	return cs.SetValueExpiration(key, time.Now().Add(after))
+}
+
+func (cs *Store) RemoveValueExpiration(key string) error {
+	cs.expirationsMutex.Lock()
+	_, ok := cs.expirations[key]
+	cs.expirationsMutex.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := cs.backend.Delete(cs.toExpirationKey(key), 0); err != nil && !errors.Is(err, ErrBackendKeyNotFound) {
+		return err
+	}
+	cs.unindexExpiration(key, 0)
+	return nil
+}
+
+// GetValueExpiration returns the time the value expires at, false if the value does not expire
+func (cs *Store) GetValueExpiration(key string) (time.Time, bool) {
+	cs.expirationsMutex.Lock()
+	defer cs.expirationsMutex.Unlock()
+	if item, ok := cs.expirations[key]; ok {
+		return time.Unix(0, item.expireAt), true
+	}
+	return time.Time{}, false
+}
+
+// SubscribeExpirations returns a channel getting keys and last values of values which expired
+func (cs *Store) SubscribeExpirations(callbackID string) chan KeyValue {
+	onBufferOverflow := func() {
+		lg.Logf(lg.WarnLevel, "SubscribeExpirations SubscriptionNotificationsBuffer overflow for callbackID=%s!", callbackID)
+	}
+	callbackChannelIn, callbackChannelOut := system.CreateDimSizeChannel[KeyValue](cs.cacheConfig.levelSubscriptionNotificationsBufferMaxSize, onBufferOverflow)
+	cs.expirationSubscribers.Store(callbackID, &subscriberChannel{in: callbackChannelIn})
+	return callbackChannelOut
+}
+
+func (cs *Store) UnsubscribeExpirations(callbackID string) {
+	if v, ok := cs.expirationSubscribers.LoadAndDelete(callbackID); ok {
+		v.(*subscriberChannel).close()
+	}
+}
+
+// indexExpiration adds or updates the expiration in the index unless it already has a newer revision
+func (cs *Store) indexExpiration(key string, expireAt int64, revision uint64) {
+	cs.expirationsMutex.Lock()
+	defer cs.expirationsMutex.Unlock()
+	if item, ok := cs.expirations[key]; ok {
+		if item.revision > revision {
+			return
+		}
+		item.expireAt = expireAt
+		item.revision = revision
+		heap.Fix(&cs.expirationsIndex, item.heapIndex)
+	} else {
+		item := &expirationItem{key: key, expireAt: expireAt, revision: revision}
+		cs.expirations[key] = item
+		heap.Push(&cs.expirationsIndex, item)
+	}
+	select {
+	case cs.expirationsWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// unindexExpiration removes the expiration from the index, 0 revision removes any
+func (cs *Store) unindexExpiration(key string, revision uint64) {
+	cs.expirationsMutex.Lock()
+	defer cs.expirationsMutex.Unlock()
+	if item, ok := cs.expirations[key]; ok && (revision == 0 || item.revision <= revision) {
+		heap.Remove(&cs.expirationsIndex, item.heapIndex)
+		delete(cs.expirations, key)
+	}
+}
+
+func (cs *Store) expirationsUpdatesHandler(w BackendWatcher, initChan chan bool) {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("cache.expirationsUpdatesHandler")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("cache.expirationsUpdatesHandler")
+	defer func() { system.MsgOnErrorReturn(w.Stop()) }()
+
+	inited := false
+	for {
+		select {
+		case <-cs.ctx.Done():
+			return
+		case entry, ok := <-w.Updates():
+			if !ok { // Backend was closed
+				return
+			}
+			if entry == nil {
+				if !inited {
+					inited = true
+					close(initChan)
+				}
+				continue
+			}
+			key := cs.fromExpirationKey(entry.Key)
+			if !entry.Deleted && len(entry.Value) == 8 {
+				cs.indexExpiration(key, system.BytesToInt64(entry.Value), entry.Revision)
+			} else {
+				cs.unindexExpiration(key, entry.Revision)
+			}
+		}
+	}
+}
+
+func (cs *Store) expirationsHandler() {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("cache.expirationsHandler")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("cache.expirationsHandler")
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		now := system.GetCurrentTimeNs()
+		var due []expirationItem
+		wait := time.Hour
+
+		cs.expirationsMutex.Lock()
+		for cs.expirationsIndex.Len() > 0 {
+			item := cs.expirationsIndex[0]
+			if item.expireAt > now {
+				wait = time.Duration(item.expireAt - now)
+				break
+			}
+			heap.Pop(&cs.expirationsIndex)
+			delete(cs.expirations, item.key)
+			due = append(due, *item)
+		}
+		cs.expirationsMutex.Unlock()
+
+		for _, item := range due {
+			cs.expireValue(item)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-cs.ctx.Done():
+			return
+		case <-cs.expirationsWakeup:
+		case <-timer.C:
+		}
+	}
+}
+
+// expireValue deletes the expired value if the expiration record is still the same one
+func (cs *Store) expireValue(item expirationItem) {
+	if err := cs.backend.Delete(cs.toExpirationKey(item.key), item.revision); err != nil {
+		if entry, getErr := cs.backend.Get(cs.toExpirationKey(item.key)); getErr == nil && entry.Revision == item.revision {
+			// Record was not changed by anyone, retrying later
+			lg.Logf(lg.WarnLevel, "Cannot delete expiration record of key=%s: %s", item.key, err)
+			cs.indexExpiration(item.key, system.GetCurrentTimeNs()+int64(expirationRetryInterval), item.revision)
+		}
+		// Otherwise the expiration was changed or handled by other store, watcher updates the index
+		return
+	}
+
+	value, err := cs.GetValue(item.key)
+	if err != nil {
+		return
+	}
+	cs.DeleteValue(item.key, true, -1, "")
+
+	if counterVec, err := system.GlobalPrometrics.EnsureCounterVecSimple("cache_values_expired", "", []string{"id"}); err == nil {
+		counterVec.With(prometheus.Labels{"id": cs.cacheConfig.id}).Inc()
+	}
+	cs.expirationSubscribers.Range(func(_, v interface{}) bool {
+		notifySubscriber(v.(*subscriberChannel), item.key, value)
+		return true
+	})
+}
+
+func (cs *Store) toExpirationKey(key string) string {
+	return cs.cacheConfig.kvExpirationsPrefix + "." + key
+}
+
+func (cs *Store) fromExpirationKey(key string) string {
+	return key[len(cs.cacheConfig.kvExpirationsPrefix)+1:]
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+Scans list keys starting with a prefix in lexical order page by page. The prefix is a plain string prefix: "a.b." lists
+all keys under "a.b", "a.b" also lists "a.b" itself and "a.bc...". The returned continuation token is passed as startAfter
+to get the next page, empty token means there are no more keys.
+
+A page is served from the local tree if the deepest tree level the prefix lies on holds all its subkeys (nothing was
+purged by LRU under it), otherwise keys are listed by the backend and merged with the local tree, so values not synced
+yet are listed and values deleted locally are not.
+*/
+
+// ScanKeys returns up to limit keys starting with the prefix and greater than startAfter in lexical order
+func (cs *Store) ScanKeys(prefix string, startAfter string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid scan limit %d", limit)
+	}
+
+	level := cs.getLastExistingCacheStoreValueByKey(prefix)
+	existingKeys, deletedKeys := cs.scanLocalKeys(level, level.GetFullKeyString(), prefix, startAfter)
+
+	if atomic.LoadInt64(&level.storeConsistencyWithKVLossTime) == 0 { // Level holds all its subkeys
+		return scanPage(existingKeys, limit, "")
+	}
+
+	storeKeys, err := cs.backend.ListKeys(cs.toStoreKey(prefix), cs.toStoreKey(startAfter), limit)
+	if err != nil {
+		return nil, "", err
+	}
+	var lastBackendKey string
+	if len(storeKeys) == limit { // Backend may have more keys after the last one, merged page must not go beyond it
+		lastBackendKey = cs.fromStoreKey(storeKeys[len(storeKeys)-1])
+	}
+
+	keys := map[string]struct{}{}
+	for _, storeKey := range storeKeys {
+		if key := cs.fromStoreKey(storeKey); !deletedKeys[key] {
+			keys[key] = struct{}{}
+		}
+	}
+	for _, key := range existingKeys {
+		if len(lastBackendKey) == 0 || key <= lastBackendKey {
+			keys[key] = struct{}{}
+		}
+	}
+	mergedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		mergedKeys = append(mergedKeys, key)
+	}
+	sort.Strings(mergedKeys)
+	return scanPage(mergedKeys, limit, lastBackendKey)
+}
+
+// ScanValues returns up to limit keys with their values the same way ScanKeys does
+func (cs *Store) ScanValues(prefix string, startAfter string, limit int) ([]KeyValue, string, error) {
+	keys, next, err := cs.ScanKeys(prefix, startAfter, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	values := make([]KeyValue, 0, len(keys))
+	for _, key := range keys {
+		if value, err := cs.GetValue(key); err == nil { // Skipping values deleted meanwhile
+			values = append(values, KeyValue{Key: key, Value: value})
+		}
+	}
+	return values, next, nil
+}
+
+// scanLocalKeys returns sorted keys of existing values and the set of keys of deleted ones under the level
+func (cs *Store) scanLocalKeys(level *StoreValue, levelKey string, prefix string, startAfter string) ([]string, map[string]bool) {
+	existingKeys := []string{}
+	deletedKeys := map[string]bool{}
+
+	cacheStoreValueStack := []*StoreValue{level}
+	keysStack := []string{levelKey}
+	for len(cacheStoreValueStack) > 0 {
+		lastID := len(cacheStoreValueStack) - 1
+		currentStoreValue := cacheStoreValueStack[lastID]
+		currentKey := keysStack[lastID]
+		cacheStoreValueStack = cacheStoreValueStack[:lastID]
+		keysStack = keysStack[:lastID]
+
+		currentStoreValue.Range(func(key, value interface{}) bool {
+			childKey := key.(string)
+			if len(currentKey) > 0 {
+				childKey = currentKey + "." + childKey
+			}
+			if !strings.HasPrefix(childKey, prefix) && !strings.HasPrefix(prefix, childKey+".") {
+				return true // Neither the child nor its subkeys start with the prefix
+			}
+
+			csvChild := value.(*StoreValue)
+			if strings.HasPrefix(childKey, prefix) && childKey > startAfter {
+				csvChild.Lock("scanLocalKeys")
+				if csvChild.valueExists {
+					existingKeys = append(existingKeys, childKey)
+				} else {
+					deletedKeys[childKey] = true
+				}
+				csvChild.Unlock("scanLocalKeys")
+			}
+			cacheStoreValueStack = append(cacheStoreValueStack, csvChild)
+			keysStack = append(keysStack, childKey)
+			return true
+		})
+	}
+	sort.Strings(existingKeys)
+	return existingKeys, deletedKeys
+}
+
+// scanPage cuts sorted keys to the page, lastKey - the last key the page may end with if keys are not all ones
+func scanPage(keys []string, limit int, lastKey string) ([]string, string, error) {
+	if len(keys) > limit {
+		keys = keys[:limit]
+		return keys, keys[len(keys)-1], nil
+	}
+	return keys, lastKey, nil
+}
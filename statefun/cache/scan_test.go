@@ -0,0 +1,64 @@
+package cache_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	"github.com/stretchr/testify/suite"
+)
+
+type ScanTestSuite struct {
+	suite.Suite
+}
+
+func TestScanTestSuite(t *testing.T) {
+	suite.Run(t, new(ScanTestSuite))
+}
+
+func (s *ScanTestSuite) Test_PaginatesInOrder() {
+	store := cache.NewCacheStore(context.Background(), cache.NewCacheConfig("scan").SetBackend(cache.NewMemoryBackend()).SetLRUSize(5), nil, nil)
+	defer store.Destroy()
+
+	expected := []string{}
+	for i := 24; i >= 0; i-- {
+		key := fmt.Sprintf("scan.k%02d", i)
+		store.SetValue(key, []byte(key), true, -1, "")
+		if i != 7 {
+			expected = append([]string{key}, expected...)
+		}
+	}
+	store.SetValue("scanx.k", []byte("other prefix"), true, -1, "")
+	store.DeleteValue("scan.k07", true, -1, "")
+	s.NoError(store.Flush())
+
+	scanAll := func() []string {
+		keys, next := []string{}, ""
+		for {
+			page, token, err := store.ScanKeys("scan.", next, 7)
+			s.Require().NoError(err)
+			s.LessOrEqual(len(page), 7)
+			keys = append(keys, page...)
+			if token == "" {
+				return keys
+			}
+			next = token
+		}
+	}
+	s.Equal(expected, scanAll())
+
+	// Values purged by LRU are listed by the backend
+	s.Eventually(func() bool { return store.GetValueUpdateTime("scan.k24") < 0 }, 5*time.Second, 50*time.Millisecond)
+	s.Equal(expected, scanAll())
+
+	values, next, err := store.ScanValues("scan.k1", "scan.k12", 3)
+	s.NoError(err)
+	s.Equal("scan.k15", next)
+	s.Equal([]cache.KeyValue{
+		{Key: "scan.k13", Value: []byte("scan.k13")},
+		{Key: "scan.k14", Value: []byte("scan.k14")},
+		{Key: "scan.k15", Value: []byte("scan.k15")},
+	}, values)
+}
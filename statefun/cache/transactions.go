@@ -0,0 +1,460 @@
+package cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Transaction buffers its writes until TransactionEnd, TransactionGetValue sees them. Keys are read from the local tree
+(loaded from the backend on a cache miss only), write times of all keys the transaction reads or writes are remembered
+when they are touched first. Commit of a transaction writing into the backend checks them against the backend and fails
+with ErrTransactionConflict if any key was written meanwhile (including a write not yet received by the local tree);
+a transaction with no backend writes commits without touching the backend. TransactionAbort discards the transaction.
+
+Commit goes through a journal record "<kvTransactionsPrefix>.<id>" holding all writes of the transaction:
+ 1. the record is created as "pending", keys of pending records are locked for other transactions and conditional writes
+    of all runtimes sharing the bucket (the earlier record wins);
+ 2. write times are checked, on conflict the record is deleted;
+ 3. the record is updated to "committed" - the commit point; every store watching the bucket applies all writes of the
+    record to its local tree at once;
+ 4. the writes are put into the keys (write time of the transaction is kept unless a key already has a newer one), then
+    the record is deleted.
+
+Committed records left by a crashed runtime are finished by the store which starts next or, if they are older than
+TransactionCommittedTimeout, by any store watching the bucket; pending ones expire after TransactionPendingTimeout.
+*/
+
+const (
+	TransactionPendingTimeout   = 30 * time.Second
+	TransactionCommittedTimeout = 30 * time.Second
+	transactionRecoveryInterval = 5 * time.Second
+
+	transactionStatePending   = "pending"
+	transactionStateCommitted = "committed"
+)
+
+var (
+	ErrTransactionConflict = errors.New("transaction conflict")
+	ErrTransactionNotFound = errors.New("transaction not found")
+)
+
+type TransactionOperator struct {
+	operatorType int // 0 - set, 1 - delete
+	key          string
+	value        []byte
+	updateInKV   bool
+	customTime   int64
+}
+
+type Transaction struct {
+	operators    []*TransactionOperator
+	writes       map[string]*TransactionOperator // Last KV operator of every key
+	times        map[string]int64                // Write time of every key touched when seen first, -1 - did not exist
+	beginCounter int
+	mutex        *sync.Mutex
+}
+
+type transactionJournalOperator struct {
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+type transactionJournal struct {
+	State     string                       `json:"state"`
+	Time      int64                        `json:"time"`
+	Operators []transactionJournalOperator `json:"operators"`
+}
+
+type transactionJournalEntry struct {
+	journal  transactionJournal
+	revision uint64
+	keys     map[string]struct{}
+}
+
+func (cs *Store) TransactionBegin(transactionID string) {
+	if v, ok := cs.transactions.Load(transactionID); ok {
+		transaction := v.(*Transaction)
+		transaction.mutex.Lock()
+		transaction.beginCounter++
+		transaction.mutex.Unlock()
+	} else {
+		cs.transactions.Store(transactionID, &Transaction{
+			operators:    []*TransactionOperator{},
+			writes:       map[string]*TransactionOperator{},
+			times:        map[string]int64{},
+			beginCounter: 1,
+			mutex:        &sync.Mutex{},
+		})
+	}
+}
+
+// TransactionEnd commits the transaction when it is ended as many times as it was begun
+func (cs *Store) TransactionEnd(transactionID string) error {
+	v, ok := cs.transactions.Load(transactionID)
+	if !ok {
+		return ErrTransactionNotFound
+	}
+	transaction := v.(*Transaction)
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+
+	transaction.beginCounter--
+	if transaction.beginCounter > 0 {
+		return nil
+	}
+	cs.transactions.Delete(transactionID)
+	return cs.commitTransaction(transactionID, transaction)
+}
+
+// TransactionAbort discards the transaction however many times it was begun
+func (cs *Store) TransactionAbort(transactionID string) {
+	cs.transactions.Delete(transactionID)
+}
+
+// TransactionGetValue returns the value as the transaction sees it
+func (cs *Store) TransactionGetValue(transactionID string, key string) ([]byte, error) {
+	v, ok := cs.transactions.Load(transactionID)
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+	transaction := v.(*Transaction)
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+
+	if op, ok := transaction.writes[key]; ok {
+		if op.operatorType == 1 {
+			return nil, fmt.Errorf("Value for key=%s does not exist", key)
+		}
+		return op.value, nil
+	}
+	value, updateTime, err := cs.transactionLoadValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := transaction.times[key]; !ok {
+		transaction.times[key] = updateTime
+	}
+	if updateTime < 0 {
+		return nil, fmt.Errorf("Value for key=%s does not exist", key)
+	}
+	return value, nil
+}
+
+func (cs *Store) addTransactionOperator(transactionID string, op *TransactionOperator) error {
+	v, ok := cs.transactions.Load(transactionID)
+	if !ok {
+		return fmt.Errorf("transaction with id=%s doesn't exist", transactionID)
+	}
+	transaction := v.(*Transaction)
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+
+	transaction.operators = append(transaction.operators, op)
+	if !op.updateInKV {
+		return nil
+	}
+	transaction.writes[op.key] = op
+	if _, ok := transaction.times[op.key]; !ok {
+		_, updateTime, err := cs.transactionLoadValue(op.key)
+		if err != nil {
+			return err
+		}
+		transaction.times[op.key] = updateTime
+	}
+	return nil
+}
+
+// transactionLoadValue reads the value together with its write time from the local tree, loading the value from the
+// backend on a cache miss; the time is -1 if the value does not exist
+func (cs *Store) transactionLoadValue(key string) ([]byte, int64, error) {
+	if !keyValidationRegexp.MatchString(key) {
+		return nil, -1, fmt.Errorf("invalid key %s", key)
+	}
+	csv := cs.getLastKeyCacheStoreValue(key)
+	if csv == nil {
+		if _, err := cs.GetValue(key); err != nil && !errors.Is(err, ErrBackendKeyNotFound) {
+			return nil, -1, err
+		}
+		if csv = cs.getLastKeyCacheStoreValue(key); csv == nil {
+			return nil, -1, nil
+		}
+	}
+	csv.Lock("transactionLoadValue")
+	defer csv.Unlock("transactionLoadValue")
+	if !csv.ValueExists() {
+		return nil, -1, nil
+	}
+	value, _ := csv.value.([]byte)
+	return value, csv.valueUpdateTime, nil
+}
+
+func (cs *Store) commitTransaction(transactionID string, transaction *Transaction) error {
+	keys := make([]string, 0, len(transaction.times))
+	for key := range transaction.times {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cs.casMutex.Lock(key)
+	}
+	defer func() {
+		for _, key := range keys {
+			cs.casMutex.Unlock(key)
+		}
+	}()
+
+	journal := transactionJournal{State: transactionStatePending, Time: system.GetCurrentTimeNs()}
+	for _, key := range keys {
+		if op, ok := transaction.writes[key]; ok {
+			journal.Operators = append(journal.Operators, transactionJournalOperator{Key: key, Value: op.value, Delete: op.operatorType == 1})
+		}
+	}
+
+	var journalKey string
+	var journalRevision uint64
+	if len(journal.Operators) > 0 {
+		journalKey = cs.cacheConfig.kvTransactionsPrefix + "." + system.GetHashStr(transactionID+strconv.FormatInt(journal.Time, 10))
+		journalBytes, _ := json.Marshal(journal)
+		revision, err := cs.backend.Update(journalKey, journalBytes, 0)
+		if err != nil {
+			return err
+		}
+		journalRevision = revision
+	}
+	abort := func(err error) error {
+		if journalRevision > 0 {
+			system.MsgOnErrorReturn(cs.backend.Delete(journalKey, 0))
+		}
+		return err
+	}
+
+	if journalRevision > 0 {
+		if err := cs.waitTransactionJournals(journalRevision); err != nil {
+			return abort(err)
+		}
+		if cs.transactionKeysLocked(keys, journalRevision) {
+			return abort(ErrTransactionConflict)
+		}
+		for _, key := range keys {
+			state, err := cs.casLoad(key)
+			if err != nil {
+				return abort(err)
+			}
+			stateTime := int64(-1)
+			if state.exists {
+				stateTime = state.time
+			}
+			if stateTime != transaction.times[key] {
+				return abort(ErrTransactionConflict)
+			}
+			if state.time >= journal.Time {
+				journal.Time = state.time + 1
+			}
+		}
+	}
+	for _, key := range keys {
+		if writeTime := cs.GetValueUpdateTime(key); writeTime >= journal.Time {
+			journal.Time = writeTime + 1
+		}
+	}
+
+	if journalRevision > 0 {
+		journal.State = transactionStateCommitted
+		journalBytes, _ := json.Marshal(journal)
+		if _, err := cs.backend.Update(journalKey, journalBytes, journalRevision); err != nil {
+			return abort(err)
+		}
+	}
+
+	// Committed, applying
+	cs.transactionsMutex.Lock()
+	for _, op := range transaction.operators {
+		customTime := op.customTime
+		if op.updateInKV {
+			customTime = journal.Time
+		}
+		switch op.operatorType {
+		case 0:
+			cs.SetValue(op.key, op.value, false, customTime, "")
+		case 1:
+			cs.DeleteValue(op.key, false, customTime, "")
+		}
+	}
+	cs.transactionsMutex.Unlock()
+
+	if journalRevision > 0 {
+		return cs.finishTransactionJournal(journalKey, journal)
+	}
+	return nil
+}
+
+// finishTransactionJournal puts the writes of the committed journal into the keys and deletes the journal
+func (cs *Store) finishTransactionJournal(journalKey string, journal transactionJournal) error {
+	for _, op := range journal.Operators {
+		value := make([]byte, 9, 9+len(op.Value))
+		binary.BigEndian.PutUint64(value, uint64(journal.Time))
+		if !op.Delete {
+			value[8] = 1 // Append flag
+			value = append(value, op.Value...)
+		}
+		for {
+			var revision uint64
+			if entry, err := cs.backend.Get(cs.toStoreKey(op.Key)); err == nil {
+				if valueBytes := entry.Value; len(valueBytes) >= 9 && int64(binary.BigEndian.Uint64(valueBytes[:8])) >= journal.Time {
+					break // Key has a newer write already
+				}
+				revision = entry.Revision
+			} else if !errors.Is(err, ErrBackendKeyNotFound) {
+				return err
+			}
+			if _, err := cs.backend.Update(cs.toStoreKey(op.Key), value, revision); err == nil {
+				break
+			} else if !errors.Is(err, ErrBackendRevisionMismatch) {
+				return err
+			}
+		}
+	}
+	return cs.backend.Delete(journalKey, 0)
+}
+
+// waitTransactionJournals waits until the journals watcher gets the journal of the revision
+func (cs *Store) waitTransactionJournals(revision uint64) error {
+	deadline := time.Now().Add(TransactionPendingTimeout)
+	for atomic.LoadUint64(&cs.transactionJournalsRevision) < revision {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("transaction journal of revision %d was not received", revision)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+// transactionKeysLocked tells if any of the keys is locked by an unfinished journal of revision less than the given one,
+// 0 revision - by any journal
+func (cs *Store) transactionKeysLocked(keys []string, revision uint64) bool {
+	cs.transactionJournalsMutex.Lock()
+	defer cs.transactionJournalsMutex.Unlock()
+	expired := system.GetCurrentTimeNs() - int64(TransactionPendingTimeout)
+	for _, entry := range cs.transactionJournals {
+		if revision > 0 && entry.revision >= revision {
+			continue
+		}
+		if entry.journal.State == transactionStatePending && entry.journal.Time < expired {
+			continue
+		}
+		for _, key := range keys {
+			if _, ok := entry.keys[key]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (cs *Store) transactionJournalsUpdatesHandler(w BackendWatcher, initChan chan bool) {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("cache.transactionJournalsUpdatesHandler")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("cache.transactionJournalsUpdatesHandler")
+	defer func() { system.MsgOnErrorReturn(w.Stop()) }()
+
+	recoveryTicker := time.NewTicker(transactionRecoveryInterval)
+	defer recoveryTicker.Stop()
+
+	inited := false
+	for {
+		select {
+		case <-cs.ctx.Done():
+			return
+		case <-recoveryTicker.C:
+			if inited {
+				cs.recoverTransactionJournals(system.GetCurrentTimeNs() - int64(TransactionCommittedTimeout))
+			}
+		case entry, ok := <-w.Updates():
+			if !ok { // Backend was closed
+				return
+			}
+			if entry == nil {
+				if !inited {
+					inited = true
+					cs.recoverTransactionJournals(math.MaxInt64)
+					close(initChan)
+				}
+				continue
+			}
+
+			var journal transactionJournal
+			if !entry.Deleted && json.Unmarshal(entry.Value, &journal) == nil {
+				keys := map[string]struct{}{}
+				for _, op := range journal.Operators {
+					keys[op.Key] = struct{}{}
+				}
+				cs.transactionJournalsMutex.Lock()
+				cs.transactionJournals[entry.Key] = &transactionJournalEntry{journal: journal, revision: entry.Revision, keys: keys}
+				cs.transactionJournalsMutex.Unlock()
+
+				if journal.State == transactionStateCommitted {
+					cs.applyTransactionJournal(journal)
+				}
+			} else {
+				cs.transactionJournalsMutex.Lock()
+				delete(cs.transactionJournals, entry.Key)
+				cs.transactionJournalsMutex.Unlock()
+			}
+			atomic.StoreUint64(&cs.transactionJournalsRevision, entry.Revision)
+		}
+	}
+}
+
+// applyTransactionJournal puts all writes of the committed journal into the local tree unless it has newer ones
+func (cs *Store) applyTransactionJournal(journal transactionJournal) {
+	cs.transactionsMutex.Lock()
+	defer cs.transactionsMutex.Unlock()
+	for _, op := range journal.Operators {
+		if cs.GetValueUpdateTime(op.Key) >= journal.Time {
+			continue
+		}
+		if op.Delete {
+			cs.DeleteValue(op.Key, false, journal.Time, "")
+		} else {
+			cs.SetValue(op.Key, op.Value, false, journal.Time, "")
+		}
+	}
+}
+
+// recoverTransactionJournals finishes committed journals older than committedBefore and deletes expired pending ones left
+// by crashed runtimes
+func (cs *Store) recoverTransactionJournals(committedBefore int64) {
+	cs.transactionJournalsMutex.Lock()
+	journals := map[string]transactionJournal{}
+	for journalKey, entry := range cs.transactionJournals {
+		journals[journalKey] = entry.journal
+	}
+	cs.transactionJournalsMutex.Unlock()
+
+	expired := system.GetCurrentTimeNs() - int64(TransactionPendingTimeout)
+	for journalKey, journal := range journals {
+		switch {
+		case journal.State == transactionStateCommitted:
+			if journal.Time >= committedBefore {
+				continue
+			}
+			if err := cs.finishTransactionJournal(journalKey, journal); err != nil {
+				lg.Logf(lg.ErrorLevel, "Cannot finish transaction journal %s: %s", journalKey, err)
+			}
+		case journal.Time < expired:
+			system.MsgOnErrorReturn(cs.backend.Delete(journalKey, 0))
+		}
+	}
+}
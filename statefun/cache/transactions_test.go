@@ -0,0 +1,97 @@
+package cache_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	"github.com/stretchr/testify/suite"
+)
+
+type TransactionsTestSuite struct {
+	suite.Suite
+}
+
+// countingGetsBackend counts reads of values
+type countingGetsBackend struct {
+	cache.Backend
+	gets int64
+}
+
+func (b *countingGetsBackend) Get(key string) (*cache.BackendEntry, error) {
+	atomic.AddInt64(&b.gets, 1)
+	return b.Backend.Get(key)
+}
+
+func TestTransactionsTestSuite(t *testing.T) {
+	suite.Run(t, new(TransactionsTestSuite))
+}
+
+func (s *TransactionsTestSuite) Test_StaleCommittedJournalFinished() {
+	backend := cache.NewMemoryBackend()
+	store := cache.NewCacheStore(context.Background(), cache.NewCacheConfig("journals").SetBackend(backend), nil, nil)
+	defer store.Destroy()
+
+	// Committed journal of a runtime which stopped before finishing it
+	journal := fmt.Sprintf(`{"state": "committed", "time": %d, "operators": [{"key": "tx.stale", "value": "MQ=="}]}`,
+		time.Now().Add(-2*cache.TransactionCommittedTimeout).UnixNano())
+	_, err := backend.Update(cache.KVTransactionsPrefix+".stale", []byte(journal), 0)
+	s.NoError(err)
+
+	s.Eventually(func() bool {
+		_, err := backend.Get(cache.KVTransactionsPrefix + ".stale")
+		return errors.Is(err, cache.ErrBackendKeyNotFound)
+	}, 10*time.Second, 100*time.Millisecond)
+	value, _, err := store.GetValueRevision("tx.stale")
+	s.NoError(err)
+	s.Equal([]byte("1"), value)
+}
+
+func (s *TransactionsTestSuite) Test_ReadsDoNotTouchBackend() {
+	backend := &countingGetsBackend{Backend: cache.NewMemoryBackend()}
+	store := cache.NewCacheStore(context.Background(), cache.NewCacheConfig("reads").SetBackend(backend), nil, nil)
+	defer store.Destroy()
+
+	_, err := store.SetValueIfRevision("tx.a", []byte("1"), 0)
+	s.NoError(err)
+	gets := atomic.LoadInt64(&backend.gets)
+
+	store.TransactionBegin("read")
+	for i := 0; i < 3; i++ {
+		value, err := store.TransactionGetValue("read", "tx.a")
+		s.NoError(err)
+		s.Equal([]byte("1"), value)
+	}
+	store.SetValue("tx.b", []byte("2"), false, -1, "read")
+	s.NoError(store.TransactionEnd("read"))
+	s.Equal(gets, atomic.LoadInt64(&backend.gets))
+}
+
+func (s *TransactionsTestSuite) Test_ConflictWithWriteNotYetReceived() {
+	backend := cache.NewMemoryBackend()
+	store := cache.NewCacheStore(context.Background(), cache.NewCacheConfig("conflicts").SetBackend(backend), nil, nil)
+	defer store.Destroy()
+
+	revision, err := store.SetValueIfRevision("tx.a", []byte("1"), 0)
+	s.NoError(err)
+
+	store.TransactionBegin("conflict")
+	_, err = store.TransactionGetValue("conflict", "tx.a")
+	s.NoError(err)
+	store.SetValue("tx.a", []byte("2"), true, -1, "conflict")
+
+	// Write of another runtime straight into the backend
+	value := make([]byte, 9, 10)
+	binary.BigEndian.PutUint64(value, uint64(time.Now().Add(time.Hour).UnixNano()))
+	value[8] = 1 // Append flag
+	value = append(value, '3')
+	_, err = backend.Update(cache.KVStorePrefix+".tx.a", value, revision)
+	s.NoError(err)
+
+	s.ErrorIs(store.TransactionEnd("conflict"), cache.ErrTransactionConflict)
+}
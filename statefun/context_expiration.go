@@ -0,0 +1,216 @@
+package statefun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	contextExpiredCallerTypename = "expiry"
+	ContextExpiredPayloadKey     = "context_expired"
+
+	contextExpirationsKeyPrefix = "context_expirations"
+	functionContextKind         = "function"
+	objectContextKind           = "object"
+
+	legacyContextExpirationSweptKey  = "migrations.legacy_context_expiration"
+	legacyContextExpirationSweepPage = 1000
+)
+
+/*
+Contexts expire natively in the cache (see cache.Store.SetValueExpiration). Function types configured with
+SetContextExpiredSignal are signaled when their function context or an object context of any id expires:
+
+	caller: {"typename": "expiry", "id": <typename>}
+	payload: {"context_expired": {"kind": "function" | "object", "key": <cache key>, "context": <last context>}}
+
+The context is already deleted when the signal is handled. Only keys given an expiration through the context's
+SetContextExpirationAfter or SetObjectContextExpirationAfter are signaled: the runtime records them in the backend as
+"context_expirations.<cache key>" with {"kind": ..., "typename": ...}, the record is removed when the expiration is
+handled, removed or the context is deleted. Other expiring values (idempotency records, values given an expiration
+through the cache directly) are not signaled.
+
+Contexts written by older runtimes keep their expiration inside under "____ctx_expires_after_ms". The first runtime started
+on a domain sweeps the whole cache once and moves such expirations into the cache, so older runtimes must be stopped
+before: contexts they write after the sweep keep the expiration inside and do not expire.
+*/
+
+func (r *Runtime) runContextExpirations(ctx context.Context) {
+	defer r.wg.Done()
+	system.GlobalPrometrics.GetRoutinesCounter().Started("runtime-runContextExpirations")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("runtime-runContextExpirations")
+
+	callbackID := "runtime-context-expirations"
+	expirations := r.Domain.cache.SubscribeExpirations(callbackID)
+	defer r.Domain.cache.UnsubscribeExpirations(callbackID)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.shutdown:
+			return
+		case kv := <-expirations:
+			r.signalContextExpired(kv)
+		}
+	}
+}
+
+func contextExpirationKey(keyValueID string) string {
+	return contextExpirationsKeyPrefix + "." + keyValueID
+}
+
+func (r *Runtime) signalContextExpired(kv cache.KeyValue) {
+	key, ok := kv.Key.(string)
+	if !ok {
+		return
+	}
+	entry, err := r.Domain.kv.Get(contextExpirationKey(key))
+	if err != nil { // Not a context
+		if !errors.Is(err, cache.ErrBackendKeyNotFound) {
+			lg.Logf(lg.ErrorLevel, "Expired key %s cannot be checked for being a context: %s", key, err)
+		}
+		return
+	}
+	system.MsgOnErrorReturn(r.Domain.kv.Delete(contextExpirationKey(key), 0))
+	record, ok := easyjson.JSONFromBytes(entry.Value)
+	if !ok {
+		return
+	}
+	kind := record.GetByPath("kind").AsStringDefault(objectContextKind)
+	typename := record.GetByPath("typename").AsStringDefault("")
+
+	for _, ft := range r.getRegisteredFunctionTypes() {
+		if !ft.config.contextExpiredSignal {
+			continue
+		}
+		id := key
+		if kind == functionContextKind {
+			if ft.name != typename {
+				continue
+			}
+			id = strings.TrimPrefix(key, typename+".")
+		}
+
+		event := easyjson.NewJSONObjectWithKeyValue("kind", easyjson.NewJSON(kind))
+		event.SetByPath("key", easyjson.NewJSON(key))
+		if valueBytes, ok := kv.Value.([]byte); ok {
+			if value, ok := easyjson.JSONFromBytes(valueBytes); ok {
+				event.SetByPath("context", value)
+			}
+		}
+		payload := easyjson.NewJSONObjectWithKeyValue(ContextExpiredPayloadKey, event)
+		system.MsgOnErrorReturn(r.signal(ft.config.preferredSignalProvider(), contextExpiredCallerTypename, ft.name, ft.name, id, &payload, nil))
+	}
+}
+
+func (r *Runtime) sweepLegacyContextExpirations(ctx context.Context) {
+	defer r.wg.Done()
+	system.GlobalPrometrics.GetRoutinesCounter().Started("runtime-sweepLegacyContextExpirations")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("runtime-sweepLegacyContextExpirations")
+
+	if _, err := r.Domain.kv.Get(legacyContextExpirationSweptKey); err == nil { // Already swept
+		return
+	} else if !errors.Is(err, cache.ErrBackendKeyNotFound) {
+		lg.Logf(lg.ErrorLevel, "Legacy context expirations sweep cannot start: %s", err)
+		return
+	}
+
+	migrated := 0
+	startAfter := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.shutdown:
+			return
+		default:
+		}
+
+		values, next, err := r.Domain.cache.ScanValues("", startAfter, legacyContextExpirationSweepPage)
+		if err != nil {
+			lg.Logf(lg.ErrorLevel, "Legacy context expirations sweep failed after key %s: %s", startAfter, err)
+			return
+		}
+		for _, kv := range values {
+			if r.migrateLegacyContextExpiration(kv.Key.(string), kv.Value.([]byte)) {
+				migrated++
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		startAfter = next
+	}
+
+	if _, err := r.Domain.kv.Put(legacyContextExpirationSweptKey, system.Int64ToBytes(time.Now().UnixNano())); err != nil {
+		lg.Logf(lg.ErrorLevel, "Legacy context expirations sweep cannot be marked as done: %s", err)
+	}
+	lg.Logf(lg.InfoLevel, "Legacy context expirations sweep migrated %d contexts", migrated)
+}
+
+// migrateLegacyContextExpiration moves expiration kept inside of the context into the cache unless the context was
+// changed meanwhile, then it is migrated when loaded
+func (r *Runtime) migrateLegacyContextExpiration(keyValueID string, value []byte) bool {
+	if !bytes.Contains(value, []byte(legacyContextExpirationKey)) {
+		return false
+	}
+	context, ok := easyjson.JSONFromBytes(value)
+	if !ok {
+		return false
+	}
+	expirationTime, ok := context.GetByPath(legacyContextExpirationKey).AsNumeric()
+	if !ok {
+		return false
+	}
+	context.RemoveByPath(legacyContextExpirationKey)
+	if swapped, err := r.Domain.cache.SetValueIfEquals(keyValueID, context.ToBytes(), value); err != nil || !swapped {
+		system.MsgOnErrorReturn(err)
+		return false
+	}
+	kind, typename := objectContextKind, ""
+	if i := strings.LastIndex(keyValueID, "."); i >= 0 { // <typename>.<id>, ids have no dots
+		kind, typename = functionContextKind, keyValueID[:i]
+	}
+	system.MsgOnErrorReturn(r.registerContextExpiration(keyValueID, kind, typename))
+	system.MsgOnErrorReturn(r.Domain.cache.SetValueExpiration(keyValueID, time.Unix(0, int64(expirationTime))))
+	return true
+}
+
+// registerContextExpiration records that the expiring key is a context of the kind, see signalContextExpired
+func (r *Runtime) registerContextExpiration(keyValueID string, kind string, typename string) error {
+	record := easyjson.NewJSONObjectWithKeyValue("kind", easyjson.NewJSON(kind))
+	record.SetByPath("typename", easyjson.NewJSON(typename))
+	_, err := r.Domain.kv.Put(contextExpirationKey(keyValueID), record.ToBytes())
+	return err
+}
+
+// forgetContextExpiration removes the record of the context's expiration if the context has one
+func (r *Runtime) forgetContextExpiration(keyValueID string) {
+	if _, ok := r.Domain.cache.GetValueExpiration(keyValueID); !ok {
+		return
+	}
+	if err := r.Domain.kv.Delete(contextExpirationKey(keyValueID), 0); err != nil && !errors.Is(err, cache.ErrBackendKeyNotFound) {
+		system.MsgOnErrorReturn(err)
+	}
+}
+
+// setContextExpirationAfter makes the context of the kind expire after the duration, negative duration removes expiration
+func (ft *FunctionType) setContextExpirationAfter(keyValueID string, kind string, after time.Duration) {
+	if after < 0 {
+		ft.runtime.forgetContextExpiration(keyValueID)
+	} else if err := ft.runtime.registerContextExpiration(keyValueID, kind, ft.name); err != nil {
+		system.MsgOnErrorReturn(err)
+		return
+	}
+	system.MsgOnErrorReturn(ft.runtime.Domain.cache.SetValueExpirationAfter(keyValueID, after))
+}
@@ -0,0 +1,96 @@
+package statefun
+
+import (
+	"fmt"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/prometheus/client_golang/prometheus"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	ContextVersionKey = "__version"
+)
+
+/*
+Contexts are versioned when migrations are set for them in the function type's config. A context without the "__version"
+marker is version 1, migrations[i] converts version i+1 into i+2, so the current version is len(migrations)+1:
+
+	statefun.NewFunctionTypeConfig().SetObjectContextMigrations(
+		func(v1 *easyjson.JSON) (*easyjson.JSON, error) { ... return v2, nil },
+		func(v2 *easyjson.JSON) (*easyjson.JSON, error) { ... return v3, nil },
+	)
+
+A context is migrated lazily when the handler gets it, the migrated context is stored back right away. Contexts set by the
+handler are marked with the current version, unless the stored one has a newer version (written by a newer deployment),
+which is kept then. A context of a version newer than the current one is returned as is. A failed migration panics, so
+the message is handled as a failed one and the context stays untouched.
+
+Object context is shared by all function types of the object: types without object context migrations keep the version
+of the stored context when setting one without the marker.
+*/
+
+// ContextMigrationFunc converts a context of the previous version into the next one, returned context must not be nil
+type ContextMigrationFunc func(context *easyjson.JSON) (*easyjson.JSON, error)
+
+func contextVersion(context *easyjson.JSON) int {
+	return int(context.GetByPath(ContextVersionKey).AsNumericDefault(1))
+}
+
+func (ft *FunctionType) getVersionedContext(keyValueID string, migrations []ContextMigrationFunc, transactionID string) *easyjson.JSON {
+	context := ft.getContext(keyValueID, transactionID)
+	if len(migrations) == 0 || !context.IsNonEmptyObject() {
+		return context
+	}
+
+	version := contextVersion(context)
+	currentVersion := len(migrations) + 1
+	if version >= currentVersion {
+		if version > currentVersion {
+			lg.Logf(lg.WarnLevel, "Function type %s: context %s has version %d newer than the current %d", ft.name, keyValueID, version, currentVersion)
+		}
+		return context
+	}
+
+	for ; version < currentVersion; version++ {
+		migrated, err := migrations[version-1](context.Clone().GetPtr())
+		if err == nil && migrated == nil {
+			err = fmt.Errorf("migration returned nil context")
+		}
+		if err != nil {
+			panic(fmt.Errorf("function type %s: context %s migration from version %d to %d failed: %w", ft.name, keyValueID, version, version+1, err))
+		}
+		context = migrated
+	}
+	context.SetByPath(ContextVersionKey, easyjson.NewJSON(currentVersion))
+	ft.setContext(keyValueID, context, transactionID)
+
+	if counterVec, err := system.GlobalPrometrics.EnsureCounterVecSimple("statefun_context_migrations", "Contexts migrated to the current version", []string{"typename"}); err == nil {
+		counterVec.With(prometheus.Labels{"typename": ft.name}).Inc()
+	}
+	return context
+}
+
+func (ft *FunctionType) setVersionedContext(keyValueID string, context *easyjson.JSON, migrations []ContextMigrationFunc, keepStoredVersion bool, transactionID string) {
+	if context != nil && context.IsObject() {
+		version := 0 // No marker
+		if len(migrations) > 0 {
+			version = len(migrations) + 1
+		}
+		if context.PathExists(ContextVersionKey) && contextVersion(context) > version {
+			version = contextVersion(context)
+		}
+		if len(migrations) > 0 || keepStoredVersion {
+			if stored := ft.getContext(keyValueID, transactionID); stored.PathExists(ContextVersionKey) && contextVersion(stored) > version {
+				version = contextVersion(stored) // Written by a newer deployment, must not be downgraded
+			}
+		}
+		if version > 0 && (!context.PathExists(ContextVersionKey) || contextVersion(context) != version) {
+			context = context.Clone().GetPtr() // Context of the caller stays untouched
+			context.SetByPath(ContextVersionKey, easyjson.NewJSON(version))
+		}
+	}
+	ft.setContext(keyValueID, context, transactionID)
+}
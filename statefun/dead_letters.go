@@ -0,0 +1,336 @@
+package statefun
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	DeadLetterPrefix = "deadletter"
+
+	deliveryErrorLifetimeMs = 10 * 60 * 1000
+)
+
+type DeadLetter struct {
+	Sequence   uint64 // Sequence of the dead letter in the function type's dead-letter stream
+	Typename   string
+	ID         string
+	Subject    string // Original subject the signal was published to
+	Caller     sfPlugins.StatefunAddress
+	Payload    *easyjson.JSON
+	Options    *easyjson.JSON
+	Error      string // Last error registered for the signal
+	Deliveries uint64
+	Time       time.Time
+}
+
+type deliveryError struct {
+	err  string
+	time int64
+}
+
+// rateLimitDelays counts redeliveries of a signal caused by rate limits, they are not counted toward max deliver
+type rateLimitDelays struct {
+	count atomic.Uint64
+	time  atomic.Int64
+}
+
+func deadLetterFromJSON(sequence uint64, j *easyjson.JSON) DeadLetter {
+	dl := DeadLetter{
+		Sequence:   sequence,
+		Typename:   j.GetByPath("typename").AsStringDefault(""),
+		ID:         j.GetByPath("id").AsStringDefault(""),
+		Subject:    j.GetByPath("subject").AsStringDefault(""),
+		Error:      j.GetByPath("error").AsStringDefault(""),
+		Deliveries: uint64(j.GetByPath("deliveries").AsNumericDefault(0)),
+		Time:       time.Unix(0, int64(j.GetByPath("time").AsNumericDefault(0))),
+		Caller: sfPlugins.StatefunAddress{
+			Typename: j.GetByPath("caller_typename").AsStringDefault(""),
+			ID:       j.GetByPath("caller_id").AsStringDefault(""),
+		},
+		Payload: easyjson.NewJSONObject().GetPtr(),
+		Options: easyjson.NewJSONObject().GetPtr(),
+	}
+	if j.GetByPath("payload").IsObject() {
+		dl.Payload = j.GetByPath("payload").GetPtr()
+	}
+	if j.GetByPath("options").IsObject() {
+		dl.Options = j.GetByPath("options").GetPtr()
+	}
+	return dl
+}
+
+func (dl DeadLetter) ToJSON() *easyjson.JSON {
+	j := easyjson.NewJSONObject()
+	j.SetByPath("sequence", easyjson.NewJSON(dl.Sequence))
+	j.SetByPath("typename", easyjson.NewJSON(dl.Typename))
+	j.SetByPath("id", easyjson.NewJSON(dl.ID))
+	j.SetByPath("subject", easyjson.NewJSON(dl.Subject))
+	j.SetByPath("caller_typename", easyjson.NewJSON(dl.Caller.Typename))
+	j.SetByPath("caller_id", easyjson.NewJSON(dl.Caller.ID))
+	if dl.Payload != nil {
+		j.SetByPath("payload", *dl.Payload)
+	}
+	if dl.Options != nil {
+		j.SetByPath("options", *dl.Options)
+	}
+	j.SetByPath("error", easyjson.NewJSON(dl.Error))
+	j.SetByPath("deliveries", easyjson.NewJSON(dl.Deliveries))
+	j.SetByPath("time", easyjson.NewJSON(dl.Time.UnixNano()))
+	return &j
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+func (ft *FunctionType) deadLettersEnabled() bool {
+	return ft.config.maxDeliver > 0
+}
+
+func (ft *FunctionType) getDeadLetterStreamName() string {
+	return fmt.Sprintf("%s_dead_letters", system.GetHashStr(ft.subject))
+}
+
+func (ft *FunctionType) getDeadLetterSubject(id string) string {
+	return fmt.Sprintf("%s.%s.%s.%s", DeadLetterPrefix, ft.runtime.Domain.name, ft.name, id)
+}
+
+func (ft *FunctionType) registerDeliveryError(msg *nats.Msg, err string) {
+	if meta, e := msg.Metadata(); e == nil {
+		ft.deliveryErrors.Store(meta.Sequence.Stream, deliveryError{err: err, time: time.Now().UnixNano()})
+	}
+}
+
+// releaseDeliveryError forgets what was registered for the delivered signal, returns its last error
+func (ft *FunctionType) releaseDeliveryError(msg *nats.Msg) (err string) {
+	if meta, e := msg.Metadata(); e == nil {
+		if v, ok := ft.deliveryErrors.LoadAndDelete(meta.Sequence.Stream); ok {
+			err = v.(deliveryError).err
+		}
+		ft.rateLimitDelays.Delete(meta.Sequence.Stream)
+	}
+	return
+}
+
+func (ft *FunctionType) registerRateLimitDelay(msg *nats.Msg) {
+	if meta, e := msg.Metadata(); e == nil {
+		v, _ := ft.rateLimitDelays.LoadOrStore(meta.Sequence.Stream, &rateLimitDelays{})
+		delays := v.(*rateLimitDelays)
+		delays.count.Add(1)
+		delays.time.Store(time.Now().UnixNano())
+	}
+}
+
+/*
+countedDeliveries returns the number of deliveries of the signal counted toward max deliver: redeliveries caused by rate
+limits are not counted. Delays are tracked by the runtime which delayed the signal, so a signal redelivered to another
+runtime of the domain gets its delays counted there only.
+*/
+func (ft *FunctionType) countedDeliveries(msg *nats.Msg) uint64 {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return 0
+	}
+	deliveries := meta.NumDelivered
+	if v, ok := ft.rateLimitDelays.Load(meta.Sequence.Stream); ok {
+		if delays := v.(*rateLimitDelays).count.Load(); delays < deliveries {
+			deliveries -= delays
+		} else {
+			deliveries = 1
+		}
+	}
+	return deliveries
+}
+
+func (ft *FunctionType) gcDeliveryErrors(now int64) {
+	ft.deliveryErrors.Range(func(key, value any) bool {
+		if value.(deliveryError).time+int64(deliveryErrorLifetimeMs)*int64(time.Millisecond) < now {
+			ft.deliveryErrors.Delete(key)
+		}
+		return true
+	})
+	ft.rateLimitDelays.Range(func(key, value any) bool {
+		if value.(*rateLimitDelays).time.Load()+int64(deliveryErrorLifetimeMs)*int64(time.Millisecond) < now {
+			ft.rateLimitDelays.Delete(key)
+		}
+		return true
+	})
+}
+
+// nakWithBackoff naks a JetStream message, delaying its redelivery according to the function type's retry policy
+func (ft *FunctionType) nakWithBackoff(msg *nats.Msg, reason string) error {
+	ft.registerDeliveryError(msg, reason)
+
+	if ft.config.retryBackoffInitialMs <= 0 {
+		return msg.Nak()
+	}
+	var delivered uint64 = 1
+	if deliveries := ft.countedDeliveries(msg); deliveries > 0 {
+		delivered = deliveries
+	}
+	delayMs := float64(ft.config.retryBackoffInitialMs) * math.Pow(2, float64(delivered-1))
+	if ft.config.retryBackoffMaxMs > 0 && delayMs > float64(ft.config.retryBackoffMaxMs) {
+		delayMs = float64(ft.config.retryBackoffMaxMs)
+	}
+	return msg.NakWithDelay(time.Duration(delayMs) * time.Millisecond)
+}
+
+// deadLetter moves a JetStream message which used up its deliveries into the function type's dead-letter stream
+func (ft *FunctionType) deadLetter(msg *nats.Msg, id string, data *easyjson.JSON, deliveries uint64) error {
+	lastErr := ft.releaseDeliveryError(msg)
+	if len(lastErr) == 0 {
+		lastErr = "handler did not ack the signal in time"
+	}
+
+	dl := DeadLetter{
+		Typename:   ft.name,
+		ID:         id,
+		Subject:    msg.Subject,
+		Error:      lastErr,
+		Deliveries: deliveries,
+		Time:       time.Now(),
+		Caller: sfPlugins.StatefunAddress{
+			Typename: data.GetByPath("caller_typename").AsStringDefault(""),
+			ID:       data.GetByPath("caller_id").AsStringDefault(""),
+		},
+	}
+	if data.GetByPath("payload").IsObject() {
+		dl.Payload = data.GetByPath("payload").GetPtr()
+	}
+	if data.GetByPath("options").IsObject() {
+		dl.Options = data.GetByPath("options").GetPtr()
+	}
+
+	if _, err := ft.runtime.js.Publish(ft.getDeadLetterSubject(id), dl.ToJSON().ToBytes()); err != nil {
+		return err
+	}
+	lg.Logf(lg.WarnLevel, "Signal for function type %s with id=%s was moved to dead letters after %d deliveries: %s", ft.name, id, deliveries, lastErr)
+
+	if counterVec, err := system.GlobalPrometrics.EnsureCounterVecSimple("statefun_dead_letters", "Signals moved to dead letters", []string{"typename"}); err == nil {
+		counterVec.With(prometheus.Labels{"typename": ft.name}).Inc()
+	}
+
+	return msg.Term()
+}
+
+func (ft *FunctionType) createDeadLetterStream(existingStreams []string) error {
+	if contains(existingStreams, ft.getDeadLetterStreamName()) {
+		return nil
+	}
+	_, err := ft.runtime.js.AddStream(&nats.StreamConfig{
+		Name:     ft.getDeadLetterStreamName(),
+		Subjects: []string{ft.getDeadLetterSubject("*")},
+	})
+	return err
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+func (r *Runtime) getDeadLetterFunctionType(typename string) (*FunctionType, error) {
+	if r.js == nil {
+		return nil, ErrRuntimeWithoutNats
+	}
+	ft, ok := r.getRegisteredFunctionType(typename)
+	if !ok {
+		return nil, fmt.Errorf("function type %s is not registered", typename)
+	}
+	if !ft.deadLettersEnabled() {
+		return nil, fmt.Errorf("function type %s has no dead letters: max deliver is not set", typename)
+	}
+	return ft, nil
+}
+
+// DeadLetters lists all dead letters of a function type
+func (r *Runtime) DeadLetters(typename string) ([]DeadLetter, error) {
+	ft, err := r.getDeadLetterFunctionType(typename)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := r.js.StreamInfo(ft.getDeadLetterStreamName())
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := []DeadLetter{}
+	if info.State.Msgs == 0 {
+		return deadLetters, nil
+	}
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq; seq++ {
+		dl, err := r.GetDeadLetter(typename, seq)
+		if err != nil {
+			if errors.Is(err, nats.ErrMsgNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		deadLetters = append(deadLetters, *dl)
+	}
+	return deadLetters, nil
+}
+
+// GetDeadLetter returns a single dead letter of a function type by its sequence
+func (r *Runtime) GetDeadLetter(typename string, sequence uint64) (*DeadLetter, error) {
+	ft, err := r.getDeadLetterFunctionType(typename)
+	if err != nil {
+		return nil, err
+	}
+
+	rawMsg, err := r.js.GetMsg(ft.getDeadLetterStreamName(), sequence)
+	if err != nil {
+		return nil, err
+	}
+	j, ok := easyjson.JSONFromBytes(rawMsg.Data)
+	if !ok {
+		return nil, fmt.Errorf("dead letter %d of function type %s is not a JSON", sequence, typename)
+	}
+	dl := deadLetterFromJSON(sequence, &j)
+	return &dl, nil
+}
+
+// ReplayDeadLetter republishes a dead letter into its original subject and removes it from the dead letters.
+// Replayed signal starts with a fresh delivery counter.
+func (r *Runtime) ReplayDeadLetter(typename string, sequence uint64) error {
+	dl, err := r.GetDeadLetter(typename, sequence)
+	if err != nil {
+		return err
+	}
+
+	subject := dl.Subject
+	if len(subject) == 0 || !strings.HasPrefix(subject, DomainSubjectsIngressPrefix) {
+		subject = fmt.Sprintf(DomainIngressSubjectsTmpl, r.Domain.name, fmt.Sprintf("%s.%s.%s.%s", SignalPrefix, r.Domain.name, typename, dl.ID))
+	}
+	if _, err := r.js.Publish(subject, buildNatsData(r.Domain.name, dl.Caller.Typename, dl.Caller.ID, dl.Payload, dl.Options)); err != nil {
+		return err
+	}
+	return r.DeleteDeadLetter(typename, sequence)
+}
+
+// DeleteDeadLetter removes a single dead letter of a function type
+func (r *Runtime) DeleteDeadLetter(typename string, sequence uint64) error {
+	ft, err := r.getDeadLetterFunctionType(typename)
+	if err != nil {
+		return err
+	}
+	return r.js.DeleteMsg(ft.getDeadLetterStreamName(), sequence)
+}
+
+// PurgeDeadLetters removes all dead letters of a function type
+func (r *Runtime) PurgeDeadLetters(typename string) error {
+	ft, err := r.getDeadLetterFunctionType(typename)
+	if err != nil {
+		return err
+	}
+	return r.js.PurgeStream(ft.getDeadLetterStreamName())
+}
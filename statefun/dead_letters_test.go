@@ -0,0 +1,87 @@
+package statefun_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/statefun"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/test"
+	"github.com/stretchr/testify/suite"
+)
+
+type DeadLettersTestSuite struct {
+	test.StatefunTestSuite
+}
+
+func TestDeadLettersTestSuite(t *testing.T) {
+	suite.Run(t, new(DeadLettersTestSuite))
+}
+
+func (s *DeadLettersTestSuite) Test_DeadLetters_MaxDeliverReplayAndPurge() {
+	typename := "functions.tests.deadletters.flaky"
+	var failing atomic.Bool
+	failing.Store(true)
+	s.RegisterFunction(typename, func(executor sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		if failing.Load() {
+			panic("unavailable")
+		}
+		counterFunction(executor, ctx)
+	}, *statefun.NewFunctionTypeConfig().SetMaxDeliver(2))
+	s.NoError(s.StartRuntime())
+
+	waitDeadLetters := func(count int) []statefun.DeadLetter {
+		var deadLetters []statefun.DeadLetter
+		s.Eventually(func() bool {
+			var err error
+			deadLetters, err = s.Runtime().DeadLetters(typename)
+			return err == nil && len(deadLetters) == count
+		}, 5*time.Second, 100*time.Millisecond)
+		return deadLetters
+	}
+
+	payload := easyjson.NewJSONObjectWithKeyValue("k", easyjson.NewJSON("v"))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", &payload, nil))
+	deadLetters := waitDeadLetters(1)
+	s.Equal("a", deadLetters[0].ID)
+	s.EqualValues(3, deadLetters[0].Deliveries)
+	s.Contains(deadLetters[0].Error, "unavailable")
+
+	// Replayed signal is handled once the handler recovers and leaves the dead letters
+	failing.Store(false)
+	s.NoError(s.Runtime().ReplayDeadLetter(typename, deadLetters[0].Sequence))
+	s.Eventually(func() bool {
+		v, err := s.CacheValue("a")
+		return err == nil && v.GetByPath("counter").AsNumericDefault(0) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+	waitDeadLetters(0)
+
+	failing.Store(true)
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "b", nil, nil))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "c", nil, nil))
+	waitDeadLetters(2)
+	s.NoError(s.Runtime().PurgeDeadLetters(typename))
+	waitDeadLetters(0)
+}
+
+func (s *DeadLettersTestSuite) Test_DeadLetters_RateLimitDelaysNotCounted() {
+	typename := "functions.tests.deadletters.throttled"
+	s.RegisterFunction(typename, counterFunction, *statefun.NewFunctionTypeConfig().
+		SetMaxDeliver(1).
+		SetIDRateLimit(4, 1))
+	s.NoError(s.StartRuntime())
+
+	// Signals beyond the first one are delayed by the rate limit, some of them several times
+	for i := 0; i < 5; i++ {
+		s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", nil, nil))
+	}
+	s.Eventually(func() bool {
+		v, err := s.CacheValue("a")
+		return err == nil && v.GetByPath("counter").AsNumericDefault(0) == 5
+	}, 10*time.Second, 100*time.Millisecond)
+	deadLetters, err := s.Runtime().DeadLetters(typename)
+	s.NoError(err)
+	s.Empty(deadLetters)
+}
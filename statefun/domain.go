@@ -49,7 +49,8 @@ type Domain struct {
 	weakClusterDomainsMutex sync.Mutex
 	nc                      *nats.Conn
 	js                      nats.JetStreamContext
-	kv                      nats.KeyValue
+	kv                      cache.Backend // Backend of the cache, the runtime keeps its own records in it too
+	bucket                  string        // Key/value bucket of the backend, empty for a custom one
 	cache                   *cache.Store
 }
 
@@ -58,9 +59,13 @@ func NewDomain(nc *nats.Conn, js nats.JetStreamContext, desiredHubDomainName str
 	if err != nil {
 		return nil, err
 	}
+	return newDomain(nc, js, desiredHubDomainName, accInfo.Domain), nil
+}
 
+// newDomain makes the domain of the JetStream domain, empty one is not known or not set
+func newDomain(nc *nats.Conn, js nats.JetStreamContext, desiredHubDomainName string, jsDomainName string) *Domain {
 	hubDomainName := desiredHubDomainName
-	thisDomainName := accInfo.Domain
+	thisDomainName := jsDomainName
 	if thisDomainName == "" {
 		if hubDomainName == "" {
 			thisDomainName = DefaultHubDomainName
@@ -74,15 +79,13 @@ func NewDomain(nc *nats.Conn, js nats.JetStreamContext, desiredHubDomainName str
 		}
 	}
 
-	domain := &Domain{
+	return &Domain{
 		hubDomainName:      hubDomainName,
 		name:               thisDomainName,
 		weakClusterDomains: map[string]struct{}{thisDomainName: {}},
 		nc:                 nc,
 		js:                 js,
 	}
-
-	return domain, nil
 }
 
 func (dm *Domain) HubDomainName() string {
@@ -212,30 +215,27 @@ func (dm *Domain) CreateObjectIDWithHubDomain(objectID string, domainReplace boo
 }
 
 func (dm *Domain) start(cacheConfig *cache.Config, createDomainRouters bool) error {
-	bucketName := fmt.Sprintf("%s_%s_cache_bucket", dm.name, cacheConfig.GetId())
-
-	// Create application key value store bucket if does not exist --
-	kvExists := false
-	if kv, err := dm.js.KeyValue(bucketName); err == nil {
-		dm.kv = kv
-		kvExists = true
+	// Create application key value store bucket if does not exist, unless the cache keeps its values elsewhere --
+	var bucket nats.KeyValue
+	if cacheConfig.GetBackend() == nil && dm.js == nil { // Runtime without NATS
+		cacheConfig.SetBackend(cache.NewMemoryBackend())
 	}
-	if !kvExists {
+	if cacheConfig.GetBackend() == nil {
+		bucketName := CacheBucketName(dm.name, cacheConfig.GetId())
 		var err error
-		dm.kv, err = kv.CreateKeyValue(dm.nc, dm.js, &nats.KeyValueConfig{
-			Bucket: bucketName,
-		})
-		if err != nil {
-			return err
+		if bucket, err = dm.js.KeyValue(bucketName); err != nil {
+			bucket, err = kv.CreateKeyValue(dm.nc, dm.js, &nats.KeyValueConfig{
+				Bucket: bucketName,
+			})
+			if err != nil {
+				return err
+			}
 		}
-		kvExists = true
-	}
-	if !kvExists {
-		return fmt.Errorf("Nats KV was not inited")
+		dm.bucket = bucketName
 	}
 	// --------------------------------------------------------------
 
-	if createDomainRouters {
+	if createDomainRouters && dm.js != nil {
 		if dm.hubDomainName == dm.name {
 			if err := dm.createHubSignalStream(); err != nil {
 				return err
@@ -256,7 +256,8 @@ func (dm *Domain) start(cacheConfig *cache.Config, createDomainRouters bool) err
 	}
 
 	lg.Logln(lg.TraceLevel, "Initializing the cache store...")
-	dm.cache = cache.NewCacheStore(context.Background(), cacheConfig, dm.js, dm.kv)
+	dm.cache = cache.NewCacheStore(context.Background(), cacheConfig, dm.js, bucket)
+	dm.kv = dm.cache.Backend()
 	lg.Logln(lg.TraceLevel, "Cache store inited!")
 
 	return nil
@@ -0,0 +1,153 @@
+package statefun
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	drainPollInterval = 10 * time.Millisecond
+)
+
+// msgAcker acks JetStream messages asynchronously, acks sent after flush are acked synchronously
+type msgAcker struct {
+	channel chan *nats.Msg
+	done    chan struct{}
+	flushed bool
+	mutex   sync.RWMutex
+}
+
+func newMsgAcker(channelSize int) *msgAcker {
+	a := &msgAcker{
+		channel: make(chan *nats.Msg, channelSize),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *msgAcker) run() {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("AddSignalSourceJetstreamQueuePushConsumer-msgAcker")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("AddSignalSourceJetstreamQueuePushConsumer-msgAcker")
+	for msg := range a.channel {
+		system.MsgOnErrorReturn(msg.Ack())
+	}
+	close(a.done)
+}
+
+func (a *msgAcker) ack(msg *nats.Msg) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.flushed {
+		system.MsgOnErrorReturn(msg.Ack())
+		return
+	}
+	a.channel <- msg
+}
+
+// flush sends all pending acks, returns false if it did not manage to do it within the timeout
+func (a *msgAcker) flush(timeout time.Duration) bool {
+	a.mutex.Lock()
+	if !a.flushed {
+		a.flushed = true
+		close(a.channel)
+	}
+	a.mutex.Unlock()
+
+	select {
+	case <-a.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// stopSubscriptions stops receiving new messages, already received ones are still being handled
+func (ft *FunctionType) stopSubscriptions() {
+	for _, sub := range ft.subscriptions {
+		system.MsgOnErrorReturn(sub.Drain())
+	}
+}
+
+func (ft *FunctionType) subscriptionsStopped() bool {
+	for _, sub := range ft.subscriptions {
+		if sub.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+func (ft *FunctionType) idle() bool {
+	return atomic.LoadInt64(&ft.msgsInFlight) == 0
+}
+
+func waitUntil(deadline time.Time, condition func() bool) bool {
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
+
+/*
+drain stops accepting new messages, lets already received ones be handled within the drain timeout and flushes pending
+acks. Signals which were not handled in time are left unacked and will be redelivered to other runtimes.
+*/
+func (r *Runtime) drain() {
+	r.drainFunctionTypes(r.getRegisteredFunctionTypes())
+}
+
+func (r *Runtime) drainFunctionTypes(functionTypes []*FunctionType) {
+	deadline := time.Now().Add(time.Duration(r.config.drainTimeoutSec) * time.Second)
+
+	for _, ft := range functionTypes {
+		ft.stopSubscriptions()
+	}
+	for _, ft := range functionTypes {
+		if !waitUntil(deadline, ft.subscriptionsStopped) {
+			lg.Logf(lg.WarnLevel, "Function type %s subscriptions were not drained in time", ft.name)
+		}
+		ft.draining.Store(true)
+	}
+
+	for _, ft := range functionTypes {
+		if !waitUntil(deadline, ft.idle) {
+			lg.Logf(lg.WarnLevel, "Function type %s has %d messages left unhandled on drain", ft.name, atomic.LoadInt64(&ft.msgsInFlight))
+		}
+	}
+
+	for _, ft := range functionTypes {
+		if ft.msgAcker != nil && !ft.msgAcker.flush(time.Until(deadline)) {
+			lg.Logf(lg.WarnLevel, "Function type %s pending acks were not flushed in time", ft.name)
+		}
+	}
+}
+
+// releaseSingleInstanceLocks unlocks KeyMutexes held by single-instance function types
+func (r *Runtime) releaseSingleInstanceLocks() {
+	r.singleInstanceRevisionsMutex.Lock()
+	defer r.singleInstanceRevisionsMutex.Unlock()
+	for ftName := range r.singleInstanceRevisions {
+		r.releaseSingleInstanceLock(ftName)
+	}
+}
+
+// releaseSingleInstanceLock must be called with singleInstanceRevisionsMutex locked
+func (r *Runtime) releaseSingleInstanceLock(ftName string) {
+	if revID, ok := r.singleInstanceRevisions[ftName]; ok {
+		system.MsgOnErrorReturn(KeyMutexUnlock(context.Background(), r, system.GetHashStr(ftName), revID))
+		delete(r.singleInstanceRevisions, ftName)
+	}
+}
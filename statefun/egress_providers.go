@@ -0,0 +1,497 @@
+package statefun
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Egress providers besides NatsCoreEgress are registered by the application with their settings, function types choose
+which one their AutoEgressSelect messages (OpMediator replies to external callers among them) go through:
+
+	jsEgress, err := statefun.NewJetstreamEgress(runtime, *statefun.NewJetstreamEgressConfig().SetMaxAge(24 * time.Hour))
+	runtime.RegisterEgressTransport(sfPlugins.JetstreamEgress, jsEgress)
+
+	statefun.NewFunctionType(runtime, "functions.app.api", api, *statefun.NewFunctionTypeConfig().
+		SetEgressProvider(sfPlugins.JetstreamEgress))
+*/
+
+const (
+	JetstreamEgressSubjectPrefix = "durable_egress"
+	JetstreamEgressSubjectsTmpl  = JetstreamEgressSubjectPrefix + ".%s.%s.%s" // domain, caller typename, caller id
+	JetstreamEgressStreamPrefix  = "durable_egress_"
+
+	WebhookEgressTimeoutSec       = 10
+	WebhookEgressMaxAttempts      = 5
+	WebhookEgressBackoffInitialMs = 500
+	WebhookEgressBackoffMaxMs     = 30000
+	WebhookEgressQueueSize        = 1024
+	WebhookEgressWorkers          = 4
+	WebhookEgressSignatureHeader  = "X-Statefun-Signature"
+	WebhookEgressTimestampHeader  = "X-Statefun-Timestamp"
+	WebhookEgressTypenameHeader   = "X-Statefun-Caller-Typename"
+	WebhookEgressIDHeader         = "X-Statefun-Caller-Id"
+
+	FileEgressMaxSizeBytes = 100 << 20
+	FileEgressMaxBackups   = 5
+)
+
+func countLostEgress(provider string, callerTypename string) {
+	if counterVec, err := system.GlobalPrometrics.EnsureCounterVecSimple("statefun_egress_lost", "Egress messages which could not be delivered", []string{"provider", "typename"}); err == nil {
+		counterVec.With(prometheus.Labels{"provider": provider, "typename": callerTypename}).Inc()
+	}
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+type JetstreamEgressConfig struct {
+	stream   string
+	maxAge   time.Duration
+	maxMsgs  int64
+	maxBytes int64
+	replicas int
+}
+
+func NewJetstreamEgressConfig() *JetstreamEgressConfig {
+	return &JetstreamEgressConfig{
+		maxMsgs:  -1,
+		maxBytes: -1,
+		replicas: 1,
+	}
+}
+
+// SetStream sets the stream name, "durable_egress_<domain>" by default
+func (jec *JetstreamEgressConfig) SetStream(stream string) *JetstreamEgressConfig {
+	jec.stream = stream
+	return jec
+}
+
+// SetMaxAge sets how long messages are kept in the stream, zero keeps them forever
+func (jec *JetstreamEgressConfig) SetMaxAge(maxAge time.Duration) *JetstreamEgressConfig {
+	jec.maxAge = maxAge
+	return jec
+}
+
+// SetMaxMsgs sets how many messages are kept in the stream, oldest are discarded first; -1 is unlimited
+func (jec *JetstreamEgressConfig) SetMaxMsgs(maxMsgs int64) *JetstreamEgressConfig {
+	jec.maxMsgs = maxMsgs
+	return jec
+}
+
+// SetMaxBytes sets the stream size limit, oldest messages are discarded first; -1 is unlimited
+func (jec *JetstreamEgressConfig) SetMaxBytes(maxBytes int64) *JetstreamEgressConfig {
+	jec.maxBytes = maxBytes
+	return jec
+}
+
+func (jec *JetstreamEgressConfig) SetReplicas(replicas int) *JetstreamEgressConfig {
+	jec.replicas = replicas
+	return jec
+}
+
+// JetstreamEgress stores egress messages in a stream on "durable_egress.<domain>.<caller typename>.<caller id>" subjects,
+// they are kept for consumers which connect later. Egress returns after the stream acknowledged the message.
+type JetstreamEgress struct {
+	r      *Runtime
+	stream string
+}
+
+// NewJetstreamEgress creates the stream or updates its retention settings
+func NewJetstreamEgress(r *Runtime, config JetstreamEgressConfig) (*JetstreamEgress, error) {
+	if r.js == nil {
+		return nil, ErrRuntimeWithoutNats
+	}
+	stream := config.stream
+	if len(stream) == 0 {
+		stream = JetstreamEgressStreamPrefix + r.Domain.name
+	}
+	sc := &nats.StreamConfig{
+		Name:      stream,
+		Subjects:  []string{fmt.Sprintf("%s.%s.>", JetstreamEgressSubjectPrefix, r.Domain.name)},
+		Retention: nats.LimitsPolicy,
+		MaxAge:    config.maxAge,
+		MaxMsgs:   config.maxMsgs,
+		MaxBytes:  config.maxBytes,
+		Replicas:  config.replicas,
+		Discard:   nats.DiscardOld,
+	}
+	if _, err := r.js.StreamInfo(stream); err == nil {
+		if _, err := r.js.UpdateStream(sc); err != nil {
+			return nil, err
+		}
+	} else if _, err := r.js.AddStream(sc); err != nil {
+		return nil, err
+	}
+	return &JetstreamEgress{r: r, stream: stream}, nil
+}
+
+func (e *JetstreamEgress) Egress(caller sfPlugins.StatefunAddress, payload *easyjson.JSON) error {
+	data := []byte("null")
+	if payload != nil {
+		data = payload.ToBytes()
+	}
+	_, err := e.r.js.Publish(fmt.Sprintf(JetstreamEgressSubjectsTmpl, e.r.Domain.name, caller.Typename, caller.ID), data, nats.ExpectStream(e.stream))
+	if err != nil {
+		countLostEgress("jetstream", caller.Typename)
+	}
+	return err
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+type WebhookEgressConfig struct {
+	url              string
+	secret           []byte
+	headers          map[string]string
+	timeout          time.Duration
+	maxAttempts      int
+	backoffInitialMs int
+	backoffMaxMs     int
+	queueSize        int
+	workers          int
+}
+
+func NewWebhookEgressConfig(url string) *WebhookEgressConfig {
+	return &WebhookEgressConfig{
+		url:              url,
+		headers:          map[string]string{},
+		timeout:          WebhookEgressTimeoutSec * time.Second,
+		maxAttempts:      WebhookEgressMaxAttempts,
+		backoffInitialMs: WebhookEgressBackoffInitialMs,
+		backoffMaxMs:     WebhookEgressBackoffMaxMs,
+		queueSize:        WebhookEgressQueueSize,
+		workers:          WebhookEgressWorkers,
+	}
+}
+
+// SetSecret makes every request signed: X-Statefun-Signature is "sha256=" + hex HMAC-SHA256 of
+// "<X-Statefun-Timestamp>.<body>" with the secret
+func (wec *WebhookEgressConfig) SetSecret(secret string) *WebhookEgressConfig {
+	wec.secret = []byte(secret)
+	return wec
+}
+
+func (wec *WebhookEgressConfig) SetHeader(key string, value string) *WebhookEgressConfig {
+	wec.headers[key] = value
+	return wec
+}
+
+func (wec *WebhookEgressConfig) SetTimeout(timeout time.Duration) *WebhookEgressConfig {
+	wec.timeout = timeout
+	return wec
+}
+
+// SetRetry sets how many times a message is posted until the endpoint accepts it, waiting between attempts grows
+// exponentially from initialMs up to maxMs
+func (wec *WebhookEgressConfig) SetRetry(maxAttempts int, backoffInitialMs int, backoffMaxMs int) *WebhookEgressConfig {
+	wec.maxAttempts = maxAttempts
+	wec.backoffInitialMs = backoffInitialMs
+	wec.backoffMaxMs = backoffMaxMs
+	return wec
+}
+
+// SetQueue sets how many messages can wait for delivery and how many are posted concurrently
+func (wec *WebhookEgressConfig) SetQueue(queueSize int, workers int) *WebhookEgressConfig {
+	wec.queueSize = queueSize
+	wec.workers = workers
+	return wec
+}
+
+type webhookEgressMsg struct {
+	caller sfPlugins.StatefunAddress
+	body   []byte
+}
+
+// WebhookEgress posts egress message payloads to an HTTP endpoint. Egress only queues the message, it is posted in the
+// background and retried on network errors, 429 and 5xx responses. On shutdown queued messages are posted without waiting between attempts.
+type WebhookEgress struct {
+	config  WebhookEgressConfig
+	client  *http.Client
+	queue   chan webhookEgressMsg
+	closing chan struct{}
+	closed  bool
+	mutex   sync.RWMutex
+	wg      sync.WaitGroup
+}
+
+func NewWebhookEgress(config WebhookEgressConfig) *WebhookEgress {
+	e := &WebhookEgress{
+		config:  config,
+		client:  &http.Client{Timeout: config.timeout},
+		queue:   make(chan webhookEgressMsg, config.queueSize),
+		closing: make(chan struct{}),
+	}
+	for i := 0; i < config.workers; i++ {
+		e.wg.Add(1)
+		go e.worker()
+	}
+	return e
+}
+
+func (e *WebhookEgress) Egress(caller sfPlugins.StatefunAddress, payload *easyjson.JSON) error {
+	body := []byte("null")
+	if payload != nil {
+		body = payload.ToBytes()
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.closed {
+		countLostEgress("webhook", caller.Typename)
+		return fmt.Errorf("webhook egress is closed")
+	}
+	select {
+	case e.queue <- webhookEgressMsg{caller: caller, body: body}:
+		return nil
+	default:
+		countLostEgress("webhook", caller.Typename)
+		return fmt.Errorf("webhook egress queue is full")
+	}
+}
+
+// Close stops accepting messages and waits until queued ones are posted
+func (e *WebhookEgress) Close() error {
+	e.mutex.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.closing)
+		close(e.queue)
+	}
+	e.mutex.Unlock()
+	e.wg.Wait()
+	return nil
+}
+
+func (e *WebhookEgress) worker() {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("WebhookEgress.worker")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("WebhookEgress.worker")
+	defer e.wg.Done()
+
+	for msg := range e.queue {
+		e.deliver(msg)
+	}
+}
+
+func (e *WebhookEgress) deliver(msg webhookEgressMsg) {
+	backoff := time.Duration(e.config.backoffInitialMs) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		retryable, err := e.post(msg)
+		if err == nil {
+			return
+		}
+		if !retryable || attempt >= e.config.maxAttempts {
+			lg.Logf(lg.ErrorLevel, "webhook egress: message from %s with id=%s is lost after %d attempts: %s", msg.caller.Typename, msg.caller.ID, attempt, err)
+			countLostEgress("webhook", msg.caller.Typename)
+			return
+		}
+		lg.Logf(lg.WarnLevel, "webhook egress: attempt %d for message from %s with id=%s failed: %s", attempt, msg.caller.Typename, msg.caller.ID, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-e.closing:
+			// Shutting down, the last attempt is made right away
+			attempt = e.config.maxAttempts - 1
+		}
+		backoff *= 2
+		if maxBackoff := time.Duration(e.config.backoffMaxMs) * time.Millisecond; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (e *WebhookEgress) post(msg webhookEgressMsg) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, e.config.url, bytes.NewReader(msg.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEgressTypenameHeader, msg.caller.Typename)
+	req.Header.Set(WebhookEgressIDHeader, msg.caller.ID)
+	for key, value := range e.config.headers {
+		req.Header.Set(key, value)
+	}
+	if len(e.config.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookEgressTimestampHeader, timestamp)
+		req.Header.Set(WebhookEgressSignatureHeader, SignWebhookEgress(e.config.secret, timestamp, msg.body))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, fmt.Errorf("endpoint replied with status %d", resp.StatusCode)
+}
+
+// SignWebhookEgress returns the X-Statefun-Signature value, receivers use it to verify requests
+func SignWebhookEgress(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+type FileEgressConfig struct {
+	path         string
+	maxSizeBytes int64
+	maxBackups   int
+	sync         bool
+}
+
+func NewFileEgressConfig(path string) *FileEgressConfig {
+	return &FileEgressConfig{
+		path:         path,
+		maxSizeBytes: FileEgressMaxSizeBytes,
+		maxBackups:   FileEgressMaxBackups,
+	}
+}
+
+// SetRotation sets the file size after which it is renamed to "<path>.1" (older files are shifted up to
+// "<path>.<maxBackups>", the oldest one is removed)
+func (fec *FileEgressConfig) SetRotation(maxSizeBytes int64, maxBackups int) *FileEgressConfig {
+	fec.maxSizeBytes = maxSizeBytes
+	fec.maxBackups = maxBackups
+	return fec
+}
+
+// SetSync makes every record flushed to disk before Egress returns
+func (fec *FileEgressConfig) SetSync(sync bool) *FileEgressConfig {
+	fec.sync = sync
+	return fec
+}
+
+// FileEgress appends egress messages to a local NDJSON file:
+// {"time":<unix ms>,"caller_typename":...,"caller_id":...,"payload":...}
+type FileEgress struct {
+	config FileEgressConfig
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+}
+
+func NewFileEgress(config FileEgressConfig) (*FileEgress, error) {
+	e := &FileEgress{config: config}
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *FileEgress) open() error {
+	file, err := os.OpenFile(e.config.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	e.file, e.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the file to the first backup shifting older ones. On error the rotation is aborted and the file stays
+// open for appending if possible.
+func (e *FileEgress) rotate() error {
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", e.config.path, i) }
+	if e.config.maxBackups > 0 {
+		if err := os.Remove(backup(e.config.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for i := e.config.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(backup(i), backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	if err := e.file.Close(); err != nil {
+		e.file = nil
+		return err
+	}
+	e.file = nil
+	var err error
+	if e.config.maxBackups > 0 {
+		err = os.Rename(e.config.path, backup(1))
+	} else {
+		err = os.Remove(e.config.path)
+	}
+	if err != nil {
+		system.MsgOnErrorReturn(e.open())
+		return err
+	}
+	return e.open()
+}
+
+func (e *FileEgress) Egress(caller sfPlugins.StatefunAddress, payload *easyjson.JSON) error {
+	record := easyjson.NewJSONObject()
+	record.SetByPath("time", easyjson.NewJSON(time.Now().UnixMilli()))
+	record.SetByPath("caller_typename", easyjson.NewJSON(caller.Typename))
+	record.SetByPath("caller_id", easyjson.NewJSON(caller.ID))
+	if payload != nil {
+		record.SetByPath("payload", *payload)
+	}
+	line := append(record.ToBytes(), '\n')
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.file == nil {
+		countLostEgress("file", caller.Typename)
+		return fmt.Errorf("file egress is closed")
+	}
+	if e.config.maxSizeBytes > 0 && e.size > 0 && e.size+int64(len(line)) > e.config.maxSizeBytes {
+		if err := e.rotate(); err != nil {
+			lg.Logf(lg.ErrorLevel, "File egress %s cannot be rotated: %s", e.config.path, err)
+			if e.file == nil {
+				countLostEgress("file", caller.Typename)
+				return err
+			}
+		}
+	}
+	n, err := e.file.Write(line)
+	e.size += int64(n)
+	if err == nil && e.config.sync {
+		err = e.file.Sync()
+	}
+	if err != nil {
+		countLostEgress("file", caller.Typename)
+	}
+	return err
+}
+
+func (e *FileEgress) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close()
+	e.file = nil
+	return err
+}
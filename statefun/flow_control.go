@@ -0,0 +1,195 @@
+package statefun
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfMediators "github.com/foliagecp/sdk/statefun/mediator"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+// RateLimitAction defines what happens to a message which exceeds the function type's rate limits
+type RateLimitAction int
+
+const (
+	RateLimitNakWithDelay RateLimitAction = iota // JetStream signals are redelivered when the limit allows, requests are rejected
+	RateLimitReject                              // Requests are replied with a failed OpMsg, signals are dropped
+	RateLimitDrop                                // Signals are dropped, only the metric is increased, requests are rejected
+)
+
+const (
+	MsgPriorityOptionKey = "priority"
+	MsgPriorityControl   = "control"
+	MsgPriorityBulk      = "bulk"
+
+	rateLimitMaxDelay = time.Minute
+)
+
+func (a RateLimitAction) String() string {
+	switch a {
+	case RateLimitNakWithDelay:
+		return "nak_with_delay"
+	case RateLimitReject:
+		return "reject"
+	case RateLimitDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+/*
+idMsgQueue is an id handler's message queue with two priority lanes. Messages with the "priority" option set to "control"
+go to the control lane which is always emptied first, all others go to the bulk lane. Bulk rate limits are not applied to
+the control lane, so control messages get through even when a chatty producer has used up the limits. The control lane has
+its own optional limit instead, since the priority option is set by the caller.
+*/
+type idMsgQueue struct {
+	control chan FunctionTypeMsg
+	bulk    chan FunctionTypeMsg
+}
+
+func newIDMsgQueue(size int) *idMsgQueue {
+	return &idMsgQueue{
+		control: make(chan FunctionTypeMsg, size),
+		bulk:    make(chan FunctionTypeMsg, size),
+	}
+}
+
+func isControlMsg(msg FunctionTypeMsg) bool {
+	return msg.Options != nil && msg.Options.GetByPath(MsgPriorityOptionKey).AsStringDefault(MsgPriorityBulk) == MsgPriorityControl
+}
+
+func (q *idMsgQueue) push(msg FunctionTypeMsg) bool {
+	lane := q.bulk
+	if isControlMsg(msg) {
+		lane = q.control
+	}
+	select {
+	case lane <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// pop returns the next message, control lane first. Returns false when the queue is closed and empty.
+func (q *idMsgQueue) pop() (FunctionTypeMsg, bool) {
+	select {
+	case msg, ok := <-q.control:
+		if ok {
+			return msg, true
+		}
+	default:
+	}
+
+	select {
+	case msg, ok := <-q.control:
+		if ok {
+			return msg, true
+		}
+		// Control lane is closed, so is the bulk one
+		msg, ok = <-q.bulk
+		return msg, ok
+	case msg, ok := <-q.bulk:
+		if ok {
+			return msg, true
+		}
+		// Bulk lane is closed, drain the control one
+		msg, ok = <-q.control
+		return msg, ok
+	}
+}
+
+func (q *idMsgQueue) len() int {
+	return len(q.control) + len(q.bulk)
+}
+
+func (q *idMsgQueue) close() {
+	close(q.control)
+	close(q.bulk)
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+func newRateLimiter(ratePerSec float64, burst int) *rate.Limiter {
+	if ratePerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(ratePerSec), burst)
+}
+
+func (ft *FunctionType) getIDRateLimiter(id string) *rate.Limiter {
+	if ft.config.idRateLimit <= 0 {
+		return nil
+	}
+	v, _ := ft.idRateLimiters.LoadOrStore(id, newRateLimiter(ft.config.idRateLimit, ft.config.idRateBurst))
+	return v.(*rate.Limiter)
+}
+
+// reserveRate takes a token from every bucket, or returns how long to wait for them
+func reserveRate(limiters ...*rate.Limiter) (delay time.Duration, allowed bool) {
+	now := time.Now()
+	reservations := []*rate.Reservation{}
+	for _, limiter := range limiters {
+		if limiter == nil {
+			continue
+		}
+		reservation := limiter.ReserveN(now, 1)
+		if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+			delay = rateLimitMaxDelay
+			if reservation.OK() && reservation.DelayFrom(now) < delay {
+				delay = reservation.DelayFrom(now)
+			}
+			reservation.CancelAt(now)
+			for _, r := range reservations {
+				r.CancelAt(now)
+			}
+			return delay, false
+		}
+		reservations = append(reservations, reservation)
+	}
+	return 0, true
+}
+
+func (ft *FunctionType) rateLimited(id string, msg FunctionTypeMsg, delay time.Duration) {
+	action := ft.config.rateLimitAction
+	if counterVec, err := system.GlobalPrometrics.EnsureCounterVecSimple("statefun_rate_limited", "Messages exceeded function type rate limits", []string{"typename", "action"}); err == nil {
+		counterVec.With(prometheus.Labels{"typename": ft.name, "action": action.String()}).Inc()
+	}
+	lg.Logf(lg.TraceLevel, "Function type %s with id=%s is rate limited, action: %s", ft.name, id, action)
+
+	switch action {
+	case RateLimitNakWithDelay:
+		if msg.DelayCallback != nil {
+			msg.DelayCallback(delay)
+			return
+		}
+		if msg.RequestCallback == nil {
+			if msg.RefusalCallback != nil {
+				msg.RefusalCallback()
+			}
+			return
+		}
+		fallthrough // Requests cannot be delayed
+	case RateLimitReject:
+		if msg.RequestCallback != nil {
+			msg.RequestCallback(sfMediators.OpMsgFailed("rate limit exceeded").ToJson())
+			return
+		}
+		lg.Logf(lg.WarnLevel, "Signal for function type %s with id=%s is rejected: rate limit exceeded", ft.name, id)
+		if msg.AckCallback != nil {
+			msg.AckCallback(true)
+		}
+	case RateLimitDrop:
+		if msg.RequestCallback != nil { // Requester waits for a reply, so it is not dropped silently
+			msg.RequestCallback(sfMediators.OpMsgFailed("rate limit exceeded").ToJson())
+			return
+		}
+		if msg.AckCallback != nil {
+			msg.AckCallback(true)
+		}
+	}
+}
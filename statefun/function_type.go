@@ -15,6 +15,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/foliagecp/easyjson"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/time/rate"
 
 	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
 	"github.com/foliagecp/sdk/statefun/system"
@@ -28,6 +30,7 @@ type FunctionType struct {
 	subject                 string
 	config                  FunctionTypeConfig
 	logicHandler            FunctionLogicHandler
+	interceptors            []FunctionInterceptor
 	idKeyMutex              system.KeyMutex
 	idHandlersChannel       sync.Map
 	idHandlersLastMsgTime   sync.Map
@@ -35,13 +38,34 @@ type FunctionType struct {
 	executor                *sfPlugins.TypenameExecutorPlugin
 	instancesControlChannel chan struct{}
 	resourceMutex           sync.Mutex
+	deliveryErrors          sync.Map
+	rateLimitDelays         sync.Map
+	idPanics                sync.Map
+	schema                  *easyjson.JSON
+	subscriptions           []*nats.Subscription
+	msgAcker                *msgAcker
+	msgsInFlight            int64
+	draining                atomic.Bool
+	stopped                 chan struct{}
+	rateLimiter             *rate.Limiter
+	controlRateLimiter      *rate.Limiter
+	idRateLimiters          sync.Map
+	localSignalBacklogs     sync.Map
 }
 
 const (
-	contextExpirationKey = "____ctx_expires_after_ms"
+	// Expiration of contexts written before expirations moved into the cache
+	legacyContextExpirationKey = "____ctx_expires_after_ms"
 )
 
+// NewFunctionType registers a function type before the runtime is started, see Runtime.RegisterFunctionType for a running one
 func NewFunctionType(runtime *Runtime, name string, logicHandler FunctionLogicHandler, config FunctionTypeConfig) *FunctionType {
+	ft := newFunctionType(runtime, name, logicHandler, config)
+	runtime.addFunctionType(ft)
+	return ft
+}
+
+func newFunctionType(runtime *Runtime, name string, logicHandler FunctionLogicHandler, config FunctionTypeConfig) *FunctionType {
 	ft := &FunctionType{
 		runtime:                 runtime,
 		name:                    name,
@@ -50,11 +74,13 @@ func NewFunctionType(runtime *Runtime, name string, logicHandler FunctionLogicHa
 		idKeyMutex:              system.NewKeyMutex(),
 		config:                  config,
 		instancesControlChannel: nil,
+		stopped:                 make(chan struct{}),
+		rateLimiter:             newRateLimiter(config.typeRateLimit, config.typeRateBurst),
+		controlRateLimiter:      newRateLimiter(config.controlRateLimit, config.controlRateBurst),
 	}
 	if config.maxIdHandlers > 0 {
 		ft.instancesControlChannel = make(chan struct{}, config.maxIdHandlers)
 	}
-	runtime.registeredFunctionTypes[ft.name] = ft
 	return ft
 }
 
@@ -68,14 +94,30 @@ func (ft *FunctionType) SetExecutor(alias string, content string, constructor fu
 func (ft *FunctionType) sendMsg(originId string, msg FunctionTypeMsg) {
 	id := ft.runtime.Domain.CreateObjectIDWithThisDomain(originId, false)
 
+	if ft.draining.Load() || ft.isQuarantined(id) {
+		if msg.RefusalCallback != nil {
+			msg.RefusalCallback()
+		}
+		return
+	}
+
+	limiters := []*rate.Limiter{ft.controlRateLimiter}
+	if !isControlMsg(msg) {
+		limiters = []*rate.Limiter{ft.rateLimiter, ft.getIDRateLimiter(id)}
+	}
+	if delay, allowed := reserveRate(limiters...); !allowed {
+		ft.rateLimited(id, msg, delay)
+		return
+	}
+
 	ft.idKeyMutex.Lock(id)
 	defer ft.idKeyMutex.Unlock(id)
 
 	// Send msg to type id handler ------------------------------------------------------
-	var msgChannel chan FunctionTypeMsg
+	var msgQueue *idMsgQueue
 
 	if value, ok := ft.idHandlersChannel.Load(id); ok {
-		msgChannel = value.(chan FunctionTypeMsg)
+		msgQueue = value.(*idMsgQueue)
 	} else {
 		// Limit typename's max id handlers running -------
 		if ft.instancesControlChannel != nil {
@@ -88,18 +130,18 @@ func (ft *FunctionType) sendMsg(originId string, msg FunctionTypeMsg) {
 		}
 		// ------------------------------------------------
 
-		msgChannel = make(chan FunctionTypeMsg, ft.config.msgChannelSize)
+		msgQueue = newIDMsgQueue(ft.config.msgChannelSize)
 
-		go ft.idHandlerRoutine(id, msgChannel)
-		ft.idHandlersChannel.Store(id, msgChannel)
+		go ft.idHandlerRoutine(id, msgQueue)
+		ft.idHandlersChannel.Store(id, msgQueue)
 		if ft.executor != nil {
 			ft.executor.AddForID(id)
 		}
 	}
 	ft.idHandlersLastMsgTime.Store(id, time.Now().UnixNano())
 
-	select {
-	case msgChannel <- msg:
+	atomic.AddInt64(&ft.msgsInFlight, 1)
+	if msgQueue.push(msg) {
 		// Debug values update ----------------------------
 		gc := atomic.LoadInt64(&ft.runtime.gc)
 
@@ -110,7 +152,8 @@ func (ft *FunctionType) sendMsg(originId string, msg FunctionTypeMsg) {
 		}
 		atomic.AddInt64(&ft.runtime.gc, 1)
 		// ------------------------------------------------
-	default:
+	} else {
+		atomic.AddInt64(&ft.msgsInFlight, -1)
 		if msg.RefusalCallback != nil {
 			msg.RefusalCallback()
 		}
@@ -118,17 +161,27 @@ func (ft *FunctionType) sendMsg(originId string, msg FunctionTypeMsg) {
 	// ----------------------------------------------------------------------------------
 }
 
-func (ft *FunctionType) idHandlerRoutine(id string, msgChannel chan FunctionTypeMsg) {
+func (ft *FunctionType) idHandlerRoutine(id string, msgQueue *idMsgQueue) {
 	system.GlobalPrometrics.GetRoutinesCounter().Started("functiontype-idHandlerRoutine")
 	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("functiontype-idHandlerRoutine")
+	var transactionID string // Transaction contexts of the message being handled are written in, empty if there is none
 	typenameIDContextProcessor := sfPlugins.StatefunContextProcessor{
-		GetFunctionContext:        func() *easyjson.JSON { return ft.getContext(ft.name + "." + id) },
-		SetFunctionContext:        func(context *easyjson.JSON) { ft.setContext(ft.name+"."+id, context) },
-		SetContextExpirationAfter: func(after time.Duration) { ft.setContextExpirationAfter(ft.name+"."+id, after) },
-		GetObjectContext:          func() *easyjson.JSON { return ft.getContext(id) },
-		SetObjectContext:          func(context *easyjson.JSON) { ft.setContext(id, context) },
-		Domain:                    ft.runtime.Domain,
-		Self:                      sfPlugins.StatefunAddress{Typename: ft.name, ID: id},
+		GetFunctionContext: func() *easyjson.JSON {
+			return ft.getVersionedContext(ft.name+"."+id, ft.config.functionContextMigrations, transactionID)
+		},
+		SetFunctionContext: func(context *easyjson.JSON) {
+			ft.setVersionedContext(ft.name+"."+id, context, ft.config.functionContextMigrations, false, transactionID)
+		},
+		SetContextExpirationAfter:       func(after time.Duration) { ft.setContextExpirationAfter(ft.name+"."+id, functionContextKind, after) },
+		SetObjectContextExpirationAfter: func(after time.Duration) { ft.setContextExpirationAfter(id, objectContextKind, after) },
+		GetObjectContext: func() *easyjson.JSON {
+			return ft.getVersionedContext(id, ft.config.objectContextMigrations, transactionID)
+		},
+		SetObjectContext: func(context *easyjson.JSON) {
+			ft.setVersionedContext(id, context, ft.config.objectContextMigrations, true, transactionID)
+		},
+		Domain: ft.runtime.Domain,
+		Self:   sfPlugins.StatefunAddress{Typename: ft.name, ID: id},
 		Signal: func(signalProvider sfPlugins.SignalProvider, targetTypename string, targetID string, j *easyjson.JSON, o *easyjson.JSON) error {
 			return ft.runtime.signal(signalProvider, ft.name, id, targetTypename, targetID, j, o)
 		},
@@ -142,6 +195,15 @@ func (ft *FunctionType) idHandlerRoutine(id string, msgChannel chan FunctionType
 			}
 			return ft.runtime.egress(egressProvider, ft.name, egressId, j)
 		},
+		SignalAfter: func(signalProvider sfPlugins.SignalProvider, delay time.Duration, targetTypename string, targetID string, j *easyjson.JSON, o *easyjson.JSON) (string, error) {
+			return ft.runtime.signalAt(signalProvider, time.Now().Add(delay), ft.name, id, targetTypename, targetID, j, o)
+		},
+		SignalAt: func(signalProvider sfPlugins.SignalProvider, at time.Time, targetTypename string, targetID string, j *easyjson.JSON, o *easyjson.JSON) (string, error) {
+			return ft.runtime.signalAt(signalProvider, at, ft.name, id, targetTypename, targetID, j, o)
+		},
+		CancelTimer: func(handle string) error {
+			return ft.runtime.cancelTimer(handle)
+		},
 		// To be assigned later:
 		// Call: ...
 		// Payload: ...
@@ -149,20 +211,32 @@ func (ft *FunctionType) idHandlerRoutine(id string, msgChannel chan FunctionType
 		// Caller: ...
 	}
 
-	for msg := range msgChannel {
-		ft.handleMsgForID(id, msg, &typenameIDContextProcessor)
+	for msg, ok := msgQueue.pop(); ok; msg, ok = msgQueue.pop() {
+		ft.handleMsgForID(id, msg, &typenameIDContextProcessor, &transactionID)
+		atomic.AddInt64(&ft.msgsInFlight, -1)
 	}
 	if ft.instancesControlChannel != nil {
 		<-ft.instancesControlChannel
 	}
 }
 
-func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameIDContextProcessor *sfPlugins.StatefunContextProcessor) {
+func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameIDContextProcessor *sfPlugins.StatefunContextProcessor, transactionID *string) {
 	ft.idRunningStatus.Store(id, true)
 	defer ft.idRunningStatus.Store(id, false)
 
+	idempotencyKey := ft.getIdempotencyKey(msg)
+	if len(idempotencyKey) > 0 {
+		if ft.isProcessed(id, idempotencyKey) {
+			ft.skipDuplicate(id, idempotencyKey, msg)
+			return
+		}
+		*transactionID = ft.beginIdempotentTransaction(id)
+		defer func() { *transactionID = "" }()
+	}
+
 	msgRequestCallback := msg.RequestCallback
 	replyDataChannel := make(chan *easyjson.JSON, 1)
+	typenameIDContextProcessor.Reply = nil // Context processor is reused by id's messages, signals must not see a reply of the previous request
 	if msgRequestCallback != nil {
 		typenameIDContextProcessor.Reply = &sfPlugins.SyncReply{}
 
@@ -180,6 +254,7 @@ func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameI
 			cancelReplyIfExists()
 			replyDataChannel <- data // Put new value that will replace existing
 		}
+		typenameIDContextProcessor.Reply.Stream = msg.streamChunk
 		typenameIDContextProcessor.Reply.OverrideRequestCallback = func() *sfPlugins.SyncReply {
 			msgRequestCallback = nil
 
@@ -187,6 +262,7 @@ func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameI
 			overridenReply.With = func(data *easyjson.JSON) {
 				msg.RequestCallback(data)
 			}
+			overridenReply.Stream = msg.streamChunk
 			overridenReply.CancelDefaultReply = func() {}
 			overridenReply.OverrideRequestCallback = func() *sfPlugins.SyncReply { return nil }
 			return overridenReply
@@ -209,9 +285,9 @@ func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameI
 		lockId := fmt.Sprintf("%s-lock", objectId)
 		revId, err := KeyMutexLock(context.TODO(), ft.runtime, lockId, errorOnLocked)
 		if err == nil {
-			objCtx := ft.getContext(lockId)
+			objCtx := ft.getContext(lockId, "")
 			objCtx.SetByPath("__lock_rev_id", easyjson.NewJSON(revId))
-			ft.setContext(lockId, objCtx)
+			ft.setContext(lockId, objCtx, "")
 			return nil
 		}
 		return err
@@ -219,7 +295,7 @@ func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameI
 	typenameIDContextProcessor.ObjectMutexUnlock = func(objectId string) error {
 		lockId := fmt.Sprintf("%s-lock", objectId)
 
-		objCtx := ft.getContext(lockId)
+		objCtx := ft.getContext(lockId, "")
 		v, ok := objCtx.GetByPath("__lock_rev_id").AsNumeric()
 		if !ok {
 			return fmt.Errorf("object:%s was not locked", lockId)
@@ -237,11 +313,11 @@ func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameI
 	start := time.Now()
 
 	// Calling typename handler function --------------------
+	var executor sfPlugins.StatefunExecutor = nil
 	if ft.executor != nil {
-		ft.logicHandler(ft.executor.GetForID(id), typenameIDContextProcessor)
-	} else {
-		ft.logicHandler(nil, typenameIDContextProcessor)
+		executor = ft.executor.GetForID(id)
 	}
+	panicErr := ft.callLogicHandler(ft.getInterceptedLogicHandler(), executor, typenameIDContextProcessor)
 	// -------------------------------------------------------
 
 	measureName := fmt.Sprintf("%s_execution_time", strings.ReplaceAll(ft.name, ".", ""))
@@ -249,6 +325,37 @@ func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameI
 		gaugeVec.With(prometheus.Labels{"id": id}).Set(float64(time.Since(start).Microseconds()))
 	}
 
+	if panicErr != nil {
+		if len(*transactionID) > 0 {
+			ft.runtime.Domain.cache.TransactionAbort(*transactionID)
+		}
+		ft.registerHandlerPanic(id, panicErr)
+		if msg.ErrorCallback != nil {
+			msg.ErrorCallback(panicErr)
+		} else if msg.AckCallback != nil {
+			msg.AckCallback(false)
+		}
+		if msgRequestCallback != nil {
+			if msg.ReplyStream != nil {
+				msg.ReplyStream.fail(panicErr)
+			} else {
+				msgRequestCallback(panicErr.ToOpMsg().ToJson())
+			}
+		}
+		atomic.StoreInt64(&ft.runtime.glce, time.Now().UnixNano())
+		return
+	}
+	if len(idempotencyKey) > 0 {
+		if err := ft.markProcessed(id, idempotencyKey, *transactionID); err != nil {
+			lg.Logf(lg.WarnLevel, "Function type %s with id=%s cannot commit contexts of signal with idempotency key %s, redelivering: %s", ft.name, id, idempotencyKey, err)
+			if msg.AckCallback != nil {
+				msg.AckCallback(false)
+			}
+			return
+		}
+	}
+	ft.registerHandlerSuccess(id)
+
 	if msg.AckCallback != nil {
 		msg.AckCallback(true)
 	}
@@ -268,16 +375,7 @@ func (ft *FunctionType) handleMsgForID(id string, msg FunctionTypeMsg, typenameI
 func (ft *FunctionType) gc(typenameIDLifetimeMs int) (garbageCollected int, handlersRunning int) {
 	now := time.Now().UnixNano()
 
-	// Deleting function contexts which are expired ---------
-	for _, funcCtxKey := range ft.runtime.Domain.Cache().GetKeysByPattern(ft.name + ".>") {
-		expirationTime := int64(ft.getContext(funcCtxKey).GetByPath(contextExpirationKey).AsNumericDefault(-1))
-		if expirationTime > 0 {
-			if expirationTime < now {
-				ft.runtime.Domain.Cache().DeleteValue(funcCtxKey, true, -1, "")
-			}
-		}
-	}
-	// ------------------------------------------------------
+	ft.gcDeliveryErrors(now)
 
 	ft.idHandlersLastMsgTime.Range(func(key, value interface{}) bool {
 		id := key.(string)
@@ -293,13 +391,16 @@ func (ft *FunctionType) gc(typenameIDLifetimeMs int) (garbageCollected int, hand
 					return true
 				}
 			}
+			if !ft.removeLocalSignalBacklog(id) { // Local signals are still waiting for the id handler
+				return true
+			}
 
 			ft.idKeyMutex.Lock(id)
 
 			v, _ := ft.idHandlersChannel.Load(id)
-			msgChannel := v.(chan FunctionTypeMsg)
-			close(msgChannel)
+			v.(*idMsgQueue).close()
 			ft.idHandlersChannel.Delete(id)
+			ft.idRateLimiters.Delete(id)
 			ft.idHandlersLastMsgTime.Delete(id)
 			ft.idRunningStatus.Delete(id)
 			if ft.executor != nil {
@@ -322,34 +423,39 @@ func (ft *FunctionType) gc(typenameIDLifetimeMs int) (garbageCollected int, hand
 	return
 }
 
-func (ft *FunctionType) getContext(keyValueID string) *easyjson.JSON {
-	if j, err := ft.runtime.Domain.cache.GetValueAsJSON(keyValueID); err == nil {
-		return j
+// getContext returns the context as the transaction sees it, empty transactionID - as the cache has it
+func (ft *FunctionType) getContext(keyValueID string, transactionID string) *easyjson.JSON {
+	var value []byte
+	var err error
+	if len(transactionID) > 0 {
+		value, err = ft.runtime.Domain.cache.TransactionGetValue(transactionID, keyValueID)
+	} else {
+		value, err = ft.runtime.Domain.cache.GetValue(keyValueID)
+	}
+	if err == nil {
+		if j, ok := easyjson.JSONFromBytes(value); ok {
+			return &j
+		}
 	}
 	j := easyjson.NewJSONObject()
 	return &j
 }
 
-func (ft *FunctionType) setContext(keyValueID string, context *easyjson.JSON) {
+func (ft *FunctionType) setContext(keyValueID string, context *easyjson.JSON, transactionID string) {
 	if context == nil {
-		ft.runtime.Domain.cache.DeleteValue(keyValueID, true, -1, "")
-	} else {
-		ft.runtime.Domain.cache.SetValue(keyValueID, context.ToBytes(), true, -1, "")
-	}
-}
-
-// Negative duration removes expiration
-func (ft *FunctionType) setContextExpirationAfter(keyValueID string, after time.Duration) {
-	if j, err := ft.runtime.Domain.cache.GetValueAsJSON(keyValueID); err == nil {
-		if after < 0 {
-			j.RemoveByPath(contextExpirationKey)
-		} else {
-			j.SetByPath(contextExpirationKey, easyjson.NewJSON(time.Now().Add(after).UnixNano()))
+		if len(transactionID) == 0 {
+			ft.runtime.forgetContextExpiration(keyValueID)
 		}
-		ft.runtime.Domain.cache.SetValue(keyValueID, j.ToBytes(), true, -1, "")
+		ft.runtime.Domain.cache.DeleteValue(keyValueID, true, -1, transactionID)
+	} else {
+		ft.runtime.Domain.cache.SetValue(keyValueID, context.ToBytes(), true, -1, transactionID)
 	}
 }
 
 func (ft *FunctionType) getStreamName() string {
 	return fmt.Sprintf("%s_stream", system.GetHashStr(ft.subject))
 }
+
+func (ft *FunctionType) getConsumerName() string {
+	return ft.runtime.Domain.name + "-" + strings.ReplaceAll(ft.name, ".", "")
+}
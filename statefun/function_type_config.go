@@ -3,6 +3,8 @@
 package statefun
 
 import (
+	"time"
+
 	"github.com/foliagecp/easyjson"
 	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
 )
@@ -15,19 +17,43 @@ const (
 	MutexLifetimeSec         = 120
 	MultipleInstancesAllowed = false
 	MaxIdHandlers            = 20
+	MsgMaxDeliver            = -1
+	MsgRetryBackoffInitialMs = 0
+	MsgRetryBackoffMaxMs     = 60000
+	ControlRateLimit         = 0 // Unlimited
+	ControlRateBurst         = 0
 )
 
 type FunctionTypeConfig struct {
-	msgAckWaitMs             int
-	msgChannelSize           int
-	msgAckChannelSize        int
-	balanceNeeded            bool
-	mutexLifeTimeSec         int
-	options                  *easyjson.JSON
-	multipleInstancesAllowed bool
-	maxIdHandlers            int
-	allowedSignalProviders   map[sfPlugins.SignalProvider]struct{}
-	allowedRequestProviders  map[sfPlugins.RequestProvider]struct{}
+	msgAckWaitMs              int
+	msgChannelSize            int
+	msgAckChannelSize         int
+	balanceNeeded             bool
+	mutexLifeTimeSec          int
+	options                   *easyjson.JSON
+	multipleInstancesAllowed  bool
+	maxIdHandlers             int
+	allowedSignalProviders    map[sfPlugins.SignalProvider]struct{}
+	allowedRequestProviders   map[sfPlugins.RequestProvider]struct{}
+	maxDeliver                int
+	retryBackoffInitialMs     int
+	retryBackoffMaxMs         int
+	scheduleInterval          time.Duration
+	scheduleIDs               []string
+	quarantinePanics          int
+	quarantineDuration        time.Duration
+	typeRateLimit             float64
+	typeRateBurst             int
+	idRateLimit               float64
+	idRateBurst               int
+	controlRateLimit          float64
+	controlRateBurst          int
+	rateLimitAction           RateLimitAction
+	idempotencyTTL            time.Duration
+	egressProvider            sfPlugins.EgressProvider
+	functionContextMigrations []ContextMigrationFunc
+	objectContextMigrations   []ContextMigrationFunc
+	contextExpiredSignal      bool
 }
 
 func NewFunctionTypeConfig() *FunctionTypeConfig {
@@ -42,6 +68,12 @@ func NewFunctionTypeConfig() *FunctionTypeConfig {
 		maxIdHandlers:            MaxIdHandlers,
 		allowedSignalProviders:   map[sfPlugins.SignalProvider]struct{}{},
 		allowedRequestProviders:  map[sfPlugins.RequestProvider]struct{}{},
+		maxDeliver:               MsgMaxDeliver,
+		retryBackoffInitialMs:    MsgRetryBackoffInitialMs,
+		retryBackoffMaxMs:        MsgRetryBackoffMaxMs,
+		controlRateLimit:         ControlRateLimit,
+		controlRateBurst:         ControlRateBurst,
+		egressProvider:           sfPlugins.NatsCoreEgress,
 	}
 	ft.allowedSignalProviders[sfPlugins.AutoSignalSelect] = struct{}{}
 	return ft
@@ -93,6 +125,17 @@ func (ftc *FunctionTypeConfig) IsSignalProviderAllowed(signalProvider sfPlugins.
 	return false
 }
 
+// preferredSignalProvider returns a provider the runtime signals the function type with on its own (schedule ticks,
+// expiration events): automatic selection or JetStream if allowed, otherwise any allowed one
+func (ftc *FunctionTypeConfig) preferredSignalProvider() sfPlugins.SignalProvider {
+	for _, provider := range []sfPlugins.SignalProvider{sfPlugins.AutoSignalSelect, sfPlugins.JetstreamGlobalSignal, sfPlugins.GolangLocalSignal, sfPlugins.InMemorySignal} {
+		if _, ok := ftc.allowedSignalProviders[provider]; ok {
+			return provider
+		}
+	}
+	return sfPlugins.AutoSignalSelect
+}
+
 func (ftc *FunctionTypeConfig) IsRequestProviderAllowed(requestProvider sfPlugins.RequestProvider) bool {
 	if _, ok := ftc.allowedRequestProviders[sfPlugins.AutoRequestSelect]; ok {
 		return true
@@ -122,3 +165,139 @@ func (ftc *FunctionTypeConfig) SetMaxIdHandlers(maxIdHandlers int) *FunctionType
 	ftc.maxIdHandlers = maxIdHandlers
 	return ftc
 }
+
+// SetMaxDeliver sets how many times a JetStream signal may be delivered to the function type.
+// A signal that is still not acked after maxDeliver deliveries is moved into the function type's
+// dead-letter stream. Non-positive value means unlimited redelivery and no dead-lettering.
+func (ftc *FunctionTypeConfig) SetMaxDeliver(maxDeliver int) *FunctionTypeConfig {
+	ftc.maxDeliver = maxDeliver
+	return ftc
+}
+
+// SetRetryBackoff sets exponential backoff for redelivery of refused signals:
+// delay = initialMs * 2^(delivery-1), capped by maxMs. Non-positive initialMs means immediate redelivery.
+func (ftc *FunctionTypeConfig) SetRetryBackoff(initialMs int, maxMs int) *FunctionTypeConfig {
+	ftc.retryBackoffInitialMs = initialMs
+	ftc.retryBackoffMaxMs = maxMs
+	return ftc
+}
+
+// SetSchedule makes the function type to be signaled periodically on the given ids.
+// Across all runtimes every tick is fired exactly once. The set of ids can be changed later via Runtime.SetScheduledIDs.
+func (ftc *FunctionTypeConfig) SetSchedule(interval time.Duration, ids ...string) *FunctionTypeConfig {
+	ftc.scheduleInterval = interval
+	ftc.scheduleIDs = append([]string{}, ids...)
+	return ftc
+}
+
+// SetPanicQuarantine makes an id which handler panicked maxPanics times in a row to refuse all messages for the duration.
+// Non-positive maxPanics disables the quarantine.
+func (ftc *FunctionTypeConfig) SetPanicQuarantine(maxPanics int, duration time.Duration) *FunctionTypeConfig {
+	ftc.quarantinePanics = maxPanics
+	ftc.quarantineDuration = duration
+	return ftc
+}
+
+// SetRateLimit limits the rate of bulk messages to the function type by a token bucket: ratePerSec tokens are added per second
+// up to burst. Non-positive ratePerSec disables the limit.
+func (ftc *FunctionTypeConfig) SetRateLimit(ratePerSec float64, burst int) *FunctionTypeConfig {
+	ftc.typeRateLimit = ratePerSec
+	ftc.typeRateBurst = burst
+	return ftc
+}
+
+// SetIDRateLimit is the same as SetRateLimit, but the token bucket is kept for every object id separately
+func (ftc *FunctionTypeConfig) SetIDRateLimit(ratePerSec float64, burst int) *FunctionTypeConfig {
+	ftc.idRateLimit = ratePerSec
+	ftc.idRateBurst = burst
+	return ftc
+}
+
+// SetControlRateLimit limits the rate of control messages to the function type the same way SetRateLimit does for bulk ones.
+// The control lane is not limited by default, but any caller can mark a message as a control one, so a function type
+// exposed to untrusted callers should limit it.
+func (ftc *FunctionTypeConfig) SetControlRateLimit(ratePerSec float64, burst int) *FunctionTypeConfig {
+	ftc.controlRateLimit = ratePerSec
+	ftc.controlRateBurst = burst
+	return ftc
+}
+
+// SetRateLimitAction sets what happens to a message which exceeds the rate limits, RateLimitNakWithDelay by default
+func (ftc *FunctionTypeConfig) SetRateLimitAction(action RateLimitAction) *FunctionTypeConfig {
+	ftc.rateLimitAction = action
+	return ftc
+}
+
+// SetIdempotency makes signals to be handled at most once per idempotency key, processed keys are remembered for the ttl.
+// Non-positive ttl disables deduplication.
+func (ftc *FunctionTypeConfig) SetIdempotency(ttl time.Duration) *FunctionTypeConfig {
+	ftc.idempotencyTTL = ttl
+	return ftc
+}
+
+// SetEgressProvider sets the provider egress messages sent with AutoEgressSelect are delivered through, NatsCoreEgress by default
+func (ftc *FunctionTypeConfig) SetEgressProvider(egressProvider sfPlugins.EgressProvider) *FunctionTypeConfig {
+	ftc.egressProvider = egressProvider
+	return ftc
+}
+
+// SetFunctionContextMigrations sets migrations of the function context, migrations[i] converts version i+1 into i+2
+func (ftc *FunctionTypeConfig) SetFunctionContextMigrations(migrations ...ContextMigrationFunc) *FunctionTypeConfig {
+	ftc.functionContextMigrations = migrations
+	return ftc
+}
+
+// SetObjectContextMigrations sets migrations of the object context, migrations[i] converts version i+1 into i+2
+func (ftc *FunctionTypeConfig) SetObjectContextMigrations(migrations ...ContextMigrationFunc) *FunctionTypeConfig {
+	ftc.objectContextMigrations = migrations
+	return ftc
+}
+
+// SetContextExpiredSignal makes the function type signaled when its function context or an object context expires
+func (ftc *FunctionTypeConfig) SetContextExpiredSignal(enabled bool) *FunctionTypeConfig {
+	ftc.contextExpiredSignal = enabled
+	return ftc
+}
+
+// ToJSON describes the config, used by the runtime admin report
+func (ftc *FunctionTypeConfig) ToJSON() easyjson.JSON {
+	signalProviders := []int{}
+	for provider := range ftc.allowedSignalProviders {
+		signalProviders = append(signalProviders, int(provider))
+	}
+	requestProviders := []int{}
+	for provider := range ftc.allowedRequestProviders {
+		requestProviders = append(requestProviders, int(provider))
+	}
+
+	j := easyjson.NewJSONObject()
+	j.SetByPath("msg_ack_wait_ms", easyjson.NewJSON(ftc.msgAckWaitMs))
+	j.SetByPath("msg_channel_size", easyjson.NewJSON(ftc.msgChannelSize))
+	j.SetByPath("msg_ack_channel_size", easyjson.NewJSON(ftc.msgAckChannelSize))
+	j.SetByPath("balance_needed", easyjson.NewJSON(ftc.balanceNeeded))
+	j.SetByPath("mutex_lifetime_sec", easyjson.NewJSON(ftc.mutexLifeTimeSec))
+	j.SetByPath("options", ftc.options.Clone())
+	j.SetByPath("multiple_instances_allowed", easyjson.NewJSON(ftc.multipleInstancesAllowed))
+	j.SetByPath("max_id_handlers", easyjson.NewJSON(ftc.maxIdHandlers))
+	j.SetByPath("allowed_signal_providers", easyjson.JSONFromArray(signalProviders))
+	j.SetByPath("allowed_request_providers", easyjson.JSONFromArray(requestProviders))
+	j.SetByPath("max_deliver", easyjson.NewJSON(ftc.maxDeliver))
+	j.SetByPath("retry_backoff_initial_ms", easyjson.NewJSON(ftc.retryBackoffInitialMs))
+	j.SetByPath("retry_backoff_max_ms", easyjson.NewJSON(ftc.retryBackoffMaxMs))
+	j.SetByPath("schedule_interval_ms", easyjson.NewJSON(ftc.scheduleInterval.Milliseconds()))
+	j.SetByPath("quarantine_panics", easyjson.NewJSON(ftc.quarantinePanics))
+	j.SetByPath("quarantine_duration_ms", easyjson.NewJSON(ftc.quarantineDuration.Milliseconds()))
+	j.SetByPath("rate_limit", easyjson.NewJSON(ftc.typeRateLimit))
+	j.SetByPath("rate_burst", easyjson.NewJSON(ftc.typeRateBurst))
+	j.SetByPath("id_rate_limit", easyjson.NewJSON(ftc.idRateLimit))
+	j.SetByPath("id_rate_burst", easyjson.NewJSON(ftc.idRateBurst))
+	j.SetByPath("control_rate_limit", easyjson.NewJSON(ftc.controlRateLimit))
+	j.SetByPath("control_rate_burst", easyjson.NewJSON(ftc.controlRateBurst))
+	j.SetByPath("rate_limit_action", easyjson.NewJSON(ftc.rateLimitAction.String()))
+	j.SetByPath("idempotency_ttl_ms", easyjson.NewJSON(ftc.idempotencyTTL.Milliseconds()))
+	j.SetByPath("egress_provider", easyjson.NewJSON(int(ftc.egressProvider)))
+	j.SetByPath("function_context_version", easyjson.NewJSON(len(ftc.functionContextMigrations)+1))
+	j.SetByPath("object_context_version", easyjson.NewJSON(len(ftc.objectContextMigrations)+1))
+	j.SetByPath("context_expired_signal", easyjson.NewJSON(ftc.contextExpiredSignal))
+	return j
+}
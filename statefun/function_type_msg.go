@@ -1,6 +1,8 @@
 package statefun
 
 import (
+	"time"
+
 	"github.com/foliagecp/easyjson"
 
 	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
@@ -11,6 +13,8 @@ type HandlerMsgRefusalType int
 type RefusalCallbackAction = func()
 type RequestCallbackAction = func(data *easyjson.JSON)
 type SignalCallbackAction = func(ack bool)
+type ErrorCallbackAction = func(err error)
+type DelayCallbackAction = func(delay time.Duration)
 
 type FunctionTypeMsg struct {
 	Caller          *sfPlugins.StatefunAddress
@@ -19,4 +23,15 @@ type FunctionTypeMsg struct {
 	RefusalCallback RefusalCallbackAction
 	RequestCallback RequestCallbackAction
 	AckCallback     SignalCallbackAction
+	ErrorCallback   ErrorCallbackAction // Called instead of AckCallback(false) when the handler fails, if defined
+	DelayCallback   DelayCallbackAction // Redelivers the message after the delay, if the message source supports it
+	IdempotencyKey  string              // Default idempotency key of the message, if the message source provides it
+	ReplyStream     replyStreamSink     // Receives chunks of a streamed reply, RequestCallback ends the stream
+}
+
+func (msg FunctionTypeMsg) streamChunk(chunk *easyjson.JSON) error {
+	if msg.ReplyStream == nil {
+		return ErrReplyStreamNotRequested
+	}
+	return msg.ReplyStream.chunk(chunk)
 }
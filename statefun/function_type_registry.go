@@ -0,0 +1,177 @@
+package statefun
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+)
+
+func (r *Runtime) getRegisteredFunctionType(typename string) (*FunctionType, bool) {
+	r.registeredFunctionTypesMutex.RLock()
+	defer r.registeredFunctionTypesMutex.RUnlock()
+	ft, ok := r.registeredFunctionTypes[typename]
+	return ft, ok
+}
+
+func (r *Runtime) getRegisteredFunctionTypes() []*FunctionType {
+	r.registeredFunctionTypesMutex.RLock()
+	defer r.registeredFunctionTypesMutex.RUnlock()
+	functionTypes := make([]*FunctionType, 0, len(r.registeredFunctionTypes))
+	for _, ft := range r.registeredFunctionTypes {
+		functionTypes = append(functionTypes, ft)
+	}
+	return functionTypes
+}
+
+func (r *Runtime) addFunctionType(ft *FunctionType) {
+	r.registeredFunctionTypesMutex.Lock()
+	defer r.registeredFunctionTypesMutex.Unlock()
+	r.registeredFunctionTypes[ft.name] = ft
+}
+
+/*
+registerFunctionType adds the function type to the registry. Runtime.Start holds the registry locked while starting the
+registered function types, so a function type registered before the runtime is started is started by Start. On a
+running runtime the name is reserved under the lock, the function type is started outside of it, so senders looking up
+registered function types are not blocked by its JetStream round trips, and it is added to the registry once started.
+*/
+func (r *Runtime) registerFunctionType(ft *FunctionType) error {
+	r.registeredFunctionTypesMutex.Lock()
+	_, registered := r.registeredFunctionTypes[ft.name]
+	_, starting := r.startingFunctionTypes[ft.name]
+	if registered || starting {
+		r.registeredFunctionTypesMutex.Unlock()
+		return fmt.Errorf("function type %s is already registered", ft.name)
+	}
+	if !r.started.Load() {
+		r.registeredFunctionTypes[ft.name] = ft
+		r.registeredFunctionTypesMutex.Unlock()
+		return nil
+	}
+	r.startingFunctionTypes[ft.name] = ft
+	r.registeredFunctionTypesMutex.Unlock()
+
+	err := r.startFunctionType(ft)
+
+	r.registeredFunctionTypesMutex.Lock()
+	delete(r.startingFunctionTypes, ft.name)
+	if err == nil {
+		r.registeredFunctionTypes[ft.name] = ft
+	}
+	r.registeredFunctionTypesMutex.Unlock()
+
+	if err != nil {
+		r.stopFunctionType(ft)
+		return err
+	}
+	lg.Logf(lg.DebugLevel, "Function type %s is registered", ft.name)
+	return nil
+}
+
+// startFunctionType does for a function type registered on a running runtime what Runtime.Start does for all of them
+func (r *Runtime) startFunctionType(ft *FunctionType) error {
+	if err := r.createStreams(r.startCtx, []*FunctionType{ft}); err != nil {
+		return err
+	}
+	if err := r.lockSingleInstanceFunctionType(r.startCtx, ft); err != nil {
+		return err
+	}
+	if err := r.startFunctionTypeSubscriptions(ft); err != nil {
+		return err
+	}
+	if err := r.startSchedule(r.startCtx, ft); err != nil {
+		return err
+	}
+	return r.publishSchema(ft)
+}
+
+// stopFunctionType drains the function type and releases its id handlers and single-instance lock
+func (r *Runtime) stopFunctionType(ft *FunctionType) {
+	r.drainFunctionTypes([]*FunctionType{ft})
+	close(ft.stopped)
+	ft.releaseIDHandlers()
+
+	r.singleInstanceRevisionsMutex.Lock()
+	r.releaseSingleInstanceLock(ft.name)
+	r.singleInstanceRevisionsMutex.Unlock()
+}
+
+// purgeFunctionType removes the function type's streams, consumer and key/value records from the domain
+func (r *Runtime) purgeFunctionType(ft *FunctionType) error {
+	ignoreNotFound := func(err error) error {
+		if err == nil || errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrConsumerNotFound) || errors.Is(err, cache.ErrBackendKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	errs := []error{}
+	if ft.config.IsSignalProviderAllowed(sfPlugins.JetstreamGlobalSignal) && r.js != nil {
+		errs = append(errs, ignoreNotFound(r.js.DeleteConsumer(ft.getStreamName(), ft.getConsumerName())))
+		errs = append(errs, ignoreNotFound(r.js.DeleteStream(ft.getStreamName())))
+		if ft.deadLettersEnabled() {
+			errs = append(errs, ignoreNotFound(r.js.DeleteStream(ft.getDeadLetterStreamName())))
+		}
+	}
+	for _, key := range []string{schemaKey(ft.name), scheduleIDsKey(ft.name), scheduleTickKey(ft.name)} {
+		errs = append(errs, ignoreNotFound(r.Domain.kv.Delete(key, 0)))
+	}
+	return errors.Join(errs...)
+}
+
+// releaseIDHandlers stops id handler routines of a stopped function type
+func (ft *FunctionType) releaseIDHandlers() {
+	ft.idHandlersChannel.Range(func(key, value interface{}) bool {
+		id := key.(string)
+		ft.idKeyMutex.Lock(id)
+		value.(*idMsgQueue).close()
+		ft.idHandlersChannel.Delete(id)
+		ft.idRateLimiters.Delete(id)
+		ft.idHandlersLastMsgTime.Delete(id)
+		ft.idRunningStatus.Delete(id)
+		if ft.executor != nil {
+			ft.executor.RemoveForID(id)
+		}
+		ft.idKeyMutex.Unlock(id)
+		return true
+	})
+}
+
+// RegisterFunctionType registers a function type, if the runtime is already started the function type's streams and
+// subscriptions are created right away
+func (r *Runtime) RegisterFunctionType(name string, logicHandler FunctionLogicHandler, config FunctionTypeConfig) (*FunctionType, error) {
+	ft := newFunctionType(r, name, logicHandler, config)
+	return ft, r.registerFunctionType(ft)
+}
+
+/*
+UnregisterFunctionType stops handling the function type by this runtime. Messages already received are handled within the
+drain timeout, the rest are redelivered to other runtimes. If purge is true the function type is removed from the whole
+domain: its streams, consumer, schedule and schema are deleted.
+*/
+func (r *Runtime) UnregisterFunctionType(typename string, purge bool) error {
+	r.registeredFunctionTypesMutex.Lock()
+	ft, ok := r.registeredFunctionTypes[typename]
+	if !ok {
+		r.registeredFunctionTypesMutex.Unlock()
+		return fmt.Errorf("function type %s is not registered", typename)
+	}
+	delete(r.registeredFunctionTypes, typename)
+	r.registeredFunctionTypesMutex.Unlock()
+
+	if !r.started.Load() {
+		return nil
+	}
+	r.stopFunctionType(ft)
+	lg.Logf(lg.DebugLevel, "Function type %s is unregistered", ft.name)
+
+	if purge {
+		return r.purgeFunctionType(ft)
+	}
+	return nil
+}
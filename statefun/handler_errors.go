@@ -0,0 +1,136 @@
+package statefun
+
+import (
+	"fmt"
+	goruntime "runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/prometheus/client_golang/prometheus"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfMediators "github.com/foliagecp/sdk/statefun/mediator"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+// HandlerPanicError is a recovered panic of a FunctionLogicHandler, implements sfPlugins.PluginError
+type HandlerPanicError struct {
+	Message    string
+	Location   string
+	StackTrace string
+}
+
+var _ sfPlugins.PluginError = (*HandlerPanicError)(nil)
+
+func newHandlerPanicError(recovered any) *HandlerPanicError {
+	return &HandlerPanicError{
+		Message:    fmt.Sprintf("function handler panicked: %v", recovered),
+		Location:   panicLocation(),
+		StackTrace: string(debug.Stack()),
+	}
+}
+
+func (e *HandlerPanicError) Error() string {
+	return e.Message
+}
+
+func (e *HandlerPanicError) GetLocation() string {
+	return e.Location
+}
+
+func (e *HandlerPanicError) GetStackTrace() string {
+	return e.StackTrace
+}
+
+// ToOpMsg converts the error into a failed OpMsg with location and stack trace in its data
+func (e *HandlerPanicError) ToOpMsg() sfMediators.OpMsg {
+	data := easyjson.NewJSONObject()
+	data.SetByPath("location", easyjson.NewJSON(e.Location))
+	data.SetByPath("stack_trace", easyjson.NewJSON(e.StackTrace))
+	return sfMediators.MakeOpMsg(sfMediators.SYNC_OP_STATUS_FAILED, e.Message, "", data)
+}
+
+// panicLocation finds the first frame below the panic which does not belong to the go runtime
+func panicLocation() string {
+	pcs := make([]uintptr, 32)
+	n := goruntime.Callers(3, pcs)
+	frames := goruntime.CallersFrames(pcs[:n])
+	afterPanic := false
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" || strings.HasPrefix(frame.Function, "runtime.panic") {
+			afterPanic = true
+		} else if afterPanic && !strings.HasPrefix(frame.Function, "runtime.") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return "unknown"
+}
+
+type idPanicsState struct {
+	panics           int
+	quarantinedUntil int64
+}
+
+// callLogicHandler calls the handler and recovers its panic if any
+func (ft *FunctionType) callLogicHandler(logicHandler FunctionLogicHandler, executor sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) (panicErr *HandlerPanicError) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panicErr = newHandlerPanicError(recovered)
+		}
+	}()
+	logicHandler(executor, ctx)
+	return nil
+}
+
+func (ft *FunctionType) registerHandlerPanic(id string, panicErr *HandlerPanicError) {
+	lg.Logf(lg.ErrorLevel, "Function type %s with id=%s: %s at %s\n%s", ft.name, id, panicErr.Message, panicErr.Location, panicErr.StackTrace)
+
+	if counterVec, err := system.GlobalPrometrics.EnsureCounterVecSimple("statefun_handler_panics", "Recovered function handler panics", []string{"typename"}); err == nil {
+		counterVec.With(prometheus.Labels{"typename": ft.name}).Inc()
+	}
+
+	if ft.config.quarantinePanics <= 0 {
+		return
+	}
+	v, _ := ft.idPanics.LoadOrStore(id, &idPanicsState{})
+	state := v.(*idPanicsState)
+	ft.resourceMutex.Lock()
+	defer ft.resourceMutex.Unlock()
+	state.panics++
+	if state.panics >= ft.config.quarantinePanics {
+		state.panics = 0
+		state.quarantinedUntil = time.Now().Add(ft.config.quarantineDuration).UnixNano()
+		lg.Logf(lg.WarnLevel, "Function type %s with id=%s is quarantined for %s", ft.name, id, ft.config.quarantineDuration)
+	}
+}
+
+func (ft *FunctionType) registerHandlerSuccess(id string) {
+	if ft.config.quarantinePanics > 0 {
+		ft.idPanics.Delete(id)
+	}
+}
+
+func (ft *FunctionType) isQuarantined(id string) bool {
+	if ft.config.quarantinePanics <= 0 {
+		return false
+	}
+	v, ok := ft.idPanics.Load(id)
+	if !ok {
+		return false
+	}
+	ft.resourceMutex.Lock()
+	defer ft.resourceMutex.Unlock()
+	return v.(*idPanicsState).quarantinedUntil > time.Now().UnixNano()
+}
+
+// ReleaseQuarantine removes the quarantine from the id of the function type
+func (ft *FunctionType) ReleaseQuarantine(id string) {
+	ft.idPanics.Delete(ft.runtime.Domain.CreateObjectIDWithThisDomain(id, false))
+}
@@ -0,0 +1,237 @@
+package statefun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/nats-io/nats.go"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	HTTPIngressAddress        = ":8080"
+	HTTPIngressTimeoutSec     = 30
+	HTTPIngressMaxBodySize    = 1 << 20
+	HTTPIngressCallerTypename = "ingress"
+	HTTPIngressCallerID       = "http"
+)
+
+// HTTPIngressAuthFunc authorizes a call of the function type's id, returned error is replied with 401 status
+type HTTPIngressAuthFunc func(req *http.Request, typename string, id string) error
+
+type HTTPIngressConfig struct {
+	address              string
+	timeout              time.Duration
+	maxBodySize          int64
+	auth                 HTTPIngressAuthFunc
+	allowedFunctionTypes []string
+	signalProvider       sfPlugins.SignalProvider
+	requestProvider      sfPlugins.RequestProvider
+	readHeaderTimeout    time.Duration
+}
+
+func NewHTTPIngressConfig() *HTTPIngressConfig {
+	return &HTTPIngressConfig{
+		address:           HTTPIngressAddress,
+		timeout:           HTTPIngressTimeoutSec * time.Second,
+		maxBodySize:       HTTPIngressMaxBodySize,
+		signalProvider:    sfPlugins.AutoSignalSelect,
+		requestProvider:   sfPlugins.AutoRequestSelect,
+		readHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func (hic *HTTPIngressConfig) SetAddress(address string) *HTTPIngressConfig {
+	hic.address = address
+	return hic
+}
+
+// SetTimeout sets how long a request waits for the function's reply
+func (hic *HTTPIngressConfig) SetTimeout(timeout time.Duration) *HTTPIngressConfig {
+	hic.timeout = timeout
+	return hic
+}
+
+func (hic *HTTPIngressConfig) SetMaxBodySize(maxBodySize int64) *HTTPIngressConfig {
+	hic.maxBodySize = maxBodySize
+	return hic
+}
+
+func (hic *HTTPIngressConfig) SetAuth(auth HTTPIngressAuthFunc) *HTTPIngressConfig {
+	hic.auth = auth
+	return hic
+}
+
+// SetAllowedFunctionTypes sets function types which can be called, NATS-like wildcards are supported: "functions.graph.>"
+func (hic *HTTPIngressConfig) SetAllowedFunctionTypes(typenamePatterns ...string) *HTTPIngressConfig {
+	hic.allowedFunctionTypes = typenamePatterns
+	return hic
+}
+
+func (hic *HTTPIngressConfig) SetSignalProvider(signalProvider sfPlugins.SignalProvider) *HTTPIngressConfig {
+	hic.signalProvider = signalProvider
+	return hic
+}
+
+func (hic *HTTPIngressConfig) SetRequestProvider(requestProvider sfPlugins.RequestProvider) *HTTPIngressConfig {
+	hic.requestProvider = requestProvider
+	return hic
+}
+
+func (hic *HTTPIngressConfig) isFunctionTypeAllowed(typename string) bool {
+	for _, pattern := range hic.allowedFunctionTypes {
+		if typenameMatchesPattern(pattern, typename) {
+			return true
+		}
+	}
+	return false
+}
+
+func typenameMatchesPattern(pattern string, typename string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	typenameTokens := strings.Split(typename, ".")
+	for i, patternToken := range patternTokens {
+		if patternToken == ">" {
+			return i < len(typenameTokens)
+		}
+		if i >= len(typenameTokens) || (patternToken != "*" && patternToken != typenameTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(typenameTokens)
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+/*
+HTTPIngress lets services without a NATS client call functions over HTTP:
+
+	POST /signal/<typename>/<id>   {"payload": {...}, "options": {...}}   -> 202 {"status": "ok"}
+	POST /request/<typename>/<id>  {"payload": {...}, "options": {...}}   -> 200 <reply JSON>
+
+Only function types from the config's allow-list can be called. HTTPIngress is an http.Handler, so it can be mounted into
+an existing server, or run on its own with ListenAndServe:
+
+	ingress := statefun.NewHTTPIngress(runtime, *statefun.NewHTTPIngressConfig().SetAllowedFunctionTypes("functions.app.>"))
+	runtime.RegisterOnAfterStartFunction(func(ctx context.Context, r *statefun.Runtime) error {
+		return ingress.ListenAndServe()
+	}, true)
+*/
+type HTTPIngress struct {
+	runtime *Runtime
+	config  HTTPIngressConfig
+	server  *http.Server
+}
+
+func NewHTTPIngress(runtime *Runtime, config HTTPIngressConfig) *HTTPIngress {
+	ingress := &HTTPIngress{
+		runtime: runtime,
+		config:  config,
+	}
+	ingress.server = &http.Server{
+		Addr:              config.address,
+		Handler:           ingress,
+		ReadHeaderTimeout: config.readHeaderTimeout,
+	}
+	return ingress
+}
+
+// ListenAndServe serves HTTP requests until Shutdown is called
+func (hi *HTTPIngress) ListenAndServe() error {
+	lg.Logf(lg.InfoLevel, "HTTP ingress is listening on %s", hi.config.address)
+	if err := hi.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for active ones to be replied
+func (hi *HTTPIngress) Shutdown(ctx context.Context) error {
+	return hi.server.Shutdown(ctx)
+}
+
+func writeHTTPIngressReply(w http.ResponseWriter, status int, reply easyjson.JSON) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err := w.Write(reply.ToBytes())
+	system.MsgOnErrorReturn(err)
+}
+
+func writeHTTPIngressError(w http.ResponseWriter, status int, err string) {
+	writeHTTPIngressReply(w, status, easyjson.NewJSONObjectWithKeyValue("error", easyjson.NewJSON(err)))
+}
+
+func (hi *HTTPIngress) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPIngressError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Object ids may contain the domain separator, so everything after the typename is the id
+	tokens := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 3)
+	if len(tokens) != 3 || (tokens[0] != SignalPrefix && tokens[0] != RequestPrefix) || len(tokens[1]) == 0 || len(tokens[2]) == 0 {
+		writeHTTPIngressError(w, http.StatusNotFound, "expected /signal/<typename>/<id> or /request/<typename>/<id>")
+		return
+	}
+	kind, typename, id := tokens[0], tokens[1], tokens[2]
+
+	if !hi.config.isFunctionTypeAllowed(typename) {
+		writeHTTPIngressError(w, http.StatusNotFound, "function type is not callable")
+		return
+	}
+	if hi.config.auth != nil {
+		if err := hi.config.auth(req, typename, id); err != nil {
+			writeHTTPIngressError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+	}
+
+	var payload, options *easyjson.JSON
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, hi.config.maxBodySize))
+	if err != nil {
+		writeHTTPIngressError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if len(body) > 0 {
+		data, ok := easyjson.JSONFromBytes(body)
+		if !ok || !data.IsObject() {
+			writeHTTPIngressError(w, http.StatusBadRequest, "body is not a JSON object")
+			return
+		}
+		if data.GetByPath("payload").IsObject() {
+			payload = data.GetByPath("payload").GetPtr()
+		}
+		if data.GetByPath("options").IsObject() {
+			options = data.GetByPath("options").GetPtr()
+		}
+	}
+
+	if kind == SignalPrefix {
+		if err := hi.runtime.signal(hi.config.signalProvider, HTTPIngressCallerTypename, HTTPIngressCallerID, typename, id, payload, options); err != nil {
+			writeHTTPIngressError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeHTTPIngressReply(w, http.StatusAccepted, easyjson.NewJSONObjectWithKeyValue("status", easyjson.NewJSON("ok")))
+		return
+	}
+
+	reply, err := hi.runtime.request(hi.config.requestProvider, HTTPIngressCallerTypename, HTTPIngressCallerID, typename, id, payload, options, hi.config.timeout)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		writeHTTPIngressError(w, status, err.Error())
+		return
+	}
+	writeHTTPIngressReply(w, http.StatusOK, *reply)
+}
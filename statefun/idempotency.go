@@ -0,0 +1,88 @@
+package statefun
+
+import (
+	"fmt"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	IdempotencyKeyOptionKey = "idempotency_key"
+	idempotencyKeysToken    = "__processed"
+)
+
+/*
+Signals of a function type with idempotency enabled are handled at most once per idempotency key. The key is taken from
+the "idempotency_key" option of the signal, if it is absent the JetStream stream sequence of the message is used, so
+a redelivered message is recognized as well.
+
+Processed keys are recorded in the domain cache under "<typename>.__processed.<id hash>.<key hash>" before the message is
+acked, and expire natively in the cache after the configured TTL (see cache.Store.SetValueExpiration). Contexts the
+handler sets are written in a cache transaction together with the processed key, so either both are stored or the
+message is redelivered and handled again.
+*/
+func (ft *FunctionType) idempotencyEnabled() bool {
+	return ft.config.idempotencyTTL > 0
+}
+
+func (ft *FunctionType) getIdempotencyCacheKey(id string, idempotencyKey string) string {
+	return fmt.Sprintf("%s.%s.%s.%s", ft.name, idempotencyKeysToken, system.GetHashStr(id), system.GetHashStr(idempotencyKey))
+}
+
+// getIdempotencyKey returns the idempotency key of a signal, empty string means the message is not deduplicated
+func (ft *FunctionType) getIdempotencyKey(msg FunctionTypeMsg) string {
+	if !ft.idempotencyEnabled() || msg.RequestCallback != nil {
+		return ""
+	}
+	if msg.Options != nil {
+		if key, ok := msg.Options.GetByPath(IdempotencyKeyOptionKey).AsString(); ok && len(key) > 0 {
+			return key
+		}
+	}
+	return msg.IdempotencyKey
+}
+
+func jetstreamIdempotencyKey(msg *nats.Msg) string {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("js:%s:%d", meta.Stream, meta.Sequence.Stream)
+}
+
+func (ft *FunctionType) isProcessed(id string, idempotencyKey string) bool {
+	_, err := ft.runtime.Domain.cache.GetValue(ft.getIdempotencyCacheKey(id, idempotencyKey))
+	return err == nil
+}
+
+func (ft *FunctionType) beginIdempotentTransaction(id string) string {
+	transactionID := ft.name + "." + id + "." + system.GetUniqueStrID()
+	ft.runtime.Domain.cache.TransactionBegin(transactionID)
+	return transactionID
+}
+
+// markProcessed commits the processed key in the transaction contexts were set in by the handler
+func (ft *FunctionType) markProcessed(id string, idempotencyKey string, transactionID string) error {
+	record := easyjson.NewJSONObject()
+	ft.setContext(ft.getIdempotencyCacheKey(id, idempotencyKey), &record, transactionID)
+	if err := ft.runtime.Domain.cache.TransactionEnd(transactionID); err != nil {
+		return err
+	}
+	system.MsgOnErrorReturn(ft.runtime.Domain.cache.SetValueExpirationAfter(ft.getIdempotencyCacheKey(id, idempotencyKey), ft.config.idempotencyTTL))
+	return nil
+}
+
+func (ft *FunctionType) skipDuplicate(id string, idempotencyKey string, msg FunctionTypeMsg) {
+	lg.Logf(lg.TraceLevel, "Function type %s with id=%s skips already processed signal with idempotency key %s", ft.name, id, idempotencyKey)
+	if counterVec, err := system.GlobalPrometrics.EnsureCounterVecSimple("statefun_duplicates_skipped", "Already processed signals skipped by idempotency key", []string{"typename"}); err == nil {
+		counterVec.With(prometheus.Labels{"typename": ft.name}).Inc()
+	}
+	if msg.AckCallback != nil {
+		msg.AckCallback(true)
+	}
+}
@@ -0,0 +1,62 @@
+package statefun
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	inProcessNatsReadyTimeout = 10 * time.Second
+)
+
+/*
+startInProcessNats runs a NATS server with JetStream inside the runtime's process. It does not listen on the network,
+the runtime connects to it directly. JetStream storage is kept in a temporary directory which is removed on shutdown,
+so nothing survives the process.
+*/
+func (r *Runtime) startInProcessNats() (*nats.Conn, error) {
+	storeDir, err := os.MkdirTemp("", "statefun-nats-")
+	if err != nil {
+		return nil, err
+	}
+	srv, err := server.NewServer(&server.Options{
+		ServerName: r.config.name,
+		JetStream:  true,
+		StoreDir:   storeDir,
+		DontListen: true,
+		NoSigs:     true,
+		NoLog:      true,
+	})
+	if err != nil {
+		system.MsgOnErrorReturn(os.RemoveAll(storeDir))
+		return nil, err
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(inProcessNatsReadyTimeout) {
+		srv.Shutdown()
+		system.MsgOnErrorReturn(os.RemoveAll(storeDir))
+		return nil, fmt.Errorf("in-process NATS server is not ready in %s", inProcessNatsReadyTimeout)
+	}
+	r.inProcessNats = srv
+	r.inProcessNatsStoreDir = storeDir
+
+	return nats.Connect("", nats.InProcessServer(srv))
+}
+
+func (r *Runtime) stopInProcessNats() {
+	if r.inProcessNats == nil {
+		return
+	}
+	if r.nc != nil {
+		r.nc.Close()
+	}
+	r.inProcessNats.Shutdown()
+	r.inProcessNats.WaitForShutdown()
+	system.MsgOnErrorReturn(os.RemoveAll(r.inProcessNatsStoreDir))
+}
@@ -0,0 +1,72 @@
+package statefun
+
+import (
+	"github.com/foliagecp/easyjson"
+
+	sfMediators "github.com/foliagecp/sdk/statefun/mediator"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+)
+
+/*
+FunctionInterceptor wraps a FunctionLogicHandler call. An interceptor sees the StatefunContextProcessor before and after
+calling next, and may short-circuit the call by not calling next at all, for e.g. by replying with an OpMediator:
+
+	func(next FunctionLogicHandler) FunctionLogicHandler {
+		return func(executor sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+			if !authorized(ctx) {
+				sfMediators.NewOpMediator(ctx).AggregateOpMsg(sfMediators.OpMsgFailed("unauthorized")).Reply()
+				return
+			}
+			next(executor, ctx)
+		}
+	}
+
+Runtime interceptors are the outermost ones, function type interceptors are called after them in order of registration.
+*/
+type FunctionInterceptor func(next FunctionLogicHandler) FunctionLogicHandler
+
+// UseInterceptors registers interceptors for all function types of the runtime
+func (r *Runtime) UseInterceptors(interceptors ...FunctionInterceptor) {
+	r.interceptorsMutex.Lock()
+	defer r.interceptorsMutex.Unlock()
+	r.interceptors = append(r.interceptors, interceptors...)
+}
+
+// UseInterceptors registers interceptors for this function type only
+func (ft *FunctionType) UseInterceptors(interceptors ...FunctionInterceptor) *FunctionType {
+	ft.resourceMutex.Lock()
+	defer ft.resourceMutex.Unlock()
+	ft.interceptors = append(ft.interceptors, interceptors...)
+	return ft
+}
+
+func (ft *FunctionType) getInterceptedLogicHandler() FunctionLogicHandler {
+	handler := ft.logicHandler
+
+	ft.resourceMutex.Lock()
+	for i := len(ft.interceptors) - 1; i >= 0; i-- {
+		handler = ft.interceptors[i](handler)
+	}
+	ft.resourceMutex.Unlock()
+
+	ft.runtime.interceptorsMutex.Lock()
+	for i := len(ft.runtime.interceptors) - 1; i >= 0; i-- {
+		handler = ft.runtime.interceptors[i](handler)
+	}
+	ft.runtime.interceptorsMutex.Unlock()
+
+	return handler
+}
+
+// PayloadValidationInterceptor short-circuits the call with a failed OpMsg reply if the payload is invalid
+func PayloadValidationInterceptor(validate func(payload *easyjson.JSON) error) FunctionInterceptor {
+	return func(next FunctionLogicHandler) FunctionLogicHandler {
+		return func(executor sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+			if err := validate(ctx.Payload); err != nil {
+				sfMediators.NewOpMediator(ctx).AggregateOpMsg(sfMediators.OpMsgFailed(err.Error())).Reply()
+				return
+			}
+			next(executor, ctx)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package statefun_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/statefun"
+	sfMediators "github.com/foliagecp/sdk/statefun/mediator"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/test"
+	"github.com/stretchr/testify/suite"
+)
+
+type InterceptorsTestSuite struct {
+	test.StatefunTestSuite
+}
+
+func TestInterceptorsTestSuite(t *testing.T) {
+	suite.Run(t, new(InterceptorsTestSuite))
+}
+
+func tracingInterceptor(name string) statefun.FunctionInterceptor {
+	return func(next statefun.FunctionLogicHandler) statefun.FunctionLogicHandler {
+		return func(executor sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+			trace := ctx.Options.GetByPath("trace").AsStringDefault("")
+			ctx.Options.SetByPath("trace", easyjson.NewJSON(trace+name))
+			next(executor, ctx)
+		}
+	}
+}
+
+func (s *InterceptorsTestSuite) Test_Interceptors_Order() {
+	typename := "functions.tests.interceptors.echo"
+	cfg := *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.AutoRequestSelect)
+
+	s.Runtime().UseInterceptors(tracingInterceptor("g"))
+	ft := statefun.NewFunctionType(s.Runtime(), typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		sfMediators.NewOpMediator(ctx).AggregateOpMsg(sfMediators.OpMsgOk(ctx.Options.GetByPath("trace"))).Reply()
+	}, cfg)
+	ft.UseInterceptors(tracingInterceptor("1"), tracingInterceptor("2"))
+	s.NoError(s.StartRuntime())
+
+	result, err := s.Request(sfPlugins.AutoRequestSelect, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Equal("g12", result.GetByPath("data").AsStringDefault(""))
+}
+
+func (s *InterceptorsTestSuite) Test_PayloadValidationInterceptor_ShortCircuits() {
+	typename := "functions.tests.interceptors.validated"
+	cfg := *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.AutoRequestSelect)
+
+	called := false
+	ft := statefun.NewFunctionType(s.Runtime(), typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		called = true
+		sfMediators.NewOpMediator(ctx).AggregateOpMsg(sfMediators.OpMsgOk(easyjson.NewJSONNull())).Reply()
+	}, cfg)
+	ft.UseInterceptors(statefun.PayloadValidationInterceptor(func(payload *easyjson.JSON) error {
+		if !payload.GetByPath("name").IsString() {
+			return fmt.Errorf("name is required")
+		}
+		return nil
+	}))
+	s.NoError(s.StartRuntime())
+
+	result, err := s.Request(sfPlugins.AutoRequestSelect, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Equal("failed", result.GetByPath("status").AsStringDefault(""))
+	s.Equal("name is required", result.GetByPath("details").AsStringDefault(""))
+	s.False(called)
+}
@@ -1,13 +1,13 @@
 package statefun
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/foliagecp/easyjson"
 	"github.com/nats-io/nats.go"
 
-	"github.com/foliagecp/sdk/statefun/logger"
 	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
 	"github.com/foliagecp/sdk/statefun/system"
 )
@@ -25,14 +25,15 @@ func buildNatsData(callerDomain string, callerTypename string, callerID string,
 	return data.ToBytes()
 }
 
-func (r *Runtime) signalShadowObject(callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON) error {
+// shadowObjectAddresses returns the domain of a shadow object, its id in that domain and the caller's id seen from there
+func (r *Runtime) shadowObjectAddresses(callerID string, targetID string) (remoteDomain string, remoteTargetID string, shadowCallerID string, err error) {
 	tDomainName, tObjectIdWithoutDomain, err := r.Domain.GetShadowObjectDomainAndID(targetID)
 	if err != nil {
-		return err
+		return "", "", "", err
 	}
 	objectIdInRemoteDomain := fmt.Sprintf("%s%s%s", tDomainName, ObjectIDDomainSeparator, tObjectIdWithoutDomain)
 
-	shadowCallerID := fmt.Sprintf(
+	shadowCallerID = fmt.Sprintf(
 		"%s%s%s%s%s",
 		tDomainName,
 		ObjectIDDomainSeparator,
@@ -40,58 +41,61 @@ func (r *Runtime) signalShadowObject(callerTypename string, callerID string, tar
 		ObjectIDWeakClusteringDomainSeparator,
 		r.Domain.GetObjectIDWithoutDomain(callerID),
 	)
-	system.MsgOnErrorReturn(r.nc.Publish(
-		fmt.Sprintf(DomainIngressSubjectsTmpl, tDomainName, fmt.Sprintf("%s.%s.%s.%s", SignalPrefix, tDomainName, targetTypename, objectIdInRemoteDomain)),
-		buildNatsData(r.Domain.name, callerTypename, shadowCallerID, payload, options),
-	))
+	return tDomainName, objectIdInRemoteDomain, shadowCallerID, nil
+}
 
-	return nil
+// jetstreamSignalMsg returns the subject and the data the signal is published with to reach the target's stream
+func (r *Runtime) jetstreamSignalMsg(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) (string, []byte, error) {
+	if r.Domain.IsShadowObject(target.ID) {
+		tDomainName, objectIdInRemoteDomain, shadowCallerID, err := r.shadowObjectAddresses(caller.ID, target.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf(DomainIngressSubjectsTmpl, tDomainName, fmt.Sprintf("%s.%s.%s.%s", SignalPrefix, tDomainName, target.Typename, objectIdInRemoteDomain)),
+			buildNatsData(r.Domain.name, caller.Typename, shadowCallerID, payload, options), nil
+	}
+	data := buildNatsData(r.Domain.name, caller.Typename, caller.ID, payload, options)
+	// If publishing signal to the same domain
+	if r.Domain.name == r.Domain.GetDomainFromObjectID(target.ID) { // Publish directly into function's topic bypassing egress router
+		return fmt.Sprintf(DomainIngressSubjectsTmpl, r.Domain.name, fmt.Sprintf("%s.%s.%s.%s", SignalPrefix, r.Domain.name, target.Typename, target.ID)), data, nil
+	}
+	// Publish into egress router
+	return fmt.Sprintf(DomainEgressSubjectsTmpl, r.Domain.name, fmt.Sprintf("%s.%s.%s.%s", SignalPrefix, r.Domain.GetDomainFromObjectID(target.ID), target.Typename, target.ID)), data, nil
 }
 
 func (r *Runtime) requestShadowObject(callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON) (*nats.Msg, error) {
-	tDomainName, tObjectIdWithoutDomain, err := r.Domain.GetShadowObjectDomainAndID(targetID)
+	subject, data, err := r.shadowObjectRequest(callerTypename, callerID, targetTypename, targetID, payload, options)
 	if err != nil {
 		return nil, err
 	}
-	objectIdInRemoteDomain := fmt.Sprintf("%s%s%s", tDomainName, ObjectIDDomainSeparator, tObjectIdWithoutDomain)
+	return r.nc.Request(subject, data, time.Duration(r.config.requestTimeoutSec)*time.Second)
+}
 
-	shadowCallerID := fmt.Sprintf(
-		"%s%s%s%s%s",
-		tDomainName,
-		ObjectIDDomainSeparator,
-		r.Domain.GetDomainFromObjectID(callerID),
-		ObjectIDWeakClusteringDomainSeparator,
-		r.Domain.GetObjectIDWithoutDomain(callerID),
-	)
-	resp, err := r.nc.Request(
-		fmt.Sprintf("%s.%s.%s.%s", RequestPrefix, tDomainName, targetTypename, objectIdInRemoteDomain),
-		buildNatsData(r.Domain.name, callerTypename, shadowCallerID, payload, options),
-		time.Duration(r.config.requestTimeoutSec)*time.Second,
-	)
+// shadowObjectRequest returns the subject and the data of a request to a shadow object in its remote domain
+func (r *Runtime) shadowObjectRequest(callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON) (string, []byte, error) {
+	tDomainName, objectIdInRemoteDomain, shadowCallerID, err := r.shadowObjectAddresses(callerID, targetID)
+	if err != nil {
+		return "", nil, err
+	}
+	subject := fmt.Sprintf("%s.%s.%s.%s", RequestPrefix, tDomainName, targetTypename, objectIdInRemoteDomain)
+	return subject, buildNatsData(r.Domain.name, callerTypename, shadowCallerID, payload, options), nil
+}
 
-	return resp, err
+type natsCoreEgressTransport struct {
+	r *Runtime
 }
 
-func (r *Runtime) egress(egressProvider sfPlugins.EgressProvider, callerTypename string, callerID string, payload *easyjson.JSON) error {
-	natsCoreEgress := func() error {
-		go func() {
-			system.GlobalPrometrics.GetRoutinesCounter().Started("ingress-jetstreamGlobalSignal-gofunc")
-			defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("ingress-jetstreamGlobalSignal-gofunc")
-
-			system.MsgOnErrorReturn(r.nc.Publish(
-				fmt.Sprintf("%s.%s.%s", "egress", callerTypename, callerID),
-				payload.ToBytes(),
-			))
-		}()
-		return nil
-	}
+func (t *natsCoreEgressTransport) Egress(caller sfPlugins.StatefunAddress, payload *easyjson.JSON) error {
+	go func() {
+		system.GlobalPrometrics.GetRoutinesCounter().Started("ingress-jetstreamGlobalSignal-gofunc")
+		defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("ingress-jetstreamGlobalSignal-gofunc")
 
-	switch egressProvider {
-	case sfPlugins.NatsCoreEgress:
-		return natsCoreEgress()
-	default:
-		return fmt.Errorf("unknown egress provider: %d", egressProvider)
-	}
+		system.MsgOnErrorReturn(t.r.nc.Publish(
+			fmt.Sprintf("%s.%s.%s", "egress", caller.Typename, caller.ID),
+			payload.ToBytes(),
+		))
+	}()
+	return nil
 }
 
 /* return
@@ -100,16 +104,16 @@ func (r *Runtime) egress(egressProvider sfPlugins.EgressProvider, callerTypename
 * 2 - function is not registered
 * 3 - function does not support this communication type
  */
-func (r *Runtime) functionTypeIsReadyForGoLangCommunication(targetFunctionTypeName string, isRequest bool, targetID string) int {
+func (r *Runtime) functionTypeIsReadyForGoLangCommunication(targetFunctionTypeName string, isRequest bool, targetID string) (*FunctionType, int) {
 	var targetFT *FunctionType
 	if r.Domain.GetDomainFromObjectID(targetID) == r.Domain.name {
-		if ft, ok := r.registeredFunctionTypes[targetFunctionTypeName]; ok {
+		if ft, ok := r.getRegisteredFunctionType(targetFunctionTypeName); ok {
 			targetFT = ft
 		} else {
-			return 2
+			return nil, 2
 		}
 	} else {
-		return 1
+		return nil, 1
 	}
 	supportsCommunicationType := false
 	if isRequest {
@@ -122,222 +126,210 @@ func (r *Runtime) functionTypeIsReadyForGoLangCommunication(targetFunctionTypeNa
 		}
 	}
 	if !supportsCommunicationType {
-		return 3
+		return nil, 3
 	}
-	return 0
+	return targetFT, 0
 }
 
-func (r *Runtime) signal(signalProvider sfPlugins.SignalProvider, callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON) error {
-	jetstreamGlobalSignal := func() error {
-		go func() {
-			system.GlobalPrometrics.GetRoutinesCounter().Started("ingress-jetstreamGlobalSignal-gofunc")
-			defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("ingress-jetstreamGlobalSignal-gofunc")
-
-			if r.Domain.IsShadowObject(targetID) {
-				system.MsgOnErrorReturn(r.signalShadowObject(callerTypename, callerID, targetTypename, targetID, payload, options))
-			} else {
-				// If publishing signal to the same domain
-				if r.Domain.name == r.Domain.GetDomainFromObjectID(targetID) {
-					system.MsgOnErrorReturn(r.nc.Publish( // Publish directly into function's topic bypassing egress router
-						fmt.Sprintf(DomainIngressSubjectsTmpl, r.Domain.name, fmt.Sprintf("%s.%s.%s.%s", SignalPrefix, r.Domain.name, targetTypename, targetID)),
-						buildNatsData(r.Domain.name, callerTypename, callerID, payload, options),
-					))
-				} else { // Publish into egress router
-					system.MsgOnErrorReturn(r.nc.Publish(
-						fmt.Sprintf(DomainEgressSubjectsTmpl, r.Domain.name, fmt.Sprintf("%s.%s.%s.%s", SignalPrefix, r.Domain.GetDomainFromObjectID(targetID), targetTypename, targetID)),
-						buildNatsData(r.Domain.name, callerTypename, callerID, payload, options),
-					))
-				}
-			}
-		}()
-		return nil
-	}
-	// TODO: This implementation is weak, cause we always should wait one functions ends its execution before next can start
-	goLangLocalSignal := func() error {
-		switch r.functionTypeIsReadyForGoLangCommunication(targetTypename, false, targetID) {
-		case 0:
-			func() {
-				targetFT := r.registeredFunctionTypes[targetTypename]
-
-				// Do not send original data, prevents same data concurrent access from different functions
-				var payloadCopy *easyjson.JSON = nil
-				var optionsCopy *easyjson.JSON = nil
-				if payload != nil {
-					payloadCopy = payload.Clone().GetPtr()
-				}
-				if options != nil {
-					optionsCopy = options.Clone().GetPtr()
-				}
-				// ----------------------------------------------------------------------------------------
-				functionMsg := FunctionTypeMsg{
-					Caller:  &sfPlugins.StatefunAddress{Typename: callerTypename, ID: callerID},
-					Payload: payloadCopy,
-					Options: optionsCopy,
-				}
-
-				ackChannel := make(chan struct{})
-				nackChannel := make(chan struct{})
-
-				functionMsg.AckCallback = func(ack bool) {
-					if ack {
-						ackChannel <- struct{}{}
-					} else {
-						functionMsg.RefusalCallback()
-					}
-				}
-				functionMsg.RefusalCallback = func() {
-					logger.Logf(logger.WarnLevel, "goLangLocalSignal: receiver typename=%s called on id=%s refused from msg, for safety reasons msg is being redirected to NATS Jetstream", targetTypename, targetID)
-					system.MsgOnErrorReturn(r.signal(sfPlugins.JetstreamGlobalSignal, callerTypename, callerID, targetTypename, targetID, payload, options))
-					nackChannel <- struct{}{}
-				}
-
-				targetFT.sendMsg(targetID, functionMsg)
-
-				select {
-				case _, ok := <-ackChannel:
-					if !ok {
-						logger.Logln(logger.ErrorLevel, "goLangLocalSignal: ackChannel was unexpectedly closed")
-					}
-					// if ok - whether signal is delivered
-				case _, ok := <-nackChannel:
-					if !ok {
-						logger.Logln(logger.ErrorLevel, "goLangLocalSignal: nackChannel was unexpectedly closed")
-					}
-					// if ok - whether signal is redirected to NATS Jetstream due to nack command
-				case <-time.After(time.Duration(targetFT.config.msgAckWaitMs) * time.Millisecond):
-					logger.Logf(logger.WarnLevel, "goLangLocalSignal: receiver typename=%s called on id=%s did not ack msg in time, for safety reasons msg is being redirected to NATS Jetstream", targetTypename, targetID)
-					system.MsgOnErrorReturn(r.signal(sfPlugins.JetstreamGlobalSignal, callerTypename, callerID, targetTypename, targetID, payload, options))
-				}
-			}()
-			return nil
-		case 1:
-			return fmt.Errorf("goLangLocalSignal: cannot request function with the typename %s via golang, domain differs: %s(runtime) != %s(id)", callerTypename, r.Domain.name, r.Domain.GetDomainFromObjectID(targetID))
-		case 2:
-			return fmt.Errorf("goLangLocalSignal: cannot request function with the typename %s via golang, not registered", callerTypename)
-		case 3:
-			fallthrough
-		default:
-			logger.Logf(logger.WarnLevel, "goLangLocalSignal: receiver typename=%s does not support golang signals, for safety reasons msg is being redirected to NATS Jetstream", targetTypename)
-			system.MsgOnErrorReturn(r.signal(sfPlugins.JetstreamGlobalSignal, callerTypename, callerID, targetTypename, targetID, payload, options))
-			return nil
+type jetstreamSignalTransport struct {
+	r *Runtime
+}
+
+func (t *jetstreamSignalTransport) Signal(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) error {
+	r := t.r
+	go func() {
+		system.GlobalPrometrics.GetRoutinesCounter().Started("ingress-jetstreamGlobalSignal-gofunc")
+		defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("ingress-jetstreamGlobalSignal-gofunc")
+
+		subject, data, err := r.jetstreamSignalMsg(caller, target, payload, options)
+		if err != nil {
+			system.MsgOnErrorReturn(err)
+			return
 		}
+		system.MsgOnErrorReturn(r.nc.Publish(subject, data))
+	}()
+	return nil
+}
+
+// SignalConfirmed publishes the signal and returns when the target's stream has stored it
+func (t *jetstreamSignalTransport) SignalConfirmed(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) error {
+	subject, data, err := t.r.jetstreamSignalMsg(caller, target, payload, options)
+	if err != nil {
+		return err
 	}
+	_, err = t.r.js.Publish(subject, data)
+	return err
+}
 
-	switch signalProvider {
-	case sfPlugins.JetstreamGlobalSignal:
-		return jetstreamGlobalSignal()
-	case sfPlugins.GolangLocalSignal:
-		return goLangLocalSignal()
-	case sfPlugins.AutoSignalSelect:
-		return jetstreamGlobalSignal() // TODO: Find a way to fix weak solution of the goLangLocalSignal
-		/*selection := sfPlugins.JetstreamGlobalSignal
-		if r.isShadowObject(targetID) {
-			if r.functionTypeIsReadyForGoLangCommunication(targetTypename, false, targetID) == 0 {
-				selection = sfPlugins.GolangLocalSignal
-			}
+func (t *jetstreamSignalTransport) Subscribe(ft *FunctionType) error {
+	return AddSignalSourceJetstreamQueuePushConsumer(ft)
+}
+
+type natsCoreRequestTransport struct {
+	r *Runtime
+}
+
+func (t *natsCoreRequestTransport) Request(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON, timeout time.Duration) (*easyjson.JSON, error) {
+	r := t.r
+	var (
+		resp *nats.Msg
+		err  error
+	)
+
+	if r.Domain.IsShadowObject(target.ID) {
+		resp, err = r.requestShadowObject(caller.Typename, caller.ID, target.Typename, target.ID, payload, options)
+	} else {
+		resp, err = r.nc.Request(
+			fmt.Sprintf("%s.%s.%s.%s", RequestPrefix, r.Domain.GetDomainFromObjectID(target.ID), target.Typename, target.ID),
+			buildNatsData(r.Domain.name, caller.Typename, caller.ID, payload, options),
+			timeout,
+		)
+	}
+
+	if err == nil {
+		if j, ok := easyjson.JSONFromBytes(resp.Data); ok {
+			return &j, nil
 		}
-		return r.signal(selection, callerTypename, callerID, targetTypename, targetID, payload, options)*/
-	default:
-		return fmt.Errorf("unknown signal provider: %d", signalProvider)
+		return nil, fmt.Errorf("response from function typename \"%s\" with id \"%s\" is not a json", target.Typename, target.ID)
 	}
+	return nil, err
 }
 
-func (r *Runtime) request(requestProvider sfPlugins.RequestProvider, callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON, timeout ...time.Duration) (*easyjson.JSON, error) {
-	requestTimeoutDuration := time.Duration(r.config.requestTimeoutSec) * time.Second
-	if len(timeout) > 0 {
-		requestTimeoutDuration = timeout[0]
-	}
-	natsCoreGlobalRequest := func() (*easyjson.JSON, error) {
-		var (
-			resp *nats.Msg
-			err  error
-		)
+func (t *natsCoreRequestTransport) Subscribe(ft *FunctionType) error {
+	return AddRequestSourceNatsCore(ft)
+}
 
-		if r.Domain.IsShadowObject(targetID) {
-			resp, err = r.requestShadowObject(callerTypename, callerID, targetTypename, targetID, payload, options)
-		} else {
-			resp, err = r.nc.Request(
-				fmt.Sprintf("%s.%s.%s.%s", RequestPrefix, r.Domain.GetDomainFromObjectID(targetID), targetTypename, targetID),
-				buildNatsData(r.Domain.name, callerTypename, callerID, payload, options),
-				requestTimeoutDuration,
-			)
+type golangLocalRequestTransport struct {
+	r *Runtime
+}
+
+func (t *golangLocalRequestTransport) Request(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON, timeout time.Duration) (*easyjson.JSON, error) {
+	r := t.r
+	callerTypename, targetTypename, targetID := caller.Typename, target.Typename, target.ID
+
+	targetFT, readiness := r.functionTypeIsReadyForGoLangCommunication(targetTypename, true, targetID)
+	switch readiness {
+	case 0:
+		resultJSONChannel := make(chan *easyjson.JSON, 1) // Buffered, reply may come before waiting for it
+
+		// Do not send original data, prevents same data concurrent access from different functions
+		var payloadCopy *easyjson.JSON = nil
+		var optionsCopy *easyjson.JSON = nil
+		if payload != nil {
+			payloadCopy = payload.Clone().GetPtr()
+		}
+		if options != nil {
+			optionsCopy = options.Clone().GetPtr()
+		}
+		// ----------------------------------------------------------------------------------------
+		functionMsg := FunctionTypeMsg{
+			Caller:  &sfPlugins.StatefunAddress{Typename: caller.Typename, ID: caller.ID},
+			Payload: payloadCopy,
+			Options: optionsCopy,
 		}
 
-		if err == nil {
-			if j, ok := easyjson.JSONFromBytes(resp.Data); ok {
-				return &j, nil
-			}
-			return nil, fmt.Errorf("response from function typename \"%s\" with id \"%s\" is not a json", targetTypename, targetID)
+		functionMsg.RequestCallback = func(data *easyjson.JSON) {
+			resultJSONChannel <- data.Clone().GetPtr() // Clone().GetPtr() prevents data to contain custom Golang types
+		}
+		functionMsg.RefusalCallback = func() {
+			close(resultJSONChannel)
 		}
-		return nil, err
-	}
-	goLangLocalRequest := func() (*easyjson.JSON, error) {
-		switch r.functionTypeIsReadyForGoLangCommunication(targetTypename, true, targetID) {
-		case 0:
-			targetFT := r.registeredFunctionTypes[targetTypename]
-			resultJSONChannel := make(chan *easyjson.JSON)
-
-			// Do not send original data, prevents same data concurrent access from different functions
-			var payloadCopy *easyjson.JSON = nil
-			var optionsCopy *easyjson.JSON = nil
-			if payload != nil {
-				payloadCopy = payload.Clone().GetPtr()
-			}
-			if options != nil {
-				optionsCopy = options.Clone().GetPtr()
-			}
-			// ----------------------------------------------------------------------------------------
-			functionMsg := FunctionTypeMsg{
-				Caller:  &sfPlugins.StatefunAddress{Typename: callerTypename, ID: callerID},
-				Payload: payloadCopy,
-				Options: optionsCopy,
-			}
 
-			functionMsg.RequestCallback = func(data *easyjson.JSON) {
-				resultJSONChannel <- data.Clone().GetPtr() // Clone().GetPtr() prevents data to contain custom Golang types
-			}
-			functionMsg.RefusalCallback = func() {
-				close(resultJSONChannel)
+		targetFT.sendMsg(targetID, functionMsg)
+
+		select {
+		case resultJSON, ok := <-resultJSONChannel:
+			if ok {
+				return resultJSON, nil
 			}
+			return nil, fmt.Errorf("goLangLocalRequest: target function with typename \"%s\" with id \"%s\" resufes to handle request", targetTypename, targetID)
+		case <-time.After(timeout):
+			return nil, fmt.Errorf("goLangLocalRequest: timeout occured while requesting function typename \"%s\" with id \"%s\": %w", targetTypename, targetID, context.DeadlineExceeded)
+		}
+	case 1:
+		return nil, fmt.Errorf("goLangLocalRequest: cannot request function with the typename %s via golang, domain differs: %s(runtime) != %s(id)", callerTypename, r.Domain.name, r.Domain.GetDomainFromObjectID(targetID))
+	case 2:
+		return nil, fmt.Errorf("goLangLocalRequest: cannot request function with the typename %s via golang, not registered", callerTypename)
+	case 3:
+		fallthrough
+	default:
+		return nil, fmt.Errorf("goLangLocalRequest: function with the typename %s does not support request-reply via golang", callerTypename)
+	}
+}
 
-			targetFT.sendMsg(targetID, functionMsg)
+// ------------------------------------------------------------------------------------------------
 
-			select {
-			case resultJSON, ok := <-resultJSONChannel:
-				if ok {
-					return resultJSON, nil
-				}
-				return nil, fmt.Errorf("goLangLocalRequest: target function with typename \"%s\" with id \"%s\" resufes to handle request", targetTypename, targetID)
-			case <-time.After(requestTimeoutDuration):
-				return nil, fmt.Errorf("goLangLocalRequest: timeout occured while requesting function typename \"%s\" with id \"%s\"", targetTypename, targetID)
-			}
-		case 1:
-			return nil, fmt.Errorf("goLangLocalRequest: cannot request function with the typename %s via golang, domain differs: %s(runtime) != %s(id)", callerTypename, r.Domain.name, r.Domain.GetDomainFromObjectID(targetID))
-		case 2:
-			return nil, fmt.Errorf("goLangLocalRequest: cannot request function with the typename %s via golang, not registered", callerTypename)
-		case 3:
-			fallthrough
-		default:
-			return nil, fmt.Errorf("goLangLocalRequest: function with the typename %s does not support request-reply via golang", callerTypename)
+// signalTransport returns the transport of the provider, AutoSignalSelect is resolved for the target
+func (r *Runtime) signalTransport(signalProvider sfPlugins.SignalProvider, targetTypename string, targetID string) (SignalTransport, error) {
+	if signalProvider == sfPlugins.AutoSignalSelect {
+		signalProvider = sfPlugins.JetstreamGlobalSignal
+		if r.js == nil || r.localSignalIsPreferred(targetTypename, targetID) {
+			signalProvider = sfPlugins.GolangLocalSignal
 		}
 	}
+	transport, ok := r.getSignalTransport(signalProvider)
+	if !ok {
+		return nil, fmt.Errorf("unknown signal provider: %d", signalProvider)
+	}
+	return transport, nil
+}
 
-	switch requestProvider {
-	case sfPlugins.NatsCoreGlobalRequest:
-		return natsCoreGlobalRequest()
-	case sfPlugins.GolangLocalRequest:
-		return goLangLocalRequest()
-	case sfPlugins.AutoRequestSelect:
-		selection := sfPlugins.NatsCoreGlobalRequest
-		if !r.Domain.IsShadowObject(targetID) {
-			if r.functionTypeIsReadyForGoLangCommunication(targetTypename, true, targetID) == 0 {
-				selection = sfPlugins.GolangLocalRequest
+func (r *Runtime) signal(signalProvider sfPlugins.SignalProvider, callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON) error {
+	transport, err := r.signalTransport(signalProvider, targetTypename, targetID)
+	if err != nil {
+		return err
+	}
+	return transport.Signal(sfPlugins.StatefunAddress{Typename: callerTypename, ID: callerID}, sfPlugins.StatefunAddress{Typename: targetTypename, ID: targetID}, payload, options)
+}
+
+// signalConfirmed sends the signal through the provider's transport, returns when the signal is durably accepted if the
+// transport can tell it
+func (r *Runtime) signalConfirmed(signalProvider sfPlugins.SignalProvider, callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON) error {
+	transport, err := r.signalTransport(signalProvider, targetTypename, targetID)
+	if err != nil {
+		return err
+	}
+	caller, target := sfPlugins.StatefunAddress{Typename: callerTypename, ID: callerID}, sfPlugins.StatefunAddress{Typename: targetTypename, ID: targetID}
+	if confirmed, ok := transport.(ConfirmedSignalTransport); ok {
+		return confirmed.SignalConfirmed(caller, target, payload, options)
+	}
+	return transport.Signal(caller, target, payload, options)
+}
+
+func (r *Runtime) request(requestProvider sfPlugins.RequestProvider, callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON, timeout ...time.Duration) (*easyjson.JSON, error) {
+	requestTimeoutDuration := time.Duration(r.config.requestTimeoutSec) * time.Second
+	if len(timeout) > 0 {
+		requestTimeoutDuration = timeout[0]
+	}
+	if requestProvider == sfPlugins.AutoRequestSelect {
+		requestProvider = sfPlugins.NatsCoreGlobalRequest
+		if r.nc == nil {
+			requestProvider = sfPlugins.GolangLocalRequest
+		} else if !r.Domain.IsShadowObject(targetID) {
+			if _, readiness := r.functionTypeIsReadyForGoLangCommunication(targetTypename, true, targetID); readiness == 0 {
+				requestProvider = sfPlugins.GolangLocalRequest
 			}
 		}
-		return r.request(selection, callerTypename, callerID, targetTypename, targetID, payload, options)
-	default:
+	}
+	transport, ok := r.getRequestTransport(requestProvider)
+	if !ok {
 		return nil, fmt.Errorf("unknown request provider: %d", requestProvider)
 	}
+	return transport.Request(sfPlugins.StatefunAddress{Typename: callerTypename, ID: callerID}, sfPlugins.StatefunAddress{Typename: targetTypename, ID: targetID}, payload, options, requestTimeoutDuration)
+}
+
+func (r *Runtime) egress(egressProvider sfPlugins.EgressProvider, callerTypename string, callerID string, payload *easyjson.JSON) error {
+	if egressProvider == sfPlugins.AutoEgressSelect {
+		egressProvider = sfPlugins.NatsCoreEgress
+		if ft, ok := r.getRegisteredFunctionType(callerTypename); ok {
+			egressProvider = ft.config.egressProvider
+		}
+	}
+	transport, ok := r.getEgressTransport(egressProvider)
+	if !ok {
+		return fmt.Errorf("unknown egress provider: %d", egressProvider)
+	}
+	return transport.Egress(sfPlugins.StatefunAddress{Typename: callerTypename, ID: callerID}, payload)
 }
 
 func (r *Runtime) Signal(signalProvider sfPlugins.SignalProvider, typename string, id string, payload *easyjson.JSON, options *easyjson.JSON) error {
@@ -6,14 +6,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
 	lg "github.com/foliagecp/sdk/statefun/logger"
 
+	"github.com/foliagecp/sdk/statefun/cache"
 	"github.com/foliagecp/sdk/statefun/system"
-	"github.com/nats-io/nats.go"
 )
 
 var (
@@ -24,9 +23,14 @@ var (
 
 // KeyMutexLock
 // errorOnLocked - if mutex is already locked, exit with error (do not wait for unlocking)
-func KeyMutexLock(ctx context.Context, runtime *Runtime, key string, errorOnLocked bool) (uint64, error) {
+func KeyMutexLock(ctx context.Context, runtime *Runtime, key string, errorOnLocked bool) (lockRevisionID uint64, err error) {
 	le := lg.NewLogger(lg.Options{ReportCaller: true, Level: lg.TraceLevel})
 	kv := runtime.Domain.kv
+	defer func() {
+		if err == nil {
+			runtime.heldKeyMutexes.Store(key, &heldKeyMutex{revision: lockRevisionID, lockedAt: system.GetCurrentTimeNs()})
+		}
+	}()
 	mutexResetLock := func(keyMutex string, now int64) (uint64, error) {
 		lockRevisionID, err := kv.Put(keyMutex, system.Int64ToBytes(now))
 		if err == nil {
@@ -35,24 +39,28 @@ func KeyMutexLock(ctx context.Context, runtime *Runtime, key string, errorOnLock
 		}
 		return 0, err
 	}
-	mutexMereLock := func(entry nats.KeyValueEntry, now int64) (uint64, error) {
+	mutexMereLock := func(entry *cache.BackendEntry, now int64) (uint64, error) {
 		// Try to lock mutex by updating it with current time value using revision obtained during last Get
-		lockRevisionID, err := kv.Update(entry.Key(), system.Int64ToBytes(now), entry.Revision())
+		lockRevisionID, err := kv.Update(entry.Key, system.Int64ToBytes(now), entry.Revision)
 		if err != nil { // If no error appeared
-			if strings.Contains(err.Error(), "nats: wrong last sequence") { // If error "wrong revision" appeared
+			if errors.Is(err, cache.ErrBackendRevisionMismatch) { // If error "wrong revision" appeared
 				//le.Tracef(lg.ErrorLevel, "%s: ERROR mutexMereLock: tried to lock with wrong revisionId", caller)
 				return 0, nil
 			}
 			return 0, err // Terminate with error
 		}
-		le.Tracef(ctx, "============== Locked %s", entry.Key())
+		le.Tracef(ctx, "============== Locked %s", entry.Key)
 		return lockRevisionID, nil // Successfully locked
 	}
-	getKeyWatch := func(keyMutex string) (nats.KeyWatcher, error) {
+	getKeyWatch := func(keyMutex string) (cache.BackendWatcher, error) {
 		kwWatchMutex.Lock()
-		return kv.Watch(keyMutex, nats.IgnoreDeletes())
+		w, err := kv.Watch(keyMutex, true)
+		if err != nil {
+			kwWatchMutex.Unlock()
+		}
+		return w, err
 	}
-	releaseKeyWatch := func(w nats.KeyWatcher) {
+	releaseKeyWatch := func(w cache.BackendWatcher) {
 		system.MsgOnErrorReturn(w.Stop())
 		kwWatchMutex.Unlock()
 	}
@@ -61,7 +69,7 @@ func KeyMutexLock(ctx context.Context, runtime *Runtime, key string, errorOnLock
 			if w, err := getKeyWatch(keyMutex); err == nil {
 				entry := <-w.Updates()
 				if entry != nil {
-					lockTime := system.BytesToInt64(entry.Value())
+					lockTime := system.BytesToInt64(entry.Value)
 					if lockTime == 0 {
 						releaseKeyWatch(w)
 						return
@@ -91,7 +99,7 @@ func KeyMutexLock(ctx context.Context, runtime *Runtime, key string, errorOnLock
 
 		entry, err := kv.Get(keyMutex) // Getting last mutex state for key
 		if err != nil {
-			if errors.Is(err, nats.ErrKeyNotFound) {
+			if errors.Is(err, cache.ErrBackendKeyNotFound) {
 				mutexResetLockNeeded = true
 			} else {
 				//keyValueMutexOperationMutex.Unlock()
@@ -103,7 +111,7 @@ func KeyMutexLock(ctx context.Context, runtime *Runtime, key string, errorOnLock
 			return mutexResetLock(keyMutex, now)
 		}
 
-		lockTime := system.BytesToInt64(entry.Value())
+		lockTime := system.BytesToInt64(entry.Value)
 		if lockTime == 0 { // Mutex is ready to be locked
 			//defer keyValueMutexOperationMutex.Unlock()
 			revId, err := mutexMereLock(entry, now)
@@ -136,16 +144,19 @@ func KeyMutexLockUpdate(ctx context.Context, runtime *Runtime, key string, lockR
 	if err != nil {
 		return 0, err
 	}
-	if entry.Revision() != lockRevisionID {
-		le.Warnf(ctx, "Context mutex for key=%s with revision=%d was violated, new revision=%d!", key, lockRevisionID, entry.Revision())
+	if entry.Revision != lockRevisionID {
+		le.Warnf(ctx, "Context mutex for key=%s with revision=%d was violated, new revision=%d!", key, lockRevisionID, entry.Revision)
 	}
-	lockTime := system.BytesToInt64(entry.Value())
+	lockTime := system.BytesToInt64(entry.Value)
 	if lockTime != 0 {
-		revId, err := kv.Update(keyMutex, system.Int64ToBytes(system.GetCurrentTimeNs()), entry.Revision())
+		revId, err := kv.Update(keyMutex, system.Int64ToBytes(system.GetCurrentTimeNs()), entry.Revision)
 		if err != nil {
 			return 0, err
 		}
 		le.Tracef(ctx, "============== Updated %s", keyMutex)
+		if v, ok := runtime.heldKeyMutexes.Load(key); ok {
+			runtime.heldKeyMutexes.Store(key, &heldKeyMutex{revision: revId, lockedAt: v.(*heldKeyMutex).lockedAt})
+		}
 		return revId, err
 	} else {
 		return 0, fmt.Errorf("Context mutex for key=%s was already unlocked", key)
@@ -164,12 +175,12 @@ func KeyMutexUnlock(ctx context.Context, runtime *Runtime, key string, lockRevis
 	if err != nil {
 		return err
 	}
-	if entry.Revision() != lockRevisionID {
-		le.Warnf(ctx, "Context mutex for key=%s with revision=%d was violated, new revision=%d!", key, lockRevisionID, entry.Revision())
+	if entry.Revision != lockRevisionID {
+		le.Warnf(ctx, "Context mutex for key=%s with revision=%d was violated, new revision=%d!", key, lockRevisionID, entry.Revision)
 	}
-	lockTime := system.BytesToInt64(entry.Value())
+	lockTime := system.BytesToInt64(entry.Value)
 	if lockTime != 0 {
-		_, err := kv.Update(keyMutex, system.Int64ToBytes(0), entry.Revision())
+		_, err := kv.Update(keyMutex, system.Int64ToBytes(0), entry.Revision)
 		if err != nil {
 			return err
 		}
@@ -177,6 +188,7 @@ func KeyMutexUnlock(ctx context.Context, runtime *Runtime, key string, lockRevis
 		le.Warnf(ctx, "Context mutex for key=%s was already unlocked!", key)
 	}
 	le.Tracef(ctx, "============== Unlocked %s", keyMutex)
+	runtime.heldKeyMutexes.Delete(key)
 	return nil // Successfully unlocked
 }
 
@@ -0,0 +1,444 @@
+package statefun
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	localSignalRetryInterval      = 10 * time.Millisecond
+	localSignalRedeliveryInterval = time.Second
+	localSignalsWALCompactSize    = 1 << 20
+
+	localSignalsWALPut = "put"
+	localSignalsWALAck = "ack"
+)
+
+type localSignal struct {
+	walSeq  uint64
+	caller  sfPlugins.StatefunAddress
+	target  sfPlugins.StatefunAddress
+	payload *easyjson.JSON
+	options *easyjson.JSON
+}
+
+// localSignalBacklog keeps signals for an id which its handler's queue did not accept yet, in order they were sent
+type localSignalBacklog struct {
+	mutex   sync.Mutex
+	signals []*localSignal
+	removed bool // Garbage collected with the id handler, a new backlog is to be created
+}
+
+/*
+GolangLocalSignal enqueues a signal into the target id handler's queue without waiting for it to be handled. When the
+queue does not accept the signal, the signal and all following ones for the same id wait in the id's backlog, so their
+order is kept. Signals which are not accepted within the target's ack wait time, or not handled successfully, are
+redirected to JetStream. A runtime without NATS sends them to the handler again after a while instead.
+
+If the runtime has a local signals write-ahead log, every local signal is written to it before being enqueued and is
+removed when it is handled or redirected to JetStream. Signals left in the log are sent again on the next start.
+*/
+type golangLocalSignalTransport struct {
+	r *Runtime
+}
+
+func (t *golangLocalSignalTransport) Signal(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) error {
+	r := t.r
+	targetFT, readiness := r.functionTypeIsReadyForGoLangCommunication(target.Typename, false, target.ID)
+	switch readiness {
+	case 0:
+		// Do not send original data, prevents same data concurrent access from different functions
+		signal := &localSignal{caller: caller, target: target}
+		if payload != nil {
+			signal.payload = payload.Clone().GetPtr()
+		}
+		if options != nil {
+			signal.options = options.Clone().GetPtr()
+		}
+		// ----------------------------------------------------------------------------------------
+		signal.walSeq = r.localSignalsWAL.put(signal)
+		targetFT.enqueueLocalSignal(signal)
+		return nil
+	case 1:
+		return fmt.Errorf("goLangLocalSignal: cannot request function with the typename %s via golang, domain differs: %s(runtime) != %s(id)", caller.Typename, r.Domain.name, r.Domain.GetDomainFromObjectID(target.ID))
+	case 2:
+		return fmt.Errorf("goLangLocalSignal: cannot request function with the typename %s via golang, not registered", caller.Typename)
+	case 3:
+		fallthrough
+	default:
+		lg.Logf(lg.WarnLevel, "goLangLocalSignal: receiver typename=%s does not support golang signals, for safety reasons msg is being redirected to NATS Jetstream", target.Typename)
+		return r.signal(sfPlugins.JetstreamGlobalSignal, caller.Typename, caller.ID, target.Typename, target.ID, payload, options)
+	}
+}
+
+// SignalConfirmed sends the signal once it is written to the write-ahead log, without the log the signal is published
+// into JetStream instead, unless the runtime runs without NATS
+func (t *golangLocalSignalTransport) SignalConfirmed(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) error {
+	if t.r.localSignalsWAL == nil && t.r.js != nil {
+		return t.r.signalConfirmed(sfPlugins.JetstreamGlobalSignal, caller.Typename, caller.ID, target.Typename, target.ID, payload, options)
+	}
+	return t.Signal(caller, target, payload, options)
+}
+
+// localSignalIsPreferred tells whether AutoSignalSelect should deliver the signal within this runtime: the target's type
+// is registered and started in it for the target's domain
+func (r *Runtime) localSignalIsPreferred(targetTypename string, targetID string) bool {
+	if r.Domain.IsShadowObject(targetID) {
+		return false
+	}
+	ft, readiness := r.functionTypeIsReadyForGoLangCommunication(targetTypename, false, targetID)
+	if readiness != 0 || !r.started.Load() {
+		return false
+	}
+	if !ft.config.multipleInstancesAllowed {
+		r.singleInstanceRevisionsMutex.Lock()
+		_, ok := r.singleInstanceRevisions[ft.name]
+		r.singleInstanceRevisionsMutex.Unlock()
+		return ok
+	}
+	return true
+}
+
+func (ft *FunctionType) enqueueLocalSignal(signal *localSignal) {
+	id := ft.runtime.Domain.CreateObjectIDWithThisDomain(signal.target.ID, false)
+	var backlog *localSignalBacklog
+	for {
+		v, _ := ft.localSignalBacklogs.LoadOrStore(id, &localSignalBacklog{})
+		backlog = v.(*localSignalBacklog)
+		backlog.mutex.Lock()
+		if !backlog.removed {
+			break
+		}
+		backlog.mutex.Unlock()
+	}
+	defer backlog.mutex.Unlock()
+	if len(backlog.signals) == 0 && ft.pushLocalSignal(id, signal) {
+		return
+	}
+	backlog.signals = append(backlog.signals, signal)
+	if len(backlog.signals) == 1 {
+		go ft.flushLocalSignalBacklog(id, backlog)
+	}
+}
+
+// pushLocalSignal returns false if the id handler's queue did not accept the signal
+func (ft *FunctionType) pushLocalSignal(id string, signal *localSignal) bool {
+	var refused atomic.Bool
+	msg := FunctionTypeMsg{
+		Caller:  &signal.caller,
+		Payload: signal.payload,
+		Options: signal.options,
+	}
+	msg.AckCallback = func(ack bool) {
+		if ack {
+			ft.runtime.localSignalsWAL.ack(signal.walSeq)
+		} else {
+			ft.runtime.redirectLocalSignal(signal, "signal was not acked by the handler")
+		}
+	}
+	msg.ErrorCallback = func(err error) {
+		ft.runtime.redirectLocalSignal(signal, err.Error())
+	}
+	msg.RefusalCallback = func() {
+		refused.Store(true)
+	}
+	ft.sendMsg(id, msg)
+	return !refused.Load()
+}
+
+// removeLocalSignalBacklog removes the id's backlog if it holds no signals, returns false otherwise
+func (ft *FunctionType) removeLocalSignalBacklog(id string) bool {
+	v, ok := ft.localSignalBacklogs.Load(id)
+	if !ok {
+		return true
+	}
+	backlog := v.(*localSignalBacklog)
+	backlog.mutex.Lock()
+	defer backlog.mutex.Unlock()
+	if len(backlog.signals) > 0 {
+		return false
+	}
+	backlog.removed = true
+	ft.localSignalBacklogs.Delete(id)
+	return true
+}
+
+func (ft *FunctionType) flushLocalSignalBacklog(id string, backlog *localSignalBacklog) {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("functiontype-flushLocalSignalBacklog")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("functiontype-flushLocalSignalBacklog")
+
+	deadline := time.Now().Add(time.Duration(ft.config.msgAckWaitMs) * time.Millisecond)
+	for {
+		backlog.mutex.Lock()
+		if len(backlog.signals) == 0 {
+			backlog.mutex.Unlock()
+			return
+		}
+		if ft.pushLocalSignal(id, backlog.signals[0]) {
+			backlog.signals = backlog.signals[1:]
+			deadline = time.Now().Add(time.Duration(ft.config.msgAckWaitMs) * time.Millisecond)
+			backlog.mutex.Unlock()
+			continue
+		}
+		if ft.draining.Load() || time.Now().After(deadline) {
+			for _, signal := range backlog.signals {
+				ft.runtime.redirectLocalSignal(signal, "receiver did not accept signal in time")
+			}
+			backlog.signals = nil
+			backlog.mutex.Unlock()
+			return
+		}
+		backlog.mutex.Unlock()
+		time.Sleep(localSignalRetryInterval)
+	}
+}
+
+// redirectLocalSignal publishes the signal into the target function type's JetStream stream. Without NATS the signal is
+// enqueued again after a while, one left at shutdown is kept in the log.
+func (r *Runtime) redirectLocalSignal(signal *localSignal, reason string) {
+	if r.js == nil {
+		ft, ok := r.getRegisteredFunctionType(signal.target.Typename)
+		if !ok || ft.draining.Load() {
+			return
+		}
+		lg.Logf(lg.WarnLevel, "goLangLocalSignal: receiver typename=%s called on id=%s: %s, signal is sent again in %s", signal.target.Typename, signal.target.ID, reason, localSignalRedeliveryInterval)
+		time.AfterFunc(localSignalRedeliveryInterval, func() {
+			ft.enqueueLocalSignal(signal)
+		})
+		return
+	}
+	lg.Logf(lg.WarnLevel, "goLangLocalSignal: receiver typename=%s called on id=%s: %s, for safety reasons msg is being redirected to NATS Jetstream", signal.target.Typename, signal.target.ID, reason)
+	r.publishRedirectedLocalSignal(signal)
+}
+
+// publishRedirectedLocalSignal publishes the signal into JetStream and removes it from the write-ahead log. A signal the
+// stream does not accept is published again after a while until the runtime shuts down, it is kept in the log meanwhile.
+func (r *Runtime) publishRedirectedLocalSignal(signal *localSignal) {
+	_, err := r.js.Publish(
+		fmt.Sprintf(DomainIngressSubjectsTmpl, r.Domain.name, fmt.Sprintf("%s.%s.%s.%s", SignalPrefix, r.Domain.name, signal.target.Typename, signal.target.ID)),
+		buildNatsData(r.Domain.name, signal.caller.Typename, signal.caller.ID, signal.payload, signal.options),
+	)
+	if err == nil {
+		r.localSignalsWAL.ack(signal.walSeq)
+		return
+	}
+	select {
+	case <-r.shutdown:
+		lg.Logf(lg.ErrorLevel, "goLangLocalSignal: cannot redirect signal for typename=%s id=%s to NATS Jetstream: %s", signal.target.Typename, signal.target.ID, err)
+		return
+	default:
+	}
+	lg.Logf(lg.ErrorLevel, "goLangLocalSignal: cannot redirect signal for typename=%s id=%s to NATS Jetstream: %s, retrying in %s", signal.target.Typename, signal.target.ID, err, localSignalRedeliveryInterval)
+	time.AfterFunc(localSignalRedeliveryInterval, func() {
+		r.publishRedirectedLocalSignal(signal)
+	})
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+/*
+localSignalsWAL is an append-only file of NDJSON records: {"op":"put","seq":...,"signal":...} when a local signal is sent
+and {"op":"ack","seq":...} when it is handled. The file is truncated when no signals are pending and it grew large.
+*/
+type localSignalsWAL struct {
+	mutex   sync.Mutex
+	file    *os.File
+	seq     uint64
+	pending map[uint64]struct{}
+	size    int64
+}
+
+// openLocalSignalsWAL opens the log and returns signals left pending in it with their sequences, they stay in the log
+// until handled. A torn record at the end of the log is cut off.
+func openLocalSignalsWAL(path string) (*localSignalsWAL, []*localSignal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w := &localSignalsWAL{file: file, pending: map[uint64]struct{}{}}
+	pendingRecords := map[uint64]easyjson.JSON{}
+	order := []uint64{}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break // Torn write at the end of the log if the line is not empty
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		w.size += int64(len(line))
+		record, ok := easyjson.JSONFromBytes(line)
+		if !ok {
+			continue
+		}
+		seq := uint64(record.GetByPath("seq").AsNumericDefault(0))
+		w.seq = max(w.seq, seq)
+		switch record.GetByPath("op").AsStringDefault("") {
+		case localSignalsWALPut:
+			pendingRecords[seq] = record.GetByPath("signal")
+			order = append(order, seq)
+		case localSignalsWALAck:
+			delete(pendingRecords, seq)
+		}
+	}
+
+	pending := []*localSignal{}
+	for _, seq := range order {
+		if j, ok := pendingRecords[seq]; ok {
+			signal := localSignalFromJSON(j)
+			signal.walSeq = seq
+			pending = append(pending, signal)
+			w.pending[seq] = struct{}{}
+		}
+	}
+
+	if err := file.Truncate(w.size); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(w.size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return w, pending, nil
+}
+
+func localSignalToJSON(signal *localSignal) easyjson.JSON {
+	j := easyjson.NewJSONObject()
+	j.SetByPath("caller_typename", easyjson.NewJSON(signal.caller.Typename))
+	j.SetByPath("caller_id", easyjson.NewJSON(signal.caller.ID))
+	j.SetByPath("typename", easyjson.NewJSON(signal.target.Typename))
+	j.SetByPath("id", easyjson.NewJSON(signal.target.ID))
+	if signal.payload != nil {
+		j.SetByPath("payload", *signal.payload)
+	}
+	if signal.options != nil {
+		j.SetByPath("options", *signal.options)
+	}
+	return j
+}
+
+func localSignalFromJSON(j easyjson.JSON) *localSignal {
+	signal := &localSignal{
+		caller: sfPlugins.StatefunAddress{Typename: j.GetByPath("caller_typename").AsStringDefault(""), ID: j.GetByPath("caller_id").AsStringDefault("")},
+		target: sfPlugins.StatefunAddress{Typename: j.GetByPath("typename").AsStringDefault(""), ID: j.GetByPath("id").AsStringDefault("")},
+	}
+	if j.PathExists("payload") {
+		signal.payload = j.GetByPath("payload").GetPtr()
+	}
+	if j.PathExists("options") {
+		signal.options = j.GetByPath("options").GetPtr()
+	}
+	return signal
+}
+
+func (w *localSignalsWAL) write(record easyjson.JSON, sync bool) error {
+	data := append(record.ToBytes(), '\n')
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if sync {
+		return w.file.Sync()
+	}
+	return nil
+}
+
+// put writes the signal into the log and returns its sequence, 0 if the log is disabled
+func (w *localSignalsWAL) put(signal *localSignal) uint64 {
+	if w == nil {
+		return 0
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.seq++
+	record := easyjson.NewJSONObjectWithKeyValue("op", easyjson.NewJSON(localSignalsWALPut))
+	record.SetByPath("seq", easyjson.NewJSON(w.seq))
+	record.SetByPath("signal", localSignalToJSON(signal))
+	if err := w.write(record, true); err != nil {
+		lg.Logf(lg.ErrorLevel, "Local signals write-ahead log failed to put signal: %s", err)
+		return 0
+	}
+	w.pending[w.seq] = struct{}{}
+	return w.seq
+}
+
+func (w *localSignalsWAL) ack(seq uint64) {
+	if w == nil || seq == 0 {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, ok := w.pending[seq]; !ok {
+		return
+	}
+	delete(w.pending, seq)
+
+	if len(w.pending) == 0 && w.size > localSignalsWALCompactSize {
+		if err := w.file.Truncate(0); err == nil {
+			_, err = w.file.Seek(0, io.SeekStart)
+			system.MsgOnErrorReturn(err)
+			w.size = 0
+			return
+		}
+	}
+	record := easyjson.NewJSONObjectWithKeyValue("op", easyjson.NewJSON(localSignalsWALAck))
+	record.SetByPath("seq", easyjson.NewJSON(seq))
+	system.MsgOnErrorReturn(w.write(record, false))
+}
+
+func (w *localSignalsWAL) close() {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	system.MsgOnErrorReturn(w.file.Close())
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+func (r *Runtime) openLocalSignalsWAL() error {
+	if len(r.config.localSignalsWALPath) == 0 {
+		return nil
+	}
+	wal, pending, err := openLocalSignalsWAL(r.config.localSignalsWALPath)
+	if err != nil {
+		return err
+	}
+	r.localSignalsWAL = wal
+	r.pendingLocalSignals = pending
+	return nil
+}
+
+// replayLocalSignals sends signals left in the write-ahead log by the previous run, they keep their records in the log
+func (r *Runtime) replayLocalSignals() {
+	if len(r.pendingLocalSignals) > 0 {
+		lg.Logf(lg.InfoLevel, "Replaying %d local signals from the write-ahead log", len(r.pendingLocalSignals))
+	}
+	for _, signal := range r.pendingLocalSignals {
+		if targetFT, readiness := r.functionTypeIsReadyForGoLangCommunication(signal.target.Typename, false, signal.target.ID); readiness == 0 {
+			targetFT.enqueueLocalSignal(signal)
+		} else {
+			r.redirectLocalSignal(signal, "receiver does not take golang signals")
+		}
+	}
+	r.pendingLocalSignals = nil
+}
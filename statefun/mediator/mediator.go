@@ -266,7 +266,7 @@ func (om *OpMediator) ReplyWithData(data *easyjson.JSON) error {
 			} else {
 				if paTypename, paId, aggrId, ok := om.releaseAggPackAndGetParentSignalAggregator(aggregationPack); ok {
 					if len(paTypename) == 0 || len(paId) == 0 {
-						return om.ctx.Egress(sfPlugins.NatsCoreEgress, reply)
+						return om.ctx.Egress(sfPlugins.AutoEgressSelect, reply)
 					} else {
 						if len(aggrId) > 0 {
 							reply.SetByPath("__mAggregationIdReply", easyjson.NewJSON(aggrId))
@@ -282,7 +282,7 @@ func (om *OpMediator) ReplyWithData(data *easyjson.JSON) error {
 				om.ctx.Reply.With(reply)
 			} else {
 				if len(om.ctx.Caller.Typename) == 0 || len(om.ctx.Caller.ID) == 0 {
-					return om.ctx.Egress(sfPlugins.NatsCoreEgress, reply)
+					return om.ctx.Egress(sfPlugins.AutoEgressSelect, reply)
 				} else {
 					if om.opType == WorkerIsTaskedByAggregatorOp {
 						reply.SetByPath("__mAggregationIdReply", easyjson.NewJSON(om.meta1))
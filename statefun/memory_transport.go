@@ -0,0 +1,192 @@
+package statefun
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+type MemoryEgressHandler func(caller sfPlugins.StatefunAddress, payload *easyjson.JSON)
+
+/*
+MemoryTransport delivers signals, requests and egress messages between runtimes of the same process without NATS. Every
+attached runtime serves its domain, messages to objects of other domains are delivered to the runtime attached for that
+domain. Nothing is persisted: a signal which was refused or failed in the handler is lost, delayed by rate limits is kept
+in memory only. The domain cache, timers and key mutexes live in JetStream unless the runtime runs without NATS
+(RuntimeConfig.SetWithoutNats), a runtime with no NATS server to connect to may also run an in-process one
+(RuntimeConfig.SetInProcessNats).
+
+	transport := statefun.NewMemoryTransport()
+	transport.Attach(runtime)
+
+	statefun.NewFunctionType(runtime, "functions.app.counter", counter, *statefun.NewFunctionTypeConfig().
+		SetAllowedSignalProviders(sfPlugins.InMemorySignal).
+		SetAllowedRequestProviders(sfPlugins.InMemoryRequest))
+
+	runtime.Signal(sfPlugins.InMemorySignal, "functions.app.counter", "a", nil, nil)
+*/
+type MemoryTransport struct {
+	runtimes            sync.Map // domain name -> *Runtime
+	egressHandlers      sync.Map // handler id -> MemoryEgressHandler
+	egressHandlersCount int64
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+// Attach registers the transport for InMemorySignal, InMemoryRequest and InMemoryEgress providers of the runtime
+func (t *MemoryTransport) Attach(r *Runtime) {
+	t.runtimes.Store(r.Domain.name, r)
+	endpoint := &memoryTransportEndpoint{transport: t, r: r}
+	system.MsgOnErrorReturn(r.RegisterSignalTransport(sfPlugins.InMemorySignal, endpoint))
+	system.MsgOnErrorReturn(r.RegisterRequestTransport(sfPlugins.InMemoryRequest, endpoint))
+	system.MsgOnErrorReturn(r.RegisterEgressTransport(sfPlugins.InMemoryEgress, endpoint))
+}
+
+// Detach stops delivering messages to the runtime's domain
+func (t *MemoryTransport) Detach(r *Runtime) {
+	t.runtimes.CompareAndDelete(r.Domain.name, r)
+}
+
+// SubscribeEgress calls the handler for every InMemoryEgress message, the handler is called synchronously by the sender
+func (t *MemoryTransport) SubscribeEgress(handler MemoryEgressHandler) (unsubscribe func()) {
+	handlerID := atomic.AddInt64(&t.egressHandlersCount, 1)
+	t.egressHandlers.Store(handlerID, handler)
+	return func() {
+		t.egressHandlers.Delete(handlerID)
+	}
+}
+
+func (t *MemoryTransport) getRuntime(domain string) (*Runtime, bool) {
+	if v, ok := t.runtimes.Load(domain); ok {
+		return v.(*Runtime), true
+	}
+	return nil, false
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// memoryTransportEndpoint resolves object ids of messages sent from its runtime
+type memoryTransportEndpoint struct {
+	transport *MemoryTransport
+	r         *Runtime
+}
+
+// resolve returns the target function type and the caller as seen by it
+func (e *memoryTransportEndpoint) resolve(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, isRequest bool) (*FunctionType, sfPlugins.StatefunAddress, string, error) {
+	targetDomain := e.r.Domain.GetDomainFromObjectID(target.ID)
+	targetID := target.ID
+	if e.r.Domain.IsShadowObject(target.ID) {
+		remoteDomain, remoteTargetID, shadowCallerID, err := e.r.shadowObjectAddresses(caller.ID, target.ID)
+		if err != nil {
+			return nil, caller, "", err
+		}
+		targetDomain, targetID, caller.ID = remoteDomain, remoteTargetID, shadowCallerID
+	}
+
+	targetRuntime, ok := e.transport.getRuntime(targetDomain)
+	if !ok {
+		return nil, caller, "", fmt.Errorf("memory transport: no runtime is attached for domain %s", targetDomain)
+	}
+	ft, ok := targetRuntime.getRegisteredFunctionType(target.Typename)
+	if !ok {
+		return nil, caller, "", fmt.Errorf("memory transport: function type %s is not registered in domain %s", target.Typename, targetDomain)
+	}
+	if (isRequest && !ft.config.IsRequestProviderAllowed(sfPlugins.InMemoryRequest)) || (!isRequest && !ft.config.IsSignalProviderAllowed(sfPlugins.InMemorySignal)) {
+		return nil, caller, "", fmt.Errorf("memory transport: function type %s does not allow in-memory communication", target.Typename)
+	}
+	return ft, caller, targetID, nil
+}
+
+func memoryTransportMsg(caller sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) FunctionTypeMsg {
+	// Do not send original data, prevents same data concurrent access from different functions
+	msg := FunctionTypeMsg{Caller: &caller}
+	if payload != nil {
+		msg.Payload = payload.Clone().GetPtr()
+	}
+	if options != nil {
+		msg.Options = options.Clone().GetPtr()
+	}
+	return msg
+}
+
+func (e *memoryTransportEndpoint) Signal(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) error {
+	ft, caller, targetID, err := e.resolve(caller, target, false)
+	if err != nil {
+		return err
+	}
+	return deliverMemorySignal(ft, targetID, memoryTransportMsg(caller, payload, options))
+}
+
+func deliverMemorySignal(ft *FunctionType, id string, msg FunctionTypeMsg) error {
+	var refused atomic.Bool
+	msg.AckCallback = func(ack bool) {}
+	msg.ErrorCallback = func(err error) {
+		lg.Logf(lg.WarnLevel, "memory transport: signal for function type %s with id=%s is lost: %s", ft.name, id, err)
+	}
+	msg.DelayCallback = func(delay time.Duration) {
+		time.AfterFunc(delay, func() {
+			if err := deliverMemorySignal(ft, id, msg); err != nil {
+				lg.Logf(lg.WarnLevel, "memory transport: delayed signal for function type %s with id=%s is lost: %s", ft.name, id, err)
+			}
+		})
+	}
+	msg.RefusalCallback = func() {
+		refused.Store(true)
+	}
+
+	ft.sendMsg(id, msg)
+	if refused.Load() {
+		return fmt.Errorf("memory transport: function type %s with id=%s refuses to handle signal", ft.name, id)
+	}
+	return nil
+}
+
+func (e *memoryTransportEndpoint) Request(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON, timeout time.Duration) (*easyjson.JSON, error) {
+	ft, caller, targetID, err := e.resolve(caller, target, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resultJSONChannel := make(chan *easyjson.JSON, 1) // Buffered, reply may come before waiting for it
+	msg := memoryTransportMsg(caller, payload, options)
+	msg.RequestCallback = func(data *easyjson.JSON) {
+		resultJSONChannel <- data.Clone().GetPtr() // Clone().GetPtr() prevents data to contain custom Golang types
+	}
+	msg.RefusalCallback = func() {
+		close(resultJSONChannel)
+	}
+
+	ft.sendMsg(targetID, msg)
+
+	select {
+	case resultJSON, ok := <-resultJSONChannel:
+		if ok {
+			return resultJSON, nil
+		}
+		return nil, fmt.Errorf("memory transport: function type %s with id=%s refuses to handle request", target.Typename, targetID)
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("memory transport: timeout occured while requesting function type %s with id=%s: %w", target.Typename, targetID, context.DeadlineExceeded)
+	}
+}
+
+func (e *memoryTransportEndpoint) Egress(caller sfPlugins.StatefunAddress, payload *easyjson.JSON) error {
+	e.transport.egressHandlers.Range(func(_, value any) bool {
+		var payloadCopy *easyjson.JSON
+		if payload != nil {
+			payloadCopy = payload.Clone().GetPtr()
+		}
+		value.(MemoryEgressHandler)(caller, payloadCopy)
+		return true
+	})
+	return nil
+}
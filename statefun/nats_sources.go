@@ -15,7 +15,7 @@ import (
 )
 
 func AddRequestSourceNatsCore(ft *FunctionType) error {
-	_, err := ft.runtime.nc.Subscribe(RequestPrefix+"."+ft.runtime.Domain.name+"."+ft.name+".*", func(msg *nats.Msg) {
+	sub, err := ft.runtime.nc.Subscribe(RequestPrefix+"."+ft.runtime.Domain.name+"."+ft.name+".*", func(msg *nats.Msg) {
 		system.MsgOnErrorReturn(handleNatsMsg(ft, msg, true, nil))
 	})
 
@@ -23,12 +23,13 @@ func AddRequestSourceNatsCore(ft *FunctionType) error {
 		lg.Logf(lg.ErrorLevel, "Invalid request reply subscription for function type %s: %s", ft.name, err)
 		return err
 	}
+	ft.subscriptions = append(ft.subscriptions, sub)
 
 	return nil
 }
 
 func AddSignalSourceJetstreamQueuePushConsumer(ft *FunctionType) error {
-	consumerName := ft.runtime.Domain.name + "-" + strings.ReplaceAll(ft.name, ".", "")
+	consumerName := ft.getConsumerName()
 	consumerGroup := consumerName + "-group"
 	lg.Logf(lg.TraceLevel, "Handling function type %s", ft.name)
 
@@ -54,22 +55,15 @@ func AddSignalSourceJetstreamQueuePushConsumer(ft *FunctionType) error {
 	// --------------------------------------------------------------
 
 	// For auto message acking msg ----------------------------------
-	msgAcker := func(msgAckChannel chan *nats.Msg) {
-		system.GlobalPrometrics.GetRoutinesCounter().Started("AddSignalSourceJetstreamQueuePushConsumer-msgAcker")
-		defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("AddSignalSourceJetstreamQueuePushConsumer-msgAcker")
-		for msg := range msgAckChannel {
-			system.MsgOnErrorReturn(msg.Ack())
-		}
-	}
-	msgAckChannel := make(chan *nats.Msg, ft.config.msgAckChannelSize)
-	go msgAcker(msgAckChannel)
+	acker := newMsgAcker(ft.config.msgAckChannelSize)
+	ft.msgAcker = acker
 	// --------------------------------------------------------------
 
-	_, err := ft.runtime.js.QueueSubscribe(
+	sub, err := ft.runtime.js.QueueSubscribe(
 		ft.subject,
 		consumerGroup,
 		func(msg *nats.Msg) {
-			system.MsgOnErrorReturn(handleNatsMsg(ft, msg, false, msgAckChannel))
+			system.MsgOnErrorReturn(handleNatsMsg(ft, msg, false, acker))
 		},
 		nats.Bind(ft.getStreamName(), consumerName),
 		nats.ManualAck(),
@@ -78,10 +72,11 @@ func AddSignalSourceJetstreamQueuePushConsumer(ft *FunctionType) error {
 		lg.Logf(lg.ErrorLevel, "Invalid signal subscription for function type %s: %s", ft.name, err)
 		return err
 	}
+	ft.subscriptions = append(ft.subscriptions, sub)
 	return nil
 }
 
-func handleNatsMsg(ft *FunctionType, msg *nats.Msg, requestReply bool, msgAckChannel chan *nats.Msg) (err error) {
+func handleNatsMsg(ft *FunctionType, msg *nats.Msg, requestReply bool, acker *msgAcker) (err error) {
 	tokens := strings.Split(msg.Subject, ".")
 	id := tokens[len(tokens)-1]
 
@@ -107,6 +102,13 @@ func handleNatsMsg(ft *FunctionType, msg *nats.Msg, requestReply bool, msgAckCha
 		msgOptions = easyjson.NewJSONObject().GetPtr()
 	}
 
+	// Move signal into dead letters if it used up its deliveries
+	if !requestReply && ft.deadLettersEnabled() {
+		if deliveries := ft.countedDeliveries(msg); deliveries > uint64(ft.config.maxDeliver) {
+			return ft.deadLetter(msg, id, &data, deliveries)
+		}
+	}
+
 	caller := sfPlugins.StatefunAddress{}
 	if data.GetByPath("caller_typename").IsString() && data.GetByPath("caller_id").IsString() {
 		caller.Typename, _ = data.GetByPath("caller_typename").AsString()
@@ -119,7 +121,16 @@ func handleNatsMsg(ft *FunctionType, msg *nats.Msg, requestReply bool, msgAckCha
 		Payload: payload,
 		Options: msgOptions,
 	}
-	if requestReply {
+	if window, ok := data.GetByPath("reply_stream.window").AsNumeric(); requestReply && ok && len(msg.Reply) > 0 {
+		stream := newNatsReplyStreamSink(ft.runtime.nc, msg.Reply, int(window), time.Duration(ft.runtime.config.requestTimeoutSec)*time.Second)
+		functionMsg.ReplyStream = stream
+		functionMsg.RequestCallback = func(data *easyjson.JSON) {
+			stream.end(data)
+		}
+		functionMsg.RefusalCallback = func() {
+			stream.fail(fmt.Errorf("function type %s with id=%s refuses to handle request", ft.name, id))
+		}
+	} else if requestReply {
 		functionMsg.RequestCallback = func(data *easyjson.JSON) {
 			system.MsgOnErrorReturn(msg.Respond(data.ToBytes()))
 		}
@@ -127,17 +138,26 @@ func handleNatsMsg(ft *FunctionType, msg *nats.Msg, requestReply bool, msgAckCha
 			system.MsgOnErrorReturn(msg.Respond([]byte{}))
 		}
 	} else {
+		functionMsg.IdempotencyKey = jetstreamIdempotencyKey(msg)
 		functionMsg.AckCallback = func(ack bool) {
 			if ack {
-				if msgAckChannel != nil {
-					msgAckChannel <- msg
+				ft.releaseDeliveryError(msg)
+				if acker != nil {
+					acker.ack(msg)
 				}
 			} else {
-				system.MsgOnErrorReturn(msg.Nak())
+				system.MsgOnErrorReturn(ft.nakWithBackoff(msg, "signal was not acked by the handler"))
 			}
 		}
+		functionMsg.DelayCallback = func(delay time.Duration) {
+			ft.registerRateLimitDelay(msg)
+			system.MsgOnErrorReturn(msg.NakWithDelay(delay))
+		}
+		functionMsg.ErrorCallback = func(err error) {
+			system.MsgOnErrorReturn(ft.nakWithBackoff(msg, err.Error()))
+		}
 		functionMsg.RefusalCallback = func() {
-			system.MsgOnErrorReturn(msg.Nak())
+			system.MsgOnErrorReturn(ft.nakWithBackoff(msg, "signal was refused: runtime is draining, id is quarantined, id handlers limit or id handler queue size is reached"))
 		}
 	}
 	// ------------------------------------------------
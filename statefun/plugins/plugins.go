@@ -31,6 +31,7 @@ const (
 	AutoSignalSelect SignalProvider = iota
 	JetstreamGlobalSignal
 	GolangLocalSignal
+	InMemorySignal
 )
 
 type RequestProvider int
@@ -39,6 +40,7 @@ const (
 	AutoRequestSelect RequestProvider = iota
 	NatsCoreGlobalRequest
 	GolangLocalRequest
+	InMemoryRequest
 )
 
 type EgressProvider int
@@ -46,13 +48,22 @@ type EgressProvider int
 type SFSignalFunc func(SignalProvider, string, string, *easyjson.JSON, *easyjson.JSON) error
 type SFRequestFunc func(RequestProvider, string, string, *easyjson.JSON, *easyjson.JSON, ...time.Duration) (*easyjson.JSON, error)
 type SFEgressFunc func(EgressProvider, *easyjson.JSON, ...string) error
+type SFSignalAfterFunc func(SignalProvider, time.Duration, string, string, *easyjson.JSON, *easyjson.JSON) (string, error)
+type SFSignalAtFunc func(SignalProvider, time.Time, string, string, *easyjson.JSON, *easyjson.JSON) (string, error)
+type SFCancelTimerFunc func(string) error
 
 const (
 	NatsCoreEgress EgressProvider = iota
+	InMemoryEgress
+	JetstreamEgress
+	HTTPWebhookEgress
+	FileEgress
+	AutoEgressSelect // Provider set for the sending function type by FunctionTypeConfig.SetEgressProvider
 )
 
 type SyncReply struct {
 	With                    func(*easyjson.JSON)
+	Stream                  func(*easyjson.JSON) error // Sends a chunk of a streamed reply, blocks until the caller is ready for it; on error handler should stop streaming
 	CancelDefaultReply      func()
 	OverrideRequestCallback func() *SyncReply
 }
@@ -87,23 +98,29 @@ type Domain interface {
 }
 
 type StatefunContextProcessor struct {
-	GetFunctionContext        func() *easyjson.JSON
-	SetFunctionContext        func(*easyjson.JSON)
-	SetContextExpirationAfter func(time.Duration)
-	GetObjectContext          func() *easyjson.JSON
-	SetObjectContext          func(*easyjson.JSON)
-	ObjectMutexLock           func(objectId string, errorOnLocked bool) error
-	ObjectMutexUnlock         func(objectId string) error
-	Domain                    Domain
+	GetFunctionContext func() *easyjson.JSON
+	SetFunctionContext func(*easyjson.JSON)
+	// Function and object contexts expire after the duration, negative duration removes expiration
+	SetContextExpirationAfter       func(time.Duration)
+	SetObjectContextExpirationAfter func(time.Duration)
+	GetObjectContext                func() *easyjson.JSON
+	SetObjectContext                func(*easyjson.JSON)
+	ObjectMutexLock                 func(objectId string, errorOnLocked bool) error
+	ObjectMutexUnlock               func(objectId string) error
+	Domain                          Domain
 	// TODO: DownstreamSignal(<function type>, <links filters>, <payload>, <options>)
 	Signal  SFSignalFunc
 	Request SFRequestFunc
 	Egress  SFEgressFunc
-	Self    StatefunAddress
-	Caller  StatefunAddress
-	Payload *easyjson.JSON
-	Options *easyjson.JSON
-	Reply   *SyncReply // when requested in function: nil - function was signaled, !nil - function was requested
+	// Durable delayed signals, return a timer handle which can be used to cancel the timer
+	SignalAfter SFSignalAfterFunc
+	SignalAt    SFSignalAtFunc
+	CancelTimer SFCancelTimerFunc
+	Self        StatefunAddress
+	Caller      StatefunAddress
+	Payload     *easyjson.JSON
+	Options     *easyjson.JSON
+	Reply       *SyncReply // when requested in function: nil - function was signaled, !nil - function was requested
 }
 
 type StatefunExecutor interface {
@@ -0,0 +1,370 @@
+package statefun
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/nats-io/nats.go"
+
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	replyStreamFrameChunk = "chunk"
+	replyStreamFrameEnd   = "end"
+	replyStreamFrameError = "error"
+
+	replyStreamAck    = "ack"
+	replyStreamCancel = "cancel"
+)
+
+var (
+	ErrReplyStreamClosed       = errors.New("reply stream is closed by the caller")
+	ErrReplyStreamNotRequested = errors.New("reply stream was not requested")
+)
+
+// replyStreamSink receives a streamed reply on the handler side, its methods are called by a single producer
+type replyStreamSink interface {
+	chunk(data *easyjson.JSON) error
+	end(reply *easyjson.JSON)
+	fail(err error)
+}
+
+/*
+ReplyStream is a streamed reply of a function requested with Runtime.RequestStream. The handler emits chunks via
+ctx.Reply.Stream(chunk), the final reply (ctx.Reply.With or the default one) ends the stream:
+
+	stream, err := runtime.RequestStream(sfPlugins.AutoRequestSelect, "functions.graph.print", id, nil, nil)
+	if err != nil {
+		...
+	}
+	defer stream.Close()
+	for chunk := range stream.Chunks() {
+		...
+	}
+	if err := stream.Err(); err != nil {
+		...
+	}
+	reply := stream.Reply()
+
+Chunks are flow controlled: the handler is blocked in ctx.Reply.Stream when the caller does not read them, and gets an
+error if the caller does not read them within the request timeout or closes the stream.
+*/
+type ReplyStream struct {
+	chunks     chan *easyjson.JSON
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	finishOnce sync.Once
+	timeout    time.Duration
+	reply      *easyjson.JSON
+	err        error
+}
+
+func newReplyStream(window int, timeout time.Duration) *ReplyStream {
+	return &ReplyStream{
+		chunks:  make(chan *easyjson.JSON, window),
+		cancel:  make(chan struct{}),
+		timeout: timeout,
+	}
+}
+
+// Chunks returns the channel of reply chunks, it is closed when the stream ends
+func (s *ReplyStream) Chunks() <-chan *easyjson.JSON {
+	return s.chunks
+}
+
+// Reply returns the final reply, valid after the chunks channel is closed
+func (s *ReplyStream) Reply() *easyjson.JSON {
+	return s.reply
+}
+
+// Err returns the error the stream ended with, valid after the chunks channel is closed
+func (s *ReplyStream) Err() error {
+	return s.err
+}
+
+// Close tells the handler to stop streaming, chunks which are already sent are still in the channel
+func (s *ReplyStream) Close() {
+	s.cancelOnce.Do(func() {
+		close(s.cancel)
+	})
+}
+
+func (s *ReplyStream) chunk(data *easyjson.JSON) error {
+	select {
+	case <-s.cancel:
+		return ErrReplyStreamClosed
+	default:
+	}
+	select {
+	case s.chunks <- data.Clone().GetPtr(): // Clone().GetPtr() prevents data to contain custom Golang types
+		return nil
+	case <-s.cancel:
+		return ErrReplyStreamClosed
+	case <-time.After(s.timeout):
+		return fmt.Errorf("reply stream chunk was not read by the caller in %s", s.timeout)
+	}
+}
+
+func (s *ReplyStream) end(reply *easyjson.JSON) {
+	s.finishOnce.Do(func() {
+		if reply != nil {
+			s.reply = reply.Clone().GetPtr()
+		}
+		close(s.chunks)
+	})
+}
+
+func (s *ReplyStream) fail(err error) {
+	s.finishOnce.Do(func() {
+		s.err = err
+		close(s.chunks)
+	})
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+/*
+Streamed replies over NATS core: the caller publishes a request with the "reply_stream.window" field and its inbox as
+reply subject. The handler publishes chunk frames into the inbox with its ack subject as reply subject, the caller acks
+every chunk it passed to the consumer or cancels the stream. The handler does not have more than window chunks unacked.
+The stream ends with the end frame carrying the final reply, or with the error frame.
+*/
+type natsReplyStreamSink struct {
+	nc      *nats.Conn
+	inbox   string
+	window  int
+	timeout time.Duration
+	ackSub  *nats.Subscription
+	sent    int
+	acked   int
+	closed  error
+}
+
+func newNatsReplyStreamSink(nc *nats.Conn, inbox string, window int, timeout time.Duration) *natsReplyStreamSink {
+	if window < 1 {
+		window = 1
+	}
+	return &natsReplyStreamSink{nc: nc, inbox: inbox, window: window, timeout: timeout}
+}
+
+func replyStreamFrame(frameType string, seq int, data *easyjson.JSON) []byte {
+	frame := easyjson.NewJSONObjectWithKeyValue("frame", easyjson.NewJSON(frameType))
+	frame.SetByPath("seq", easyjson.NewJSON(seq))
+	if data != nil {
+		frame.SetByPath("data", *data)
+	}
+	return frame.ToBytes()
+}
+
+func (s *natsReplyStreamSink) receiveAck(timeout time.Duration) error {
+	msg, err := s.ackSub.NextMsg(timeout)
+	if err != nil {
+		return fmt.Errorf("reply stream chunk was not acked by the caller: %w", err)
+	}
+	if string(msg.Data) == replyStreamCancel {
+		return ErrReplyStreamClosed
+	}
+	s.acked++
+	return nil
+}
+
+func (s *natsReplyStreamSink) chunk(data *easyjson.JSON) error {
+	if s.closed != nil {
+		return s.closed
+	}
+	if s.ackSub == nil {
+		sub, err := s.nc.SubscribeSync(nats.NewInbox())
+		if err != nil {
+			return err
+		}
+		s.ackSub = sub
+	}
+
+	// Take acks which already came without waiting, caller may have cancelled the stream
+	for pending, _, _ := s.ackSub.Pending(); pending > 0; pending, _, _ = s.ackSub.Pending() {
+		if s.closed = s.receiveAck(s.timeout); s.closed != nil {
+			return s.closed
+		}
+	}
+	for s.sent-s.acked >= s.window {
+		if s.closed = s.receiveAck(s.timeout); s.closed != nil {
+			return s.closed
+		}
+	}
+
+	s.sent++
+	return s.nc.PublishMsg(&nats.Msg{Subject: s.inbox, Reply: s.ackSub.Subject, Data: replyStreamFrame(replyStreamFrameChunk, s.sent, data)})
+}
+
+func (s *natsReplyStreamSink) finish(frame []byte) {
+	system.MsgOnErrorReturn(s.nc.Publish(s.inbox, frame))
+	if s.ackSub != nil {
+		system.MsgOnErrorReturn(s.ackSub.Unsubscribe())
+	}
+}
+
+func (s *natsReplyStreamSink) end(reply *easyjson.JSON) {
+	s.finish(replyStreamFrame(replyStreamFrameEnd, s.sent+1, reply))
+}
+
+func (s *natsReplyStreamSink) fail(err error) {
+	errorData := easyjson.NewJSON(err.Error())
+	s.finish(replyStreamFrame(replyStreamFrameError, s.sent+1, &errorData))
+}
+
+// receiveNatsReplyStream passes frames from the caller's inbox into the stream until it ends
+func receiveNatsReplyStream(sub *nats.Subscription, stream *ReplyStream, timeout time.Duration) {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("receiveNatsReplyStream")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("receiveNatsReplyStream")
+	defer func() {
+		system.MsgOnErrorReturn(sub.Unsubscribe())
+	}()
+
+	seq := 0
+	for {
+		msg, err := sub.NextMsg(timeout)
+		if err != nil {
+			stream.fail(fmt.Errorf("reply stream: %w", err))
+			return
+		}
+		frame, ok := easyjson.JSONFromBytes(msg.Data)
+		if !ok {
+			stream.fail(fmt.Errorf("reply stream frame is not a json"))
+			return
+		}
+		seq++
+		if int(frame.GetByPath("seq").AsNumericDefault(0)) != seq {
+			system.MsgOnErrorReturn(msg.Respond([]byte(replyStreamCancel)))
+			stream.fail(fmt.Errorf("reply stream frame %d is missing", seq))
+			return
+		}
+
+		data := frame.GetByPath("data")
+		switch frame.GetByPath("frame").AsStringDefault("") {
+		case replyStreamFrameChunk:
+			if err := stream.chunk(&data); err != nil {
+				system.MsgOnErrorReturn(msg.Respond([]byte(replyStreamCancel)))
+				stream.fail(err)
+				return
+			}
+			system.MsgOnErrorReturn(msg.Respond([]byte(replyStreamAck)))
+		case replyStreamFrameEnd:
+			stream.end(&data)
+			return
+		case replyStreamFrameError:
+			stream.fail(errors.New(data.AsStringDefault("unknown error")))
+			return
+		default:
+			stream.fail(fmt.Errorf("reply stream frame has unknown type"))
+			return
+		}
+	}
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+func (r *Runtime) requestStream(requestProvider sfPlugins.RequestProvider, callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON, timeout ...time.Duration) (*ReplyStream, error) {
+	requestTimeoutDuration := time.Duration(r.config.requestTimeoutSec) * time.Second
+	if len(timeout) > 0 {
+		requestTimeoutDuration = timeout[0]
+	}
+	natsCoreGlobalRequestStream := func() (*ReplyStream, error) {
+		if r.nc == nil {
+			return nil, ErrRuntimeWithoutNats
+		}
+		subject := fmt.Sprintf("%s.%s.%s.%s", RequestPrefix, r.Domain.GetDomainFromObjectID(targetID), targetTypename, targetID)
+		var data []byte
+		if r.Domain.IsShadowObject(targetID) {
+			var err error
+			if subject, data, err = r.shadowObjectRequest(callerTypename, callerID, targetTypename, targetID, payload, options); err != nil {
+				return nil, err
+			}
+		} else {
+			data = buildNatsData(r.Domain.name, callerTypename, callerID, payload, options)
+		}
+		j, _ := easyjson.JSONFromBytes(data)
+		j.SetByPath("reply_stream.window", easyjson.NewJSON(r.config.replyStreamWindow))
+
+		inbox := nats.NewInbox()
+		sub, err := r.nc.SubscribeSync(inbox)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.nc.PublishRequest(subject, inbox, j.ToBytes()); err != nil {
+			system.MsgOnErrorReturn(sub.Unsubscribe())
+			return nil, err
+		}
+
+		stream := newReplyStream(r.config.replyStreamWindow, requestTimeoutDuration)
+		go receiveNatsReplyStream(sub, stream, requestTimeoutDuration)
+		return stream, nil
+	}
+	goLangLocalRequestStream := func() (*ReplyStream, error) {
+		targetFT, readiness := r.functionTypeIsReadyForGoLangCommunication(targetTypename, true, targetID)
+		switch readiness {
+		case 0:
+			var payloadCopy *easyjson.JSON = nil
+			var optionsCopy *easyjson.JSON = nil
+			if payload != nil {
+				payloadCopy = payload.Clone().GetPtr()
+			}
+			if options != nil {
+				optionsCopy = options.Clone().GetPtr()
+			}
+
+			stream := newReplyStream(r.config.replyStreamWindow, requestTimeoutDuration)
+			functionMsg := FunctionTypeMsg{
+				Caller:      &sfPlugins.StatefunAddress{Typename: callerTypename, ID: callerID},
+				Payload:     payloadCopy,
+				Options:     optionsCopy,
+				ReplyStream: stream,
+			}
+			functionMsg.RequestCallback = func(data *easyjson.JSON) {
+				stream.end(data)
+			}
+			functionMsg.RefusalCallback = func() {
+				stream.fail(fmt.Errorf("goLangLocalRequest: target function with typename \"%s\" with id \"%s\" resufes to handle request", targetTypename, targetID))
+			}
+
+			targetFT.sendMsg(targetID, functionMsg)
+			return stream, nil
+		case 1:
+			return nil, fmt.Errorf("goLangLocalRequest: cannot request function with the typename %s via golang, domain differs: %s(runtime) != %s(id)", callerTypename, r.Domain.name, r.Domain.GetDomainFromObjectID(targetID))
+		case 2:
+			return nil, fmt.Errorf("goLangLocalRequest: cannot request function with the typename %s via golang, not registered", callerTypename)
+		case 3:
+			fallthrough
+		default:
+			return nil, fmt.Errorf("goLangLocalRequest: function with the typename %s does not support request-reply via golang", callerTypename)
+		}
+	}
+
+	switch requestProvider {
+	case sfPlugins.NatsCoreGlobalRequest:
+		return natsCoreGlobalRequestStream()
+	case sfPlugins.GolangLocalRequest:
+		return goLangLocalRequestStream()
+	case sfPlugins.AutoRequestSelect:
+		selection := sfPlugins.NatsCoreGlobalRequest
+		if r.nc == nil {
+			selection = sfPlugins.GolangLocalRequest
+		} else if !r.Domain.IsShadowObject(targetID) {
+			if _, readiness := r.functionTypeIsReadyForGoLangCommunication(targetTypename, true, targetID); readiness == 0 {
+				selection = sfPlugins.GolangLocalRequest
+			}
+		}
+		return r.requestStream(selection, callerTypename, callerID, targetTypename, targetID, payload, options, timeout...)
+	default:
+		return nil, fmt.Errorf("unknown request provider: %d", requestProvider)
+	}
+}
+
+// RequestStream requests a function which streams its reply with ctx.Reply.Stream
+func (r *Runtime) RequestStream(requestProvider sfPlugins.RequestProvider, typename string, id string, payload *easyjson.JSON, options *easyjson.JSON, timeout ...time.Duration) (*ReplyStream, error) {
+	return r.requestStream(requestProvider, "ingress", "request", typename, id, payload, options, timeout...)
+}
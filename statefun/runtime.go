@@ -9,6 +9,7 @@ import (
 
 	lg "github.com/foliagecp/sdk/statefun/logger"
 	"github.com/foliagecp/sdk/statefun/system"
+	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
 
 	"github.com/foliagecp/sdk/statefun/cache"
@@ -16,6 +17,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// ErrRuntimeWithoutNats is returned by what needs NATS on a runtime configured by RuntimeConfig.SetWithoutNats
+var ErrRuntimeWithoutNats = errors.New("runtime runs without NATS")
+
 type OnAfterStartFunction func(ctx context.Context, runtime *Runtime) error
 
 type onAfterStartFunctionWithMode struct {
@@ -31,12 +35,31 @@ type Runtime struct {
 	Domain *Domain
 
 	registeredFunctionTypes       map[string]*FunctionType
+	startingFunctionTypes         map[string]*FunctionType // Registered on a running runtime, not started yet
+	registeredFunctionTypesMutex  sync.RWMutex
+	singleInstanceRevisions       map[string]uint64
+	singleInstanceRevisionsMutex  sync.Mutex
+	heldKeyMutexes                sync.Map
+	adminSubscription             *nats.Subscription
 	onAfterStartFunctionsWithMode []onAfterStartFunctionWithMode
+	timers                        *timers
+	interceptors                  []FunctionInterceptor
+	interceptorsMutex             sync.Mutex
+	signalTransports              map[sfPlugins.SignalProvider]SignalTransport
+	requestTransports             map[sfPlugins.RequestProvider]RequestTransport
+	egressTransports              map[sfPlugins.EgressProvider]EgressTransport
+	transportsMutex               sync.RWMutex
+	inProcessNats                 *server.Server
+	inProcessNatsStoreDir         string
+	localSignalsWAL               *localSignalsWAL
+	pendingLocalSignals           []*localSignal
 
 	gt0  int64 // Global time 0 - time of the very first message receiving by any function type
 	glce int64 // Global last call ended - time of last call of last function handling id of any function type
 	gc   int64 // Global counter - max total id handlers for all function types
 
+	startCtx context.Context
+	started  atomic.Bool
 	shutdown chan struct{}
 	wg       sync.WaitGroup
 }
@@ -46,22 +69,39 @@ func NewRuntime(config RuntimeConfig) (*Runtime, error) {
 	r := &Runtime{
 		config:                  config,
 		registeredFunctionTypes: make(map[string]*FunctionType),
+		startingFunctionTypes:   make(map[string]*FunctionType),
+		singleInstanceRevisions: make(map[string]uint64),
 		shutdown:                make(chan struct{}),
 	}
+	r.timers = newTimers(r)
+	r.registerDefaultTransports()
+
+	if config.withoutNats {
+		r.Domain = newDomain(nil, nil, config.desiredHUBDomainName, "")
+		r.config.desiredHUBDomainName = r.Domain.hubDomainName
+		return r, nil
+	}
 
 	var err error
-	r.nc, err = nats.Connect(config.natsURL)
+	if config.inProcessNats {
+		r.nc, err = r.startInProcessNats()
+	} else {
+		r.nc, err = nats.Connect(config.natsURL)
+	}
 	if err != nil {
+		r.stopInProcessNats()
 		return nil, err
 	}
 
 	r.js, err = r.nc.JetStream(nats.PublishAsyncMaxPending(256))
 	if err != nil {
+		r.stopInProcessNats()
 		return nil, err
 	}
 
 	r.Domain, err = NewDomain(r.nc, r.js, config.desiredHUBDomainName)
 	if err != nil {
+		r.stopInProcessNats()
 		return nil, err
 	}
 	r.config.desiredHUBDomainName = r.Domain.hubDomainName
@@ -82,8 +122,8 @@ func (r *Runtime) RegisterOnAfterStartFunction(f OnAfterStartFunction, async boo
 func (r *Runtime) Start(ctx context.Context, cacheConfig *cache.Config) error {
 	logger := lg.NewLogger(lg.Options{ReportCaller: true, Level: lg.InfoLevel})
 
-	// Create streams if they do not exist.
-	if err := r.createStreams(ctx); err != nil {
+	// Open local signals write-ahead log.
+	if err := r.openLocalSignalsWAL(); err != nil {
 		return err
 	}
 
@@ -92,17 +132,31 @@ func (r *Runtime) Start(ctx context.Context, cacheConfig *cache.Config) error {
 		return err
 	}
 
-	// Handle single-instance functions.
-	singleInstanceFunctionRevisions := make(map[string]uint64)
-	if err := r.handleSingleInstanceFunctions(ctx, singleInstanceFunctionRevisions); err != nil {
+	// Start durable timers.
+	r.wg.Add(1)
+	go r.timers.run(ctx)
+
+	// Signal function types about expired contexts.
+	r.wg.Add(1)
+	go r.runContextExpirations(ctx)
+	r.wg.Add(1)
+	go r.sweepLegacyContextExpirations(ctx)
+
+	// Start registered function types, function types registered from now on are started right away.
+	if err := r.startFunctionTypes(ctx); err != nil {
 		return err
 	}
 
-	// Start function subscriptions.
-	if err := r.startFunctionSubscriptions(ctx, singleInstanceFunctionRevisions); err != nil {
-		return err
+	// Start admin requests handling.
+	if r.config.handlesAdminRequests && r.nc != nil {
+		if err := r.startAdminSubscription(); err != nil {
+			return err
+		}
 	}
 
+	// Send local signals left by the previous run.
+	r.replayLocalSignals()
+
 	// Run after-start functions.
 	r.runAfterStartFunctions(ctx)
 
@@ -115,80 +169,163 @@ func (r *Runtime) Start(ctx context.Context, cacheConfig *cache.Config) error {
 
 	// Perform cleanup.
 	logger.Infof(context.TODO(), "Shutting down runtime...")
+	r.stopAdminSubscription()
+	r.drain()
 	r.wg.Wait()
+	r.closeEgressTransports()
+	r.releaseSingleInstanceLocks()
+	r.localSignalsWAL.close()
+	if err := r.Domain.cache.Flush(); err != nil {
+		logger.Errorf(context.TODO(), "Failed to flush cache: %v", err)
+	}
+	r.Domain.cache.Destroy()
+	r.stopInProcessNats()
 	return nil
 }
 
-// Shutdown gracefully stops the runtime.
+// Shutdown gracefully stops the runtime: drains function subscriptions, releases single-instance locks and flushes the cache.
+// Start returns when it is done.
 func (r *Runtime) Shutdown() {
 	close(r.shutdown)
 }
 
+// startFunctionTypes starts all registered function types and marks the runtime started. The registry is locked meanwhile,
+// so a function type registered concurrently is started either here or by registerFunctionType, never by both or none.
+func (r *Runtime) startFunctionTypes(ctx context.Context) error {
+	r.registeredFunctionTypesMutex.Lock()
+	defer r.registeredFunctionTypesMutex.Unlock()
+
+	functionTypes := make([]*FunctionType, 0, len(r.registeredFunctionTypes))
+	for _, ft := range r.registeredFunctionTypes {
+		functionTypes = append(functionTypes, ft)
+	}
+
+	// Create streams if they do not exist.
+	if err := r.createStreams(ctx, functionTypes); err != nil {
+		return err
+	}
+
+	// Handle single-instance functions.
+	if err := r.handleSingleInstanceFunctions(ctx, functionTypes); err != nil {
+		return err
+	}
+
+	// Start function subscriptions.
+	if err := r.startFunctionSubscriptions(functionTypes); err != nil {
+		return err
+	}
+
+	// Start periodic schedules.
+	if err := r.startSchedules(ctx, functionTypes); err != nil {
+		return err
+	}
+
+	// Publish schemas of typed function types.
+	if err := r.publishSchemas(functionTypes); err != nil {
+		return err
+	}
+
+	r.startCtx = ctx
+	r.started.Store(true)
+	return nil
+}
+
 // createStreams ensures that the necessary NATS streams exist.
-func (r *Runtime) createStreams(ctx context.Context) error {
-	logger := lg.NewLogger(lg.Options{ReportCaller: true, Level: lg.InfoLevel})
-	var existingStreams []string
+func (r *Runtime) createStreams(ctx context.Context, functionTypes []*FunctionType) error {
+	if r.js == nil {
+		return nil
+	}
+	existingStreams := r.getExistingStreams(ctx)
+	for _, ft := range functionTypes {
+		if err := r.createFunctionTypeStreams(ft, existingStreams); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (r *Runtime) getExistingStreams(ctx context.Context) []string {
+	var existingStreams []string
 	streamInfoCh := r.js.StreamsInfo(nats.Context(ctx))
 	for info := range streamInfoCh {
 		existingStreams = append(existingStreams, info.Config.Name)
 	}
+	return existingStreams
+}
 
-	for _, ft := range r.registeredFunctionTypes {
-		if ft.config.IsSignalProviderAllowed(sfPlugins.JetstreamGlobalSignal) {
-			if !contains(existingStreams, ft.getStreamName()) {
-				_, err := r.js.AddStream(&nats.StreamConfig{
-					Name:      ft.getStreamName(),
-					Subjects:  []string{ft.subject},
-					Retention: nats.InterestPolicy,
-				})
-				if err != nil {
-					logger.Errorf(context.TODO(), "Failed to add stream: %v", err)
-					return err
-				}
-			}
+// createFunctionTypeStreams ensures that the function type's stream and dead-letter stream exist.
+func (r *Runtime) createFunctionTypeStreams(ft *FunctionType, existingStreams []string) error {
+	logger := lg.NewLogger(lg.Options{ReportCaller: true, Level: lg.InfoLevel})
+	if !ft.config.IsSignalProviderAllowed(sfPlugins.JetstreamGlobalSignal) {
+		return nil
+	}
+	if !contains(existingStreams, ft.getStreamName()) {
+		_, err := r.js.AddStream(&nats.StreamConfig{
+			Name:      ft.getStreamName(),
+			Subjects:  []string{ft.subject},
+			Retention: nats.InterestPolicy,
+		})
+		if err != nil {
+			logger.Errorf(context.TODO(), "Failed to add stream: %v", err)
+			return err
+		}
+	}
+	if ft.deadLettersEnabled() {
+		if err := ft.createDeadLetterStream(existingStreams); err != nil {
+			logger.Errorf(context.TODO(), "Failed to add dead-letter stream: %v", err)
+			return err
 		}
 	}
 	return nil
 }
 
 // handleSingleInstanceFunctions manages single-instance function locks.
-func (r *Runtime) handleSingleInstanceFunctions(ctx context.Context, revisions map[string]uint64) error {
-	for ftName, ft := range r.registeredFunctionTypes {
-		if !ft.config.multipleInstancesAllowed {
-			revID, err := KeyMutexLock(ctx, r, system.GetHashStr(ftName), true)
-			if err != nil {
-				if errors.Is(err, ErrMutexLocked) {
-					lg.Logf(lg.WarnLevel, "Function type %s is already running elsewhere; skipping", ft.name)
-					continue
-				}
-				return err
-			}
-			revisions[ftName] = revID
+func (r *Runtime) handleSingleInstanceFunctions(ctx context.Context, functionTypes []*FunctionType) error {
+	for _, ft := range functionTypes {
+		if err := r.lockSingleInstanceFunctionType(ctx, ft); err != nil {
+			return err
 		}
 	}
 
 	// Start lock updater for single-instance functions.
-	if len(revisions) > 0 {
-		r.wg.Add(1)
-		go r.singleInstanceFunctionLocksUpdater(ctx, revisions)
-	}
+	r.wg.Add(1)
+	go r.singleInstanceFunctionLocksUpdater(ctx)
 
 	return nil
 }
 
+func (r *Runtime) lockSingleInstanceFunctionType(ctx context.Context, ft *FunctionType) error {
+	if ft.config.multipleInstancesAllowed {
+		return nil
+	}
+	revID, err := KeyMutexLock(ctx, r, system.GetHashStr(ft.name), true)
+	if err != nil {
+		if errors.Is(err, ErrMutexLocked) {
+			lg.Logf(lg.WarnLevel, "Function type %s is already running elsewhere; skipping", ft.name)
+			return nil
+		}
+		return err
+	}
+	r.singleInstanceRevisionsMutex.Lock()
+	r.singleInstanceRevisions[ft.name] = revID
+	r.singleInstanceRevisionsMutex.Unlock()
+	return nil
+}
+
 // startFunctionSubscriptions starts the function subscriptions based on the configuration.
-func (r *Runtime) startFunctionSubscriptions(ctx context.Context, revisions map[string]uint64) error {
-	for _, ft := range r.registeredFunctionTypes {
-		if ft.config.IsSignalProviderAllowed(sfPlugins.JetstreamGlobalSignal) {
-			if err := AddSignalSourceJetstreamQueuePushConsumer(ft); err != nil {
-				return err
-			}
+func (r *Runtime) startFunctionSubscriptions(functionTypes []*FunctionType) error {
+	for _, ft := range functionTypes {
+		if err := r.startFunctionTypeSubscriptions(ft); err != nil {
+			return err
 		}
-		if ft.config.IsRequestProviderAllowed(sfPlugins.NatsCoreGlobalRequest) {
-			if err := AddRequestSourceNatsCore(ft); err != nil {
-				return err
-			}
+	}
+	return nil
+}
+
+func (r *Runtime) startFunctionTypeSubscriptions(ft *FunctionType) error {
+	for _, source := range r.transportSources(ft) {
+		if err := source.Subscribe(ft); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -244,7 +381,7 @@ func (r *Runtime) collectGarbage() {
 		lg.Logf(lg.ErrorLevel, "Error ensuring GaugeVec: %v", err)
 	}
 
-	for _, ft := range r.registeredFunctionTypes {
+	for _, ft := range r.getRegisteredFunctionTypes() {
 		collected, running := ft.gc(r.config.functionTypeIDLifetimeMs)
 		totalGarbageCollected += collected
 		totalHandlersRunning += running
@@ -275,7 +412,7 @@ func (r *Runtime) reportPerformanceMetrics() {
 }
 
 // singleInstanceFunctionLocksUpdater periodically updates locks for single-instance functions.
-func (r *Runtime) singleInstanceFunctionLocksUpdater(ctx context.Context, revisions map[string]uint64) {
+func (r *Runtime) singleInstanceFunctionLocksUpdater(ctx context.Context) {
 	defer r.wg.Done()
 	ticker := time.NewTicker(time.Duration(r.config.kvMutexLifeTimeSec) / 2 * time.Second)
 	defer ticker.Stop()
@@ -287,14 +424,16 @@ func (r *Runtime) singleInstanceFunctionLocksUpdater(ctx context.Context, revisi
 		case <-r.shutdown:
 			return
 		case <-ticker.C:
-			for ftName, revID := range revisions {
+			r.singleInstanceRevisionsMutex.Lock()
+			for ftName, revID := range r.singleInstanceRevisions {
 				newRevID, err := KeyMutexLockUpdate(ctx, r, system.GetHashStr(ftName), revID)
 				if err != nil {
 					lg.Logf(lg.ErrorLevel, "KeyMutexLockUpdate failed for %s: %v", ftName, err)
 				} else {
-					revisions[ftName] = newRevID
+					r.singleInstanceRevisions[ftName] = newRevID
 				}
 			}
+			r.singleInstanceRevisionsMutex.Unlock()
 		}
 	}
 }
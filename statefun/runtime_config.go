@@ -10,8 +10,11 @@ const (
 	FunctionTypeIDLifetimeMs    = 5000
 	RequestTimeoutSec           = 60
 	GCIntervalSec               = 5
+	DrainTimeoutSec             = 30
+	ReplyStreamWindow           = 16
 	DefaultHubDomainName        = "hub"
 	HandlesDomainRouters        = true
+	HandlesAdminRequests        = false
 )
 
 type RuntimeConfig struct {
@@ -22,8 +25,14 @@ type RuntimeConfig struct {
 	functionTypeIDLifetimeMs       int
 	requestTimeoutSec              int
 	gcIntervalSec                  int
+	drainTimeoutSec                int
+	replyStreamWindow              int
 	desiredHUBDomainName           string
 	handlesDomainRouters           bool
+	handlesAdminRequests           bool
+	inProcessNats                  bool
+	withoutNats                    bool
+	localSignalsWALPath            string
 }
 
 func NewRuntimeConfig() *RuntimeConfig {
@@ -35,8 +44,11 @@ func NewRuntimeConfig() *RuntimeConfig {
 		functionTypeIDLifetimeMs:       FunctionTypeIDLifetimeMs,
 		requestTimeoutSec:              RequestTimeoutSec,
 		gcIntervalSec:                  GCIntervalSec,
+		drainTimeoutSec:                DrainTimeoutSec,
+		replyStreamWindow:              ReplyStreamWindow,
 		desiredHUBDomainName:           DefaultHubDomainName,
 		handlesDomainRouters:           HandlesDomainRouters,
+		handlesAdminRequests:           HandlesAdminRequests,
 	}
 }
 
@@ -85,7 +97,54 @@ func (ro *RuntimeConfig) SetGCIntervalSec(gcIntervalSec int) *RuntimeConfig {
 	return ro
 }
 
+// SetDrainTimeoutSec sets how long Shutdown waits for already received messages to be handled
+func (ro *RuntimeConfig) SetDrainTimeoutSec(drainTimeoutSec int) *RuntimeConfig {
+	ro.drainTimeoutSec = drainTimeoutSec
+	return ro
+}
+
+// SetInProcessNats makes the runtime run its own NATS server with JetStream inside the process instead of connecting to
+// the NATS URL. Its storage is removed on shutdown. With MemoryTransport it lets a whole app run in a single binary or test:
+// messages go through memory, the cache still needs JetStream, which the in-process server provides. See SetWithoutNats to
+// run with no NATS server at all.
+func (ro *RuntimeConfig) SetInProcessNats(inProcessNats bool) *RuntimeConfig {
+	ro.inProcessNats = inProcessNats
+	return ro
+}
+
+// SetWithoutNats makes the runtime run with no NATS connection: the cache keeps its values in the process memory (unless
+// the cache config sets a backend), timers and key mutexes live in it too, no streams are created. Signals and requests
+// are delivered within the runtime (GolangLocalSignal, GolangLocalRequest, AutoSignalSelect and AutoRequestSelect pick
+// them) or through transports registered for other providers, e.g. MemoryTransport. NATS providers are unknown to such a
+// runtime; dead letters, jetstream egress, admin reports requests and snapshots of buckets return ErrRuntimeWithoutNats.
+// The hub domain name is the domain name of the runtime.
+func (ro *RuntimeConfig) SetWithoutNats(withoutNats bool) *RuntimeConfig {
+	ro.withoutNats = withoutNats
+	return ro
+}
+
+// SetLocalSignalsWAL makes GolangLocalSignal signals durable: they are written to the log file at the path until handled,
+// and those left in it are sent again on start. Empty path disables the log, local signals are lost on crash then.
+// AutoSignalSelect delivers signals to function types of this runtime locally either way.
+func (ro *RuntimeConfig) SetLocalSignalsWAL(path string) *RuntimeConfig {
+	ro.localSignalsWALPath = path
+	return ro
+}
+
+// SetReplyStreamWindow sets how many chunks of a streamed reply may be sent before the caller receives them
+func (ro *RuntimeConfig) SetReplyStreamWindow(replyStreamWindow int) *RuntimeConfig {
+	ro.replyStreamWindow = replyStreamWindow
+	return ro
+}
+
 func (ro *RuntimeConfig) SetDomainRoutersHandling(handlesDomainRouters bool) *RuntimeConfig {
 	ro.handlesDomainRouters = handlesDomainRouters
 	return ro
 }
+
+// SetAdminRequestsHandling enables the runtime admin report on the "admin.<domain>.<runtime name>" request subject.
+// Disabled by default: the subject is not authorized, any client of the NATS server can request the report.
+func (ro *RuntimeConfig) SetAdminRequestsHandling(handlesAdminRequests bool) *RuntimeConfig {
+	ro.handlesAdminRequests = handlesAdminRequests
+	return ro
+}
@@ -0,0 +1,904 @@
+package statefun_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/statefun"
+	"github.com/foliagecp/sdk/statefun/cache"
+	sfMediators "github.com/foliagecp/sdk/statefun/mediator"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+	"github.com/foliagecp/sdk/statefun/test"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+)
+
+type RuntimeTestSuite struct {
+	test.StatefunTestSuite
+}
+
+func TestRuntimeTestSuite(t *testing.T) {
+	suite.Run(t, new(RuntimeTestSuite))
+}
+
+func panickingFunction(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+	panic("boom")
+}
+
+func (s *RuntimeTestSuite) Test_HandlerPanic_RepliesWithFailure() {
+	typename := "functions.tests.panics.request"
+	s.RegisterFunction(typename, panickingFunction, *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.AutoRequestSelect))
+	s.NoError(s.StartRuntime())
+
+	result, err := s.Request(sfPlugins.AutoRequestSelect, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Equal("failed", result.GetByPath("status").AsStringDefault(""))
+	s.Contains(result.GetByPath("details").AsStringDefault(""), "boom")
+	s.Contains(result.GetByPath("data.location").AsStringDefault(""), "runtime_test.go")
+}
+
+func (s *RuntimeTestSuite) Test_HandlerPanic_SignalGoesToDeadLetters() {
+	typename := "functions.tests.panics.signal"
+	s.RegisterFunction(typename, panickingFunction, *statefun.NewFunctionTypeConfig().SetMaxDeliver(2))
+	s.NoError(s.StartRuntime())
+
+	payload := easyjson.NewJSONObjectWithKeyValue("k", easyjson.NewJSON("v"))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", &payload, nil))
+
+	var deadLetters []statefun.DeadLetter
+	s.Eventually(func() bool {
+		var err error
+		deadLetters, err = s.Runtime().DeadLetters(typename)
+		return err == nil && len(deadLetters) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+	s.Contains(deadLetters[0].Error, "boom")
+	s.Equal("v", deadLetters[0].Payload.GetByPath("k").AsStringDefault(""))
+
+	s.NoError(s.Runtime().PurgeDeadLetters(typename))
+	deadLetters, err := s.Runtime().DeadLetters(typename)
+	s.NoError(err)
+	s.Empty(deadLetters)
+}
+
+type greetRequest struct {
+	Name string `json:"name" validate:"required"`
+	Lang string `json:"lang" validate:"oneof=en fr"`
+}
+
+type greetResponse struct {
+	Greeting string `json:"greeting"`
+}
+
+func (s *RuntimeTestSuite) Test_TypedFunctionType_DecodesAndValidates() {
+	typename := "functions.tests.typed.greet"
+	statefun.NewTypedFunctionType(s.Runtime(), typename, func(_ *sfPlugins.StatefunContextProcessor, req *greetRequest) (*greetResponse, error) {
+		return &greetResponse{Greeting: req.Lang + ":" + req.Name}, nil
+	}, *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.AutoRequestSelect))
+	s.NoError(s.StartRuntime())
+
+	payload := easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON("bob"))
+	payload.SetByPath("lang", easyjson.NewJSON("fr"))
+	result, err := s.Request(sfPlugins.AutoRequestSelect, typename, "a", &payload, nil)
+	s.NoError(err)
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+	s.Equal("fr:bob", result.GetByPath("data.greeting").AsStringDefault(""))
+
+	payload.SetByPath("lang", easyjson.NewJSON("de"))
+	result, err = s.Request(sfPlugins.AutoRequestSelect, typename, "a", &payload, nil)
+	s.NoError(err)
+	s.Equal("failed", result.GetByPath("status").AsStringDefault(""))
+	s.Contains(result.GetByPath("details").AsStringDefault(""), "lang")
+
+	schema, err := s.Runtime().FunctionTypeSchema(typename)
+	s.NoError(err)
+	s.Equal("string", schema.GetByPath("request.properties.name.type").AsStringDefault(""))
+	s.Equal("name", schema.GetByPath("request.required").ArrayElement(0).AsStringDefault(""))
+}
+
+func (s *RuntimeTestSuite) Test_Shutdown_DrainsInFlightMessages() {
+	typename := "functions.tests.drain.slow"
+	var handled atomic.Bool
+	s.RegisterFunction(typename, func(executor sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		time.Sleep(500 * time.Millisecond)
+		counterFunction(executor, ctx)
+		handled.Store(true)
+	}, *statefun.NewFunctionTypeConfig().SetMultipleInstancesAllowance(false))
+	s.NoError(s.StartRuntime())
+
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", nil, nil))
+	time.Sleep(100 * time.Millisecond)
+
+	s.NoError(s.ShutdownRuntime(5 * time.Second))
+	s.True(handled.Load())
+}
+
+func (s *RuntimeTestSuite) Test_RegisterFunctionType_AfterStart() {
+	typename := "functions.tests.dynamic.echo"
+	s.NoError(s.StartRuntime())
+
+	_, err := s.Runtime().RegisterFunctionType(typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		sfMediators.NewOpMediator(ctx).AggregateOpMsg(sfMediators.OpMsgOk(easyjson.NewJSON("echo"))).Reply()
+	}, *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.NatsCoreGlobalRequest))
+	s.NoError(err)
+
+	result, err := s.Request(sfPlugins.NatsCoreGlobalRequest, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Equal("echo", result.GetByPath("data").AsStringDefault(""))
+
+	s.NoError(s.Runtime().UnregisterFunctionType(typename, true))
+	_, err = s.Request(sfPlugins.NatsCoreGlobalRequest, typename, "a", nil, nil, time.Second)
+	s.Error(err)
+}
+
+func (s *RuntimeTestSuite) Test_AdminReport_DescribesFunctionTypes() {
+	typename := "functions.tests.admin.counter"
+	s.ReconfigureRuntime(func(cfg *statefun.RuntimeConfig) { cfg.SetAdminRequestsHandling(true) })
+	s.RegisterFunction(typename, counterFunction, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(7))
+	s.NoError(s.StartRuntime())
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", nil, nil))
+	s.Eventually(func() bool {
+		_, err := s.CacheValue("a")
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	localReport := s.Runtime().AdminReport()
+	reports, err := s.Runtime().RequestAdminReports(localReport.GetByPath("runtime.name").AsStringDefault(""), time.Second)
+	s.NoError(err)
+	s.Len(reports, 1)
+
+	functionTypes := reports[0].GetByPath("function_types")
+	s.Equal(1, functionTypes.ArraySize())
+	s.Equal(typename, functionTypes.ArrayElement(0).GetByPath("name").AsStringDefault(""))
+	s.Equal(7.0, functionTypes.ArrayElement(0).GetByPath("config.max_id_handlers").AsNumericDefault(0))
+	s.Equal(1, functionTypes.ArrayElement(0).GetByPath("id_handlers").ArraySize())
+}
+
+func (s *RuntimeTestSuite) Test_RateLimit_LimitsBulkAndControlLanesSeparately() {
+	typename := "functions.tests.ratelimit.echo"
+	s.RegisterFunction(typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		sfMediators.NewOpMediator(ctx).AggregateOpMsg(sfMediators.OpMsgOk(easyjson.NewJSONNull())).Reply()
+	}, *statefun.NewFunctionTypeConfig().
+		SetAllowedRequestProviders(sfPlugins.AutoRequestSelect).
+		SetIDRateLimit(0.001, 1).
+		SetControlRateLimit(0.001, 1).
+		SetRateLimitAction(statefun.RateLimitDrop))
+	s.NoError(s.StartRuntime())
+
+	result, err := s.Request(sfPlugins.AutoRequestSelect, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+
+	result, err = s.Request(sfPlugins.AutoRequestSelect, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Equal("failed", result.GetByPath("status").AsStringDefault(""))
+	s.Equal("rate limit exceeded", result.GetByPath("details").AsStringDefault(""))
+
+	control := easyjson.NewJSONObjectWithKeyValue(statefun.MsgPriorityOptionKey, easyjson.NewJSON(statefun.MsgPriorityControl))
+	result, err = s.Request(sfPlugins.AutoRequestSelect, typename, "a", nil, &control)
+	s.NoError(err)
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+
+	result, err = s.Request(sfPlugins.AutoRequestSelect, typename, "a", nil, &control)
+	s.NoError(err)
+	s.Equal("failed", result.GetByPath("status").AsStringDefault(""))
+
+	result, err = s.Request(sfPlugins.AutoRequestSelect, typename, "b", nil, nil)
+	s.NoError(err)
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+}
+
+func (s *RuntimeTestSuite) Test_Idempotency_SkipsDuplicateSignals() {
+	typename := "functions.tests.idempotency.counter"
+	s.RegisterFunction(typename, counterFunction, *statefun.NewFunctionTypeConfig().SetIdempotency(time.Minute))
+	s.NoError(s.StartRuntime())
+
+	options := easyjson.NewJSONObjectWithKeyValue(statefun.IdempotencyKeyOptionKey, easyjson.NewJSON("op-1"))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", nil, &options))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", nil, &options))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", nil, nil))
+
+	s.Eventually(func() bool {
+		v, err := s.CacheValue("a")
+		return err == nil && v.GetByPath("counter").AsNumericDefault(0) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	v, err := s.CacheValue("a")
+	s.NoError(err)
+	s.Equal(2.0, v.GetByPath("counter").AsNumericDefault(0))
+}
+
+func (s *RuntimeTestSuite) Test_RequestStream_DeliversChunksInOrder() {
+	typename := "functions.tests.stream.range"
+	s.RegisterFunction(typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		n := int(ctx.Payload.GetByPath("n").AsNumericDefault(0))
+		for i := 0; i < n; i++ {
+			if err := ctx.Reply.Stream(easyjson.NewJSON(i).GetPtr()); err != nil {
+				break
+			}
+		}
+		ctx.Reply.With(easyjson.NewJSON("done").GetPtr())
+	}, *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.NatsCoreGlobalRequest, sfPlugins.GolangLocalRequest))
+	s.NoError(s.StartRuntime())
+
+	payload := easyjson.NewJSONObjectWithKeyValue("n", easyjson.NewJSON(100))
+	for _, provider := range []sfPlugins.RequestProvider{sfPlugins.NatsCoreGlobalRequest, sfPlugins.GolangLocalRequest} {
+		stream, err := s.Runtime().RequestStream(provider, typename, "a", &payload, nil, 5*time.Second)
+		s.NoError(err)
+		received := 0
+		for chunk := range stream.Chunks() {
+			s.Equal(float64(received), chunk.AsNumericDefault(-1))
+			received++
+		}
+		s.NoError(stream.Err())
+		s.Equal(100, received)
+		s.Equal("done", stream.Reply().AsStringDefault(""))
+	}
+
+	result, err := s.Request(sfPlugins.GolangLocalRequest, typename, "a", &payload, nil)
+	s.NoError(err)
+	s.Equal("done", result.AsStringDefault(""))
+}
+
+func (s *RuntimeTestSuite) Test_MemoryTransport_RunsWithInProcessNats() {
+	runtime, err := statefun.NewRuntime(*statefun.NewRuntimeConfig().SetInProcessNats(true))
+	s.NoError(err)
+	transport := statefun.NewMemoryTransport()
+	transport.Attach(runtime)
+
+	egress := make(chan string, 1)
+	defer transport.SubscribeEgress(func(caller sfPlugins.StatefunAddress, payload *easyjson.JSON) {
+		egress <- payload.GetByPath("counter").AsStringDefault("")
+	})()
+
+	typename := "functions.tests.memory.counter"
+	statefun.NewFunctionType(runtime, typename, func(executor sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		counterFunction(executor, ctx)
+		if ctx.Reply != nil {
+			ctx.Reply.With(ctx.GetObjectContext())
+			return
+		}
+		system.MsgOnErrorReturn(ctx.Egress(sfPlugins.InMemoryEgress, easyjson.NewJSONObjectWithKeyValue("counter", easyjson.NewJSON("signaled")).GetPtr()))
+	}, *statefun.NewFunctionTypeConfig().
+		SetAllowedSignalProviders(sfPlugins.InMemorySignal).
+		SetAllowedRequestProviders(sfPlugins.InMemoryRequest))
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.NoError(runtime.Start(context.Background(), cache.NewCacheConfig("memory_cache")))
+	}()
+	s.Eventually(func() bool {
+		_, err := runtime.Request(sfPlugins.InMemoryRequest, typename, "a", nil, nil)
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	s.NoError(runtime.Signal(sfPlugins.InMemorySignal, typename, "a", nil, nil))
+	select {
+	case v := <-egress:
+		s.Equal("signaled", v)
+	case <-time.After(5 * time.Second):
+		s.Fail("no egress message")
+	}
+
+	result, err := runtime.Request(sfPlugins.InMemoryRequest, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Equal(3.0, result.GetByPath("counter").AsNumericDefault(0))
+
+	runtime.Shutdown()
+	<-stopped
+}
+
+func (s *RuntimeTestSuite) Test_WithoutNats_RunsOnMemoryBackend() {
+	runtime, err := statefun.NewRuntime(*statefun.NewRuntimeConfig().SetWithoutNats(true))
+	s.NoError(err)
+
+	typename := "functions.tests.without_nats.counter"
+	statefun.NewFunctionType(runtime, typename, func(executor sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		counterFunction(executor, ctx)
+		if ctx.Reply != nil {
+			ctx.Reply.With(ctx.GetObjectContext())
+		}
+	}, *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.AutoRequestSelect))
+
+	started := make(chan struct{})
+	runtime.RegisterOnAfterStartFunction(func(_ context.Context, _ *statefun.Runtime) error {
+		close(started)
+		return nil
+	}, false)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.NoError(runtime.Start(context.Background(), cache.NewCacheConfig("without_nats_cache")))
+	}()
+	<-started
+
+	s.NoError(runtime.Signal(sfPlugins.AutoSignalSelect, typename, "a", nil, nil))
+	_, err = runtime.SignalAfter(sfPlugins.AutoSignalSelect, 100*time.Millisecond, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Eventually(func() bool {
+		v, err := runtime.Domain.Cache().GetValueAsJSON(runtime.Domain.CreateObjectIDWithThisDomain("a", true))
+		return err == nil && v.GetByPath("counter").AsNumericDefault(0) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	result, err := runtime.Request(sfPlugins.AutoRequestSelect, typename, "a", nil, nil)
+	s.NoError(err)
+	s.Equal(3.0, result.GetByPath("counter").AsNumericDefault(0))
+
+	s.Error(runtime.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", nil, nil))
+	_, err = runtime.DeadLetters(typename)
+	s.ErrorIs(err, statefun.ErrRuntimeWithoutNats)
+
+	runtime.Shutdown()
+	<-stopped
+}
+
+func (s *RuntimeTestSuite) Test_GolangLocalSignal_KeepsOrderAndReplaysLog() {
+	typename := "functions.tests.local.append"
+	walPath := filepath.Join(s.T().TempDir(), "local_signals.wal")
+	replayed := fmt.Sprintf(`{"op":"put","seq":1,"signal":{"caller_typename":"ingress","caller_id":"signal","typename":"%s","id":"a","payload":{"n":-1}}}`, typename)
+	s.NoError(os.WriteFile(walPath, []byte(replayed+"\n"), 0o644))
+
+	runtime, err := statefun.NewRuntime(*statefun.NewRuntimeConfig().SetInProcessNats(true).SetLocalSignalsWAL(walPath))
+	s.NoError(err)
+	statefun.NewFunctionType(runtime, typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		objCtx := ctx.GetObjectContext()
+		list := objCtx.GetByPath("list")
+		if !list.IsArray() {
+			list = easyjson.NewJSONArray()
+		}
+		list.AddToArray(ctx.Payload.GetByPath("n"))
+		objCtx.SetByPath("list", list)
+		ctx.SetObjectContext(objCtx)
+	}, *statefun.NewFunctionTypeConfig().SetMsgChannelSize(2))
+
+	started := make(chan struct{})
+	runtime.RegisterOnAfterStartFunction(func(_ context.Context, _ *statefun.Runtime) error {
+		close(started)
+		return nil
+	}, false)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.NoError(runtime.Start(context.Background(), cache.NewCacheConfig("local_cache")))
+	}()
+	<-started
+
+	for i := 0; i < 30; i++ {
+		payload := easyjson.NewJSONObjectWithKeyValue("n", easyjson.NewJSON(i))
+		s.NoError(runtime.Signal(sfPlugins.AutoSignalSelect, typename, "a", &payload, nil))
+	}
+
+	var list easyjson.JSON
+	s.Eventually(func() bool {
+		v, err := runtime.Domain.Cache().GetValueAsJSON(runtime.Domain.CreateObjectIDWithThisDomain("a", true))
+		if err != nil {
+			return false
+		}
+		list = v.GetByPath("list")
+		return list.ArraySize() == 31
+	}, 5*time.Second, 100*time.Millisecond)
+	for i := 0; i < 31; i++ {
+		s.Equal(float64(i-1), list.ArrayElement(i).AsNumericDefault(0))
+	}
+
+	runtime.Shutdown()
+	<-stopped
+}
+
+func (s *RuntimeTestSuite) Test_GolangLocalSignal_KeptInLogUntilRedirected() {
+	walPath := filepath.Join(s.T().TempDir(), "local_signals.wal")
+	replayed := `{"op":"put","seq":7,"signal":{"caller_typename":"ingress","caller_id":"signal","typename":"functions.tests.local.missing","id":"a"}}`
+	s.NoError(os.WriteFile(walPath, []byte(replayed+"\n"+`{"op":"put","seq":8,"sig`), 0o644))
+
+	runtime, err := statefun.NewRuntime(*statefun.NewRuntimeConfig().SetInProcessNats(true).SetLocalSignalsWAL(walPath))
+	s.NoError(err)
+	started := make(chan struct{})
+	runtime.RegisterOnAfterStartFunction(func(_ context.Context, _ *statefun.Runtime) error {
+		close(started)
+		return nil
+	}, false)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.NoError(runtime.Start(context.Background(), cache.NewCacheConfig("local_cache")))
+	}()
+	<-started
+	time.Sleep(1500 * time.Millisecond) // No stream takes the redirected signal, it is retried meanwhile
+	runtime.Shutdown()
+	<-stopped
+
+	wal, err := os.ReadFile(walPath)
+	s.NoError(err)
+	s.Equal(replayed+"\n", string(wal))
+}
+
+func (s *RuntimeTestSuite) Test_HTTPIngress_CallsAllowedFunctionTypes() {
+	typename := "functions.tests.ingress.echo"
+	s.RegisterFunction(typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		if ctx.Reply != nil {
+			ctx.Reply.With(easyjson.NewJSONObjectWithKeyValue("echo", ctx.Payload.GetByPath("value")).GetPtr())
+		}
+	}, *statefun.NewFunctionTypeConfig().SetAllowedRequestProviders(sfPlugins.AutoRequestSelect))
+	s.RegisterFunction("functions.tests.private.echo", counterFunction, *statefun.NewFunctionTypeConfig())
+	s.NoError(s.StartRuntime())
+
+	ingress := statefun.NewHTTPIngress(s.Runtime(), *statefun.NewHTTPIngressConfig().
+		SetAllowedFunctionTypes("functions.tests.ingress.>").
+		SetAuth(func(req *http.Request, typename string, id string) error {
+			if req.Header.Get("Authorization") != "Bearer secret" {
+				return fmt.Errorf("invalid token")
+			}
+			return nil
+		}))
+	call := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"payload": {"value": 42}}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		ingress.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := call("/request/"+typename+"/a", "secret")
+	s.Equal(http.StatusOK, rec.Code)
+	reply, ok := easyjson.JSONFromBytes(rec.Body.Bytes())
+	s.True(ok)
+	s.Equal(42.0, reply.GetByPath("echo").AsNumericDefault(0))
+
+	s.Equal(http.StatusAccepted, call("/signal/"+typename+"/a", "secret").Code)
+	s.Equal(http.StatusUnauthorized, call("/request/"+typename+"/a", "wrong").Code)
+	s.Equal(http.StatusNotFound, call("/request/functions.tests.private.echo/a", "secret").Code)
+}
+
+func (s *RuntimeTestSuite) Test_EgressProviders_DeliverPerFunctionTypeChoice() {
+	egressFunction := func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		s.NoError(ctx.Egress(sfPlugins.AutoEgressSelect, ctx.Payload))
+	}
+	s.RegisterFunction("functions.tests.egress.file", egressFunction, *statefun.NewFunctionTypeConfig().SetEgressProvider(sfPlugins.FileEgress))
+	s.RegisterFunction("functions.tests.egress.webhook", egressFunction, *statefun.NewFunctionTypeConfig().SetEgressProvider(sfPlugins.HTTPWebhookEgress))
+	s.RegisterFunction("functions.tests.egress.jetstream", egressFunction, *statefun.NewFunctionTypeConfig().SetEgressProvider(sfPlugins.JetstreamEgress))
+
+	filePath := filepath.Join(s.T().TempDir(), "egress.ndjson")
+	fileEgress, err := statefun.NewFileEgress(*statefun.NewFileEgressConfig(filePath).SetRotation(64, 1))
+	s.NoError(err)
+	s.NoError(s.Runtime().RegisterEgressTransport(sfPlugins.FileEgress, fileEgress))
+
+	var attempts atomic.Int32
+	webhookBodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.Equal(statefun.SignWebhookEgress([]byte("secret"), req.Header.Get(statefun.WebhookEgressTimestampHeader), body), req.Header.Get(statefun.WebhookEgressSignatureHeader))
+		webhookBodies <- string(body)
+	}))
+	defer server.Close()
+	s.NoError(s.Runtime().RegisterEgressTransport(sfPlugins.HTTPWebhookEgress, statefun.NewWebhookEgress(*statefun.NewWebhookEgressConfig(server.URL).SetSecret("secret").SetRetry(3, 10, 100))))
+
+	jsEgress, err := statefun.NewJetstreamEgress(s.Runtime(), *statefun.NewJetstreamEgressConfig().SetMaxAge(time.Hour))
+	s.NoError(err)
+	s.NoError(s.Runtime().RegisterEgressTransport(sfPlugins.JetstreamEgress, jsEgress))
+	jsSub, err := s.SubscribeSync(statefun.JetstreamEgressSubjectPrefix + ".>")
+	s.NoError(err)
+	s.NoError(s.StartRuntime())
+
+	for n := 0; n < 3; n++ {
+		payload := easyjson.NewJSONObjectWithKeyValue("n", easyjson.NewJSON(n))
+		s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, "functions.tests.egress.file", "a", &payload, nil))
+	}
+	payload := easyjson.NewJSONObjectWithKeyValue("n", easyjson.NewJSON(7))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, "functions.tests.egress.webhook", "a", &payload, nil))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, "functions.tests.egress.jetstream", "a", &payload, nil))
+
+	select {
+	case body := <-webhookBodies:
+		s.JSONEq(`{"n": 7}`, body)
+		s.Equal(int32(2), attempts.Load())
+	case <-time.After(5 * time.Second):
+		s.Fail("webhook was not called")
+	}
+
+	msg, err := jsSub.NextMsg(5 * time.Second)
+	s.NoError(err)
+	s.Equal(fmt.Sprintf(statefun.JetstreamEgressSubjectsTmpl, s.Runtime().Domain.Name(), "functions.tests.egress.jetstream", s.SetThisDomainPreffix("a")), msg.Subject)
+
+	// Every record exceeds the rotation size, so the last two are left in the file and its backup
+	s.Eventually(func() bool {
+		data, err := os.ReadFile(filePath)
+		return err == nil && strings.Contains(string(data), `"n":2`)
+	}, 5*time.Second, 100*time.Millisecond)
+	backup, err := os.ReadFile(filePath + ".1")
+	s.NoError(err)
+	s.Contains(string(backup), `"n":1`)
+}
+
+func (s *RuntimeTestSuite) Test_WebSocketSubscriptions_StreamFilteredEvents() {
+	typename := "functions.tests.ws.events"
+	s.RegisterFunction(typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		s.NoError(ctx.Egress(sfPlugins.NatsCoreEgress, ctx.Payload))
+	}, *statefun.NewFunctionTypeConfig())
+	s.RegisterFunction("functions.tests.ws.counter", counterFunction, *statefun.NewFunctionTypeConfig())
+	s.NoError(s.StartRuntime())
+
+	wss := statefun.NewWebSocketSubscriptions(s.Runtime(), *statefun.NewWebSocketSubscriptionsConfig().
+		SetSubscriptionAuth(func(req *http.Request, kind string, pattern string) error {
+			if kind == statefun.WebSocketEgressSubscription && !strings.HasPrefix(pattern, "egress.functions.tests.") {
+				return fmt.Errorf("forbidden")
+			}
+			return nil
+		}))
+	server := httptest.NewServer(wss)
+	defer server.Close()
+	defer func() { s.NoError(wss.Shutdown(context.Background())) }()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	s.NoError(err)
+	defer ws.Close()
+	read := func() easyjson.JSON {
+		s.NoError(ws.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		_, data, err := ws.ReadMessage()
+		s.NoError(err)
+		msg, ok := easyjson.JSONFromBytes(data)
+		s.True(ok)
+		return msg
+	}
+
+	s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(`{"op": "subscribe", "sid": "forbidden", "kind": "egress", "typename": "functions.app.>"}`)))
+	s.Equal("error", read().GetByPath("op").AsStringDefault(""))
+	s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(`{"op": "subscribe", "sid": "e", "kind": "egress", "typename": "`+typename+`", "filter": {"kind": "object"}}`)))
+	s.Equal("subscribed", read().GetByPath("op").AsStringDefault(""))
+	s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(`{"op": "subscribe", "sid": "c", "kind": "cache", "key": "*", "filter": {"counter": 1}}`)))
+	s.Equal("subscribed", read().GetByPath("op").AsStringDefault(""))
+
+	link := easyjson.NewJSONObjectWithKeyValue("kind", easyjson.NewJSON("link"))
+	object := easyjson.NewJSONObjectWithKeyValue("kind", easyjson.NewJSON("object"))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", &link, nil))
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", &object, nil))
+
+	event := read()
+	s.Equal("e", event.GetByPath("sid").AsStringDefault(""))
+	s.Equal(typename, event.GetByPath("typename").AsStringDefault(""))
+	s.Equal(s.SetThisDomainPreffix("a"), event.GetByPath("id").AsStringDefault(""))
+	s.Equal("object", event.GetByPath("payload.kind").AsStringDefault(""))
+
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, "functions.tests.ws.counter", "b", nil, nil))
+	event = read()
+	s.Equal("c", event.GetByPath("sid").AsStringDefault(""))
+	s.Equal(s.SetThisDomainPreffix("b"), event.GetByPath("key").AsStringDefault(""))
+	s.Equal(1.0, event.GetByPath("value.counter").AsNumericDefault(0))
+}
+
+func (s *RuntimeTestSuite) Test_ContextMigrations_RunLazilyOnLoad() {
+	typename := "functions.tests.migrations.reader"
+	renameCount := func(v1 *easyjson.JSON) (*easyjson.JSON, error) {
+		v2 := easyjson.NewJSONObjectWithKeyValue("counter", v1.GetByPath("count"))
+		return &v2, nil
+	}
+	nestCounter := func(v2 *easyjson.JSON) (*easyjson.JSON, error) {
+		if !v2.PathExists("counter") {
+			return nil, fmt.Errorf("counter is missing")
+		}
+		v3 := easyjson.NewJSONObjectWithKeyValue("stats", easyjson.NewJSONObjectWithKeyValue("counter", v2.GetByPath("counter")))
+		return &v3, nil
+	}
+	s.RegisterFunction(typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		ctx.Reply.With(ctx.GetObjectContext())
+	}, *statefun.NewFunctionTypeConfig().
+		SetAllowedRequestProviders(sfPlugins.AutoRequestSelect).
+		SetObjectContextMigrations(renameCount, nestCounter))
+	writerTypename := "functions.tests.migrations.writer"
+	s.RegisterFunction(writerTypename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		objCtx := easyjson.NewJSONObjectWithKeyValue("stats", easyjson.NewJSONObjectWithKeyValue("counter", easyjson.NewJSON(1)))
+		ctx.SetObjectContext(&objCtx)
+		ctx.Reply.With(&objCtx)
+	}, *statefun.NewFunctionTypeConfig().
+		SetAllowedRequestProviders(sfPlugins.AutoRequestSelect).
+		SetObjectContextMigrations(renameCount, nestCounter))
+	s.NoError(s.StartRuntime())
+
+	contextCache := s.Runtime().Domain.Cache()
+	contextCache.SetValue(s.SetThisDomainPreffix("v1"), []byte(`{"count": 5}`), true, -1, "")
+	contextCache.SetValue(s.SetThisDomainPreffix("v2"), []byte(`{"__version": 2, "counter": 7}`), true, -1, "")
+	contextCache.SetValue(s.SetThisDomainPreffix("broken"), []byte(`{"__version": 2}`), true, -1, "")
+
+	result, err := s.Request(sfPlugins.AutoRequestSelect, typename, "v1", nil, nil)
+	s.NoError(err)
+	s.Equal(5.0, result.GetByPath("stats.counter").AsNumericDefault(0))
+	s.Equal(3.0, result.GetByPath(statefun.ContextVersionKey).AsNumericDefault(0))
+	stored, err := s.CacheValue("v1")
+	s.NoError(err)
+	s.Equal(3.0, stored.GetByPath(statefun.ContextVersionKey).AsNumericDefault(0))
+
+	result, err = s.Request(sfPlugins.AutoRequestSelect, typename, "v2", nil, nil)
+	s.NoError(err)
+	s.Equal(7.0, result.GetByPath("stats.counter").AsNumericDefault(0))
+
+	result, err = s.Request(sfPlugins.AutoRequestSelect, typename, "broken", nil, nil)
+	s.NoError(err)
+	s.Equal("failed", result.GetByPath("status").AsStringDefault(""))
+	stored, err = s.CacheValue("broken")
+	s.NoError(err)
+	s.Equal(2.0, stored.GetByPath(statefun.ContextVersionKey).AsNumericDefault(0))
+
+	contextCache.SetValue(s.SetThisDomainPreffix("v9"), []byte(`{"__version": 9}`), true, -1, "")
+	result, err = s.Request(sfPlugins.AutoRequestSelect, writerTypename, "v9", nil, nil)
+	s.NoError(err)
+	s.False(result.PathExists(statefun.ContextVersionKey)) // Context set by the handler is not changed
+	stored, err = s.CacheValue("v9")
+	s.NoError(err)
+	s.Equal(1.0, stored.GetByPath("stats.counter").AsNumericDefault(0))
+	s.Equal(9.0, stored.GetByPath(statefun.ContextVersionKey).AsNumericDefault(0))
+}
+
+func (s *RuntimeTestSuite) Test_Snapshot_ExportRestoreRoundTrip() {
+	s.NoError(s.StartRuntime())
+	domain := s.Runtime().Domain.Name()
+
+	contextCache := s.Runtime().Domain.Cache()
+	contextCache.SetValue(s.SetThisDomainPreffix("a"), []byte(`{"peer": "`+domain+`/b", "n": 1}`), true, -1, "")
+	contextCache.SetValue(s.SetThisDomainPreffix("b"), []byte(`{"n": 2}`), true, -1, "")
+	contextCache.SetValue(s.SetThisDomainPreffix("b")+".raw", []byte("not json"), true, -1, "")
+	contextCache.SetValue(s.SetThisDomainPreffix("gone"), []byte(`{}`), true, -1, "")
+	contextCache.DeleteValue(s.SetThisDomainPreffix("gone"), true, -1, "")
+
+	var snapshot bytes.Buffer
+	exported, err := s.Runtime().ExportSnapshot(&snapshot, *statefun.NewSnapshotExportConfig().SetKeyPattern("*"))
+	s.NoError(err)
+	s.Equal(2, exported)
+	s.Contains(snapshot.String(), `"value":{"n": 2}`) // Stored bytes are kept as they are
+
+	copyBucket := statefun.CacheBucketName("copy", "snapshot")
+	restored, err := s.Runtime().RestoreSnapshot(bytes.NewReader(snapshot.Bytes()), *statefun.NewSnapshotRestoreConfig().SetBucket(copyBucket).SetDomainRewrite("", "copy"))
+	s.NoError(err)
+	s.Equal(2, restored)
+
+	var copySnapshot bytes.Buffer
+	_, err = s.Runtime().ExportSnapshot(&copySnapshot, *statefun.NewSnapshotExportConfig().SetBucket(copyBucket))
+	s.NoError(err)
+	values := map[string]easyjson.JSON{}
+	for _, line := range strings.Split(strings.TrimSpace(copySnapshot.String()), "\n")[1:] {
+		record, ok := easyjson.JSONFromBytes([]byte(line))
+		s.True(ok)
+		if key := record.GetByPath("key").AsStringDefault(""); len(key) > 0 {
+			values[key] = record.GetByPath("value")
+		}
+	}
+	s.Len(values, 2)
+	s.Equal("copy/b", values["copy/a"].GetByPath("peer").AsStringDefault(""))
+	s.Equal(2.0, values["copy/b"].GetByPath("n").AsNumericDefault(0))
+
+	truncated := snapshot.Bytes()[:bytes.LastIndexByte(snapshot.Bytes()[:snapshot.Len()-1], '\n')+1]
+	_, err = s.Runtime().RestoreSnapshot(bytes.NewReader(truncated), *statefun.NewSnapshotRestoreConfig().SetBucket(copyBucket))
+	s.Error(err)
+}
+
+func (s *RuntimeTestSuite) Test_Snapshot_CustomBackendWithoutBucket() {
+	s.ReconfigureCache(func(cfg *cache.Config) { cfg.SetBackend(cache.NewMemoryBackend()) })
+	s.NoError(s.StartRuntime())
+
+	js, err := s.NatsConn().JetStream()
+	s.Require().NoError(err)
+	_, err = js.KeyValue(statefun.CacheBucketName(s.Runtime().Domain.Name(), "test_cache"))
+	s.Error(err)
+
+	s.Runtime().Domain.Cache().SetValue(s.SetThisDomainPreffix("a"), []byte(`{"n": 1}`), true, -1, "")
+	var snapshot bytes.Buffer
+	exported, err := s.Runtime().ExportSnapshot(&snapshot, *statefun.NewSnapshotExportConfig())
+	s.NoError(err)
+	s.Equal(1, exported)
+
+	s.Runtime().Domain.Cache().DeleteValue(s.SetThisDomainPreffix("a"), true, -1, "")
+	s.NoError(s.Runtime().Domain.Cache().Flush())
+	restored, err := s.Runtime().RestoreSnapshot(bytes.NewReader(snapshot.Bytes()), *statefun.NewSnapshotRestoreConfig())
+	s.NoError(err)
+	s.Equal(1, restored)
+	s.Eventually(func() bool {
+		value, err := s.CacheValue("a")
+		return err == nil && value.GetByPath("n").AsNumericDefault(0) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+// writeAfterLastRevisionBackend puts the value right after LastRevision once, as if it was written during an export
+type writeAfterLastRevisionBackend struct {
+	cache.Backend
+	key   string
+	value []byte
+}
+
+func (b *writeAfterLastRevisionBackend) LastRevision() (uint64, error) {
+	revision, err := b.Backend.LastRevision()
+	if len(b.key) > 0 {
+		_, err = b.Backend.Put(b.key, b.value)
+		b.key = ""
+	}
+	return revision, err
+}
+
+func (s *RuntimeTestSuite) Test_Snapshot_ReportsKeysWrittenDuringExport() {
+	backend := &writeAfterLastRevisionBackend{Backend: cache.NewMemoryBackend()}
+	s.ReconfigureCache(func(cfg *cache.Config) { cfg.SetBackend(backend) })
+	s.NoError(s.StartRuntime())
+
+	s.Runtime().Domain.Cache().SetValue(s.SetThisDomainPreffix("a"), []byte(`{"n": 1}`), true, -1, "")
+	s.Runtime().Domain.Cache().SetValue(s.SetThisDomainPreffix("b"), []byte(`{"n": 1}`), true, -1, "")
+	s.NoError(s.Runtime().Domain.Cache().Flush())
+	backend.key = cache.KVStorePrefix + "." + s.SetThisDomainPreffix("b")
+	backend.value = append([]byte{0, 0, 0, 0, 0, 0, 0, 1, 1}, `{"n": 2}`...)
+
+	var snapshot bytes.Buffer
+	exported, err := s.Runtime().ExportSnapshot(&snapshot, *statefun.NewSnapshotExportConfig())
+	var skippedErr *statefun.SnapshotSkippedKeysError
+	s.Require().ErrorAs(err, &skippedErr)
+	s.Equal([]string{s.SetThisDomainPreffix("b")}, skippedErr.Keys)
+	s.Equal(1, exported)
+	s.Contains(snapshot.String(), `"skipped_keys":["`+s.SetThisDomainPreffix("b")+`"]`)
+
+	restored, err := s.Runtime().RestoreSnapshot(bytes.NewReader(snapshot.Bytes()), *statefun.NewSnapshotRestoreConfig())
+	s.NoError(err)
+	s.Equal(1, restored)
+}
+
+func (s *RuntimeTestSuite) Test_ContextExpiration_LegacyContextsSweptOnStart() {
+	key := s.SetThisDomainPreffix("legacy")
+	expiresAt := time.Now().Add(time.Hour).UnixNano()
+	snapshot := fmt.Sprintf(`{"format": "foliage-snapshot", "version": 1}
+{"key": "%s", "value": {"n": 1, "____ctx_expires_after_ms": %d}}
+{"end": true, "records": 1}
+`, key, expiresAt)
+	bucket := statefun.CacheBucketName(s.Runtime().Domain.Name(), "test_cache")
+	_, err := statefun.RestoreSnapshot(s.NatsConn(), strings.NewReader(snapshot), *statefun.NewSnapshotRestoreConfig().SetBucket(bucket))
+	s.NoError(err)
+
+	s.NoError(s.StartRuntime())
+
+	s.Eventually(func() bool {
+		expiration, ok := s.Runtime().Domain.Cache().GetValueExpiration(key)
+		return ok && time.Unix(0, expiresAt).Sub(expiration).Abs() < time.Millisecond
+	}, 5*time.Second, 50*time.Millisecond)
+	value, err := s.Runtime().Domain.Cache().GetValueAsJSON(key)
+	s.NoError(err)
+	s.False(value.PathExists("____ctx_expires_after_ms"))
+	s.Equal(1.0, value.GetByPath("n").AsNumericDefault(0))
+}
+
+func (s *RuntimeTestSuite) Test_ContextExpiration_DeletesAndSignals() {
+	typename := "functions.tests.expiry.watcher"
+	events := make(chan easyjson.JSON, 10)
+	s.RegisterFunction(typename, func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		if ctx.Caller.Typename == "expiry" {
+			events <- ctx.Payload.GetByPath(statefun.ContextExpiredPayloadKey)
+			return
+		}
+		ctx.SetObjectContext(easyjson.NewJSONObjectWithKeyValue("kind", easyjson.NewJSON("object")).GetPtr())
+		ctx.SetObjectContextExpirationAfter(200 * time.Millisecond)
+		ctx.SetFunctionContext(easyjson.NewJSONObjectWithKeyValue("kind", easyjson.NewJSON("function")).GetPtr())
+		ctx.SetContextExpirationAfter(400 * time.Millisecond)
+	}, *statefun.NewFunctionTypeConfig().SetContextExpiredSignal(true))
+	s.NoError(s.StartRuntime())
+
+	contextCache := s.Runtime().Domain.Cache()
+	contextCache.SetValue("custom.key", []byte("value"), true, -1, "")
+	s.NoError(contextCache.SetValueExpirationAfter("custom.key", 100*time.Millisecond))
+	_, ok := contextCache.GetValueExpiration("custom.key")
+	s.True(ok)
+	contextCache.SetValue(s.SetThisDomainPreffix("plain"), []byte(`{}`), true, -1, "") // Not a context, though looks like one
+	s.NoError(contextCache.SetValueExpirationAfter(s.SetThisDomainPreffix("plain"), 100*time.Millisecond))
+
+	s.NoError(s.Signal(sfPlugins.JetstreamGlobalSignal, typename, "a", nil, nil))
+
+	for _, kind := range []string{"object", "function"} {
+		select {
+		case event := <-events:
+			s.Equal(kind, event.GetByPath("kind").AsStringDefault(""))
+			s.Equal(kind, event.GetByPath("context.kind").AsStringDefault(""))
+		case <-time.After(5 * time.Second):
+			s.FailNow("context expiration was not signaled", kind)
+		}
+	}
+	_, err := s.CacheValue("a")
+	s.Error(err)
+	_, err = contextCache.GetValue("custom.key")
+	s.Error(err)
+	_, ok = contextCache.GetValueExpiration("custom.key")
+	s.False(ok)
+}
+
+func (s *RuntimeTestSuite) Test_CacheCAS_ConditionalWrites() {
+	s.NoError(s.StartRuntime())
+	contextCache := s.Runtime().Domain.Cache()
+
+	contextCache.SetValue("cas.value", []byte("a"), true, -1, "")
+	ok, err := contextCache.SetValueIfEquals("cas.value", []byte("b"), []byte("x"))
+	s.NoError(err)
+	s.False(ok)
+	ok, err = contextCache.SetValueIfEquals("cas.value", []byte("b"), []byte("a"))
+	s.NoError(err)
+	s.True(ok)
+
+	value, revision, err := contextCache.GetValueRevision("cas.value")
+	s.NoError(err)
+	s.Equal([]byte("b"), value)
+	newRevision, err := contextCache.SetValueIfRevision("cas.value", []byte("c"), revision)
+	s.NoError(err)
+	s.Greater(newRevision, revision)
+	_, err = contextCache.SetValueIfRevision("cas.value", []byte("d"), revision)
+	s.ErrorIs(err, cache.ErrValueRevisionMismatch)
+	s.ErrorIs(contextCache.DeleteValueIfRevision("cas.value", revision), cache.ErrValueRevisionMismatch)
+	value, err = contextCache.GetValue("cas.value")
+	s.NoError(err)
+	s.Equal([]byte("c"), value)
+
+	increment := func(current []byte, exists bool) ([]byte, error) {
+		counter := 0
+		if exists {
+			counter = int(system.BytesToInt64(current))
+		}
+		return system.Int64ToBytes(int64(counter + 1)), nil
+	}
+	done := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		go func() {
+			_, err := contextCache.UpdateValue("cas.counter", increment)
+			s.NoError(err)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		<-done
+	}
+	value, _, err = contextCache.GetValueRevision("cas.counter")
+	s.NoError(err)
+	s.Equal(int64(20), system.BytesToInt64(value))
+}
+
+func (s *RuntimeTestSuite) Test_CacheTransactions_CommitAbortAndConflict() {
+	s.NoError(s.StartRuntime())
+	contextCache := s.Runtime().Domain.Cache()
+
+	contextCache.TransactionBegin("commit")
+	contextCache.SetValue("tx.a", []byte("1"), true, -1, "commit")
+	contextCache.SetValue("tx.b", []byte("2"), true, -1, "commit")
+	contextCache.DeleteValue("tx.b", true, -1, "commit")
+	value, err := contextCache.TransactionGetValue("commit", "tx.a")
+	s.NoError(err)
+	s.Equal([]byte("1"), value)
+	_, err = contextCache.TransactionGetValue("commit", "tx.b")
+	s.Error(err)
+	_, err = contextCache.GetValue("tx.a")
+	s.Error(err)
+	s.NoError(contextCache.TransactionEnd("commit"))
+	value, _, err = contextCache.GetValueRevision("tx.a")
+	s.NoError(err)
+	s.Equal([]byte("1"), value)
+	_, err = contextCache.GetValue("tx.b")
+	s.Error(err)
+
+	contextCache.TransactionBegin("abort")
+	contextCache.SetValue("tx.c", []byte("3"), true, -1, "abort")
+	contextCache.TransactionAbort("abort")
+	s.ErrorIs(contextCache.TransactionEnd("abort"), cache.ErrTransactionNotFound)
+	_, err = contextCache.GetValue("tx.c")
+	s.Error(err)
+
+	contextCache.TransactionBegin("conflict")
+	_, err = contextCache.TransactionGetValue("conflict", "tx.a")
+	s.NoError(err)
+	contextCache.SetValue("tx.d", []byte("4"), true, -1, "conflict")
+	contextCache.SetValue("tx.a", []byte("changed"), true, -1, "")
+	s.ErrorIs(contextCache.TransactionEnd("conflict"), cache.ErrTransactionConflict)
+	_, err = contextCache.GetValue("tx.d")
+	s.Error(err)
+}
@@ -0,0 +1,196 @@
+package statefun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	schedulesKeyPrefix        = "schedules"
+	scheduleCallerTypename    = "schedule"
+	scheduleTickOptionKey     = "schedule_tick"
+	scheduleIDsUpdateAttempts = 10
+)
+
+/*
+Schedule of a function type lives in the domain's key/value bucket:
+  - schedules.<typename hash>.ids - json array of ids the function type is being periodically signaled on
+  - schedules.<typename hash>.tick - unix time in ns of the last fired tick
+
+On every tick all runtimes which have the function type registered try to lock the schedule's KeyMutex without waiting.
+The one who succeeds fires the tick unless it was already fired by someone else.
+*/
+func scheduleKey(typename string) string {
+	return schedulesKeyPrefix + "." + system.GetHashStr(typename)
+}
+
+func scheduleIDsKey(typename string) string {
+	return scheduleKey(typename) + ".ids"
+}
+
+func scheduleTickKey(typename string) string {
+	return scheduleKey(typename) + ".tick"
+}
+
+func (r *Runtime) startSchedules(ctx context.Context, functionTypes []*FunctionType) error {
+	for _, ft := range functionTypes {
+		if err := r.startSchedule(ctx, ft); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runtime) startSchedule(ctx context.Context, ft *FunctionType) error {
+	if ft.config.scheduleInterval <= 0 {
+		return nil
+	}
+
+	ids := easyjson.JSONFromArray(ft.config.scheduleIDs)
+	if _, err := r.Domain.kv.Update(scheduleIDsKey(ft.name), ids.ToBytes(), 0); err != nil && !errors.Is(err, cache.ErrBackendRevisionMismatch) {
+		return err
+	}
+
+	r.wg.Add(1)
+	go r.scheduleRoutine(ctx, ft)
+	return nil
+}
+
+func (r *Runtime) scheduleRoutine(ctx context.Context, ft *FunctionType) {
+	defer r.wg.Done()
+	system.GlobalPrometrics.GetRoutinesCounter().Started("runtime-scheduleRoutine")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("runtime-scheduleRoutine")
+
+	interval := ft.config.scheduleInterval
+	for {
+		nextTick := time.Now().Truncate(interval).Add(interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.shutdown:
+			return
+		case <-ft.stopped:
+			return
+		case <-time.After(time.Until(nextTick)):
+			system.MsgOnErrorReturn(r.fireScheduleTick(ctx, ft, nextTick.UnixNano()))
+		}
+	}
+}
+
+func (r *Runtime) fireScheduleTick(ctx context.Context, ft *FunctionType, tick int64) error {
+	lockRevID, err := KeyMutexLock(ctx, r, scheduleKey(ft.name), true)
+	if err != nil {
+		if errors.Is(err, ErrMutexLocked) { // Tick is being fired by another runtime
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		system.MsgOnErrorReturn(KeyMutexUnlock(ctx, r, scheduleKey(ft.name), lockRevID))
+	}()
+
+	if entry, err := r.Domain.kv.Get(scheduleTickKey(ft.name)); err == nil {
+		if system.BytesToInt64(entry.Value) >= tick { // Tick was already fired
+			return nil
+		}
+	} else if !errors.Is(err, cache.ErrBackendKeyNotFound) {
+		return err
+	}
+
+	ids, _, err := r.getScheduledIDs(ft.name)
+	if err != nil {
+		return err
+	}
+	options := easyjson.NewJSONObjectWithKeyValue(scheduleTickOptionKey, easyjson.NewJSON(tick))
+	for _, id := range ids {
+		system.MsgOnErrorReturn(r.signal(ft.config.preferredSignalProvider(), scheduleCallerTypename, ft.name, ft.name, id, nil, &options))
+	}
+	lg.Logf(lg.TraceLevel, "Schedule tick for function type %s fired on %d ids", ft.name, len(ids))
+
+	_, err = r.Domain.kv.Put(scheduleTickKey(ft.name), system.Int64ToBytes(tick))
+	return err
+}
+
+func (r *Runtime) getScheduledIDs(typename string) ([]string, uint64, error) {
+	if r.Domain.kv == nil {
+		return nil, 0, ErrRuntimeNotStarted
+	}
+	entry, err := r.Domain.kv.Get(scheduleIDsKey(typename))
+	if err != nil {
+		if errors.Is(err, cache.ErrBackendKeyNotFound) {
+			return nil, 0, fmt.Errorf("function type %s has no schedule", typename)
+		}
+		return nil, 0, err
+	}
+	j, ok := easyjson.JSONFromBytes(entry.Value)
+	if !ok {
+		return nil, 0, fmt.Errorf("scheduled ids for function type %s are not a JSON", typename)
+	}
+	ids, _ := j.AsArrayString()
+	return ids, entry.Revision, nil
+}
+
+func (r *Runtime) updateScheduledIDs(typename string, update func(ids []string) []string) error {
+	for i := 0; i < scheduleIDsUpdateAttempts; i++ {
+		ids, revision, err := r.getScheduledIDs(typename)
+		if err != nil {
+			return err
+		}
+		newIDs := easyjson.JSONFromArray(update(ids))
+		if _, err := r.Domain.kv.Update(scheduleIDsKey(typename), newIDs.ToBytes(), revision); err != nil {
+			if errors.Is(err, cache.ErrBackendRevisionMismatch) { // Updated concurrently, retry
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("scheduled ids for function type %s were not updated: too many concurrent updates", typename)
+}
+
+// ScheduledIDs returns ids the function type is being periodically signaled on
+func (r *Runtime) ScheduledIDs(typename string) ([]string, error) {
+	ids, _, err := r.getScheduledIDs(typename)
+	return ids, err
+}
+
+// SetScheduledIDs replaces ids the function type is being periodically signaled on
+func (r *Runtime) SetScheduledIDs(typename string, ids []string) error {
+	return r.updateScheduledIDs(typename, func(_ []string) []string {
+		return append([]string{}, ids...)
+	})
+}
+
+// AddScheduledIDs adds ids to the function type's schedule
+func (r *Runtime) AddScheduledIDs(typename string, ids ...string) error {
+	return r.updateScheduledIDs(typename, func(old []string) []string {
+		for _, id := range ids {
+			if !slices.Contains(old, id) {
+				old = append(old, id)
+			}
+		}
+		return old
+	})
+}
+
+// RemoveScheduledIDs removes ids from the function type's schedule
+func (r *Runtime) RemoveScheduledIDs(typename string, ids ...string) error {
+	return r.updateScheduledIDs(typename, func(old []string) []string {
+		newIDs := []string{}
+		for _, id := range old {
+			if !slices.Contains(ids, id) {
+				newIDs = append(newIDs, id)
+			}
+		}
+		return newIDs
+	})
+}
@@ -0,0 +1,36 @@
+package statefun_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/foliagecp/sdk/statefun"
+	"github.com/foliagecp/sdk/statefun/test"
+	"github.com/stretchr/testify/suite"
+)
+
+type SchedulesTestSuite struct {
+	test.StatefunTestSuite
+}
+
+func TestSchedulesTestSuite(t *testing.T) {
+	suite.Run(t, new(SchedulesTestSuite))
+}
+
+func (s *SchedulesTestSuite) Test_Schedule_FiresOnScheduledIDs() {
+	typename := "functions.tests.schedules.counter"
+	s.RegisterFunction(typename, counterFunction, *statefun.NewFunctionTypeConfig().SetSchedule(300*time.Millisecond, "a"))
+	s.NoError(s.StartRuntime())
+
+	ids, err := s.Runtime().ScheduledIDs(typename)
+	s.NoError(err)
+	s.Equal([]string{"a"}, ids)
+
+	s.NoError(s.Runtime().AddScheduledIDs(typename, "b"))
+
+	s.Eventually(func() bool {
+		a, errA := s.CacheValue("a")
+		b, errB := s.CacheValue("b")
+		return errA == nil && errB == nil && a.GetByPath("counter").AsNumericDefault(0) >= 2 && b.GetByPath("counter").AsNumericDefault(0) >= 1
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,415 @@
+package statefun
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/nats-io/nats.go"
+
+	customNatsKv "github.com/foliagecp/sdk/embedded/nats/kv"
+	"github.com/foliagecp/sdk/statefun/cache"
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Snapshot is a portable NDJSON copy of a domain's key/value bucket. The first line is a header, every next one is a value,
+the last line closes the snapshot, so a truncated file is detected on restore:
+
+	{"format": "foliage-snapshot", "version": 1, "domain": "hub", "bucket": "hub_x_cache_bucket", "pattern": ">", "created_at": <unix ns>}
+	{"key": "hub/a", "time": <unix ns>, "value": {...}}       // single line JSON values, bytes as they are stored
+	{"key": "hub/b", "time": <unix ns>, "value_b64": "..."}   // other values
+	{"end": true, "records": 2, "skipped_keys": ["hub/c"]}     // skipped_keys are optional
+
+Keys are the cache keys without the bucket's key prefix, deleted values are not exported. Values are restored byte for
+byte unless the domain is rewritten. Export is bounded by the last sequence of the bucket's stream at its start: the
+backend keeps only the last value of a key, so the value at the start of a key written or deleted during the export is
+not known. Such keys are not exported, they are listed in the closing line and export returns SnapshotSkippedKeysError
+with them after writing the whole snapshot: it is consistent for all the other keys and can be restored, or exported
+again when writes calm down. Keys created during the export are listed too, the backend cannot tell them from the
+overwritten ones. Runtime.ExportSnapshot flushes the runtime's cache before the start and reads it through the cache's backend, so
+runtimes with a custom one are exported and restored the same way.
+*/
+const (
+	SnapshotFormat  = "foliage-snapshot"
+	SnapshotVersion = 1
+)
+
+// snapshotRecord is a value line or the closing one of the snapshot
+type snapshotRecord struct {
+	Key      string          `json:"key"`
+	Time     int64           `json:"time"`
+	Value    json.RawMessage `json:"value"`
+	ValueB64 string          `json:"value_b64"`
+	End      bool            `json:"end"`
+	Records  int             `json:"records"`
+	Skipped  []string        `json:"skipped_keys"`
+}
+
+// SnapshotSkippedKeysError lists keys written during the export, they are not in the snapshot
+type SnapshotSkippedKeysError struct {
+	Keys []string
+}
+
+func (e *SnapshotSkippedKeysError) Error() string {
+	return fmt.Sprintf("%d keys written during the export are skipped: %s", len(e.Keys), strings.Join(e.Keys, ", "))
+}
+
+// CacheBucketName returns the name of the key/value bucket the domain's cache is stored in
+func CacheBucketName(domain string, cacheID string) string {
+	return fmt.Sprintf("%s_%s_cache_bucket", domain, cacheID)
+}
+
+type SnapshotExportConfig struct {
+	domain        string
+	bucket        string
+	kvStorePrefix string
+	pattern       string
+}
+
+func NewSnapshotExportConfig() *SnapshotExportConfig {
+	return &SnapshotExportConfig{
+		kvStorePrefix: cache.KVStorePrefix,
+		pattern:       ">",
+	}
+}
+
+// SetDomain sets the domain recorded in the snapshot's header, restore uses it as the domain to rewrite ids from
+func (sec *SnapshotExportConfig) SetDomain(domain string) *SnapshotExportConfig {
+	sec.domain = domain
+	return sec
+}
+
+// SetBucket sets the bucket to export, Runtime.ExportSnapshot exports the runtime's one by default
+func (sec *SnapshotExportConfig) SetBucket(bucket string) *SnapshotExportConfig {
+	sec.bucket = bucket
+	return sec
+}
+
+func (sec *SnapshotExportConfig) SetKVStorePrefix(kvStorePrefix string) *SnapshotExportConfig {
+	sec.kvStorePrefix = kvStorePrefix
+	return sec
+}
+
+// SetKeyPattern exports only keys matching the NATS-like pattern: "hub/a.>", "*.out.body.*"
+func (sec *SnapshotExportConfig) SetKeyPattern(pattern string) *SnapshotExportConfig {
+	sec.pattern = pattern
+	return sec
+}
+
+type SnapshotRestoreConfig struct {
+	bucket        string
+	kvStorePrefix string
+	fromDomain    string
+	toDomain      string
+	keyRewrite    func(key string) string
+}
+
+func NewSnapshotRestoreConfig() *SnapshotRestoreConfig {
+	return &SnapshotRestoreConfig{
+		kvStorePrefix: cache.KVStorePrefix,
+	}
+}
+
+// SetBucket sets the bucket to restore into, it is created if does not exist; Runtime.RestoreSnapshot restores into the
+// runtime's one by default
+func (src *SnapshotRestoreConfig) SetBucket(bucket string) *SnapshotRestoreConfig {
+	src.bucket = bucket
+	return src
+}
+
+func (src *SnapshotRestoreConfig) SetKVStorePrefix(kvStorePrefix string) *SnapshotRestoreConfig {
+	src.kvStorePrefix = kvStorePrefix
+	return src
+}
+
+// SetDomainRewrite moves object ids of one domain into another: every key token and every string token in JSON values
+// (tokens are separated by dots) starting with "<fromDomain>/" gets "<toDomain>/" instead. Empty fromDomain means the
+// domain recorded in the snapshot's header
+func (src *SnapshotRestoreConfig) SetDomainRewrite(fromDomain string, toDomain string) *SnapshotRestoreConfig {
+	src.fromDomain = fromDomain
+	src.toDomain = toDomain
+	return src
+}
+
+// SetKeyRewrite sets a custom rewrite of keys, applied after the domain rewrite; empty result skips the value
+func (src *SnapshotRestoreConfig) SetKeyRewrite(keyRewrite func(key string) string) *SnapshotRestoreConfig {
+	src.keyRewrite = keyRewrite
+	return src
+}
+
+func (src *SnapshotRestoreConfig) rewritesDomain() bool {
+	return len(src.fromDomain) > 0 && src.fromDomain != src.toDomain
+}
+
+func (src *SnapshotRestoreConfig) rewriteTokens(s string) string {
+	if !src.rewritesDomain() {
+		return s
+	}
+	fromPrefix := src.fromDomain + ObjectIDDomainSeparator
+	if !strings.Contains(s, fromPrefix) {
+		return s
+	}
+	tokens := strings.Split(s, ".")
+	for i, token := range tokens {
+		if strings.HasPrefix(token, fromPrefix) {
+			tokens[i] = src.toDomain + ObjectIDDomainSeparator + strings.TrimPrefix(token, fromPrefix)
+		}
+	}
+	return strings.Join(tokens, ".")
+}
+
+func (src *SnapshotRestoreConfig) rewriteValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return src.rewriteTokens(v)
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, elem := range v {
+			arr[i] = src.rewriteValue(elem)
+		}
+		return arr
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			obj[src.rewriteTokens(key)] = src.rewriteValue(elem)
+		}
+		return obj
+	}
+	return value
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// ExportSnapshot writes values of the bucket into the snapshot, returns the number of exported values
+func ExportSnapshot(nc *nats.Conn, w io.Writer, config SnapshotExportConfig) (int, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return 0, err
+	}
+	kv, err := js.KeyValue(config.bucket)
+	if err != nil {
+		return 0, fmt.Errorf("cannot open bucket %s: %w", config.bucket, err)
+	}
+	return exportSnapshot(cache.NewNatsBackend(js, kv), w, config)
+}
+
+func exportSnapshot(backend cache.Backend, w io.Writer, config SnapshotExportConfig) (int, error) {
+	bw := bufio.NewWriter(w)
+	header := easyjson.NewJSONObject()
+	header.SetByPath("format", easyjson.NewJSON(SnapshotFormat))
+	header.SetByPath("version", easyjson.NewJSON(SnapshotVersion))
+	header.SetByPath("domain", easyjson.NewJSON(config.domain))
+	header.SetByPath("bucket", easyjson.NewJSON(config.bucket))
+	header.SetByPath("pattern", easyjson.NewJSON(config.pattern))
+	header.SetByPath("created_at", easyjson.NewJSON(time.Now().UnixNano()))
+	if err := writeSnapshotLine(bw, header); err != nil {
+		return 0, err
+	}
+
+	// Everything written after this point is not a part of the snapshot
+	lastRevision, err := backend.LastRevision()
+	if err != nil {
+		return 0, err
+	}
+
+	// Watcher delivers the last value or delete of every key as of its creation, then nil
+	watcher, err := backend.Watch(config.kvStorePrefix+"."+config.pattern, false)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { system.MsgOnErrorReturn(watcher.Stop()) }()
+
+	records := 0
+	skipped := []string{}
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		key := strings.TrimPrefix(entry.Key, config.kvStorePrefix+".")
+		if entry.Revision > lastRevision { // Written or deleted after the start, the value at the start is not known
+			skipped = append(skipped, key)
+			continue
+		}
+		valueBytes := entry.Value
+		if entry.Deleted || len(valueBytes) < 9 || valueBytes[8] != 1 { // Not a cache value or deleted one
+			continue
+		}
+		if err := writeSnapshotRecord(bw, key, int64(binary.BigEndian.Uint64(valueBytes[:8])), valueBytes[9:]); err != nil {
+			return records, err
+		}
+		records++
+	}
+
+	footer := easyjson.NewJSONObjectWithKeyValue("end", easyjson.NewJSON(true))
+	footer.SetByPath("records", easyjson.NewJSON(records))
+	if len(skipped) > 0 {
+		footer.SetByPath("skipped_keys", easyjson.JSONFromArray(skipped))
+	}
+	if err := writeSnapshotLine(bw, footer); err != nil {
+		return records, err
+	}
+	if err := bw.Flush(); err != nil {
+		return records, err
+	}
+	if len(skipped) > 0 {
+		return records, &SnapshotSkippedKeysError{Keys: skipped}
+	}
+	return records, nil
+}
+
+// writeSnapshotRecord writes the value as is if it is a single line JSON, base64 encoded otherwise, so restore gets the
+// same bytes back
+func writeSnapshotRecord(w *bufio.Writer, key string, time int64, value []byte) error {
+	line := make([]byte, 0, len(key)+len(value)+48)
+	line = append(line, `{"key":`...)
+	line = append(line, easyjson.NewJSON(key).ToBytes()...)
+	line = append(line, `,"time":`...)
+	line = strconv.AppendInt(line, time, 10)
+	if json.Valid(value) && !bytes.ContainsAny(value, "\r\n") {
+		line = append(line, `,"value":`...)
+		line = append(line, value...)
+	} else {
+		line = append(line, `,"value_b64":"`...)
+		line = append(line, base64.StdEncoding.EncodeToString(value)...)
+		line = append(line, '"')
+	}
+	line = append(line, '}', '\n')
+	_, err := w.Write(line)
+	return err
+}
+
+func writeSnapshotLine(w *bufio.Writer, j easyjson.JSON) error {
+	if _, err := w.Write(j.ToBytes()); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+// RestoreSnapshot puts values of the snapshot into the bucket, returns the number of restored values. Restored values get
+// the current time, so they take precedence over the cached ones in running runtimes.
+func RestoreSnapshot(nc *nats.Conn, r io.Reader, config SnapshotRestoreConfig) (int, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return 0, err
+	}
+	kv, err := js.KeyValue(config.bucket)
+	if err != nil {
+		if kv, err = customNatsKv.CreateKeyValue(nc, js, &nats.KeyValueConfig{Bucket: config.bucket}); err != nil {
+			return 0, fmt.Errorf("cannot create bucket %s: %w", config.bucket, err)
+		}
+	}
+	return restoreSnapshot(cache.NewNatsBackend(js, kv), r, config)
+}
+
+func restoreSnapshot(backend cache.Backend, r io.Reader, config SnapshotRestoreConfig) (int, error) {
+	var err error
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("snapshot is empty: %v", scanner.Err())
+	}
+	header, ok := easyjson.JSONFromBytes(scanner.Bytes())
+	if !ok || header.GetByPath("format").AsStringDefault("") != SnapshotFormat {
+		return 0, fmt.Errorf("not a %s file", SnapshotFormat)
+	}
+	if version := int(header.GetByPath("version").AsNumericDefault(0)); version != SnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	if len(config.fromDomain) == 0 && len(config.toDomain) > 0 {
+		config.fromDomain = header.GetByPath("domain").AsStringDefault("")
+	}
+
+	records, line := 0, 1
+	for scanner.Scan() {
+		line++
+		var record snapshotRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return records, fmt.Errorf("line %d is not a snapshot record: %w", line, err)
+		}
+		if record.End {
+			if record.Records != line-2 {
+				return records, fmt.Errorf("snapshot is corrupted: %d records expected, %d read", record.Records, line-2)
+			}
+			if len(record.Skipped) > 0 {
+				lg.Logf(lg.WarnLevel, "Snapshot misses %d keys written during its export: %s", len(record.Skipped), strings.Join(record.Skipped, ", "))
+			}
+			return records, nil
+		}
+
+		key := config.rewriteTokens(record.Key)
+		if config.keyRewrite != nil {
+			key = config.keyRewrite(key)
+		}
+		if len(key) == 0 {
+			continue
+		}
+		value := []byte(record.Value)
+		if record.Value == nil {
+			if value, err = base64.StdEncoding.DecodeString(record.ValueB64); err != nil {
+				return records, fmt.Errorf("line %d: %w", line, err)
+			}
+		} else if config.rewritesDomain() {
+			j, ok := easyjson.JSONFromBytes(record.Value)
+			if !ok {
+				return records, fmt.Errorf("line %d: value is not a JSON", line)
+			}
+			value = easyjson.NewJSON(config.rewriteValue(j.Value)).ToBytes()
+		}
+
+		header := make([]byte, 9)
+		binary.BigEndian.PutUint64(header, uint64(system.GetCurrentTimeNs()))
+		header[8] = 1 // Append flag
+		if _, err := backend.Put(config.kvStorePrefix+"."+key, append(header, value...)); err != nil {
+			return records, fmt.Errorf("cannot restore key %s: %w", key, err)
+		}
+		records++
+	}
+	if err := scanner.Err(); err != nil {
+		return records, err
+	}
+	return records, fmt.Errorf("snapshot is truncated after %d records", records)
+}
+
+// ExportSnapshot flushes the runtime's cache and exports its backend unless a bucket is set in the config
+func (r *Runtime) ExportSnapshot(w io.Writer, config SnapshotExportConfig) (int, error) {
+	if len(config.bucket) > 0 {
+		if r.nc == nil {
+			return 0, ErrRuntimeWithoutNats
+		}
+		return ExportSnapshot(r.nc, w, config)
+	}
+	if r.Domain.kv == nil {
+		return 0, ErrRuntimeNotStarted
+	}
+	if err := r.Domain.cache.Flush(); err != nil {
+		return 0, err
+	}
+	config.bucket = r.Domain.bucket
+	config.domain = r.Domain.name
+	return exportSnapshot(r.Domain.kv, w, config)
+}
+
+// RestoreSnapshot restores the snapshot into the runtime's backend unless a bucket is set in the config
+func (r *Runtime) RestoreSnapshot(reader io.Reader, config SnapshotRestoreConfig) (int, error) {
+	if len(config.bucket) > 0 {
+		if r.nc == nil {
+			return 0, ErrRuntimeWithoutNats
+		}
+		return RestoreSnapshot(r.nc, reader, config)
+	}
+	if r.Domain.kv == nil {
+		return 0, ErrRuntimeNotStarted
+	}
+	return restoreSnapshot(r.Domain.kv, reader, config)
+}
@@ -153,6 +153,38 @@ func (pm *Prometrics) EnsureHistogramVec(id string, metric *prometheus.Histogram
 
 // ------------------------------------------------------------------------------------------------
 
+// CounterVec -------------------------------------------------------------------------------------
+func (pm *Prometrics) EnsureCounterVecSimple(id string, help string, labelNames []string) (*prometheus.CounterVec, error) {
+	if pm == nil {
+		return nil, PrometricInstanceIsNil
+	}
+	name := strings.ReplaceAll(id, ".", "")
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: name,
+		Help: help,
+	}, labelNames)
+	return pm.EnsureCounterVec(id, metric)
+}
+
+func (pm *Prometrics) EnsureCounterVec(id string, metric *prometheus.CounterVec) (*prometheus.CounterVec, error) {
+	if pm == nil {
+		return nil, PrometricInstanceIsNil
+	}
+	pm.metricsMutex.Lock()
+	defer pm.metricsMutex.Unlock()
+	if metricAny, ok := pm.metrics[id]; ok {
+		if metric, ok := metricAny.(*prometheus.CounterVec); ok {
+			return metric, nil
+		} else {
+			return nil, PrometricDifferentTypeExistsForIdError
+		}
+	}
+	pm.metrics[id] = metric
+	return metric, prometheus.Register(*metric)
+}
+
+// ------------------------------------------------------------------------------------------------
+
 type RoutinesCounterValue struct {
 	v int64
 	m sync.Mutex
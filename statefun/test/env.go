@@ -27,6 +27,7 @@ type statefunTestEnvironment struct {
 	runtimeCfg *statefun.RuntimeConfig
 	cacheCfg   *cache.Config
 	nc         *nats.Conn
+	stopped    chan struct{}
 }
 
 func newStatefunTestEnvironment() *statefunTestEnvironment {
@@ -40,6 +41,7 @@ func newStatefunTestEnvironment() *statefunTestEnvironment {
 		runtime:    mustNewRuntime(*runtimeConfig),
 		runtimeCfg: runtimeConfig,
 		cacheCfg:   cacheConfig,
+		stopped:    make(chan struct{}),
 	}
 }
 
@@ -47,6 +49,7 @@ func (env *statefunTestEnvironment) StartRuntime() error {
 	errChan := make(chan error, 1)
 
 	go func() {
+		defer close(env.stopped)
 		if err := env.runtime.Start(context.TODO(), env.cacheCfg); err != nil {
 			errChan <- err
 		}
@@ -61,10 +64,37 @@ func (env *statefunTestEnvironment) StartRuntime() error {
 	return nil
 }
 
+// ShutdownRuntime shuts the runtime down and waits for its Start to return
+func (env *statefunTestEnvironment) ShutdownRuntime(timeout time.Duration) error {
+	env.runtime.Shutdown()
+	select {
+	case <-env.stopped:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("runtime was not shut down in %s", timeout)
+	}
+}
+
 func (env *statefunTestEnvironment) Runtime() *statefun.Runtime {
 	return env.runtime
 }
 
+// ReconfigureRuntime recreates the runtime with the changed config, must be called before functions are registered
+func (env *statefunTestEnvironment) ReconfigureRuntime(configure func(cfg *statefun.RuntimeConfig)) {
+	configure(env.runtimeCfg)
+	env.runtime = mustNewRuntime(*env.runtimeCfg)
+}
+
+// NatsConn returns a connection to the test's NATS server, independent of the runtime
+func (env *statefunTestEnvironment) NatsConn() *nats.Conn {
+	return env.nc
+}
+
+// ReconfigureCache changes the cache config the runtime is started with
+func (env *statefunTestEnvironment) ReconfigureCache(configure func(cfg *cache.Config)) {
+	configure(env.cacheCfg)
+}
+
 func (env *statefunTestEnvironment) RegisterFunction(name string, handler statefun.FunctionLogicHandler, cfg statefun.FunctionTypeConfig) {
 	statefun.NewFunctionType(env.runtime, name, handler, cfg)
 }
@@ -0,0 +1,235 @@
+package statefun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	timersKeyPrefix = "timers"
+
+	timerRetryInterval = time.Second
+	timerClaimTimeout  = 30 * time.Second
+)
+
+var (
+	ErrTimerNotFound      = errors.New("timer not found")
+	ErrRuntimeNotStarted  = errors.New("runtime is not started")
+	ErrTimerInvalidHandle = errors.New("invalid timer handle")
+)
+
+/*
+Timers are stored in the domain's key/value bucket under "timers.<handle>" keys, so they survive a runtime restart.
+Every runtime watches all timers and arms a local time.AfterFunc for each of them. When a timer fires, runtimes race to
+claim it: the winner moves the timer's time timerClaimTimeout ahead with the revision it has seen, sends the signal and
+deletes the timer only when the transport confirms the signal is durably accepted (see ConfirmedSignalTransport). If the
+signal cannot be sent, the timer is put back to fire again after timerRetryInterval; if the winner crashes, the claim
+expires and another runtime sends the signal. So a timer is never lost, but its signal may come twice when the winner
+crashes after sending it and before deleting the timer.
+*/
+type timers struct {
+	runtime *Runtime
+	armed   map[string]*time.Timer
+	mutex   sync.Mutex
+}
+
+func newTimers(runtime *Runtime) *timers {
+	return &timers{runtime: runtime, armed: map[string]*time.Timer{}}
+}
+
+func timerKey(handle string) string {
+	return timersKeyPrefix + "." + handle
+}
+
+func (t *timers) run(ctx context.Context) {
+	defer t.runtime.wg.Done()
+	system.GlobalPrometrics.GetRoutinesCounter().Started("runtime-timers")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("runtime-timers")
+
+	w, err := t.runtime.Domain.kv.Watch(timerKey("*"), false)
+	if err != nil {
+		lg.Logf(lg.ErrorLevel, "Timers kv.Watch error: %s", err)
+		return
+	}
+	defer func() {
+		system.MsgOnErrorReturn(w.Stop())
+		t.disarmAll()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.runtime.shutdown:
+			return
+		case entry, ok := <-w.Updates():
+			if !ok {
+				return
+			}
+			if entry == nil { // All existing timers are received
+				continue
+			}
+			handle := strings.TrimPrefix(entry.Key, timersKeyPrefix+".")
+			if entry.Deleted {
+				t.disarm(handle)
+			} else {
+				t.arm(handle, entry.Value, entry.Revision)
+			}
+		}
+	}
+}
+
+func (t *timers) arm(handle string, data []byte, revision uint64) {
+	j, ok := easyjson.JSONFromBytes(data)
+	if !ok {
+		lg.Logf(lg.ErrorLevel, "Timer %s is not a JSON", handle)
+		return
+	}
+	fireAt := int64(j.GetByPath("at").AsNumericDefault(0))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if timer, ok := t.armed[handle]; ok {
+		timer.Stop()
+	}
+	t.armed[handle] = time.AfterFunc(time.Until(time.Unix(0, fireAt)), func() {
+		t.fire(handle, &j, revision)
+	})
+}
+
+func (t *timers) disarm(handle string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if timer, ok := t.armed[handle]; ok {
+		timer.Stop()
+		delete(t.armed, handle)
+	}
+}
+
+func (t *timers) disarmAll() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for handle, timer := range t.armed {
+		timer.Stop()
+		delete(t.armed, handle)
+	}
+}
+
+func (t *timers) fire(handle string, j *easyjson.JSON, revision uint64) {
+	t.mutex.Lock()
+	delete(t.armed, handle)
+	t.mutex.Unlock()
+
+	// Only one runtime succeeds in claiming the timer with the revision it has seen
+	claimed := j.Clone()
+	claimed.SetByPath("at", easyjson.NewJSON(time.Now().Add(timerClaimTimeout).UnixNano()))
+	claimRevision, err := t.runtime.Domain.kv.Update(timerKey(handle), claimed.ToBytes(), revision)
+	if err != nil {
+		return
+	}
+
+	var payload *easyjson.JSON
+	if j.GetByPath("payload").IsObject() {
+		payload = j.GetByPath("payload").GetPtr()
+	}
+	var options *easyjson.JSON
+	if j.GetByPath("options").IsObject() {
+		options = j.GetByPath("options").GetPtr()
+	}
+	err = t.runtime.signalConfirmed(
+		sfPlugins.SignalProvider(j.GetByPath("provider").AsNumericDefault(0)),
+		j.GetByPath("caller_typename").AsStringDefault(""),
+		j.GetByPath("caller_id").AsStringDefault(""),
+		j.GetByPath("typename").AsStringDefault(""),
+		j.GetByPath("id").AsStringDefault(""),
+		payload,
+		options,
+	)
+	if err != nil {
+		lg.Logf(lg.WarnLevel, "Timer %s cannot send its signal, retrying in %s: %s", handle, timerRetryInterval, err)
+		retry := j.Clone()
+		retry.SetByPath("at", easyjson.NewJSON(time.Now().Add(timerRetryInterval).UnixNano()))
+		if _, err := t.runtime.Domain.kv.Update(timerKey(handle), retry.ToBytes(), claimRevision); err != nil {
+			lg.Logf(lg.WarnLevel, "Timer %s cannot be put back, it is retried when the claim expires: %s", handle, err)
+		}
+		return
+	}
+	if err := t.runtime.Domain.kv.Delete(timerKey(handle), claimRevision); err != nil && !errors.Is(err, cache.ErrBackendKeyNotFound) {
+		lg.Logf(lg.WarnLevel, "Timer %s cannot be deleted after sending its signal: %s", handle, err)
+	}
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+func (r *Runtime) signalAt(signalProvider sfPlugins.SignalProvider, at time.Time, callerTypename string, callerID string, targetTypename string, targetID string, payload *easyjson.JSON, options *easyjson.JSON) (string, error) {
+	if r.Domain.kv == nil {
+		return "", ErrRuntimeNotStarted
+	}
+
+	j := easyjson.NewJSONObject()
+	j.SetByPath("at", easyjson.NewJSON(at.UnixNano()))
+	j.SetByPath("provider", easyjson.NewJSON(int(signalProvider)))
+	j.SetByPath("caller_typename", easyjson.NewJSON(callerTypename))
+	j.SetByPath("caller_id", easyjson.NewJSON(callerID))
+	j.SetByPath("typename", easyjson.NewJSON(targetTypename))
+	j.SetByPath("id", easyjson.NewJSON(targetID))
+	if payload != nil {
+		j.SetByPath("payload", *payload)
+	}
+	if options != nil {
+		j.SetByPath("options", *options)
+	}
+
+	handle := system.GetUniqueStrID()
+	if _, err := r.Domain.kv.Update(timerKey(handle), j.ToBytes(), 0); err != nil {
+		return "", err
+	}
+	return handle, nil
+}
+
+func (r *Runtime) cancelTimer(handle string) error {
+	if r.Domain.kv == nil {
+		return ErrRuntimeNotStarted
+	}
+	if len(handle) == 0 || strings.ContainsAny(handle, ".*>") {
+		return ErrTimerInvalidHandle
+	}
+
+	entry, err := r.Domain.kv.Get(timerKey(handle))
+	if err != nil {
+		if errors.Is(err, cache.ErrBackendKeyNotFound) {
+			return ErrTimerNotFound
+		}
+		return err
+	}
+	if err := r.Domain.kv.Delete(timerKey(handle), entry.Revision); err != nil {
+		return fmt.Errorf("timer %s cannot be cancelled, it has probably already fired: %w", handle, err)
+	}
+	return nil
+}
+
+// SignalAfter durably schedules a signal to be sent after the delay. Returns a handle of the timer.
+func (r *Runtime) SignalAfter(signalProvider sfPlugins.SignalProvider, delay time.Duration, typename string, id string, payload *easyjson.JSON, options *easyjson.JSON) (string, error) {
+	return r.signalAt(signalProvider, time.Now().Add(delay), "ingress", "signal", typename, id, payload, options)
+}
+
+// SignalAt durably schedules a signal to be sent at the given time. Returns a handle of the timer.
+func (r *Runtime) SignalAt(signalProvider sfPlugins.SignalProvider, at time.Time, typename string, id string, payload *easyjson.JSON, options *easyjson.JSON) (string, error) {
+	return r.signalAt(signalProvider, at, "ingress", "signal", typename, id, payload, options)
+}
+
+// CancelTimer cancels a timer which has not fired yet
+func (r *Runtime) CancelTimer(handle string) error {
+	return r.cancelTimer(handle)
+}
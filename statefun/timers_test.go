@@ -0,0 +1,72 @@
+package statefun_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/statefun"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/test"
+	"github.com/stretchr/testify/suite"
+)
+
+type TimersTestSuite struct {
+	test.StatefunTestSuite
+}
+
+func TestTimersTestSuite(t *testing.T) {
+	suite.Run(t, new(TimersTestSuite))
+}
+
+func counterFunction(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+	objCtx := ctx.GetObjectContext()
+	objCtx.SetByPath("counter", easyjson.NewJSON(objCtx.GetByPath("counter").AsNumericDefault(0)+1))
+	ctx.SetObjectContext(objCtx)
+}
+
+func (s *TimersTestSuite) Test_SignalAfter_Fires() {
+	typename := "functions.tests.timers.counter"
+	s.RegisterFunction(typename, counterFunction, *statefun.NewFunctionTypeConfig())
+	s.NoError(s.StartRuntime())
+
+	_, err := s.Runtime().SignalAfter(sfPlugins.JetstreamGlobalSignal, 200*time.Millisecond, typename, "a", nil, nil)
+	s.NoError(err)
+
+	s.Eventually(func() bool {
+		v, err := s.CacheValue("a")
+		return err == nil && v.GetByPath("counter").AsNumericDefault(0) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func (s *TimersTestSuite) Test_CancelTimer_PreventsSignal() {
+	typename := "functions.tests.timers.counter"
+	s.RegisterFunction(typename, counterFunction, *statefun.NewFunctionTypeConfig())
+	s.NoError(s.StartRuntime())
+
+	handle, err := s.Runtime().SignalAt(sfPlugins.JetstreamGlobalSignal, time.Now().Add(500*time.Millisecond), typename, "b", nil, nil)
+	s.NoError(err)
+	s.NoError(s.Runtime().CancelTimer(handle))
+	s.ErrorIs(s.Runtime().CancelTimer(handle), statefun.ErrTimerNotFound)
+
+	time.Sleep(1 * time.Second)
+	_, err = s.CacheValue("b")
+	s.Error(err)
+}
+
+func (s *TimersTestSuite) Test_SignalAfter_KeptUntilStreamAccepts() {
+	typename := "functions.tests.timers.late"
+	s.NoError(s.StartRuntime())
+
+	// No stream takes the signal yet, the timer must be kept and retried
+	_, err := s.Runtime().SignalAfter(sfPlugins.JetstreamGlobalSignal, 100*time.Millisecond, typename, "c", nil, nil)
+	s.NoError(err)
+	time.Sleep(500 * time.Millisecond)
+	_, err = s.Runtime().RegisterFunctionType(typename, counterFunction, *statefun.NewFunctionTypeConfig())
+	s.NoError(err)
+
+	s.Eventually(func() bool {
+		v, err := s.CacheValue("c")
+		return err == nil && v.GetByPath("counter").AsNumericDefault(0) == 1
+	}, 10*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,152 @@
+package statefun
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+/*
+Signals, requests and egress messages are dispatched through the transport registered for the provider chosen by the
+caller. JetstreamGlobalSignal, GolangLocalSignal, NatsCoreGlobalRequest, GolangLocalRequest and NatsCoreEgress are
+registered by NewRuntime, JetstreamEgress, HTTPWebhookEgress and FileEgress must be registered with their settings (see
+egress_providers.go), custom providers can be registered with their own values:
+
+	const KafkaSignal sfPlugins.SignalProvider = 100
+
+	runtime.RegisterSignalTransport(KafkaSignal, kafkaTransport)
+*/
+type SignalTransport interface {
+	Signal(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) error
+}
+
+// ConfirmedSignalTransport is implemented by transports able to tell when a signal is durably accepted, timers send their
+// signals through it and are deleted only after SignalConfirmed returns no error
+type ConfirmedSignalTransport interface {
+	SignalConfirmed(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON) error
+}
+
+type RequestTransport interface {
+	Request(caller sfPlugins.StatefunAddress, target sfPlugins.StatefunAddress, payload *easyjson.JSON, options *easyjson.JSON, timeout time.Duration) (*easyjson.JSON, error)
+}
+
+type EgressTransport interface {
+	Egress(caller sfPlugins.StatefunAddress, payload *easyjson.JSON) error
+}
+
+// TransportSource is implemented by transports which receive messages for function types on their own, Subscribe is
+// called on start of every function type which allows the transport's provider. Received messages are passed to
+// FunctionType.Deliver.
+type TransportSource interface {
+	Subscribe(ft *FunctionType) error
+}
+
+func (r *Runtime) registerDefaultTransports() {
+	r.signalTransports = map[sfPlugins.SignalProvider]SignalTransport{
+		sfPlugins.GolangLocalSignal: &golangLocalSignalTransport{r},
+	}
+	r.requestTransports = map[sfPlugins.RequestProvider]RequestTransport{
+		sfPlugins.GolangLocalRequest: &golangLocalRequestTransport{r},
+	}
+	r.egressTransports = map[sfPlugins.EgressProvider]EgressTransport{}
+	if r.config.withoutNats {
+		return
+	}
+	r.signalTransports[sfPlugins.JetstreamGlobalSignal] = &jetstreamSignalTransport{r}
+	r.requestTransports[sfPlugins.NatsCoreGlobalRequest] = &natsCoreRequestTransport{r}
+	r.egressTransports[sfPlugins.NatsCoreEgress] = &natsCoreEgressTransport{r}
+}
+
+// RegisterSignalTransport sets the transport signals of the provider are sent through, must be called before Start
+func (r *Runtime) RegisterSignalTransport(signalProvider sfPlugins.SignalProvider, transport SignalTransport) error {
+	if signalProvider == sfPlugins.AutoSignalSelect {
+		return fmt.Errorf("cannot register a transport for the auto signal provider")
+	}
+	r.transportsMutex.Lock()
+	defer r.transportsMutex.Unlock()
+	r.signalTransports[signalProvider] = transport
+	return nil
+}
+
+// RegisterRequestTransport sets the transport requests of the provider are sent through, must be called before Start
+func (r *Runtime) RegisterRequestTransport(requestProvider sfPlugins.RequestProvider, transport RequestTransport) error {
+	if requestProvider == sfPlugins.AutoRequestSelect {
+		return fmt.Errorf("cannot register a transport for the auto request provider")
+	}
+	r.transportsMutex.Lock()
+	defer r.transportsMutex.Unlock()
+	r.requestTransports[requestProvider] = transport
+	return nil
+}
+
+// RegisterEgressTransport sets the transport egress messages of the provider are sent through. Transports implementing
+// io.Closer are closed on runtime's shutdown after function types stop handling messages.
+func (r *Runtime) RegisterEgressTransport(egressProvider sfPlugins.EgressProvider, transport EgressTransport) error {
+	if egressProvider == sfPlugins.AutoEgressSelect {
+		return fmt.Errorf("cannot register a transport for the auto egress provider")
+	}
+	r.transportsMutex.Lock()
+	defer r.transportsMutex.Unlock()
+	r.egressTransports[egressProvider] = transport
+	return nil
+}
+
+func (r *Runtime) getSignalTransport(signalProvider sfPlugins.SignalProvider) (SignalTransport, bool) {
+	r.transportsMutex.RLock()
+	defer r.transportsMutex.RUnlock()
+	transport, ok := r.signalTransports[signalProvider]
+	return transport, ok
+}
+
+func (r *Runtime) getRequestTransport(requestProvider sfPlugins.RequestProvider) (RequestTransport, bool) {
+	r.transportsMutex.RLock()
+	defer r.transportsMutex.RUnlock()
+	transport, ok := r.requestTransports[requestProvider]
+	return transport, ok
+}
+
+func (r *Runtime) getEgressTransport(egressProvider sfPlugins.EgressProvider) (EgressTransport, bool) {
+	r.transportsMutex.RLock()
+	defer r.transportsMutex.RUnlock()
+	transport, ok := r.egressTransports[egressProvider]
+	return transport, ok
+}
+
+func (r *Runtime) closeEgressTransports() {
+	r.transportsMutex.RLock()
+	defer r.transportsMutex.RUnlock()
+	for _, transport := range r.egressTransports {
+		if closer, ok := transport.(io.Closer); ok {
+			system.MsgOnErrorReturn(closer.Close())
+		}
+	}
+}
+
+// transportSources returns the transports which receive messages for the function type on their own
+func (r *Runtime) transportSources(ft *FunctionType) []TransportSource {
+	r.transportsMutex.RLock()
+	defer r.transportsMutex.RUnlock()
+
+	sources := []TransportSource{}
+	for provider, transport := range r.signalTransports {
+		if source, ok := transport.(TransportSource); ok && ft.config.IsSignalProviderAllowed(provider) {
+			sources = append(sources, source)
+		}
+	}
+	for provider, transport := range r.requestTransports {
+		if source, ok := transport.(TransportSource); ok && ft.config.IsRequestProviderAllowed(provider) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// Deliver passes a message received by a transport to the handler of the function type's id
+func (ft *FunctionType) Deliver(id string, msg FunctionTypeMsg) {
+	ft.sendMsg(id, msg)
+}
@@ -0,0 +1,155 @@
+package statefun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/foliagecp/easyjson"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	sfMediators "github.com/foliagecp/sdk/statefun/mediator"
+	sfPlugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	schemasKeyPrefix = "schemas"
+)
+
+/*
+TypedFunctionLogicHandler handles a payload decoded into Req. Returned response is encoded into the data of an ok OpMsg
+reply, returned error is replied as a failed OpMsg with the error in its details:
+
+	type LinkCreateRequest struct {
+		To   string `json:"to" validate:"required"`
+		Type string `json:"type" validate:"required,oneof=parent child"`
+	}
+
+	type LinkCreateResponse struct {
+		Created bool `json:"created"`
+	}
+
+	statefun.NewTypedFunctionType(runtime, "functions.links.create",
+		func(ctx *sfPlugins.StatefunContextProcessor, req *LinkCreateRequest) (*LinkCreateResponse, error) {
+			...
+		}, *statefun.NewFunctionTypeConfig())
+*/
+type TypedFunctionLogicHandler[Req any, Resp any] func(ctx *sfPlugins.StatefunContextProcessor, request *Req) (*Resp, error)
+
+// NewTypedFunctionType registers a function type whose payload is decoded and validated into Req before the handler is called
+func NewTypedFunctionType[Req any, Resp any](runtime *Runtime, name string, logicHandler TypedFunctionLogicHandler[Req, Resp], config FunctionTypeConfig) *FunctionType {
+	ft := newTypedFunctionType(runtime, name, logicHandler, config)
+	runtime.addFunctionType(ft)
+	return ft
+}
+
+// RegisterTypedFunctionType is the typed version of Runtime.RegisterFunctionType
+func RegisterTypedFunctionType[Req any, Resp any](runtime *Runtime, name string, logicHandler TypedFunctionLogicHandler[Req, Resp], config FunctionTypeConfig) (*FunctionType, error) {
+	ft := newTypedFunctionType(runtime, name, logicHandler, config)
+	return ft, runtime.registerFunctionType(ft)
+}
+
+func newTypedFunctionType[Req any, Resp any](runtime *Runtime, name string, logicHandler TypedFunctionLogicHandler[Req, Resp], config FunctionTypeConfig) *FunctionType {
+	ft := newFunctionType(runtime, name, typedLogicHandler(logicHandler), config)
+
+	schema := easyjson.NewJSONObject()
+	schema.SetByPath("typename", easyjson.NewJSON(name))
+	schema.SetByPath("request", typedSchema(reflect.TypeOf((*Req)(nil)).Elem()))
+	schema.SetByPath("response", typedSchema(reflect.TypeOf((*Resp)(nil)).Elem()))
+	ft.schema = &schema
+
+	return ft
+}
+
+func typedLogicHandler[Req any, Resp any](logicHandler TypedFunctionLogicHandler[Req, Resp]) FunctionLogicHandler {
+	return func(_ sfPlugins.StatefunExecutor, ctx *sfPlugins.StatefunContextProcessor) {
+		om := sfMediators.NewOpMediator(ctx)
+
+		request := new(Req)
+		if ctx.Payload != nil && !ctx.Payload.IsNull() {
+			if err := json.Unmarshal(ctx.Payload.ToBytes(), request); err != nil {
+				om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("invalid payload: %s", err))).Reply()
+				return
+			}
+		}
+		if err := validateTyped(request); err != nil {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("invalid payload: %s", err))).Reply()
+			return
+		}
+
+		response, err := logicHandler(ctx, request)
+		if err != nil {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed(err.Error())).Reply()
+			return
+		}
+		if response == nil {
+			om.AggregateOpMsg(sfMediators.OpMsgOk(easyjson.NewJSONNull())).Reply()
+			return
+		}
+
+		responseBytes, err := json.Marshal(response)
+		if err != nil {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed(fmt.Sprintf("invalid response: %s", err))).Reply()
+			return
+		}
+		data, ok := easyjson.JSONFromBytes(responseBytes)
+		if !ok {
+			om.AggregateOpMsg(sfMediators.OpMsgFailed("invalid response: not a JSON")).Reply()
+			return
+		}
+		om.AggregateOpMsg(sfMediators.OpMsgOk(data)).Reply()
+	}
+}
+
+// Schema returns the request/response JSON schema of a typed function type, nil for untyped ones
+func (ft *FunctionType) Schema() *easyjson.JSON {
+	return ft.schema
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+/*
+Schemas of typed function types are published into the domain's key/value bucket under "schemas.<typename hash>" keys
+on runtime start, so clients of any runtime can fetch them.
+*/
+func schemaKey(typename string) string {
+	return schemasKeyPrefix + "." + system.GetHashStr(typename)
+}
+
+func (r *Runtime) publishSchemas(functionTypes []*FunctionType) error {
+	for _, ft := range functionTypes {
+		if err := r.publishSchema(ft); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runtime) publishSchema(ft *FunctionType) error {
+	if ft.schema == nil {
+		return nil
+	}
+	_, err := r.Domain.kv.Put(schemaKey(ft.name), ft.schema.ToBytes())
+	return err
+}
+
+// FunctionTypeSchema returns the published request/response JSON schema of a typed function type
+func (r *Runtime) FunctionTypeSchema(typename string) (*easyjson.JSON, error) {
+	if r.Domain.kv == nil {
+		return nil, ErrRuntimeNotStarted
+	}
+	entry, err := r.Domain.kv.Get(schemaKey(typename))
+	if err != nil {
+		if errors.Is(err, cache.ErrBackendKeyNotFound) {
+			return nil, fmt.Errorf("function type %s has no published schema", typename)
+		}
+		return nil, err
+	}
+	j, ok := easyjson.JSONFromBytes(entry.Value)
+	if !ok {
+		return nil, fmt.Errorf("schema of function type %s is not a JSON", typename)
+	}
+	return &j, nil
+}
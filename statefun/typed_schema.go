@@ -0,0 +1,306 @@
+package statefun
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+)
+
+/*
+Typed payloads are validated by the "validate" struct tag, rules are comma separated:
+
+	required     - value must not be zero (nil for pointers, slices and maps)
+	min=<n>      - minimum for numbers, minimum length for strings, slices and maps
+	max=<n>      - maximum for numbers, maximum length for strings, slices and maps
+	oneof=<a b>  - value must be one of space separated values
+*/
+const (
+	validateTag = "validate"
+)
+
+var (
+	timeType         = reflect.TypeOf(time.Time{})
+	easyjsonJSONType = reflect.TypeOf(easyjson.JSON{})
+)
+
+type validationRule struct {
+	name  string
+	value string
+}
+
+func parseValidationRules(tag string) []validationRule {
+	rules := []validationRule{}
+	for _, token := range strings.Split(tag, ",") {
+		token = strings.TrimSpace(token)
+		if len(token) == 0 {
+			continue
+		}
+		name, value, _ := strings.Cut(token, "=")
+		rules = append(rules, validationRule{name: name, value: value})
+	}
+	return rules
+}
+
+// jsonFieldName returns the name of a struct field in json, empty string means the field is not serialized
+func jsonFieldName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if len(name) == 0 {
+		return field.Name
+	}
+	return name
+}
+
+func joinFieldPath(path string, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// validateTyped checks a decoded typed payload against its "validate" struct tags
+func validateTyped(v any) error {
+	return validateValue(reflect.ValueOf(v), "")
+}
+
+func validateValue(v reflect.Value, path string) error {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType || v.Type() == easyjsonJSONType {
+			return nil
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			fieldPath := path
+			if !field.Anonymous {
+				name := jsonFieldName(field)
+				if len(name) == 0 {
+					continue
+				}
+				fieldPath = joinFieldPath(path, name)
+			}
+			if err := validateField(v.Field(i), fieldPath, parseValidationRules(field.Tag.Get(validateTag))); err != nil {
+				return err
+			}
+			if err := validateValue(v.Field(i), fieldPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := validateValue(iter.Value(), joinFieldPath(path, fmt.Sprint(iter.Key().Interface()))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateField(v reflect.Value, path string, rules []validationRule) error {
+	for _, rule := range rules {
+		if rule.name == "required" {
+			if v.IsZero() {
+				return fmt.Errorf("field %s is required", path)
+			}
+			continue
+		}
+
+		// Other rules are not applied to absent values
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				break
+			}
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Pointer {
+			continue
+		}
+
+		switch rule.name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(rule.value, 64)
+			if err != nil {
+				return fmt.Errorf("field %s has invalid %s rule: %s", path, rule.name, rule.value)
+			}
+			measure, isLength, ok := measureValue(v)
+			if !ok {
+				continue
+			}
+			if rule.name == "min" && measure < limit {
+				if isLength {
+					return fmt.Errorf("field %s length must be at least %s", path, rule.value)
+				}
+				return fmt.Errorf("field %s must be at least %s", path, rule.value)
+			}
+			if rule.name == "max" && measure > limit {
+				if isLength {
+					return fmt.Errorf("field %s length must be at most %s", path, rule.value)
+				}
+				return fmt.Errorf("field %s must be at most %s", path, rule.value)
+			}
+		case "oneof":
+			options := strings.Fields(rule.value)
+			value := fmt.Sprint(v.Interface())
+			found := false
+			for _, option := range options {
+				if option == value {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("field %s must be one of [%s]", path, strings.Join(options, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+func measureValue(v reflect.Value) (measure float64, isLength bool, ok bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true, true
+	}
+	return 0, false, false
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// typedSchema builds a JSON schema of a go type with validation rules applied
+func typedSchema(t reflect.Type) easyjson.JSON {
+	return typeSchema(t, map[reflect.Type]bool{})
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) easyjson.JSON {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	schema := easyjson.NewJSONObject()
+	switch {
+	case t == timeType:
+		schema.SetByPath("type", easyjson.NewJSON("string"))
+		schema.SetByPath("format", easyjson.NewJSON("date-time"))
+		return schema
+	case t == easyjsonJSONType:
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		schema.SetByPath("type", easyjson.NewJSON("boolean"))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema.SetByPath("type", easyjson.NewJSON("integer"))
+	case reflect.Float32, reflect.Float64:
+		schema.SetByPath("type", easyjson.NewJSON("number"))
+	case reflect.String:
+		schema.SetByPath("type", easyjson.NewJSON("string"))
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			schema.SetByPath("type", easyjson.NewJSON("string"))
+			break
+		}
+		schema.SetByPath("type", easyjson.NewJSON("array"))
+		schema.SetByPath("items", typeSchema(t.Elem(), visiting))
+	case reflect.Map:
+		schema.SetByPath("type", easyjson.NewJSON("object"))
+		schema.SetByPath("additionalProperties", typeSchema(t.Elem(), visiting))
+	case reflect.Struct:
+		schema.SetByPath("type", easyjson.NewJSON("object"))
+		if visiting[t] { // Recursive type
+			break
+		}
+		visiting[t] = true
+		properties := easyjson.NewJSONObject()
+		required := []string{}
+		structSchemaFields(t, visiting, &properties, &required)
+		delete(visiting, t)
+		schema.SetByPath("properties", properties)
+		if len(required) > 0 {
+			schema.SetByPath("required", easyjson.JSONFromArray(required))
+		}
+	}
+	return schema
+}
+
+func structSchemaFields(t reflect.Type, visiting map[reflect.Type]bool, properties *easyjson.JSON, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && len(field.Tag.Get("json")) == 0 {
+			ft := field.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structSchemaFields(ft, visiting, properties, required)
+				continue
+			}
+		}
+		name := jsonFieldName(field)
+		if len(name) == 0 {
+			continue
+		}
+
+		fieldSchema := typeSchema(field.Type, visiting)
+		fieldKind := field.Type.Kind()
+		for _, rule := range parseValidationRules(field.Tag.Get(validateTag)) {
+			switch rule.name {
+			case "required":
+				*required = append(*required, name)
+			case "min", "max":
+				limit, err := strconv.ParseFloat(rule.value, 64)
+				if err != nil {
+					continue
+				}
+				keyword := "minimum"
+				if rule.name == "max" {
+					keyword = "maximum"
+				}
+				switch fieldKind {
+				case reflect.String:
+					keyword = map[string]string{"min": "minLength", "max": "maxLength"}[rule.name]
+				case reflect.Slice, reflect.Array:
+					keyword = map[string]string{"min": "minItems", "max": "maxItems"}[rule.name]
+				case reflect.Map:
+					keyword = map[string]string{"min": "minProperties", "max": "maxProperties"}[rule.name]
+				}
+				fieldSchema.SetByPath(keyword, easyjson.NewJSON(limit))
+			case "oneof":
+				fieldSchema.SetByPath("enum", easyjson.JSONFromArray(strings.Fields(rule.value)))
+			}
+		}
+		properties.SetByPath(name, fieldSchema)
+	}
+}
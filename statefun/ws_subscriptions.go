@@ -0,0 +1,450 @@
+package statefun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+
+	"github.com/foliagecp/sdk/statefun/cache"
+	lg "github.com/foliagecp/sdk/statefun/logger"
+	"github.com/foliagecp/sdk/statefun/system"
+)
+
+const (
+	WebSocketSubscriptionsAddress     = ":8081"
+	WebSocketSubscriptionsPath        = "/subscribe"
+	WebSocketSubscriptionsSendBuffer  = 256
+	WebSocketSubscriptionsMaxPerConn  = 64
+	WebSocketSubscriptionsPingSec     = 30
+	WebSocketSubscriptionsWriteWaitMs = 10000
+
+	WebSocketEgressSubscription = "egress"
+	WebSocketCacheSubscription  = "cache"
+)
+
+// WebSocketAuthFunc authorizes a connection before it is upgraded, returned error is replied with 401 status
+type WebSocketAuthFunc func(req *http.Request) error
+
+// WebSocketSubscriptionAuthFunc authorizes a subscription of the connection, kind is "egress" or "cache", pattern is the
+// NATS subject or the cache level key being subscribed
+type WebSocketSubscriptionAuthFunc func(req *http.Request, kind string, pattern string) error
+
+type WebSocketSubscriptionsConfig struct {
+	address          string
+	path             string
+	auth             WebSocketAuthFunc
+	subscriptionAuth WebSocketSubscriptionAuthFunc
+	checkOrigin      func(req *http.Request) bool
+	sendBuffer       int
+	maxSubscriptions int
+	pingInterval     time.Duration
+}
+
+func NewWebSocketSubscriptionsConfig() *WebSocketSubscriptionsConfig {
+	return &WebSocketSubscriptionsConfig{
+		address:          WebSocketSubscriptionsAddress,
+		path:             WebSocketSubscriptionsPath,
+		sendBuffer:       WebSocketSubscriptionsSendBuffer,
+		maxSubscriptions: WebSocketSubscriptionsMaxPerConn,
+		pingInterval:     WebSocketSubscriptionsPingSec * time.Second,
+	}
+}
+
+func (wsc *WebSocketSubscriptionsConfig) SetAddress(address string) *WebSocketSubscriptionsConfig {
+	wsc.address = address
+	return wsc
+}
+
+func (wsc *WebSocketSubscriptionsConfig) SetPath(path string) *WebSocketSubscriptionsConfig {
+	wsc.path = path
+	return wsc
+}
+
+func (wsc *WebSocketSubscriptionsConfig) SetAuth(auth WebSocketAuthFunc) *WebSocketSubscriptionsConfig {
+	wsc.auth = auth
+	return wsc
+}
+
+func (wsc *WebSocketSubscriptionsConfig) SetSubscriptionAuth(subscriptionAuth WebSocketSubscriptionAuthFunc) *WebSocketSubscriptionsConfig {
+	wsc.subscriptionAuth = subscriptionAuth
+	return wsc
+}
+
+// SetCheckOrigin sets the check of the Origin header, by default only same host origins are accepted
+func (wsc *WebSocketSubscriptionsConfig) SetCheckOrigin(checkOrigin func(req *http.Request) bool) *WebSocketSubscriptionsConfig {
+	wsc.checkOrigin = checkOrigin
+	return wsc
+}
+
+// SetSendBuffer sets how many events can wait to be written to a connection, slower clients are disconnected
+func (wsc *WebSocketSubscriptionsConfig) SetSendBuffer(sendBuffer int) *WebSocketSubscriptionsConfig {
+	wsc.sendBuffer = sendBuffer
+	return wsc
+}
+
+func (wsc *WebSocketSubscriptionsConfig) SetMaxSubscriptions(maxSubscriptions int) *WebSocketSubscriptionsConfig {
+	wsc.maxSubscriptions = maxSubscriptions
+	return wsc
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+/*
+WebSocketSubscriptions streams live events to clients such as UIs. A client sends JSON commands:
+
+	{"op": "subscribe", "sid": "s1", "kind": "egress", "typename": "functions.cmdb.api.>", "filter": {"kind": "object", "op": ["update", "delete"]}}
+	{"op": "subscribe", "sid": "s2", "kind": "cache", "key": "hub/server1.out.body.*"}
+	{"op": "unsubscribe", "sid": "s1"}
+
+"egress" subscriptions receive egress messages published on "egress.<typename>.<id>", typename and id may contain NATS
+wildcards. "cache" subscriptions receive changes of the values on the cache level (made by this runtime) via
+cache.Store.SubscribeLevelCallback. Filter keeps only events with payload (value for the cache) fields equal to the
+filter's ones, an array in the filter matches any of its elements. Graph object and link changes come from the
+egress of "functions.cmdb.api.*" function types when enabled (see embedded/graph/crud/events.go).
+
+The server replies with:
+
+	{"op": "subscribed", "sid": "s1"}
+	{"op": "error", "sid": "s1", "error": "..."}
+	{"op": "event", "sid": "s1", "subject": "egress.functions.cmdb.api.object.update.hub/a", "typename": "functions.cmdb.api.object.update", "id": "hub/a", "payload": {...}}
+	{"op": "event", "sid": "s2", "key": "hub/server1.out.body.link1", "value": {...}} // value is null when deleted
+*/
+type WebSocketSubscriptions struct {
+	runtime     *Runtime
+	config      WebSocketSubscriptionsConfig
+	upgrader    websocket.Upgrader
+	server      *http.Server
+	connections sync.Map // *wsConnection -> struct{}
+	connCounter int64
+}
+
+func NewWebSocketSubscriptions(runtime *Runtime, config WebSocketSubscriptionsConfig) *WebSocketSubscriptions {
+	wss := &WebSocketSubscriptions{
+		runtime:  runtime,
+		config:   config,
+		upgrader: websocket.Upgrader{CheckOrigin: config.checkOrigin},
+	}
+	mux := http.NewServeMux()
+	mux.Handle(config.path, wss)
+	wss.server = &http.Server{
+		Addr:              config.address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return wss
+}
+
+// ListenAndServe serves WebSocket connections until Shutdown is called
+func (wss *WebSocketSubscriptions) ListenAndServe() error {
+	lg.Logf(lg.InfoLevel, "WebSocket subscriptions are served on %s%s", wss.config.address, wss.config.path)
+	if err := wss.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and closes active ones
+func (wss *WebSocketSubscriptions) Shutdown(ctx context.Context) error {
+	err := wss.server.Shutdown(ctx)
+	wss.connections.Range(func(key, _ any) bool {
+		key.(*wsConnection).close()
+		return true
+	})
+	return err
+}
+
+func (wss *WebSocketSubscriptions) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if wss.config.auth != nil {
+		if err := wss.config.auth(req); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	ws, err := wss.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return // Upgrader has already replied with an error
+	}
+
+	conn := &wsConnection{
+		wss:           wss,
+		id:            atomic.AddInt64(&wss.connCounter, 1),
+		req:           req,
+		ws:            ws,
+		send:          make(chan []byte, wss.config.sendBuffer),
+		closed:        make(chan struct{}),
+		subscriptions: map[string]*wsSubscription{},
+	}
+	wss.connections.Store(conn, struct{}{})
+	go conn.writeLoop()
+	conn.readLoop()
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+type wsSubscription struct {
+	natsSub         *nats.Subscription
+	cacheKey        string
+	cacheCallbackID string
+}
+
+type wsConnection struct {
+	wss           *WebSocketSubscriptions
+	id            int64
+	req           *http.Request
+	ws            *websocket.Conn
+	send          chan []byte
+	closed        chan struct{}
+	closeOnce     sync.Once
+	mutex         sync.Mutex
+	subscriptions map[string]*wsSubscription
+}
+
+func (c *wsConnection) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.ws.Close()
+		c.wss.connections.Delete(c)
+
+		c.mutex.Lock()
+		for sid, sub := range c.subscriptions {
+			c.unsubscribe(sub)
+			delete(c.subscriptions, sid)
+		}
+		c.mutex.Unlock()
+	})
+}
+
+// enqueue passes a message to the writer, a client which does not keep up is disconnected
+func (c *wsConnection) enqueue(msg easyjson.JSON) {
+	select {
+	case <-c.closed:
+	case c.send <- msg.ToBytes():
+	default:
+		lg.Logf(lg.WarnLevel, "WebSocket subscriptions: connection %d is too slow, closing it", c.id)
+		go c.close()
+	}
+}
+
+func (c *wsConnection) reply(op string, sid string, err error) {
+	msg := easyjson.NewJSONObjectWithKeyValue("op", easyjson.NewJSON(op))
+	msg.SetByPath("sid", easyjson.NewJSON(sid))
+	if err != nil {
+		msg.SetByPath("error", easyjson.NewJSON(err.Error()))
+	}
+	c.enqueue(msg)
+}
+
+func (c *wsConnection) writeLoop() {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("wsConnection.writeLoop")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("wsConnection.writeLoop")
+	defer c.close()
+
+	ping := time.NewTicker(c.wss.config.pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case data := <-c.send:
+			system.MsgOnErrorReturn(c.ws.SetWriteDeadline(time.Now().Add(WebSocketSubscriptionsWriteWaitMs * time.Millisecond)))
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(WebSocketSubscriptionsWriteWaitMs*time.Millisecond)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConnection) readLoop() {
+	defer c.close()
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		cmd, ok := easyjson.JSONFromBytes(data)
+		if !ok || !cmd.IsObject() {
+			c.reply("error", "", fmt.Errorf("command is not a JSON object"))
+			continue
+		}
+		sid := cmd.GetByPath("sid").AsStringDefault("")
+		switch cmd.GetByPath("op").AsStringDefault("") {
+		case "subscribe":
+			if err := c.subscribe(sid, cmd); err != nil {
+				c.reply("error", sid, err)
+			} else {
+				c.reply("subscribed", sid, nil)
+			}
+		case "unsubscribe":
+			c.mutex.Lock()
+			if sub, ok := c.subscriptions[sid]; ok {
+				c.unsubscribe(sub)
+				delete(c.subscriptions, sid)
+			}
+			c.mutex.Unlock()
+			c.reply("unsubscribed", sid, nil)
+		default:
+			c.reply("error", sid, fmt.Errorf("unknown op, expected subscribe or unsubscribe"))
+		}
+	}
+}
+
+func (c *wsConnection) subscribe(sid string, cmd easyjson.JSON) error {
+	if len(sid) == 0 {
+		return fmt.Errorf("sid is required")
+	}
+	var filter *easyjson.JSON
+	if cmd.GetByPath("filter").IsObject() {
+		filter = cmd.GetByPath("filter").GetPtr()
+	}
+
+	kind := cmd.GetByPath("kind").AsStringDefault("")
+	var pattern string
+	switch kind {
+	case WebSocketEgressSubscription:
+		typename := cmd.GetByPath("typename").AsStringDefault("")
+		if len(typename) == 0 {
+			return fmt.Errorf("typename is required")
+		}
+		pattern = "egress." + typename
+		if !strings.HasSuffix(typename, ">") {
+			pattern += "." + cmd.GetByPath("id").AsStringDefault("*")
+		}
+	case WebSocketCacheSubscription:
+		pattern = cmd.GetByPath("key").AsStringDefault("")
+		if len(pattern) == 0 {
+			return fmt.Errorf("key is required")
+		}
+	default:
+		return fmt.Errorf("unknown kind, expected %s or %s", WebSocketEgressSubscription, WebSocketCacheSubscription)
+	}
+	if c.wss.config.subscriptionAuth != nil {
+		if err := c.wss.config.subscriptionAuth(c.req, kind, pattern); err != nil {
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	select {
+	case <-c.closed:
+		return fmt.Errorf("connection is closed")
+	default:
+	}
+	if _, ok := c.subscriptions[sid]; ok {
+		return fmt.Errorf("sid is already subscribed")
+	}
+	if len(c.subscriptions) >= c.wss.config.maxSubscriptions {
+		return fmt.Errorf("too many subscriptions")
+	}
+
+	sub := &wsSubscription{}
+	if kind == WebSocketEgressSubscription {
+		if c.wss.runtime.nc == nil {
+			return ErrRuntimeWithoutNats
+		}
+		natsSub, err := c.wss.runtime.nc.Subscribe(pattern, func(msg *nats.Msg) {
+			payload, ok := easyjson.JSONFromBytes(msg.Data)
+			if !ok || !wsFilterMatches(filter, payload) {
+				return
+			}
+			// egress.<typename>.<id>
+			typename, id := "", ""
+			if lastDot := strings.LastIndex(msg.Subject, "."); lastDot > len("egress.") {
+				typename, id = msg.Subject[len("egress."):lastDot], msg.Subject[lastDot+1:]
+			}
+			event := easyjson.NewJSONObjectWithKeyValue("op", easyjson.NewJSON("event"))
+			event.SetByPath("sid", easyjson.NewJSON(sid))
+			event.SetByPath("subject", easyjson.NewJSON(msg.Subject))
+			event.SetByPath("typename", easyjson.NewJSON(typename))
+			event.SetByPath("id", easyjson.NewJSON(id))
+			event.SetByPath("payload", payload)
+			c.enqueue(event)
+		})
+		if err != nil {
+			return err
+		}
+		sub.natsSub = natsSub
+	} else {
+		levelPrefix := ""
+		if lastDot := strings.LastIndex(pattern, "."); lastDot >= 0 {
+			levelPrefix = pattern[:lastDot+1]
+		}
+		sub.cacheKey, sub.cacheCallbackID = pattern, fmt.Sprintf("ws-%d-%s", c.id, sid)
+		updates := c.wss.runtime.Domain.Cache().SubscribeLevelCallback(sub.cacheKey, sub.cacheCallbackID)
+		if updates == nil {
+			return fmt.Errorf("cannot subscribe to cache level %s", pattern)
+		}
+		go c.forwardCacheUpdates(sid, levelPrefix, filter, updates)
+	}
+	c.subscriptions[sid] = sub
+	return nil
+}
+
+func (c *wsConnection) forwardCacheUpdates(sid string, levelPrefix string, filter *easyjson.JSON, updates chan cache.KeyValue) {
+	system.GlobalPrometrics.GetRoutinesCounter().Started("wsConnection.forwardCacheUpdates")
+	defer system.GlobalPrometrics.GetRoutinesCounter().Stopped("wsConnection.forwardCacheUpdates")
+
+	for update := range updates {
+		value := easyjson.NewJSONNull()
+		if bytes, ok := update.Value.([]byte); ok && bytes != nil {
+			if j, ok := easyjson.JSONFromBytes(bytes); ok {
+				value = j
+			}
+		}
+		if !wsFilterMatches(filter, value) {
+			continue
+		}
+		event := easyjson.NewJSONObjectWithKeyValue("op", easyjson.NewJSON("event"))
+		event.SetByPath("sid", easyjson.NewJSON(sid))
+		event.SetByPath("key", easyjson.NewJSON(fmt.Sprintf("%s%v", levelPrefix, update.Key)))
+		event.SetByPath("value", value)
+		c.enqueue(event)
+	}
+}
+
+func (c *wsConnection) unsubscribe(sub *wsSubscription) {
+	if sub.natsSub != nil {
+		system.MsgOnErrorReturn(sub.natsSub.Unsubscribe())
+	} else {
+		c.wss.runtime.Domain.Cache().UnsubscribeLevelCallback(sub.cacheKey, sub.cacheCallbackID)
+	}
+}
+
+// wsFilterMatches checks that every filter's field is equal to the data's one or to any element of the filter's array
+func wsFilterMatches(filter *easyjson.JSON, data easyjson.JSON) bool {
+	if filter == nil {
+		return true
+	}
+	for _, key := range filter.ObjectKeys() {
+		expected := filter.GetByPath(key)
+		actual := data.GetByPath(key)
+		if expected.IsArray() {
+			matched := false
+			for i := 0; i < expected.ArraySize(); i++ {
+				if expected.ArrayElement(i).Equals(actual) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		} else if !expected.Equals(actual) {
+			return false
+		}
+	}
+	return true
+}